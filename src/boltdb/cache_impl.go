@@ -0,0 +1,34 @@
+// The bolt limiter applies the limits like the memory limiter, but keeps the counters in a local database file, so
+// that long windows such as DAY or MONTH limits are not reset when the service restarts. Like the memory limiter, it
+// enforces the limits per instance of the service.
+
+package boltdb
+
+import (
+	"io"
+	"math/rand"
+
+	"github.com/coocood/freecache"
+
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/memory"
+	"github.com/envoyproxy/ratelimit/src/settings"
+	"github.com/envoyproxy/ratelimit/src/stats"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+func NewRateLimitCacheImplFromSettings(s settings.Settings, timeSource utils.TimeSource, jitterRand *rand.Rand,
+	localCache *freecache.Cache, statsManager stats.Manager,
+) (limiter.RateLimitCache, io.Closer) {
+	store := newStore(s.BoltDbPath, timeSource, s.BoltDbCompactInterval, s.BoltDbMaxBatchDelay, s.BoltDbNoSync)
+	return memory.NewRateLimitCacheImplWithStore(
+		store,
+		timeSource,
+		jitterRand,
+		s.ExpirationJitterMaxSeconds,
+		localCache,
+		statsManager,
+		s.NearLimitRatio,
+		s.CacheKeyPrefix,
+	), store
+}
@@ -0,0 +1,189 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/envoyproxy/ratelimit/src/memory"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+var bucketName = []byte("ratelimit")
+
+type BoltError string
+
+func (e BoltError) Error() string {
+	return string(e)
+}
+
+func (e BoltError) CacheUnavailable() {}
+
+// Keeps the entries of the keys in a bolt database file, so that they survive restarts. Each update is committed in
+// its own transaction, and concurrent updates are committed together to save disk syncs. Expired entries are ignored
+// on access and removed by a periodic compaction. Failures of the database panic with a BoltError, so that the failure
+// modes of the limits apply like for any other unavailable cache.
+type store struct {
+	db         *bolt.DB
+	timeSource utils.TimeSource
+	finish     chan struct{}
+	closeOnce  sync.Once
+}
+
+var _ memory.Store = (*store)(nil)
+
+// Decodes the entry of a key, or returns nil if the key is missing or expired.
+func decodeEntry(key []byte, value []byte, now int64) *memory.Entry {
+	if value == nil {
+		return nil
+	}
+	e := &memory.Entry{}
+	if err := json.Unmarshal(value, e); err != nil {
+		logger.Errorf("Unexpected value of key %s in bolt: %s", key, err)
+		return nil
+	}
+	if e.ExpiresAt <= now {
+		return nil
+	}
+	return e
+}
+
+func (this *store) Update(key string, f func(e *memory.Entry, now int64)) {
+	err := this.db.Batch(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketName)
+		now := this.timeSource.UnixNanoNow()
+		e := decodeEntry([]byte(key), bucket.Get([]byte(key)), now)
+		if e == nil {
+			e = &memory.Entry{}
+		}
+		f(e, now)
+		if e.ExpiresAt <= now {
+			return bucket.Delete([]byte(key))
+		}
+		value, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), value)
+	})
+	if err != nil {
+		panic(BoltError("Failed to update key " + key + " in bolt: " + err.Error()))
+	}
+}
+
+func (this *store) Count(key string) uint64 {
+	var count uint64
+	err := this.db.View(func(tx *bolt.Tx) error {
+		if e := decodeEntry([]byte(key), tx.Bucket(bucketName).Get([]byte(key)), this.timeSource.UnixNanoNow()); e != nil {
+			count = e.Count
+		}
+		return nil
+	})
+	if err != nil {
+		panic(BoltError("Failed to read key " + key + " from bolt: " + err.Error()))
+	}
+	return count
+}
+
+func (this *store) Delete(key string) {
+	err := this.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketName).Delete([]byte(key))
+	})
+	if err != nil {
+		panic(BoltError("Failed to delete key " + key + " from bolt: " + err.Error()))
+	}
+}
+
+// Removes the expired entries, e.g. the counters of past windows. Their pages are reused by later writes.
+// @return the number of removed entries.
+func (this *store) compact() (int, error) {
+	var removed int
+	err := this.db.Update(func(tx *bolt.Tx) error {
+		now := this.timeSource.UnixNanoNow()
+		bucket := tx.Bucket(bucketName)
+		// Deleting while iterating skips keys, so the expired keys are deleted once they were all found.
+		var expired [][]byte
+		err := bucket.ForEach(func(key []byte, value []byte) error {
+			if decodeEntry(key, value, now) == nil {
+				expired = append(expired, append([]byte{}, key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		removed = len(expired)
+		return nil
+	})
+	return removed, err
+}
+
+func (this *store) compactPeriodically(d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			removed, err := this.compact()
+			if err != nil {
+				logger.Errorf("Failed to remove expired keys from bolt: %s", err)
+			} else {
+				logger.Debugf("removed %d expired keys from bolt", removed)
+			}
+		case <-this.finish:
+			return
+		}
+	}
+}
+
+// Stops the periodic compaction and closes the database.
+func (this *store) Close() error {
+	var err error
+	this.closeOnce.Do(func() {
+		close(this.finish)
+		err = this.db.Close()
+	})
+	return err
+}
+
+// @param path supplies the path of the database file, which is created if missing.
+// @param timeSource supplies the time the entries expire by.
+// @param compactInterval supplies the interval expired entries are removed at, 0 to only ignore them on access.
+// @param maxBatchDelay supplies the maximum time an update waits for concurrent updates to be committed with.
+// @param noSync supplies whether to skip syncing commits to disk, which may lose the last updates if the host crashes.
+func newStore(path string, timeSource utils.TimeSource, compactInterval time.Duration, maxBatchDelay time.Duration,
+	noSync bool,
+) *store {
+	// Another process holding the file waits for its lock forever otherwise.
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second, NoSync: noSync})
+	if err != nil {
+		panic(BoltError("Unable to open bolt database " + path + ": " + err.Error()))
+	}
+	db.MaxBatchDelay = maxBatchDelay
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		panic(BoltError("Unable to create bucket of bolt database " + path + ": " + err.Error()))
+	}
+
+	ret := &store{
+		db:         db,
+		timeSource: timeSource,
+		finish:     make(chan struct{}),
+	}
+	if compactInterval > 0 {
+		go ret.compactPeriodically(compactInterval)
+	}
+	return ret
+}
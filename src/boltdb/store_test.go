@@ -0,0 +1,51 @@
+package boltdb
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/ratelimit/src/memory"
+	mock_utils "github.com/envoyproxy/ratelimit/test/mocks/utils"
+)
+
+func TestStoreCompaction(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	now := int64(1000e9)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	timeSource.EXPECT().UnixNanoNow().DoAndReturn(func() int64 { return now }).AnyTimes()
+	store := newStore(filepath.Join(t.TempDir(), "ratelimit.db"), timeSource, 0, 0, false)
+	defer store.Close()
+
+	for _, key := range []string{"domain_key_value_960", "domain_key_value_1020", "domain_key2_value2_0"} {
+		store.Update(key, func(e *memory.Entry, now int64) {
+			e.Count = 2
+			e.ExpiresAt = now + int64(time.Minute)
+		})
+	}
+	store.Update("domain_key2_value2_0", func(e *memory.Entry, now int64) {
+		e.Count++
+		e.ExpiresAt = now + int64(time.Hour)
+	})
+	assert.Equal(uint64(3), store.Count("domain_key2_value2_0"))
+
+	// Expired keys are ignored until they are compacted.
+	now += int64(time.Minute)
+	assert.Equal(uint64(0), store.Count("domain_key_value_960"))
+	removed, err := store.compact()
+	assert.Nil(err)
+	assert.Equal(2, removed)
+	assert.Equal(uint64(3), store.Count("domain_key2_value2_0"))
+
+	store.Delete("domain_key2_value2_0")
+	removed, err = store.compact()
+	assert.Nil(err)
+	assert.Equal(0, removed)
+	assert.Equal(uint64(0), store.Count("domain_key2_value2_0"))
+}
@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// Binary prefix trie of the descriptors matching CIDR ranges of a key. Looking up an address walks its bits from the
+// most significant one, so the most specific range containing the address is found in at most 32 or 128 steps
+// regardless of the number of ranges.
+type cidrTrie struct {
+	// Roots of the IPv4 and IPv6 ranges. IPv4 addresses never match IPv6 ranges and vice versa.
+	ipv4 cidrTrieNode
+	ipv6 cidrTrieNode
+}
+
+type cidrTrieNode struct {
+	children [2]*cidrTrieNode
+	// Range ending at the node and its descriptor, or nil if no range ends at the node.
+	addressRange netip.Prefix
+	descriptor   *rateLimitDescriptor
+}
+
+// Get the bit of an address at an index, starting from the most significant bit.
+func addressBit(address []byte, i int) int {
+	return int(address[i/8]>>(7-i%8)) & 1
+}
+
+func (this *cidrTrie) root(address netip.Addr) *cidrTrieNode {
+	if address.Is4() {
+		return &this.ipv4
+	}
+	return &this.ipv6
+}
+
+// Find the node of a range, optionally creating the missing nodes on its path.
+// @param addressRange supplies the masked range.
+// @param create supplies whether to create the missing nodes.
+// @return the node or nil if it is missing and not created.
+func (this *cidrTrie) node(addressRange netip.Prefix, create bool) *cidrTrieNode {
+	node := this.root(addressRange.Addr())
+	address := addressRange.Addr().AsSlice()
+	for i := 0; i < addressRange.Bits(); i++ {
+		bit := addressBit(address, i)
+		if node.children[bit] == nil {
+			if !create {
+				return nil
+			}
+			node.children[bit] = &cidrTrieNode{}
+		}
+		node = node.children[bit]
+	}
+	return node
+}
+
+// Whether the trie has a descriptor for a range.
+// @param addressRange supplies the masked range.
+func (this *cidrTrie) contains(addressRange netip.Prefix) bool {
+	node := this.node(addressRange, false)
+	return node != nil && node.descriptor != nil
+}
+
+// Add the descriptor of a range to the trie, replacing any descriptor of the same range.
+// @param addressRange supplies the masked range.
+// @param descriptor supplies the descriptor.
+func (this *cidrTrie) insert(addressRange netip.Prefix, descriptor *rateLimitDescriptor) {
+	node := this.node(addressRange, true)
+	node.addressRange = addressRange
+	node.descriptor = descriptor
+}
+
+// Find the descriptor of the longest range containing an address.
+// @param address supplies the unmapped address.
+// @return the descriptor or nil if no range contains the address.
+func (this *cidrTrie) lookup(address netip.Addr) *rateLimitDescriptor {
+	node := this.root(address)
+	bytes := address.AsSlice()
+	var ret *rateLimitDescriptor
+	for i := 0; node != nil; i++ {
+		if node.descriptor != nil {
+			ret = node.descriptor
+		}
+		if i == address.BitLen() {
+			break
+		}
+		node = node.children[addressBit(bytes, i)]
+	}
+	return ret
+}
+
+// Call a function with the descriptor of each range in the trie, shorter prefixes first.
+func (this *cidrTrie) walk(f func(*rateLimitDescriptor)) {
+	var walkNode func(node *cidrTrieNode)
+	walkNode = func(node *cidrTrieNode) {
+		if node == nil {
+			return
+		}
+		if node.descriptor != nil {
+			f(node.descriptor)
+		}
+		walkNode(node.children[0])
+		walkNode(node.children[1])
+	}
+	walkNode(&this.ipv4)
+	walkNode(&this.ipv6)
+}
+
+// Parse the CIDR range a descriptor matches, which is either set by value_cidr or, for remote_address descriptors,
+// by a value in CIDR notation.
+// @param config supplies the config file that owns the descriptor.
+// @param descriptorConfig supplies the YAML descriptor.
+// @return the masked range of addresses the descriptor matches, and whether the descriptor matches a range.
+// @throws RateLimitConfigError if the range is not valid.
+func parseDescriptorCidr(config RateLimitConfigToLoad, descriptorConfig YamlDescriptor) (netip.Prefix, bool) {
+	value, setting := descriptorConfig.ValueCidr, "value_cidr"
+	if value == "" {
+		if descriptorConfig.Key != remoteAddressKey || !strings.Contains(descriptorConfig.Value, "/") {
+			return netip.Prefix{}, false
+		}
+		value, setting = descriptorConfig.Value, "remote_address range"
+	}
+	addressRange, err := netip.ParsePrefix(value)
+	if err != nil {
+		panic(newRateLimitConfigError(config.Name, fmt.Sprintf("invalid %s '%s': %s", setting, value, err)))
+	}
+	// Ranges of IPv4-mapped IPv6 addresses match the IPv4 addresses they map.
+	if addressRange.Addr().Is4In6() && addressRange.Bits() >= 96 {
+		addressRange = netip.PrefixFrom(addressRange.Addr().Unmap(), addressRange.Bits()-96)
+	}
+	return addressRange.Masked(), true
+}
+
+// Add a nested descriptor matching a CIDR range of the values of a key.
+// @param key supplies the key of the descriptor.
+// @param addressRange supplies the masked range.
+// @param descriptor supplies the descriptor.
+func (this *rateLimitDescriptor) addCidrDescriptor(key string, addressRange netip.Prefix, descriptor *rateLimitDescriptor) {
+	if this.cidrDescriptors == nil {
+		this.cidrDescriptors = map[string]*cidrTrie{}
+	}
+	trie := this.cidrDescriptors[key]
+	if trie == nil {
+		trie = &cidrTrie{}
+		this.cidrDescriptors[key] = trie
+	}
+	trie.insert(addressRange, descriptor)
+}
+
+// Find the nested descriptor with the most specific CIDR range containing the address of an entry.
+// @param key supplies the key of the entry.
+// @param value supplies the value of the entry.
+// @return the descriptor or nil if the value is not an address in any range of the key.
+func (this *rateLimitDescriptor) matchCidr(key string, value string) *rateLimitDescriptor {
+	trie := this.cidrDescriptors[key]
+	if trie == nil {
+		return nil
+	}
+	address, err := netip.ParseAddr(value)
+	if err != nil {
+		return nil
+	}
+	return trie.lookup(address.Unmap())
+}
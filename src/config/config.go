@@ -1,6 +1,8 @@
 package config
 
 import (
+	"time"
+
 	pb_struct "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
 	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	"golang.org/x/net/context"
@@ -15,16 +17,77 @@ func (e RateLimitConfigError) Error() string {
 	return string(e)
 }
 
+// Algorithm used to count hits against a rate limit.
+type RateLimitAlgorithm int32
+
+const (
+	// Hits are counted in fixed windows aligned to the limit unit.
+	FixedWindow RateLimitAlgorithm = iota
+	// Hits are counted in fixed windows, but the counter of the previous window is weighted by
+	// its overlap with a window ending now. This avoids bursts of twice the limit across window boundaries.
+	SlidingWindow
+	// Tokens are refilled at the rate of the limit into a bucket holding up to Burst tokens, and each hit takes a token.
+	TokenBucket
+	// The generic cell rate algorithm stores the theoretical arrival time of the next hit. It admits bursts of up to
+	// Burst hits like a token bucket, and spaces further hits evenly at the rate of the limit.
+	GCRA
+	// Hits acquire one of RequestsPerUnit slots, which are held until they are released or until their lease of one
+	// window expires. This limits the number of concurrent requests rather than their rate.
+	Concurrency
+)
+
+var rateLimitAlgorithmNames = map[RateLimitAlgorithm]string{
+	FixedWindow:   "fixed_window",
+	SlidingWindow: "sliding_window",
+	TokenBucket:   "token_bucket",
+	GCRA:          "gcra",
+	Concurrency:   "concurrency",
+}
+
+func (this RateLimitAlgorithm) String() string {
+	return rateLimitAlgorithmNames[this]
+}
+
 // Wrapper for an individual rate limit config entry which includes the defined limit and stats.
 type RateLimit struct {
-	FullKey        string
-	Stats          stats.RateLimitStats
-	Limit          *pb.RateLimitResponse_RateLimit
-	Unlimited      bool
+	FullKey   string
+	Stats     stats.RateLimitStats
+	Limit     *pb.RateLimitResponse_RateLimit
+	Unlimited bool
+	// Whether all hits are rejected without being counted.
+	Blocked        bool
 	ShadowMode     bool
 	Name           string
 	Replaces       []string
 	DetailedMetric bool
+	Algorithm      RateLimitAlgorithm
+	// Capacity of the bucket for limits using the token bucket or the GCRA algorithm.
+	Burst uint32
+	// Number of units in a window of the limit, e.g. 15 for a limit per 15 minutes. 0 is the same as 1.
+	UnitMultiplier uint32
+	// Time zone the windows of calendar aligned limits start in, nil for windows aligned to the unix epoch.
+	CalendarLocation *time.Location
+	// Suffix of the stats and cache keys of the additional limits of a descriptor, e.g. "per_hour", which is added to
+	// the cache keys as a separate "|limit=per_hour|" field. Empty for the first limit of a descriptor.
+	KeySuffix string
+	// Further limits of the same descriptor, which are checked along with this limit.
+	AdditionalLimits []*RateLimit
+	// Limits of ancestor descriptors which hits also count against, e.g. the budget of a tenant shared by its users.
+	AlsoCountsAgainst []*RateLimit
+	// Number of leading entries of a request descriptor the limit applies to, 0 for all entries. Limits of ancestor
+	// descriptors only count the entries of their ancestor.
+	DescriptorDepth int
+	// Number of hits a request counts for each hit of its descriptor, 0 for the default cost of 1.
+	Cost uint64
+	// Whether the limit of the value of the descriptor is read from the limit store, the configured limit being the
+	// default of values without a stored limit.
+	LimitFromStore bool
+	// Share of the limit each instance leases from the cache at once and serves locally, 0 to count every hit in the
+	// cache.
+	LeaseRatio float64
+	// Handling of the hits of the limit while the cache is unavailable, FailureModeDefault for the failure mode of the
+	// service.
+	FailureMode FailureMode
 }
 
 // Interface for interacting with a loaded rate limit config.
@@ -36,7 +99,8 @@ type RateLimitConfig interface {
 	// @param ctx supplies the calling context.
 	// @param domain supplies the domain to lookup the descriptor in.
 	// @param descriptor supplies the descriptor to look up.
-	// @return a rate limit to apply or nil if no rate limit is configured for the descriptor.
+	// @return a rate limit to apply or nil if no rate limit is configured for the descriptor. Further limits of a
+	// descriptor with a list of limits are in the AdditionalLimits of the returned limit.
 	GetLimit(ctx context.Context, domain string, descriptor *pb_struct.RateLimitDescriptor) *RateLimit
 
 	// Check if the domains is empty which corresponds to no config loaded.
@@ -47,6 +111,8 @@ type RateLimitConfig interface {
 type RateLimitConfigToLoad struct {
 	Name       string
 	ConfigYaml *YamlRoot
+	// Limit overrides of an overrides file, which has no ConfigYaml.
+	Overrides []RateLimitOverride
 }
 
 // Interface for loading a configuration from a list of YAML files.
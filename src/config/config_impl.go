@@ -2,7 +2,11 @@ package config
 
 import (
 	"fmt"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	pb_struct "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
 	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
@@ -21,28 +25,127 @@ type YamlRateLimit struct {
 	RequestsPerUnit uint32 `yaml:"requests_per_unit"`
 	Unit            string
 	Unlimited       bool `yaml:"unlimited"`
+	// Reject all hits without counting them.
+	Block           bool `yaml:"block"`
 	Name            string
 	Replaces        []yamlReplaces
+	Algorithm       string
+	Burst           uint32
+	UnitMultiplier  uint32 `yaml:"unit_multiplier"`
+	CalendarAligned bool   `yaml:"calendar_aligned"`
+	Timezone        string
+	// Keys of ancestor descriptors whose limits hits also count against.
+	AlsoCountsAgainst []string `yaml:"also_counts_against"`
+	// Share of the limit each instance leases from the cache at once and serves locally.
+	LeaseRatio float64 `yaml:"lease_ratio"`
 }
 
 type YamlDescriptor struct {
-	Key            string
-	Value          string
-	RateLimit      *YamlRateLimit `yaml:"rate_limit"`
+	Key   string
+	Value string
+	// Values of a descriptor matching any value in the set.
+	Values []string
+	// Regular expression of a descriptor matching all values it fully matches.
+	ValueRegex string `yaml:"value_regex"`
+	// CIDR range of a descriptor matching all addresses in the range, e.g. 10.0.0.0/8.
+	ValueCidr string `yaml:"value_cidr"`
+	// Set if the rate_limit of the descriptor is a single rate limit.
+	RateLimit *YamlRateLimit `yaml:"-"`
+	// Set if the rate_limit of the descriptor is a list of rate limits, which are all checked.
+	RateLimits     []*YamlRateLimit `yaml:"-"`
 	Descriptors    []YamlDescriptor
 	ShadowMode     bool `yaml:"shadow_mode"`
 	DetailedMetric bool `yaml:"detailed_metric"`
+	// Number of hits a request counts for each hit of the descriptor.
+	Cost uint64
+	// Whether the limit of each value is read from the limit store, the rate_limit being the default.
+	LimitFromStore bool `yaml:"limit_from_store"`
+	// Failure mode of the limits of the descriptor and its nested descriptors.
+	FailureMode string `yaml:"failure_mode"`
+}
+
+// Unmarshal a descriptor, whose rate_limit is either a single rate limit or a list of rate limits.
+func (this *YamlDescriptor) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plainYamlDescriptor YamlDescriptor
+	if err := unmarshal((*plainYamlDescriptor)(this)); err != nil {
+		return err
+	}
+
+	var rateLimit struct {
+		RateLimit interface{} `yaml:"rate_limit"`
+	}
+	if err := unmarshal(&rateLimit); err != nil {
+		return err
+	}
+	switch rateLimit.RateLimit.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		var rateLimits struct {
+			RateLimits []*YamlRateLimit `yaml:"rate_limit"`
+		}
+		err := unmarshal(&rateLimits)
+		this.RateLimits = rateLimits.RateLimits
+		return err
+	default:
+		var rateLimit struct {
+			RateLimit *YamlRateLimit `yaml:"rate_limit"`
+		}
+		err := unmarshal(&rateLimit)
+		this.RateLimit = rateLimit.RateLimit
+		return err
+	}
 }
 
 type YamlRoot struct {
 	Domain      string
 	Descriptors []YamlDescriptor
+	// Failure mode of all limits of the domain.
+	FailureMode string `yaml:"failure_mode"`
 }
 
 type rateLimitDescriptor struct {
 	descriptors  map[string]*rateLimitDescriptor
 	limit        *RateLimit
 	wildcardKeys []string
+	// Descriptors with a set of values, by key_value for each value in their set.
+	valueSetDescriptors map[string]*rateLimitDescriptor
+	// Descriptors with a value regex, in the order of the config.
+	regexDescriptors []*regexDescriptor
+	// Descriptors with a CIDR range, in a prefix trie by key.
+	cidrDescriptors map[string]*cidrTrie
+}
+
+// Limit of an ancestor of the descriptors being loaded.
+type ancestorLimit struct {
+	key   string
+	limit *RateLimit
+}
+
+type regexDescriptor struct {
+	key        string
+	valueRegex string
+	regex      *regexp.Regexp
+	descriptor *rateLimitDescriptor
+}
+
+// Whether the descriptor has nested descriptors of any kind.
+func (this *rateLimitDescriptor) hasDescriptors() bool {
+	return len(this.descriptors) > 0 || len(this.valueSetDescriptors) > 0 || len(this.regexDescriptors) > 0 ||
+		len(this.cidrDescriptors) > 0
+}
+
+// Find the nested descriptor with a value regex matching a descriptor entry.
+// @param key supplies the key of the entry.
+// @param value supplies the value of the entry.
+// @return the first descriptor in the order of the config whose regex matches, or nil.
+func (this *rateLimitDescriptor) matchValueRegex(key string, value string) *rateLimitDescriptor {
+	for _, regexDescriptor := range this.regexDescriptors {
+		if regexDescriptor.key == key && regexDescriptor.regex.MatchString(value) {
+			return regexDescriptor.descriptor
+		}
+	}
+	return nil
 }
 
 type rateLimitDomain struct {
@@ -53,21 +156,43 @@ type rateLimitConfigImpl struct {
 	domains            map[string]*rateLimitDomain
 	statsManager       stats.Manager
 	mergeDomainConfigs bool
+	// Limits of overrides files, by descriptor key.
+	overrides map[string]*pb.RateLimitResponse_RateLimit
 }
 
 var validKeys = map[string]bool{
-	"domain":            true,
-	"key":               true,
-	"value":             true,
-	"descriptors":       true,
-	"rate_limit":        true,
-	"unit":              true,
-	"requests_per_unit": true,
-	"unlimited":         true,
-	"shadow_mode":       true,
-	"name":              true,
-	"replaces":          true,
-	"detailed_metric":   true,
+	"domain":              true,
+	"key":                 true,
+	"value":               true,
+	"values":              true,
+	"value_regex":         true,
+	"value_cidr":          true,
+	"descriptors":         true,
+	"rate_limit":          true,
+	"unit":                true,
+	"requests_per_unit":   true,
+	"unlimited":           true,
+	"block":               true,
+	"shadow_mode":         true,
+	"name":                true,
+	"replaces":            true,
+	"detailed_metric":     true,
+	"algorithm":           true,
+	"burst":               true,
+	"unit_multiplier":     true,
+	"calendar_aligned":    true,
+	"timezone":            true,
+	"also_counts_against": true,
+	"cost":                true,
+	"limit_from_store":    true,
+	"lease_ratio":         true,
+	"failure_mode":        true,
+}
+
+// Keys of lists of leaf values, all other lists contain maps.
+var leafListKeys = map[string]bool{
+	"values":              true,
+	"also_counts_against": true,
 }
 
 // Create a new rate limit config entry.
@@ -99,16 +224,77 @@ func NewRateLimit(requestsPerUnit uint32, unit pb.RateLimitResponse_RateLimit_Un
 func (this *rateLimitDescriptor) dump() string {
 	ret := ""
 	if this.limit != nil {
-		ret += fmt.Sprintf(
-			"%s: unit=%s requests_per_unit=%d, shadow_mode: %t\n", this.limit.FullKey,
-			this.limit.Limit.Unit.String(), this.limit.Limit.RequestsPerUnit, this.limit.ShadowMode)
+		for _, limit := range append([]*RateLimit{this.limit}, this.limit.AdditionalLimits...) {
+			if limit.Blocked {
+				ret += fmt.Sprintf("%s: blocked, shadow_mode: %t\n", limit.FullKey, limit.ShadowMode)
+				continue
+			}
+			ret += fmt.Sprintf(
+				"%s: unit=%s requests_per_unit=%d, shadow_mode: %t", limit.FullKey,
+				limit.Limit.Unit.String(), limit.Limit.RequestsPerUnit, limit.ShadowMode)
+			if limit.LimitFromStore {
+				ret += ", limit_from_store: true"
+			}
+			if limit.FailureMode != FailureModeDefault {
+				ret += ", failure_mode: " + limit.FailureMode.String()
+			}
+			ret += "\n"
+		}
 	}
 	for _, descriptor := range this.descriptors {
 		ret += descriptor.dump()
 	}
+	dumpedValueSets := map[*rateLimitDescriptor]bool{}
+	for _, descriptor := range this.valueSetDescriptors {
+		if !dumpedValueSets[descriptor] {
+			dumpedValueSets[descriptor] = true
+			ret += descriptor.dump()
+		}
+	}
+	for _, regexDescriptor := range this.regexDescriptors {
+		ret += regexDescriptor.descriptor.dump()
+	}
+	cidrKeys := make([]string, 0, len(this.cidrDescriptors))
+	for key := range this.cidrDescriptors {
+		cidrKeys = append(cidrKeys, key)
+	}
+	sort.Strings(cidrKeys)
+	for _, key := range cidrKeys {
+		this.cidrDescriptors[key].walk(func(descriptor *rateLimitDescriptor) {
+			ret += descriptor.dump()
+		})
+	}
 	return ret
 }
 
+// Parse the failure mode of a domain or a descriptor.
+// @param config supplies the config file that owns the failure mode.
+// @param name supplies the failure mode name.
+// @return the failure mode.
+// @throws RateLimitConfigError if the failure mode is not valid.
+func parseFailureMode(config RateLimitConfigToLoad, name string) FailureMode {
+	failureMode, valid := ParseFailureMode(name)
+	if !valid {
+		panic(newRateLimitConfigError(config.Name, fmt.Sprintf("invalid failure_mode '%s'", name)))
+	}
+	return failureMode
+}
+
+// Parse the algorithm of a rate limit. An empty algorithm selects the fixed window.
+// @param algorithm supplies the algorithm name from the config.
+// @return the algorithm and whether the name was valid.
+func parseRateLimitAlgorithm(algorithm string) (RateLimitAlgorithm, bool) {
+	if algorithm == "" {
+		return FixedWindow, true
+	}
+	for value, name := range rateLimitAlgorithmNames {
+		if strings.EqualFold(name, algorithm) {
+			return value, true
+		}
+	}
+	return FixedWindow, false
+}
+
 // Create a new config error which includes the owning file.
 // @param config supplies the config file that generated the error.
 // @param err supplies the error string.
@@ -121,81 +307,355 @@ func newRateLimitConfigError(name string, err string) RateLimitConfigError {
 // @param parentKey supplies the fully resolved key name that owns this config level.
 // @param descriptors supplies the YAML descriptors to load.
 // @param statsManager that owns the stats.Scope.
-func (this *rateLimitDescriptor) loadDescriptors(config RateLimitConfigToLoad, parentKey string, descriptors []YamlDescriptor, statsManager stats.Manager) {
+// @param ancestors supplies the limits of the ancestors of the descriptors, starting at the root.
+// @param failureMode supplies the failure mode inherited from the domain and the ancestors of the descriptors.
+func (this *rateLimitDescriptor) loadDescriptors(config RateLimitConfigToLoad, parentKey string, descriptors []YamlDescriptor, statsManager stats.Manager,
+	ancestors []ancestorLimit, failureMode FailureMode,
+) {
 	for _, descriptorConfig := range descriptors {
 		if descriptorConfig.Key == "" {
 			panic(newRateLimitConfigError(config.Name, "descriptor has empty key"))
 		}
 
-		// Value is optional, so the final key for the map is either the key only or key_value.
+		valueMatchers := 0
+		for _, present := range []bool{descriptorConfig.Value != "", len(descriptorConfig.Values) > 0, descriptorConfig.ValueRegex != "",
+			descriptorConfig.ValueCidr != "",
+		} {
+			if present {
+				valueMatchers++
+			}
+		}
+		if valueMatchers > 1 {
+			panic(newRateLimitConfigError(
+				config.Name, "descriptor should not have more than one of value, values, value_regex and value_cidr"))
+		}
+
+		// Value is optional, so the final key for the map is either the key only or key_value. Descriptors with
+		// a set of values, a value regex or a CIDR range are not part of the map, their final key only names their stats.
 		finalKey := descriptorConfig.Key
 		if descriptorConfig.Value != "" {
 			finalKey += "_" + descriptorConfig.Value
+		} else if len(descriptorConfig.Values) > 0 {
+			finalKey += "_" + strings.Join(descriptorConfig.Values, "|")
+		} else if descriptorConfig.ValueRegex != "" {
+			finalKey += "_" + descriptorConfig.ValueRegex
+		} else if descriptorConfig.ValueCidr != "" {
+			finalKey += "_" + descriptorConfig.ValueCidr
 		}
 
 		newParentKey := parentKey + finalKey
-		if _, present := this.descriptors[finalKey]; present {
+		addressRange, isAddressRange := parseDescriptorCidr(config, descriptorConfig)
+		isValueMatcher := len(descriptorConfig.Values) > 0 || descriptorConfig.ValueRegex != "" || isAddressRange
+		// Addresses are looked up in their canonical form.
+		mapKey := descriptorConfig.Key
+		if descriptorConfig.Value != "" {
+			mapKey += "_" + normalizeDescriptorValue(descriptorConfig.Key, descriptorConfig.Value)
+		}
+		if _, present := this.descriptors[mapKey]; present && !isValueMatcher {
 			panic(newRateLimitConfigError(
 				config.Name, fmt.Sprintf("duplicate descriptor composite key '%s'", newParentKey)))
 		}
+		for _, value := range descriptorConfig.Values {
+			if _, present := this.valueSetDescriptors[descriptorConfig.Key+"_"+value]; present {
+				panic(newRateLimitConfigError(
+					config.Name, fmt.Sprintf("duplicate value '%s' in value sets of key '%s'", value, descriptorConfig.Key)))
+			}
+		}
+		if isAddressRange && this.cidrDescriptors[descriptorConfig.Key] != nil &&
+			this.cidrDescriptors[descriptorConfig.Key].contains(addressRange) {
+			panic(newRateLimitConfigError(
+				config.Name, fmt.Sprintf("duplicate %s range '%s'", descriptorConfig.Key, addressRange)))
+		}
+		var valueRegex *regexp.Regexp
+		if descriptorConfig.ValueRegex != "" {
+			for _, regexDescriptor := range this.regexDescriptors {
+				if regexDescriptor.key == descriptorConfig.Key && regexDescriptor.valueRegex == descriptorConfig.ValueRegex {
+					panic(newRateLimitConfigError(
+						config.Name, fmt.Sprintf("duplicate descriptor composite key '%s'", newParentKey)))
+				}
+			}
+			if _, err := regexp.Compile(descriptorConfig.ValueRegex); err != nil {
+				panic(newRateLimitConfigError(
+					config.Name, fmt.Sprintf("invalid value_regex '%s': %s", descriptorConfig.ValueRegex, err)))
+			}
+			// The regex has to match the whole value.
+			valueRegex = regexp.MustCompile("^(?:" + descriptorConfig.ValueRegex + ")$")
+		}
 
-		var rateLimit *RateLimit = nil
-		var rateLimitDebugString string = ""
+		descriptorFailureMode := parseFailureMode(config, descriptorConfig.FailureMode)
+		if descriptorFailureMode == FailureModeDefault {
+			descriptorFailureMode = failureMode
+		}
+
+		yamlRateLimits := descriptorConfig.RateLimits
 		if descriptorConfig.RateLimit != nil {
-			unlimited := descriptorConfig.RateLimit.Unlimited
+			yamlRateLimits = append([]*YamlRateLimit{descriptorConfig.RateLimit}, yamlRateLimits...)
+		}
+		if descriptorConfig.Cost != 0 && len(yamlRateLimits) == 0 {
+			panic(newRateLimitConfigError(
+				config.Name, fmt.Sprintf("descriptor '%s' has a cost but no rate_limit", newParentKey)))
+		}
+		if descriptorConfig.LimitFromStore && len(yamlRateLimits) != 1 {
+			panic(newRateLimitConfigError(
+				config.Name, fmt.Sprintf("descriptor '%s' with limit_from_store should have a single rate_limit", newParentKey)))
+		}
 
-			value, present := pb.RateLimitResponse_RateLimit_Unit_value[strings.ToUpper(descriptorConfig.RateLimit.Unit)]
-			validUnit := present && value != int32(pb.RateLimitResponse_RateLimit_UNKNOWN)
+		var rateLimit *RateLimit = nil
+		var rateLimitDebugString string = ""
+		windows := map[string]bool{}
+		for i, yamlRateLimit := range yamlRateLimits {
+			if yamlRateLimit == nil {
+				panic(newRateLimitConfigError(config.Name, "rate limit list should not have an empty entry"))
+			}
+			if len(yamlRateLimits) > 1 && yamlRateLimit.Unlimited {
+				panic(newRateLimitConfigError(config.Name, "unlimited is not supported in a list of rate limits"))
+			}
+			if len(yamlRateLimits) > 1 && yamlRateLimit.Block {
+				panic(newRateLimitConfigError(config.Name, "block is not supported in a list of rate limits"))
+			}
 
-			if unlimited {
-				if validUnit {
-					panic(newRateLimitConfigError(
-						config.Name,
-						"should not specify rate limit unit when unlimited"))
-				}
-			} else if !validUnit {
+			window := rateLimitWindowName(yamlRateLimit)
+			if windows[window] {
 				panic(newRateLimitConfigError(
-					config.Name,
-					fmt.Sprintf("invalid rate limit unit '%s'", descriptorConfig.RateLimit.Unit)))
+					config.Name, fmt.Sprintf("duplicate rate limit window '%s' in list of rate limits", window)))
 			}
+			windows[window] = true
 
-			replaces := make([]string, len(descriptorConfig.RateLimit.Replaces))
-			for i, e := range descriptorConfig.RateLimit.Replaces {
-				replaces[i] = e.Name
+			// The first limit keeps the keys of a descriptor with a single limit, so that further limits can be added
+			// without resetting its counters.
+			keySuffix := ""
+			if i > 0 {
+				keySuffix = "per_" + window
 			}
-
-			rateLimit = NewRateLimit(
-				descriptorConfig.RateLimit.RequestsPerUnit, pb.RateLimitResponse_RateLimit_Unit(value),
-				statsManager.NewStats(newParentKey), unlimited, descriptorConfig.ShadowMode,
-				descriptorConfig.RateLimit.Name, replaces, descriptorConfig.DetailedMetric,
-			)
-			rateLimitDebugString = fmt.Sprintf(
-				" ratelimit={requests_per_unit=%d, unit=%s, unlimited=%t, shadow_mode=%t}", rateLimit.Limit.RequestsPerUnit,
-				rateLimit.Limit.Unit.String(), rateLimit.Unlimited, rateLimit.ShadowMode)
-
-			for _, replaces := range descriptorConfig.RateLimit.Replaces {
-				if replaces.Name == "" {
-					panic(newRateLimitConfigError(config.Name, "should not have an empty replaces entry"))
+			statsKey := newParentKey
+			if keySuffix != "" {
+				statsKey += "." + keySuffix
+			}
+			limit := newRateLimitFromYaml(config, statsKey, descriptorConfig, yamlRateLimit, statsManager)
+			limit.KeySuffix = keySuffix
+			limit.DescriptorDepth = len(ancestors) + 1
+			limit.Cost = descriptorConfig.Cost
+			limit.FailureMode = descriptorFailureMode
+			if descriptorConfig.LimitFromStore {
+				if limit.Unlimited || limit.Blocked {
+					panic(newRateLimitConfigError(config.Name, "limit_from_store is not supported by unlimited and blocked limits"))
 				}
-				if replaces.Name == descriptorConfig.RateLimit.Name {
-					panic(newRateLimitConfigError(config.Name, "replaces should not contain name of same descriptor"))
+				limit.LimitFromStore = true
+			}
+			for _, ancestorKey := range yamlRateLimit.AlsoCountsAgainst {
+				if limit.Unlimited || limit.Blocked {
+					panic(newRateLimitConfigError(config.Name, "also_counts_against is not supported by unlimited and blocked limits"))
 				}
+				limit.AlsoCountsAgainst = append(limit.AlsoCountsAgainst, findAncestorLimit(config, ancestors, ancestorKey))
+			}
+			rateLimitDebugString += fmt.Sprintf(
+				" ratelimit={requests_per_unit=%d, unit=%s, unit_multiplier=%d, unlimited=%t, blocked=%t, shadow_mode=%t, algorithm=%s, cost=%d}", limit.Limit.RequestsPerUnit,
+				limit.Limit.Unit.String(), limit.UnitMultiplier, limit.Unlimited, limit.Blocked, limit.ShadowMode, limit.Algorithm, limit.Cost)
+
+			if rateLimit == nil {
+				rateLimit = limit
+			} else {
+				rateLimit.AdditionalLimits = append(rateLimit.AdditionalLimits, limit)
 			}
 		}
 
 		logger.Debugf(
 			"loading descriptor: key=%s%s", newParentKey, rateLimitDebugString)
-		newDescriptor := &rateLimitDescriptor{map[string]*rateLimitDescriptor{}, rateLimit, nil}
-		newDescriptor.loadDescriptors(config, newParentKey+".", descriptorConfig.Descriptors, statsManager)
-		this.descriptors[finalKey] = newDescriptor
+		newDescriptor := &rateLimitDescriptor{descriptors: map[string]*rateLimitDescriptor{}, limit: rateLimit}
+		newDescriptor.loadDescriptors(config, newParentKey+".", descriptorConfig.Descriptors, statsManager,
+			append(ancestors[:len(ancestors):len(ancestors)], ancestorLimit{descriptorConfig.Key, rateLimit}), descriptorFailureMode)
+
+		switch {
+		case len(descriptorConfig.Values) > 0:
+			if this.valueSetDescriptors == nil {
+				this.valueSetDescriptors = map[string]*rateLimitDescriptor{}
+			}
+			for _, value := range descriptorConfig.Values {
+				this.valueSetDescriptors[descriptorConfig.Key+"_"+value] = newDescriptor
+			}
+		case isAddressRange:
+			this.addCidrDescriptor(descriptorConfig.Key, addressRange, newDescriptor)
+		case valueRegex != nil:
+			this.regexDescriptors = append(this.regexDescriptors, &regexDescriptor{
+				key:        descriptorConfig.Key,
+				valueRegex: descriptorConfig.ValueRegex,
+				regex:      valueRegex,
+				descriptor: newDescriptor,
+			})
+		default:
+			this.descriptors[mapKey] = newDescriptor
 
-		// Preload keys ending with "*" symbol.
-		if finalKey[len(finalKey)-1:] == "*" {
-			this.wildcardKeys = append(this.wildcardKeys, finalKey)
+			// Preload keys ending with "*" symbol.
+			if mapKey[len(mapKey)-1:] == "*" {
+				this.wildcardKeys = append(this.wildcardKeys, mapKey)
+			}
 		}
 	}
 }
 
+// Create a rate limit from its YAML config and check the input.
+// @param config supplies the config file that owns the rate limit.
+// @param statsKey supplies the key of the stats of the rate limit.
+// @param descriptorConfig supplies the YAML descriptor owning the rate limit.
+// @param yamlRateLimit supplies the YAML rate limit to load.
+// @param statsManager that owns the stats.Scope.
+func newRateLimitFromYaml(config RateLimitConfigToLoad, statsKey string, descriptorConfig YamlDescriptor,
+	yamlRateLimit *YamlRateLimit, statsManager stats.Manager,
+) *RateLimit {
+	unlimited := yamlRateLimit.Unlimited
+
+	value, present := pb.RateLimitResponse_RateLimit_Unit_value[strings.ToUpper(yamlRateLimit.Unit)]
+	validUnit := present && value != int32(pb.RateLimitResponse_RateLimit_UNKNOWN)
+
+	if yamlRateLimit.Block {
+		if unlimited {
+			panic(newRateLimitConfigError(config.Name, "should not specify unlimited when blocked"))
+		}
+		if yamlRateLimit.RequestsPerUnit != 0 || yamlRateLimit.Algorithm != "" || yamlRateLimit.Burst != 0 ||
+			yamlRateLimit.UnitMultiplier != 0 || yamlRateLimit.CalendarAligned || yamlRateLimit.LeaseRatio != 0 {
+			panic(newRateLimitConfigError(
+				config.Name,
+				"should only specify the rate limit unit when blocked"))
+		}
+		// Blocked limits report a limit of 0 requests per unit, which defaults to a second.
+		if yamlRateLimit.Unit == "" {
+			value, validUnit = int32(pb.RateLimitResponse_RateLimit_SECOND), true
+		}
+	}
+
+	if unlimited {
+		if validUnit {
+			panic(newRateLimitConfigError(
+				config.Name,
+				"should not specify rate limit unit when unlimited"))
+		}
+	} else if !validUnit {
+		panic(newRateLimitConfigError(
+			config.Name,
+			fmt.Sprintf("invalid rate limit unit '%s'", yamlRateLimit.Unit)))
+	}
+
+	algorithm, validAlgorithm := parseRateLimitAlgorithm(yamlRateLimit.Algorithm)
+	if !validAlgorithm {
+		panic(newRateLimitConfigError(
+			config.Name,
+			fmt.Sprintf("invalid rate limit algorithm '%s'", yamlRateLimit.Algorithm)))
+	}
+
+	burst := yamlRateLimit.Burst
+	if algorithm == TokenBucket || algorithm == GCRA {
+		// Without an explicit burst the bucket holds one unit worth of tokens.
+		if burst == 0 {
+			burst = yamlRateLimit.RequestsPerUnit
+		}
+	} else if burst != 0 {
+		panic(newRateLimitConfigError(
+			config.Name,
+			"burst is only supported by the token_bucket and gcra algorithms"))
+	}
+
+	unitMultiplier := yamlRateLimit.UnitMultiplier
+	if unitMultiplier == 0 {
+		unitMultiplier = 1
+	} else if unlimited {
+		panic(newRateLimitConfigError(
+			config.Name,
+			"should not specify rate limit unit multiplier when unlimited"))
+	}
+
+	var calendarLocation *time.Location
+	if yamlRateLimit.CalendarAligned {
+		if unitMultiplier != 1 {
+			panic(newRateLimitConfigError(
+				config.Name,
+				"unit_multiplier is not supported by calendar aligned limits"))
+		}
+		if algorithm != FixedWindow && algorithm != SlidingWindow {
+			panic(newRateLimitConfigError(
+				config.Name,
+				"calendar_aligned is only supported by the fixed_window and sliding_window algorithms"))
+		}
+		// An empty timezone loads UTC.
+		location, err := time.LoadLocation(yamlRateLimit.Timezone)
+		if err != nil {
+			panic(newRateLimitConfigError(
+				config.Name,
+				fmt.Sprintf("invalid timezone '%s'", yamlRateLimit.Timezone)))
+		}
+		calendarLocation = location
+	} else if yamlRateLimit.Timezone != "" {
+		panic(newRateLimitConfigError(
+			config.Name,
+			"timezone is only supported by calendar aligned limits"))
+	}
+
+	if yamlRateLimit.LeaseRatio != 0 {
+		if !(yamlRateLimit.LeaseRatio > 0 && yamlRateLimit.LeaseRatio <= 1) {
+			panic(newRateLimitConfigError(
+				config.Name,
+				fmt.Sprintf("invalid lease_ratio '%g', should be above 0 and at most 1", yamlRateLimit.LeaseRatio)))
+		}
+		if unlimited || algorithm != FixedWindow {
+			panic(newRateLimitConfigError(
+				config.Name,
+				"lease_ratio is only supported by the fixed_window algorithm"))
+		}
+	}
+
+	replaces := make([]string, len(yamlRateLimit.Replaces))
+	for i, e := range yamlRateLimit.Replaces {
+		replaces[i] = e.Name
+	}
+
+	rateLimit := NewRateLimit(
+		yamlRateLimit.RequestsPerUnit, pb.RateLimitResponse_RateLimit_Unit(value),
+		statsManager.NewStats(statsKey), unlimited, descriptorConfig.ShadowMode,
+		yamlRateLimit.Name, replaces, descriptorConfig.DetailedMetric,
+	)
+	rateLimit.Blocked = yamlRateLimit.Block
+	rateLimit.Algorithm = algorithm
+	rateLimit.Burst = burst
+	rateLimit.UnitMultiplier = unitMultiplier
+	rateLimit.CalendarLocation = calendarLocation
+	rateLimit.LeaseRatio = yamlRateLimit.LeaseRatio
+
+	for _, replaces := range yamlRateLimit.Replaces {
+		if replaces.Name == "" {
+			panic(newRateLimitConfigError(config.Name, "should not have an empty replaces entry"))
+		}
+		if replaces.Name == yamlRateLimit.Name {
+			panic(newRateLimitConfigError(config.Name, "replaces should not contain name of same descriptor"))
+		}
+	}
+
+	return rateLimit
+}
+
+// Find the limit of the closest ancestor descriptor with a key.
+// @param config supplies the config file that owns the descriptor.
+// @param ancestors supplies the limits of the ancestors of the descriptor, starting at the root.
+// @param key supplies the key of the ancestor.
+// @return the limit of the ancestor.
+// @throws RateLimitConfigError if no ancestor with the key has a limit.
+func findAncestorLimit(config RateLimitConfigToLoad, ancestors []ancestorLimit, key string) *RateLimit {
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		if ancestors[i].key == key && ancestors[i].limit != nil && !ancestors[i].limit.Unlimited && !ancestors[i].limit.Blocked {
+			return ancestors[i].limit
+		}
+	}
+	panic(newRateLimitConfigError(
+		config.Name, fmt.Sprintf("also_counts_against '%s' is not an ancestor descriptor with a limit", key)))
+}
+
+// Name the window of a rate limit in a list of rate limits, e.g. "hour" or "15_minute".
+func rateLimitWindowName(yamlRateLimit *YamlRateLimit) string {
+	window := strings.ToLower(yamlRateLimit.Unit)
+	if yamlRateLimit.UnitMultiplier > 1 {
+		window = fmt.Sprintf("%d_%s", yamlRateLimit.UnitMultiplier, window)
+	}
+	return window
+}
+
 // Validate a YAML config file's keys.
 // @param config specifies the file contents to load.
 // @param any specifies the yaml file and a map.
@@ -213,6 +673,9 @@ func validateYamlKeys(fileName string, config_map map[interface{}]interface{}) {
 		}
 		switch v := v.(type) {
 		case []interface{}:
+			if key, ok := k.(string); ok && leafListKeys[key] {
+				continue
+			}
 			for _, e := range v {
 				if _, ok := e.(map[interface{}]interface{}); !ok {
 					errorText := fmt.Sprintf("config error, yaml file contains list of type other than map: %v", e)
@@ -230,6 +693,8 @@ func validateYamlKeys(fileName string, config_map map[interface{}]interface{}) {
 		case int:
 		// bool is a leaf type in ratelimit config. No need to keep validating.
 		case bool:
+		// float64 is a leaf type in ratelimit config. No need to keep validating.
+		case float64:
 		// nil case is an incorrectly formed yaml. However, because this function's purpose is to validate
 		// the yaml's keys we don't panic here.
 		case nil:
@@ -244,6 +709,10 @@ func validateYamlKeys(fileName string, config_map map[interface{}]interface{}) {
 // Load a single YAML config into the global config.
 // @param config specifies the yamlRoot struct to load.
 func (this *rateLimitConfigImpl) loadConfig(config RateLimitConfigToLoad) {
+	if config.ConfigYaml == nil {
+		this.loadOverrides(config)
+		return
+	}
 	root := config.ConfigYaml
 
 	if root.Domain == "" {
@@ -257,13 +726,15 @@ func (this *rateLimitConfigImpl) loadConfig(config RateLimitConfigToLoad) {
 		}
 
 		logger.Debugf("patching domain: %s", root.Domain)
-		this.domains[root.Domain].loadDescriptors(config, root.Domain+".", root.Descriptors, this.statsManager)
+		this.domains[root.Domain].loadDescriptors(config, root.Domain+".", root.Descriptors, this.statsManager, nil,
+			parseFailureMode(config, root.FailureMode))
 		return
 	}
 
 	logger.Debugf("loading domain: %s", root.Domain)
-	newDomain := &rateLimitDomain{rateLimitDescriptor{map[string]*rateLimitDescriptor{}, nil, nil}}
-	newDomain.loadDescriptors(config, root.Domain+".", root.Descriptors, this.statsManager)
+	newDomain := &rateLimitDomain{rateLimitDescriptor{descriptors: map[string]*rateLimitDescriptor{}}}
+	newDomain.loadDescriptors(config, root.Domain+".", root.Descriptors, this.statsManager, nil,
+		parseFailureMode(config, root.FailureMode))
 	this.domains[root.Domain] = newDomain
 }
 
@@ -272,6 +743,10 @@ func (this *rateLimitConfigImpl) Dump() string {
 	for _, domain := range this.domains {
 		ret += domain.dump()
 	}
+	for key, override := range this.overrides {
+		ret += fmt.Sprintf("%s: override unit=%s requests_per_unit=%d\n", key, override.Unit.String(),
+			override.RequestsPerUnit)
+	}
 
 	return ret
 }
@@ -316,7 +791,7 @@ func (this *rateLimitConfigImpl) GetLimit(
 	for i, entry := range descriptor.Entries {
 		// First see if key_value is in the map. If that isn't in the map we look for just key
 		// to check for a default value.
-		finalKey := entry.Key + "_" + entry.Value
+		finalKey := entry.Key + "_" + normalizeDescriptorValue(entry.Key, entry.Value)
 
 		detailedMetricFullKey.WriteString(".")
 		detailedMetricFullKey.WriteString(finalKey)
@@ -324,6 +799,16 @@ func (this *rateLimitConfigImpl) GetLimit(
 		logger.Debugf("looking up key: %s", finalKey)
 		nextDescriptor := descriptorsMap[finalKey]
 
+		// Exact values take precedence over sets of values, CIDR ranges, prefixes, regexes and defaults, in this
+		// order. The most specific CIDR range containing an address is matched.
+		if nextDescriptor == nil {
+			nextDescriptor = prevDescriptor.valueSetDescriptors[finalKey]
+		}
+
+		if nextDescriptor == nil {
+			nextDescriptor = prevDescriptor.matchCidr(entry.Key, entry.Value)
+		}
+
 		if nextDescriptor == nil && len(prevDescriptor.wildcardKeys) > 0 {
 			for _, wildcardKey := range prevDescriptor.wildcardKeys {
 				if strings.HasPrefix(finalKey, strings.TrimSuffix(wildcardKey, "*")) {
@@ -333,6 +818,10 @@ func (this *rateLimitConfigImpl) GetLimit(
 			}
 		}
 
+		if nextDescriptor == nil {
+			nextDescriptor = prevDescriptor.matchValueRegex(entry.Key, entry.Value)
+		}
+
 		if nextDescriptor == nil {
 			finalKey = entry.Key
 			logger.Debugf("looking up key: %s", finalKey)
@@ -349,27 +838,62 @@ func (this *rateLimitConfigImpl) GetLimit(
 			}
 		}
 
-		if nextDescriptor != nil && len(nextDescriptor.descriptors) > 0 {
+		if nextDescriptor != nil && nextDescriptor.hasDescriptors() {
 			logger.Debugf("iterating to next level")
 			descriptorsMap = nextDescriptor.descriptors
 		} else {
-			if rateLimit != nil && rateLimit.DetailedMetric {
-				rateLimit = NewRateLimit(rateLimit.Limit.RequestsPerUnit, rateLimit.Limit.Unit, this.statsManager.NewStats(rateLimit.FullKey), rateLimit.Unlimited, rateLimit.ShadowMode, rateLimit.Name, rateLimit.Replaces, rateLimit.DetailedMetric)
-			}
-
 			break
 		}
 		prevDescriptor = nextDescriptor
 	}
 
+	// Overrides of descriptor values take precedence over the limit of the descriptor, and keep all its other settings.
+	// Their stats are kept under the key of the whole descriptor.
+	if rateLimit != nil {
+		overrideKey := descriptorKey(domain, descriptor)
+		if override := this.overrides[overrideKey]; override != nil {
+			logger.Debugf("found override: %s", overrideKey)
+			return this.newDetailedRateLimit(rateLimit, overrideKey).withLimit(override.RequestsPerUnit, override.Unit)
+		}
+	}
+
 	// Replace metric with detailed metric, if leaf descriptor is detailed.
 	if rateLimit != nil && rateLimit.DetailedMetric {
-		rateLimit.Stats = this.statsManager.NewStats(detailedMetricFullKey.String())
+		rateLimit = this.newDetailedRateLimit(rateLimit, detailedMetricFullKey.String())
 	}
 
 	return rateLimit
 }
 
+// Copy a rate limit with the stats of the detailed metric of a descriptor.
+// @param rateLimit supplies the configured rate limit.
+// @param statsKey supplies the key of the detailed metric.
+// @return the rate limit with the detailed stats, including its additional limits.
+func (this *rateLimitConfigImpl) newDetailedRateLimit(rateLimit *RateLimit, statsKey string) *RateLimit {
+	if rateLimit.KeySuffix != "" {
+		statsKey += "." + rateLimit.KeySuffix
+	}
+	detailedRateLimit := NewRateLimit(rateLimit.Limit.RequestsPerUnit, rateLimit.Limit.Unit, this.statsManager.NewStats(statsKey), rateLimit.Unlimited, rateLimit.ShadowMode, rateLimit.Name, rateLimit.Replaces, rateLimit.DetailedMetric)
+	detailedRateLimit.FullKey = rateLimit.FullKey
+	detailedRateLimit.Algorithm = rateLimit.Algorithm
+	detailedRateLimit.Burst = rateLimit.Burst
+	detailedRateLimit.UnitMultiplier = rateLimit.UnitMultiplier
+	detailedRateLimit.CalendarLocation = rateLimit.CalendarLocation
+	detailedRateLimit.KeySuffix = rateLimit.KeySuffix
+	detailedRateLimit.AlsoCountsAgainst = rateLimit.AlsoCountsAgainst
+	detailedRateLimit.DescriptorDepth = rateLimit.DescriptorDepth
+	detailedRateLimit.Cost = rateLimit.Cost
+	detailedRateLimit.Blocked = rateLimit.Blocked
+	detailedRateLimit.LimitFromStore = rateLimit.LimitFromStore
+	detailedRateLimit.LeaseRatio = rateLimit.LeaseRatio
+	detailedRateLimit.FailureMode = rateLimit.FailureMode
+	for _, additionalLimit := range rateLimit.AdditionalLimits {
+		detailedRateLimit.AdditionalLimits = append(detailedRateLimit.AdditionalLimits,
+			this.newDetailedRateLimit(additionalLimit, statsKey))
+	}
+	return detailedRateLimit
+}
+
 func (this *rateLimitConfigImpl) IsEmptyDomains() bool {
 	return len(this.domains) == 0
 }
@@ -421,7 +945,7 @@ func ConfigFileContentToYaml(fileName, content string) *YamlRoot {
 func NewRateLimitConfigImpl(
 	configs []RateLimitConfigToLoad, statsManager stats.Manager, mergeDomainConfigs bool,
 ) RateLimitConfig {
-	ret := &rateLimitConfigImpl{map[string]*rateLimitDomain{}, statsManager, mergeDomainConfigs}
+	ret := &rateLimitConfigImpl{map[string]*rateLimitDomain{}, statsManager, mergeDomainConfigs, map[string]*pb.RateLimitResponse_RateLimit{}}
 	for _, config := range configs {
 		ret.loadConfig(config)
 	}
@@ -429,15 +953,51 @@ func NewRateLimitConfigImpl(
 	return ret
 }
 
-type rateLimitConfigLoaderImpl struct{}
+// Algorithms which the backends cannot apply, by BACKEND_TYPE. Memcache has no atomic scripting for token buckets and
+// GCRA.
+var unsupportedAlgorithms = map[string][]RateLimitAlgorithm{
+	"memcache": {TokenBucket, GCRA},
+}
+
+// Reject the rate limits using an algorithm which the backend cannot apply.
+// @param config supplies the config file that owns the descriptors.
+// @param descriptors supplies the YAML descriptors to check, along with their nested descriptors.
+// @param backendType supplies the BACKEND_TYPE of the backend.
+// @throws RateLimitConfigError if a rate limit uses an unsupported algorithm.
+func checkAlgorithms(config RateLimitConfigToLoad, descriptors []YamlDescriptor, backendType string) {
+	for _, descriptorConfig := range descriptors {
+		for _, yamlRateLimit := range append([]*YamlRateLimit{descriptorConfig.RateLimit}, descriptorConfig.RateLimits...) {
+			if yamlRateLimit == nil {
+				continue
+			}
+			// Invalid algorithms are rejected when the rate limit is loaded.
+			algorithm, _ := parseRateLimitAlgorithm(yamlRateLimit.Algorithm)
+			if slices.Contains(unsupportedAlgorithms[backendType], algorithm) {
+				panic(newRateLimitConfigError(config.Name, fmt.Sprintf(
+					"rate limit algorithm '%s' is not supported by the %s backend", algorithm, backendType)))
+			}
+		}
+		checkAlgorithms(config, descriptorConfig.Descriptors, backendType)
+	}
+}
+
+type rateLimitConfigLoaderImpl struct {
+	backendType string
+}
 
 func (this *rateLimitConfigLoaderImpl) Load(
 	configs []RateLimitConfigToLoad, statsManager stats.Manager, mergeDomainConfigs bool,
 ) RateLimitConfig {
+	for _, config := range configs {
+		if config.ConfigYaml != nil {
+			checkAlgorithms(config, config.ConfigYaml.Descriptors, this.backendType)
+		}
+	}
 	return NewRateLimitConfigImpl(configs, statsManager, mergeDomainConfigs)
 }
 
+// @param backendType supplies the BACKEND_TYPE of the backend the configs are loaded for.
 // @return a new default config loader implementation.
-func NewRateLimitConfigLoaderImpl() RateLimitConfigLoader {
-	return &rateLimitConfigLoaderImpl{}
+func NewRateLimitConfigLoaderImpl(backendType string) RateLimitConfigLoader {
+	return &rateLimitConfigLoaderImpl{backendType: backendType}
 }
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+)
+
+// Limit of a single descriptor value, which overrides the limit configured for the descriptor.
+type RateLimitOverride struct {
+	Domain string `json:"domain"`
+	// Entries of the descriptor before the overridden value, as in stats keys, e.g. "tenant" or "org_acme.tenant".
+	Descriptor      string `json:"descriptor"`
+	Value           string `json:"value"`
+	Unit            string `json:"unit"`
+	RequestsPerUnit uint32 `json:"requests_per_unit"`
+}
+
+var overridesCsvColumns = []string{"domain", "descriptor", "value", "unit", "requests_per_unit"}
+
+// Check whether a runtime file contains limit overrides rather than a YAML config.
+// @param fileName specifies the name of the file.
+func IsOverridesFile(fileName string) bool {
+	return strings.HasSuffix(fileName, ".overrides.csv") || strings.HasSuffix(fileName, ".overrides.json")
+}
+
+// ConfigFileContentToOverrides converts the content of an overrides file into a list of overrides. CSV files need a
+// header naming their columns, JSON files contain a list of objects.
+// @param fileName specifies the name of the file.
+// @param content specifies the string content of the file.
+func ConfigFileContentToOverrides(fileName, content string) []RateLimitOverride {
+	if strings.HasSuffix(fileName, ".json") {
+		var overrides []RateLimitOverride
+		if err := json.Unmarshal([]byte(content), &overrides); err != nil {
+			errorText := fmt.Sprintf("error loading overrides file: %s", err.Error())
+			logger.Debugf(errorText)
+			panic(newRateLimitConfigError(fileName, errorText))
+		}
+		return overrides
+	}
+
+	reader := csv.NewReader(strings.NewReader(content))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = len(overridesCsvColumns)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		panic(newRateLimitConfigError(fileName, fmt.Sprintf("error loading overrides file: %s", err.Error())))
+	}
+	columns := map[string]int{}
+	for i, column := range header {
+		columns[strings.TrimSpace(column)] = i
+	}
+	for _, column := range overridesCsvColumns {
+		if _, present := columns[column]; !present {
+			panic(newRateLimitConfigError(fileName, fmt.Sprintf("overrides file has no '%s' column", column)))
+		}
+	}
+
+	var overrides []RateLimitOverride
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return overrides
+		}
+		if err != nil {
+			panic(newRateLimitConfigError(fileName, fmt.Sprintf("error loading overrides file: %s", err.Error())))
+		}
+
+		requestsPerUnit, err := strconv.ParseUint(record[columns["requests_per_unit"]], 10, 32)
+		if err != nil {
+			line, _ := reader.FieldPos(0)
+			panic(newRateLimitConfigError(
+				fileName,
+				fmt.Sprintf("invalid requests_per_unit '%s' on line %d", record[columns["requests_per_unit"]], line)))
+		}
+		overrides = append(overrides, RateLimitOverride{
+			Domain:          record[columns["domain"]],
+			Descriptor:      record[columns["descriptor"]],
+			Value:           record[columns["value"]],
+			Unit:            record[columns["unit"]],
+			RequestsPerUnit: uint32(requestsPerUnit),
+		})
+	}
+}
+
+// Load the overrides of a file into the config.
+// @param config specifies the overrides file to load.
+func (this *rateLimitConfigImpl) loadOverrides(config RateLimitConfigToLoad) {
+	for _, override := range config.Overrides {
+		if override.Domain == "" || override.Descriptor == "" {
+			panic(newRateLimitConfigError(config.Name, "override should have a domain and a descriptor"))
+		}
+
+		value, present := pb.RateLimitResponse_RateLimit_Unit_value[strings.ToUpper(override.Unit)]
+		if !present || value == int32(pb.RateLimitResponse_RateLimit_UNKNOWN) {
+			panic(newRateLimitConfigError(
+				config.Name,
+				fmt.Sprintf("invalid rate limit unit '%s'", override.Unit)))
+		}
+
+		// Overrides are looked up by the key of the whole descriptor.
+		key := override.Domain + "." + override.Descriptor
+		if override.Value != "" {
+			key += "_" + override.Value
+		}
+		if _, present := this.overrides[key]; present {
+			panic(newRateLimitConfigError(config.Name, fmt.Sprintf("duplicate override '%s'", key)))
+		}
+
+		logger.Debugf("loading override: key=%s requests_per_unit=%d unit=%s", key, override.RequestsPerUnit, override.Unit)
+		this.overrides[key] = &pb.RateLimitResponse_RateLimit{
+			RequestsPerUnit: override.RequestsPerUnit,
+			Unit:            pb.RateLimitResponse_RateLimit_Unit(value),
+		}
+	}
+}
@@ -1,44 +1,134 @@
 package config
 
 import (
+	"fmt"
+	"strconv"
+
 	rls_conf_v3 "github.com/envoyproxy/go-control-plane/ratelimit/config/ratelimit/v3"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Fields of api/ratelimit/config/ratelimit/v3/rls_conf.proto which the messages generated in go-control-plane do not
+// have. Decoding the resources keeps them as unknown fields of the messages, which they are read from.
+const (
+	rateLimitDescriptorRateLimitsField protowire.Number = 7
+	rateLimitDescriptorValuesField     protowire.Number = 8
+	rateLimitDescriptorValueRegexField protowire.Number = 9
+	rateLimitPolicyAlgorithmField      protowire.Number = 6
+	rateLimitPolicyUnitMultiplierField protowire.Number = 7
 )
 
+// A field of a message which is a varint or length delimited.
+type unknownField struct {
+	number protowire.Number
+	varint uint64
+	bytes  []byte
+}
+
+// @param name supplies the name of the configuration the message is part of.
+// @param message supplies the message.
+// @return the unknown varint and length delimited fields of the message, in their order.
+// @throws RateLimitConfigError if the unknown fields cannot be parsed.
+func unknownFields(name string, message proto.Message) []unknownField {
+	var fields []unknownField
+	b := message.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		number, wireType, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			panic(newRateLimitConfigError(name, fmt.Sprintf("invalid xDS config: %s", protowire.ParseError(n))))
+		}
+		b = b[n:]
+
+		field := unknownField{number: number}
+		switch wireType {
+		case protowire.VarintType:
+			field.varint, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(number, wireType, b)
+		}
+		if n < 0 {
+			panic(newRateLimitConfigError(name, fmt.Sprintf("invalid xDS config: %s", protowire.ParseError(n))))
+		}
+		b = b[n:]
+		if wireType == protowire.VarintType || wireType == protowire.BytesType {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
 // ConfigXdsProtoToYaml converts Xds Proto format to yamlRoot
 func ConfigXdsProtoToYaml(xdsProto *rls_conf_v3.RateLimitConfig) *YamlRoot {
 	return &YamlRoot{
 		Domain:      xdsProto.Domain,
-		Descriptors: rateLimitDescriptorsPbToYaml(xdsProto.Descriptors),
+		Descriptors: rateLimitDescriptorsPbToYaml(xdsProto.Name, xdsProto.Descriptors),
 	}
 }
 
-func rateLimitDescriptorsPbToYaml(pb []*rls_conf_v3.RateLimitDescriptor) []YamlDescriptor {
+func rateLimitDescriptorsPbToYaml(name string, pb []*rls_conf_v3.RateLimitDescriptor) []YamlDescriptor {
 	descriptors := make([]YamlDescriptor, len(pb))
 	for i, d := range pb {
 		descriptors[i] = YamlDescriptor{
 			Key:            d.Key,
 			Value:          d.Value,
-			RateLimit:      rateLimitPolicyPbToYaml(d.RateLimit),
-			Descriptors:    rateLimitDescriptorsPbToYaml(d.Descriptors),
+			RateLimit:      rateLimitPolicyPbToYaml(name, d.RateLimit),
+			Descriptors:    rateLimitDescriptorsPbToYaml(name, d.Descriptors),
 			ShadowMode:     d.ShadowMode,
 			DetailedMetric: d.DetailedMetric,
 		}
+		for _, field := range unknownFields(name, d) {
+			switch field.number {
+			case rateLimitDescriptorRateLimitsField:
+				// Like a list of rate limits in YAML, the rate_limits are checked along with the rate_limit.
+				policy := &rls_conf_v3.RateLimitPolicy{}
+				if err := proto.Unmarshal(field.bytes, policy); err != nil {
+					panic(newRateLimitConfigError(name, fmt.Sprintf("invalid xDS config: %s", err)))
+				}
+				descriptors[i].RateLimits = append(descriptors[i].RateLimits, rateLimitPolicyPbToYaml(name, policy))
+			case rateLimitDescriptorValuesField:
+				descriptors[i].Values = append(descriptors[i].Values, string(field.bytes))
+			case rateLimitDescriptorValueRegexField:
+				// Compiled when the config is loaded, like the value_regex of YAML descriptors.
+				descriptors[i].ValueRegex = string(field.bytes)
+			}
+		}
 	}
 
 	return descriptors
 }
 
-func rateLimitPolicyPbToYaml(pb *rls_conf_v3.RateLimitPolicy) *YamlRateLimit {
+func rateLimitPolicyPbToYaml(name string, pb *rls_conf_v3.RateLimitPolicy) *YamlRateLimit {
 	if pb == nil {
 		return nil
 	}
-	return &YamlRateLimit{
+	rateLimit := &YamlRateLimit{
 		RequestsPerUnit: pb.RequestsPerUnit,
 		Unit:            pb.Unit.String(),
 		Unlimited:       pb.Unlimited,
 		Name:            pb.Name,
 		Replaces:        rateLimitReplacesPbToYaml(pb.Replaces),
 	}
+	for _, field := range unknownFields(name, pb) {
+		switch field.number {
+		case rateLimitPolicyAlgorithmField:
+			rateLimit.Algorithm = rateLimitAlgorithmPbToYaml(field.varint)
+		case rateLimitPolicyUnitMultiplierField:
+			rateLimit.UnitMultiplier = uint32(field.varint)
+		}
+	}
+	return rateLimit
+}
+
+// The values of the RateLimitAlgorithm enum are those of RateLimitAlgorithm. Unknown values are passed on by number,
+// so that loading the config rejects them.
+func rateLimitAlgorithmPbToYaml(pb uint64) string {
+	if name, ok := rateLimitAlgorithmNames[RateLimitAlgorithm(pb)]; ok {
+		return name
+	}
+	return strconv.FormatUint(pb, 10)
 }
 
 func rateLimitReplacesPbToYaml(pb []*rls_conf_v3.RateLimitReplace) []yamlReplaces {
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"strings"
+)
+
+// Handling of the hits of a limit while the cache is unavailable.
+type FailureMode int
+
+const (
+	// Unset failure mode, which defaults to the failure mode of the service.
+	FailureModeDefault FailureMode = iota
+	// Fail the request with an error, leaving the decision to the client, e.g. the failure_mode_deny of envoy.
+	FailureModeError
+	// Allow the hits (fail-open).
+	FailureModeAllow
+	// Reject the hits (fail-closed).
+	FailureModeDeny
+	// Count the hits against a share of the limit in memory.
+	FailureModeLocal
+)
+
+var failureModeNames = map[FailureMode]string{
+	FailureModeError: "error",
+	FailureModeAllow: "allow",
+	FailureModeDeny:  "deny",
+	FailureModeLocal: "local",
+}
+
+func (this FailureMode) String() string {
+	if this == FailureModeDefault {
+		return "default"
+	}
+	return failureModeNames[this]
+}
+
+// Parse a failure mode. An empty name is the default failure mode.
+// @param name supplies the failure mode name from the config.
+// @return the failure mode and whether the name was valid.
+func ParseFailureMode(name string) (FailureMode, bool) {
+	if name == "" {
+		return FailureModeDefault, true
+	}
+	for value, valueName := range failureModeNames {
+		if strings.EqualFold(valueName, name) {
+			return value, true
+		}
+	}
+	return FailureModeDefault, false
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+)
+
+// Limit of a value of a limit_from_store descriptor, e.g. the limit of the tier of an API key managed by a billing
+// system.
+type StoredLimit struct {
+	RequestsPerUnit uint32
+	// Unit of the limit, or UNKNOWN to keep the unit of the configured limit.
+	Unit pb.RateLimitResponse_RateLimit_Unit
+}
+
+// Interface for a store of the limits of the values of limit_from_store descriptors.
+type LimitStore interface {
+	// Get the stored limit of a value.
+	// @param value supplies the value of the descriptor entry.
+	// @return the limit or nil if the value has no stored limit, in which case the configured limit applies.
+	Get(value string) *StoredLimit
+}
+
+// Parse a stored limit, which is either a number of requests per unit of the configured limit, e.g. "100", or a
+// number of requests per unit, e.g. "100/minute".
+// @param value supplies the stored value.
+// @return the limit or an error if the value is not a valid limit.
+func ParseStoredLimit(value string) (*StoredLimit, error) {
+	requestsPerUnit, unitName, hasUnit := strings.Cut(strings.TrimSpace(value), "/")
+	parsed, err := strconv.ParseUint(requestsPerUnit, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid requests per unit '%s'", requestsPerUnit)
+	}
+	limit := &StoredLimit{RequestsPerUnit: uint32(parsed)}
+	if hasUnit {
+		unit, present := pb.RateLimitResponse_RateLimit_Unit_value[strings.ToUpper(unitName)]
+		if !present || unit == int32(pb.RateLimitResponse_RateLimit_UNKNOWN) {
+			return nil, fmt.Errorf("invalid rate limit unit '%s'", unitName)
+		}
+		limit.Unit = pb.RateLimitResponse_RateLimit_Unit(unit)
+	}
+	return limit, nil
+}
+
+// Copy a limit with a stored limit. The limit keeps its stats and its cache keys, which already differ per value of
+// the descriptor.
+// @param stored supplies the stored limit of the value of the descriptor.
+// @return the limit of the value.
+func (this *RateLimit) WithStoredLimit(stored *StoredLimit) *RateLimit {
+	unit := stored.Unit
+	if unit == pb.RateLimitResponse_RateLimit_UNKNOWN {
+		unit = this.Limit.Unit
+	}
+	return this.withLimit(stored.RequestsPerUnit, unit)
+}
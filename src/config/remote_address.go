@@ -0,0 +1,24 @@
+package config
+
+import (
+	"net/netip"
+)
+
+// Key of the descriptor entries holding the address of the client, as set by the remote_address action of envoy.
+const remoteAddressKey = "remote_address"
+
+// Normalize the value of a descriptor entry. Addresses of remote_address entries are converted to their canonical
+// form, so that e.g. 2001:DB8:0::1 and ::ffff:10.0.0.1 match the values 2001:db8::1 and 10.0.0.1.
+// @param key supplies the key of the entry.
+// @param value supplies the value of the entry.
+// @return the value to look up.
+func normalizeDescriptorValue(key string, value string) string {
+	if key != remoteAddressKey {
+		return value
+	}
+	address, err := netip.ParseAddr(value)
+	if err != nil {
+		return value
+	}
+	return address.Unmap().String()
+}
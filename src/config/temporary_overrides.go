@@ -0,0 +1,87 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+)
+
+// Limit which temporarily replaces the requests per unit and the unit of a configured limit, e.g. to tighten or
+// loosen a limit during an incident without pushing a config.
+type TemporaryOverride struct {
+	// Key of the overridden limit, as in its stats, e.g. "domain.tenant_acme" or "domain.tenant.per_hour".
+	Key             string `json:"key"`
+	Unit            string `json:"unit"`
+	RequestsPerUnit uint32 `json:"requests_per_unit"`
+	// Unix time in seconds the override expires at.
+	ExpiresAt int64 `json:"expires_at"`
+}
+
+// Interface for a store of temporary overrides, which is shared by all instances of the service.
+type TemporaryOverrideStore interface {
+	// Get the active override of a limit.
+	// @param key supplies the key of the limit.
+	// @return the override of the limit or nil if the limit is not overridden.
+	Get(key string) *TemporaryOverride
+
+	// @return the active overrides.
+	List() []*TemporaryOverride
+
+	// Create or replace the override of a limit until it expires.
+	// @param override supplies the override.
+	Set(override *TemporaryOverride) error
+
+	// Delete the override of a limit.
+	// @param key supplies the key of the limit.
+	Delete(key string) error
+}
+
+// Check that an override has a key, a valid unit and a positive expiry.
+func (this *TemporaryOverride) Validate() error {
+	if this.Key == "" {
+		return fmt.Errorf("override should have a key")
+	}
+	if _, valid := this.unit(); !valid {
+		return fmt.Errorf("invalid rate limit unit '%s'", this.Unit)
+	}
+	if this.ExpiresAt <= 0 {
+		return fmt.Errorf("override should expire")
+	}
+	return nil
+}
+
+func (this *TemporaryOverride) unit() (pb.RateLimitResponse_RateLimit_Unit, bool) {
+	value, present := pb.RateLimitResponse_RateLimit_Unit_value[strings.ToUpper(this.Unit)]
+	return pb.RateLimitResponse_RateLimit_Unit(value), present && value != int32(pb.RateLimitResponse_RateLimit_UNKNOWN)
+}
+
+// Copy a limit with the requests per unit and the unit of a temporary override. The limit keeps its stats and its
+// cache keys, so hits counted before the override still count against it. The burst of token bucket and GCRA limits
+// keeps its ratio to the requests per unit.
+// @param override supplies a valid override of the limit.
+// @return the overridden limit.
+func (this *RateLimit) WithTemporaryOverride(override *TemporaryOverride) *RateLimit {
+	unit, _ := override.unit()
+	return this.withLimit(override.RequestsPerUnit, unit)
+}
+
+// Copy a limit with another number of requests per unit and unit.
+// @param requestsPerUnit supplies the requests per unit of the copy.
+// @param unit supplies the unit of the copy.
+// @return the copy of the limit.
+func (this *RateLimit) withLimit(requestsPerUnit uint32, unit pb.RateLimitResponse_RateLimit_Unit) *RateLimit {
+	overridden := *this
+	overridden.Limit = &pb.RateLimitResponse_RateLimit{
+		Name:            this.Limit.Name,
+		RequestsPerUnit: requestsPerUnit,
+		Unit:            unit,
+	}
+	if unit != this.Limit.Unit {
+		overridden.UnitMultiplier = 1
+	}
+	if this.Burst != 0 && this.Limit.RequestsPerUnit != 0 {
+		overridden.Burst = uint32(max(uint64(this.Burst)*uint64(requestsPerUnit)/uint64(this.Limit.RequestsPerUnit), 1))
+	}
+	return &overridden
+}
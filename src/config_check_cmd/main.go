@@ -22,8 +22,9 @@ func loadConfigs(allConfigs []config.RateLimitConfigToLoad, mergeDomainConfigs b
 			os.Exit(1)
 		}
 	}()
-	statsManager := stats.NewStatManager(gostats.NewStore(gostats.NewNullSink(), false), settings.NewSettings())
-	config.NewRateLimitConfigImpl(allConfigs, statsManager, mergeDomainConfigs)
+	s := settings.NewSettings()
+	statsManager := stats.NewStatManager(gostats.NewStore(gostats.NewNullSink(), false), s)
+	config.NewRateLimitConfigLoaderImpl(s.BackendType).Load(allConfigs, statsManager, mergeDomainConfigs)
 }
 
 func main() {
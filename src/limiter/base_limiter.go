@@ -3,10 +3,12 @@ package limiter
 import (
 	"math"
 	"math/rand"
+	"sync"
 
 	"github.com/coocood/freecache"
 	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	logger "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/envoyproxy/ratelimit/src/assert"
 	"github.com/envoyproxy/ratelimit/src/config"
@@ -22,6 +24,8 @@ type BaseRateLimiter struct {
 	localCache                 *freecache.Cache
 	nearLimitRatio             float32
 	StatsManager               stats.Manager
+	// Serializes the updates of leases in the local cache.
+	leaseLock sync.Mutex
 }
 
 type LimitInfo struct {
@@ -45,6 +49,20 @@ func NewRateLimitInfo(limit *config.RateLimit, limitBeforeIncrease uint64, limit
 // domain, descriptor and current timestamp.
 func (this *BaseRateLimiter) GenerateCacheKeys(request *pb.RateLimitRequest,
 	limits []*config.RateLimit, hitsAddends []uint64,
+) []CacheKey {
+	cacheKeys := this.GenerateCacheKeysWithoutHits(request, limits)
+	for i := 0; i < len(request.Descriptors); i++ {
+		// Increase statistics for limits hit by their respective requests.
+		if limits[i] != nil {
+			limits[i].Stats.TotalHits.Add(hitsAddends[i])
+		}
+	}
+	return cacheKeys
+}
+
+// Generates the cache keys of a request without counting its hits, e.g. to release the slots of concurrency limits.
+func (this *BaseRateLimiter) GenerateCacheKeysWithoutHits(request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
 ) []CacheKey {
 	assert.Assert(len(request.Descriptors) == len(limits))
 	cacheKeys := make([]CacheKey, len(request.Descriptors))
@@ -53,10 +71,6 @@ func (this *BaseRateLimiter) GenerateCacheKeys(request *pb.RateLimitRequest,
 		// generateCacheKey() returns an empty string in the key if there is no limit
 		// so that we can keep the arrays all the same size.
 		cacheKeys[i] = this.cacheKeyGenerator.GenerateCacheKey(request.Domain, request.Descriptors[i], limits[i], now)
-		// Increase statistics for limits hit by their respective requests.
-		if limits[i] != nil {
-			limits[i].Stats.TotalHits.Add(hitsAddends[i])
-		}
 	}
 	return cacheKeys
 }
@@ -73,6 +87,63 @@ func (this *BaseRateLimiter) IsOverLimitWithLocalCache(key string) bool {
 	return false
 }
 
+// Returns the number of tokens of a token bucket or GCRA limit, or the number of slots of a concurrency limit.
+func scriptedCapacity(limit *config.RateLimit) uint64 {
+	if limit.Algorithm == config.Concurrency {
+		return uint64(limit.Limit.RequestsPerUnit)
+	}
+	return uint64(limit.Burst)
+}
+
+// Generates the quota status of a limit counting hits in windows.
+// @param limit supplies the limit.
+// @param count supplies the number of hits counted against the limit in its current window.
+func (this *BaseRateLimiter) GetCounterQuotaStatus(limit *config.RateLimit, count uint64) *QuotaStatus {
+	limitRemaining := uint64(limit.Limit.RequestsPerUnit) - min(count, uint64(limit.Limit.RequestsPerUnit))
+	code := pb.RateLimitResponse_OK
+	if limitRemaining == 0 {
+		code = pb.RateLimitResponse_OVER_LIMIT
+	}
+	return &QuotaStatus{
+		Count:  count,
+		Status: this.generateResponseDescriptorStatus(code, limit, uint32(limitRemaining)),
+	}
+}
+
+// Generates the quota status of a token bucket, GCRA or concurrency limit.
+// @param limit supplies the limit.
+// @param status supplies the status of the limit for a request without hits.
+func GetScriptedQuotaStatus(limit *config.RateLimit, status *pb.RateLimitResponse_DescriptorStatus) *QuotaStatus {
+	if status.LimitRemaining == 0 {
+		status.Code = pb.RateLimitResponse_OVER_LIMIT
+	}
+	capacity := scriptedCapacity(limit)
+	return &QuotaStatus{Count: capacity - min(uint64(status.LimitRemaining), capacity), Status: status}
+}
+
+// Removes a key from the local cache of keys over the limit, e.g. once hits of the key were refunded.
+func (this *BaseRateLimiter) EvictLocalCache(key string) {
+	if this.localCache != nil {
+		this.localCache.Del([]byte(key))
+	}
+}
+
+// Checks whether a limit counts hits in windows, so that its counters can be refunded or set.
+func CountsHitsInWindows(limit *config.RateLimit) bool {
+	return limit != nil && (limit.Algorithm == config.FixedWindow || limit.Algorithm == config.SlidingWindow)
+}
+
+// Generates the response descriptor status of a limit after hits were refunded.
+// @param limit supplies the refunded limit.
+// @param count supplies the number of hits counted against the limit after the refund.
+func (this *BaseRateLimiter) GetRefundResponseDescriptorStatus(limit *config.RateLimit,
+	count uint64,
+) *pb.RateLimitResponse_DescriptorStatus {
+	limitRemaining := uint64(limit.Limit.RequestsPerUnit)
+	return this.generateResponseDescriptorStatus(pb.RateLimitResponse_OK, limit,
+		uint32(limitRemaining-min(count, limitRemaining)))
+}
+
 func (this *BaseRateLimiter) IsOverLimitThresholdReached(limitInfo *LimitInfo) bool {
 	limitInfo.overLimitThreshold = uint64(limitInfo.limit.Limit.RequestsPerUnit)
 	return limitInfo.limitAfterIncrease > limitInfo.overLimitThreshold
@@ -94,7 +165,7 @@ func (this *BaseRateLimiter) GetResponseDescriptorStatus(key string, limitInfo *
 		limitInfo.limit.Stats.OverLimit.Add(hitsAddend)
 		limitInfo.limit.Stats.OverLimitWithLocalCache.Add(hitsAddend)
 		responseDescriptorStatus = this.generateResponseDescriptorStatus(pb.RateLimitResponse_OVER_LIMIT,
-			limitInfo.limit.Limit, 0)
+			limitInfo.limit, 0)
 	} else {
 		limitInfo.overLimitThreshold = uint64(limitInfo.limit.Limit.RequestsPerUnit)
 		// The nearLimitThreshold is the number of requests that can be made before hitting the nearLimitRatio.
@@ -104,11 +175,13 @@ func (this *BaseRateLimiter) GetResponseDescriptorStatus(key string, limitInfo *
 		if limitInfo.limitAfterIncrease > limitInfo.overLimitThreshold {
 			isOverLimit = true
 			responseDescriptorStatus = this.generateResponseDescriptorStatus(pb.RateLimitResponse_OVER_LIMIT,
-				limitInfo.limit.Limit, 0)
+				limitInfo.limit, 0)
 
 			this.checkOverLimitThreshold(limitInfo, hitsAddend)
 
-			if this.localCache != nil {
+			// A sliding window limit may drop below the threshold before the window ends, as the weight of the
+			// previous window decreases. Such keys are therefore not cached as over the limit.
+			if this.localCache != nil && limitInfo.limit.Algorithm != config.SlidingWindow {
 				// Set the TTL of the local_cache to be the entire duration.
 				// Since the cache_key gets changed once the time crosses over current time slot, the over-the-limit
 				// cache keys in local_cache lose effectiveness.
@@ -116,14 +189,14 @@ func (this *BaseRateLimiter) GetResponseDescriptorStatus(key string, limitInfo *
 				// similar to mongo_1h, mongo_2h, etc. In the hour 1 (0h0m - 0h59m), the cache key is mongo_1h, we start
 				// to get ratelimited in the 50th minute, the ttl of local_cache will be set as 1 hour(0h50m-1h49m).
 				// In the time of 1h1m, since the cache key becomes different (mongo_2h), it won't get ratelimited.
-				err := this.localCache.Set([]byte(key), []byte{}, int(utils.UnitToDivider(limitInfo.limit.Limit.Unit)))
+				err := this.localCache.Set([]byte(key), []byte{}, int(utils.UnitToDividerWithMultiplier(limitInfo.limit.Limit.Unit, limitInfo.limit.UnitMultiplier)))
 				if err != nil {
 					logger.Errorf("Failing to set local cache key: %s", key)
 				}
 			}
 		} else {
 			responseDescriptorStatus = this.generateResponseDescriptorStatus(pb.RateLimitResponse_OK,
-				limitInfo.limit.Limit, uint32(limitInfo.overLimitThreshold-limitInfo.limitAfterIncrease))
+				limitInfo.limit, uint32(limitInfo.overLimitThreshold-limitInfo.limitAfterIncrease))
 
 			// The limit is OK but we additionally want to know if we are near the limit.
 			this.checkNearLimitThreshold(limitInfo, hitsAddend)
@@ -142,6 +215,45 @@ func (this *BaseRateLimiter) GetResponseDescriptorStatus(key string, limitInfo *
 	return responseDescriptorStatus
 }
 
+// Generates response descriptor status for a limit using the token bucket algorithm. Limits using the concurrency
+// algorithm are handled as a bucket of RequestsPerUnit slots.
+// @param limit supplies the token bucket limit.
+// @param allowed supplies whether the bucket held enough tokens for the hits.
+// @param tokensRemaining supplies the number of whole tokens left in the bucket.
+// @param untilNextToken supplies the duration until the next token is added to the bucket.
+// @param hitsAddend supplies the number of hits taken from the bucket.
+func (this *BaseRateLimiter) GetTokenBucketResponseDescriptorStatus(limit *config.RateLimit, allowed bool,
+	tokensRemaining uint64, untilNextToken *durationpb.Duration, hitsAddend uint64,
+) *pb.RateLimitResponse_DescriptorStatus {
+	responseDescriptorStatus := &pb.RateLimitResponse_DescriptorStatus{
+		Code:               pb.RateLimitResponse_OK,
+		CurrentLimit:       limit.Limit,
+		LimitRemaining:     uint32(min(tokensRemaining, math.MaxUint32)),
+		DurationUntilReset: untilNextToken,
+	}
+
+	if !allowed {
+		responseDescriptorStatus.Code = pb.RateLimitResponse_OVER_LIMIT
+		limit.Stats.OverLimit.Add(hitsAddend)
+		if limit.ShadowMode {
+			logger.Debugf("Limit with key %s, is in shadow_mode", limit.FullKey)
+			responseDescriptorStatus.Code = pb.RateLimitResponse_OK
+			limit.Stats.ShadowMode.Add(hitsAddend)
+		}
+		return responseDescriptorStatus
+	}
+
+	capacity := scriptedCapacity(limit)
+	// The bucket is near the limit once the share of used tokens goes above the nearLimitRatio.
+	nearLimitThreshold := uint64(math.Floor(float64(float32(capacity) * this.nearLimitRatio)))
+	if capacity-min(tokensRemaining, capacity) > nearLimitThreshold {
+		limit.Stats.NearLimit.Add(hitsAddend)
+	}
+	limit.Stats.WithinLimit.Add(hitsAddend)
+
+	return responseDescriptorStatus
+}
+
 func NewBaseRateLimit(timeSource utils.TimeSource, jitterRand *rand.Rand, expirationJitterMaxSeconds int64,
 	localCache *freecache.Cache, nearLimitRatio float32, cacheKeyPrefix string, statsManager stats.Manager,
 ) *BaseRateLimiter {
@@ -156,6 +268,30 @@ func NewBaseRateLimit(timeSource utils.TimeSource, jitterRand *rand.Rand, expira
 	}
 }
 
+// Returns the number of seconds the counter of a limit needs to be kept in the cache, including jitter.
+// Counters of sliding window limits are read again during the following window, so they are kept twice as long.
+// Calendar aligned windows vary in length, so their counters are kept until the end of the (following) window.
+func (this *BaseRateLimiter) GetExpirationSeconds(limit *config.RateLimit) int64 {
+	var expirationSeconds int64
+	if limit.CalendarLocation != nil {
+		now := this.timeSource.UnixNow()
+		_, windowEnd := utils.Window(limit.Limit.Unit, limit.UnitMultiplier, limit.CalendarLocation, now)
+		if limit.Algorithm == config.SlidingWindow {
+			_, windowEnd = utils.Window(limit.Limit.Unit, limit.UnitMultiplier, limit.CalendarLocation, windowEnd)
+		}
+		expirationSeconds = windowEnd - now
+	} else {
+		expirationSeconds = utils.UnitToDividerWithMultiplier(limit.Limit.Unit, limit.UnitMultiplier)
+		if limit.Algorithm == config.SlidingWindow {
+			expirationSeconds *= 2
+		}
+	}
+	if this.ExpirationJitterMaxSeconds > 0 {
+		expirationSeconds += this.JitterRand.Int63n(this.ExpirationJitterMaxSeconds)
+	}
+	return expirationSeconds
+}
+
 func (this *BaseRateLimiter) checkOverLimitThreshold(limitInfo *LimitInfo, hitsAddend uint64) {
 	// Increase over limit statistics. Because we support += behavior for increasing the limit, we need to
 	// assess if the entire hitsAddend were over the limit. That is, if the limit's value before adding the
@@ -198,19 +334,19 @@ func (this *BaseRateLimiter) increaseShadowModeStats(isOverLimitWithLocalCache b
 }
 
 func (this *BaseRateLimiter) generateResponseDescriptorStatus(responseCode pb.RateLimitResponse_Code,
-	limit *pb.RateLimitResponse_RateLimit, limitRemaining uint32,
+	limit *config.RateLimit, limitRemaining uint32,
 ) *pb.RateLimitResponse_DescriptorStatus {
 	if limit != nil {
 		return &pb.RateLimitResponse_DescriptorStatus{
 			Code:               responseCode,
-			CurrentLimit:       limit,
+			CurrentLimit:       limit.Limit,
 			LimitRemaining:     limitRemaining,
-			DurationUntilReset: utils.CalculateReset(&limit.Unit, this.timeSource),
+			DurationUntilReset: utils.CalculateWindowReset(&limit.Limit.Unit, limit.UnitMultiplier, limit.CalendarLocation, this.timeSource),
 		}
 	} else {
 		return &pb.RateLimitResponse_DescriptorStatus{
 			Code:           responseCode,
-			CurrentLimit:   limit,
+			CurrentLimit:   nil,
 			LimitRemaining: limitRemaining,
 		}
 	}
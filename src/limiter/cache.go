@@ -7,6 +7,33 @@ import (
 	"github.com/envoyproxy/ratelimit/src/config"
 )
 
+// Error of a cache which is unavailable, e.g. a redis.RedisError. Requests failing with it are handled according to the
+// failure modes of their limits.
+type CacheUnavailableError interface {
+	error
+	// Marks the errors of unavailable caches.
+	CacheUnavailable()
+}
+
+// Error of a cache call which timed out, because of the backend timeout or the deadline of the request. Requests
+// failing with it are handled like those failing with other CacheUnavailableErrors, and are counted as backend
+// timeouts.
+type CacheTimeoutError string
+
+func (e CacheTimeoutError) Error() string {
+	return string(e)
+}
+
+func (e CacheTimeoutError) CacheUnavailable() {}
+
+// Usage of a limit in its current window.
+type QuotaStatus struct {
+	// Hits counted in the current window, or the tokens and slots in use for token bucket, GCRA and concurrency limits.
+	Count uint64
+	// Status of the limit, which is over the limit if no hits remain.
+	Status *pb.RateLimitResponse_DescriptorStatus
+}
+
 // Interface for interacting with a cache backend for rate limiting.
 type RateLimitCache interface {
 	// Contact the cache and perform rate limiting for a set of descriptors and limits.
@@ -17,12 +44,65 @@ type RateLimitCache interface {
 	//               is done for simplicity reasons in the overall service API. The length of this
 	//               list must be same as the length of the descriptors list.
 	// @return a list of DescriptorStatuses which corresponds to each passed in descriptor/limit pair.
-	// 				 Throws RedisError if there was any error talking to the cache.
+	// 				 Throws a CacheUnavailableError if there was any error talking to the cache.
 	DoLimit(
 		ctx context.Context,
 		request *pb.RateLimitRequest,
 		limits []*config.RateLimit) []*pb.RateLimitResponse_DescriptorStatus
 
+	// Release the slots of concurrency limits acquired by DoLimit.
+	// @param ctx supplies the request context.
+	// @param request supplies the request whose slots are released, hits_addend slots per descriptor.
+	// @param limits supplies the list of associated limits. Limits which are nil or do not use the concurrency
+	//               algorithm are skipped. The length of this list must be same as the length of the
+	//               descriptors list.
+	// @return a list of DescriptorStatuses with the slots remaining after the release, or nil for skipped limits.
+	// 				 Throws a CacheUnavailableError if there was any error talking to the cache.
+	Release(
+		ctx context.Context,
+		request *pb.RateLimitRequest,
+		limits []*config.RateLimit) []*pb.RateLimitResponse_DescriptorStatus
+
+	// Refund hits counted by DoLimit, e.g. when the request was rejected before doing any work.
+	// @param ctx supplies the request context.
+	// @param request supplies the request whose hits are refunded, hits_addend hits per descriptor.
+	// @param limits supplies the list of associated limits. Limits which are nil or do not count hits in windows
+	//               are skipped. The length of this list must be same as the length of the descriptors list.
+	// @return a list of DescriptorStatuses with the hits remaining after the refund, or nil for skipped limits.
+	// 				 Throws a CacheUnavailableError if there was any error talking to the cache.
+	Refund(
+		ctx context.Context,
+		request *pb.RateLimitRequest,
+		limits []*config.RateLimit) []*pb.RateLimitResponse_DescriptorStatus
+
+	// Read the usage of limits in their current window without counting any hits.
+	// @param ctx supplies the request context.
+	// @param request supplies the request whose limits are read. Its hits_addend is ignored.
+	// @param limits supplies the list of associated limits. Limits which are nil are skipped. The length of this
+	//               list must be same as the length of the descriptors list.
+	// @return a list of QuotaStatuses which corresponds to each passed in descriptor/limit pair, or nil for skipped
+	//         limits. Throws a CacheUnavailableError if there was any error talking to the cache.
+	GetQuotaStatus(
+		ctx context.Context,
+		request *pb.RateLimitRequest,
+		limits []*config.RateLimit) []*QuotaStatus
+
+	// Set the counters of limits in their current window to a number of hits, and remove their keys from the local
+	// cache. A count of 0 resets the limits, which also resets limits which do not count hits in windows.
+	// @param ctx supplies the request context.
+	// @param request supplies the request whose limits are set. Its hits_addend is ignored.
+	// @param limits supplies the list of associated limits. Limits which are nil are skipped, and so are limits
+	//               which do not count hits in windows unless they are reset. The length of this list must be same
+	//               as the length of the descriptors list.
+	// @param count supplies the number of hits to set.
+	// @return a list of QuotaStatuses after the update, or nil for skipped limits.
+	//         Throws a CacheUnavailableError if there was any error talking to the cache.
+	SetCounters(
+		ctx context.Context,
+		request *pb.RateLimitRequest,
+		limits []*config.RateLimit,
+		count uint64) []*QuotaStatus
+
 	// Waits for any unfinished asynchronous work. This may be used by unit tests,
 	// since the memcache cache does increments in a background gorountine.
 	Flush()
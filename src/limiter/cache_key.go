@@ -2,6 +2,7 @@ package limiter
 
 import (
 	"bytes"
+	"math"
 	"strconv"
 	"sync"
 
@@ -33,6 +34,16 @@ type CacheKey struct {
 	Key string
 	// True if the key corresponds to a limit with a SECOND unit. False otherwise.
 	PerSecond bool
+	// Key of the previous window if the limit uses the sliding window algorithm. Empty otherwise.
+	PreviousKey string
+	// Portion of the previous window which overlaps with the sliding window ending now.
+	PreviousWeight float64
+}
+
+// Returns the number of hits of the previous window that still count against a sliding window limit.
+// @param previousCount supplies the counter of the previous window.
+func (this CacheKey) WeightedPreviousCount(previousCount uint64) uint64 {
+	return uint64(math.Floor(float64(previousCount) * this.PreviousWeight))
 }
 
 func isPerSecondLimit(unit pb.RateLimitResponse_RateLimit_Unit) bool {
@@ -63,18 +74,54 @@ func (this *CacheKeyGenerator) GenerateCacheKey(
 	b.WriteString(domain)
 	b.WriteByte('_')
 
-	for _, entry := range descriptor.Entries {
+	entries := descriptor.Entries
+	// Limits of ancestor descriptors are counted for the entries of the ancestor only.
+	if limit.DescriptorDepth > 0 && limit.DescriptorDepth < len(entries) {
+		entries = entries[:limit.DescriptorDepth]
+	}
+	for _, entry := range entries {
 		b.WriteString(entry.Key)
 		b.WriteByte('_')
 		b.WriteString(entry.Value)
 		b.WriteByte('_')
 	}
 
-	divider := utils.UnitToDivider(limit.Limit.Unit)
-	b.WriteString(strconv.FormatInt((now/divider)*divider, 10))
+	// Additional limits of a descriptor are counted separately from its first limit. The suffix is a field of its own,
+	// so that it cannot be confused with descriptor entries, e.g. the limit per hour of a descriptor with an entry
+	// with the value per_hour.
+	if limit.KeySuffix != "" {
+		b.Truncate(b.Len() - 1)
+		b.WriteString("|limit=")
+		b.WriteString(limit.KeySuffix)
+		b.WriteByte('|')
+	}
+
+	// Token buckets, theoretical arrival times and concurrency leases are stored in a single key which is not bound
+	// to a window.
+	if limit.Algorithm == config.TokenBucket || limit.Algorithm == config.GCRA || limit.Algorithm == config.Concurrency {
+		b.Truncate(b.Len() - 1)
+		return CacheKey{
+			Key:       b.String(),
+			PerSecond: isPerSecondLimit(limit.Limit.Unit),
+		}
+	}
+
+	windowStart, windowEnd := utils.Window(limit.Limit.Unit, limit.UnitMultiplier, limit.CalendarLocation, now)
+	prefixLength := b.Len()
+	b.WriteString(strconv.FormatInt(windowStart, 10))
 
-	return CacheKey{
+	cacheKey := CacheKey{
 		Key:       b.String(),
 		PerSecond: isPerSecondLimit(limit.Limit.Unit),
 	}
+
+	if limit.Algorithm == config.SlidingWindow {
+		b.Truncate(prefixLength)
+		previousWindowStart, _ := utils.Window(limit.Limit.Unit, limit.UnitMultiplier, limit.CalendarLocation, windowStart-1)
+		b.WriteString(strconv.FormatInt(previousWindowStart, 10))
+		cacheKey.PreviousKey = b.String()
+		cacheKey.PreviousWeight = float64(windowEnd-now) / float64(windowEnd-windowStart)
+	}
+
+	return cacheKey
 }
@@ -0,0 +1,86 @@
+package limiter
+
+import (
+	"encoding/binary"
+	"math"
+
+	logger "github.com/sirupsen/logrus"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Prefix of the local cache keys of leases, which share the local cache with the keys over the limit.
+const leaseKeyPrefix = "lease:"
+
+// Checks whether hits of a limit are served from leases of the local cache. Leasing requires the local cache.
+func (this *BaseRateLimiter) IsLeased(limit *config.RateLimit) bool {
+	return this.localCache != nil && limit != nil && limit.LeaseRatio > 0 && limit.Algorithm == config.FixedWindow
+}
+
+// Returns the number of hits of a limit leased from the cache at once, at least 1.
+func LeaseSize(limit *config.RateLimit) uint64 {
+	return max(uint64(math.Floor(float64(limit.Limit.RequestsPerUnit)*limit.LeaseRatio)), 1)
+}
+
+// A lease is stored in the local cache as the number of hits left and the count of the cache key once the lease was
+// taken, from which the count of the cache key after serving a hit from the lease is estimated.
+func (this *BaseRateLimiter) getLease(key string) (remaining uint64, count uint64) {
+	value, err := this.localCache.Get([]byte(leaseKeyPrefix + key))
+	if err != nil || len(value) != 16 {
+		return 0, 0
+	}
+	return binary.BigEndian.Uint64(value[:8]), binary.BigEndian.Uint64(value[8:])
+}
+
+// Takes hits from the lease of a cache key. If the lease holds fewer hits, they are all taken as leftover hits and
+// the request needs to lease the missing hits.
+// @param key supplies the cache key.
+// @param limit supplies the limit of the key.
+// @param hitsAddend supplies the number of hits to take.
+// @return whether the lease held all hits, the estimated count of the cache key after the hits, and the number of
+// leftover hits taken from the lease otherwise.
+func (this *BaseRateLimiter) TakeFromLease(key string, limit *config.RateLimit, hitsAddend uint64) (taken bool, count uint64, leftover uint64) {
+	this.leaseLock.Lock()
+	defer this.leaseLock.Unlock()
+	remaining, count := this.getLease(key)
+	if remaining >= hitsAddend && remaining > 0 {
+		this.setLease(key, limit, remaining-hitsAddend, count)
+		return true, count - (remaining - hitsAddend), 0
+	}
+	if remaining > 0 {
+		this.localCache.Del([]byte(leaseKeyPrefix + key))
+	}
+	return false, 0, remaining
+}
+
+// Adds leased hits to the lease of a cache key.
+// @param key supplies the cache key.
+// @param limit supplies the limit of the key.
+// @param hits supplies the number of leased hits.
+// @param count supplies the count of the cache key once the hits were leased.
+func (this *BaseRateLimiter) AddToLease(key string, limit *config.RateLimit, hits uint64, count uint64) {
+	this.leaseLock.Lock()
+	defer this.leaseLock.Unlock()
+	remaining, leaseCount := this.getLease(key)
+	// Leases taken concurrently may complete in any order.
+	this.setLease(key, limit, remaining+hits, max(count, leaseCount))
+}
+
+// Removes the lease of a cache key, e.g. once the counter of the key was set.
+func (this *BaseRateLimiter) EvictLease(key string) {
+	if this.localCache != nil {
+		this.localCache.Del([]byte(leaseKeyPrefix + key))
+	}
+}
+
+// Stores a lease until the window of its cache key ends, after which the key and its lease are no longer used.
+func (this *BaseRateLimiter) setLease(key string, limit *config.RateLimit, remaining uint64, count uint64) {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value[:8], remaining)
+	binary.BigEndian.PutUint64(value[8:], count)
+	reset := utils.CalculateWindowReset(&limit.Limit.Unit, limit.UnitMultiplier, limit.CalendarLocation, this.timeSource)
+	if err := this.localCache.Set([]byte(leaseKeyPrefix+key), value, int(max(reset.GetSeconds(), 1))); err != nil {
+		logger.Errorf("Failing to set lease of cache key: %s", key)
+	}
+}
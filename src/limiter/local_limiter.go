@@ -0,0 +1,99 @@
+package limiter
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/coocood/freecache"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/stats"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Counts hits in memory while the cache is unavailable. Each instance only sees its share of the hits, so each limit
+// is divided by the number of replicas, and hits are counted in fixed windows regardless of the algorithm of the limit.
+type LocalLimiter struct {
+	baseRateLimiter *BaseRateLimiter
+	cache           *freecache.Cache
+	replicas        uint32
+	// Serializes the increments of the counters.
+	lock sync.Mutex
+}
+
+// Returns the share of a limit enforced by each replica, nil for limits which are not counted locally.
+func (this *LocalLimiter) localLimit(limit *config.RateLimit) *config.RateLimit {
+	if limit == nil || limit.Unlimited || limit.Blocked || limit.Algorithm == config.Concurrency {
+		return nil
+	}
+	localLimit := *limit
+	localLimit.Algorithm = config.FixedWindow
+	localLimit.Limit = &pb.RateLimitResponse_RateLimit{
+		Name:            limit.Limit.Name,
+		RequestsPerUnit: (limit.Limit.RequestsPerUnit + this.replicas - 1) / this.replicas,
+		Unit:            limit.Limit.Unit,
+	}
+	return &localLimit
+}
+
+// Increases the counter of a cache key.
+// @return the count of the key before and after the increase.
+func (this *LocalLimiter) increment(key string, limit *config.RateLimit, hitsAddend uint64) (uint64, uint64) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	before := uint64(0)
+	if value, err := this.cache.Get([]byte(key)); err == nil && len(value) == 8 {
+		before = binary.BigEndian.Uint64(value)
+	}
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, before+hitsAddend)
+	err := this.cache.Set([]byte(key), value, int(utils.UnitToDividerWithMultiplier(limit.Limit.Unit, limit.UnitMultiplier)))
+	if err != nil {
+		logger.Errorf("Failing to set local limiter key: %s", key)
+	}
+	return before, before + hitsAddend
+}
+
+// Counts the hits of a request against the local share of its limits. The hits are expected to be counted in the
+// total hits of the limits already, by the cache which failed.
+// @param request supplies the request to check.
+// @param limits supplies the limit of each descriptor of the request, nil for descriptors which are not checked.
+// @return the status of each descriptor. Concurrency limits are not counted and report no limit.
+func (this *LocalLimiter) DoLimit(request *pb.RateLimitRequest, limits []*config.RateLimit) []*pb.RateLimitResponse_DescriptorStatus {
+	localLimits := make([]*config.RateLimit, len(limits))
+	for i, limit := range limits {
+		localLimits[i] = this.localLimit(limit)
+	}
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, localLimits)
+	hitsAddends := utils.GetHitsAddends(request)
+
+	responseDescriptorStatuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		var limitInfo *LimitInfo
+		if cacheKey.Key != "" {
+			before, after := this.increment(cacheKey.Key, localLimits[i], hitsAddends[i])
+			limitInfo = NewRateLimitInfo(localLimits[i], before, after, 0, 0)
+		}
+		responseDescriptorStatuses[i] = this.baseRateLimiter.GetResponseDescriptorStatus(cacheKey.Key, limitInfo,
+			false, hitsAddends[i])
+	}
+	return responseDescriptorStatuses
+}
+
+// @param timeSource supplies the time of the windows.
+// @param cacheSizeInBytes supplies the size of the in memory cache of the counters.
+// @param replicas supplies the number of instances sharing the limits, at least 1.
+// @param nearLimitRatio supplies the ratio of a limit above which hits are near the limit.
+// @param cacheKeyPrefix supplies the prefix of the cache keys.
+// @param statsManager supplies the stats manager.
+func NewLocalLimiter(timeSource utils.TimeSource, cacheSizeInBytes int, replicas uint32, nearLimitRatio float32,
+	cacheKeyPrefix string, statsManager stats.Manager,
+) *LocalLimiter {
+	return &LocalLimiter{
+		baseRateLimiter: NewBaseRateLimit(timeSource, nil, 0, nil, nearLimitRatio, cacheKeyPrefix, statsManager),
+		cache:           freecache.NewCache(cacheSizeInBytes),
+		replicas:        max(replicas, 1),
+	}
+}
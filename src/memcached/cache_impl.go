@@ -18,6 +18,8 @@ package memcached
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"math/rand"
 	"net"
 	"strconv"
@@ -41,6 +43,7 @@ import (
 
 	"github.com/envoyproxy/ratelimit/src/config"
 	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/server"
 	"github.com/envoyproxy/ratelimit/src/settings"
 	"github.com/envoyproxy/ratelimit/src/srv"
 	"github.com/envoyproxy/ratelimit/src/utils"
@@ -48,6 +51,8 @@ import (
 
 var tracer = otel.Tracer("memcached.cacheImpl")
 
+const maxRelativeExpirationSeconds = 60 * 60 * 24 * 30
+
 type rateLimitMemcacheImpl struct {
 	client                     Client
 	timeSource                 utils.TimeSource
@@ -73,6 +78,8 @@ func (this *rateLimitMemcacheImpl) DoLimit(
 	// request.HitsAddend could be 0 (default value) if not specified by the caller in the Ratelimit request.
 	hitsAddends := utils.GetHitsAddends(request)
 
+	limits = fixedWindowFallback(limits)
+
 	// First build a list of all cache keys that we are actually going to hit.
 	cacheKeys := this.baseRateLimiter.GenerateCacheKeys(request, limits, hitsAddends)
 
@@ -81,7 +88,7 @@ func (this *rateLimitMemcacheImpl) DoLimit(
 	keysToGet := make([]string, 0, len(request.Descriptors))
 
 	for i, cacheKey := range cacheKeys {
-		if cacheKey.Key == "" {
+		if cacheKey.Key == "" || limits[i].Algorithm == config.Concurrency {
 			continue
 		}
 
@@ -94,6 +101,9 @@ func (this *rateLimitMemcacheImpl) DoLimit(
 
 		logger.Debugf("looking up cache key: %s", cacheKey.Key)
 		keysToGet = append(keysToGet, cacheKey.Key)
+		if cacheKey.PreviousKey != "" {
+			keysToGet = append(keysToGet, cacheKey.PreviousKey)
+		}
 	}
 
 	// Generate trace
@@ -115,21 +125,25 @@ func (this *rateLimitMemcacheImpl) DoLimit(
 		memcacheValues, err = this.client.GetMulti(keysToGet)
 		if err != nil {
 			logger.Errorf("Error multi-getting memcache keys (%s): %s", keysToGet, err)
+			if IsMemcacheFailure(err) {
+				panic(newMemcacheError(fmt.Sprintf("Error multi-getting memcache keys: %s", err), err))
+			}
 		}
 	}
 
-	for i, cacheKey := range cacheKeys {
+	// Slots of concurrency limits are acquired synchronously once the counters were looked up, so that no slots are
+	// acquired by requests failing while memcached is unavailable.
+	concurrencyStatuses := this.doConcurrencyLimits(cacheKeys, limits, hitsAddends)
 
-		rawMemcacheValue, ok := memcacheValues[cacheKey.Key]
-		var limitBeforeIncrease uint64
-		if ok {
-			decoded, err := strconv.ParseInt(string(rawMemcacheValue.Value), 10, 32)
-			if err != nil {
-				logger.Errorf("Unexpected non-numeric value in memcached: %v", rawMemcacheValue)
-			} else {
-				limitBeforeIncrease = uint64(decoded)
-			}
+	for i, cacheKey := range cacheKeys {
+		if concurrencyStatuses != nil && concurrencyStatuses[i] != nil {
+			responseDescriptorStatuses[i] = concurrencyStatuses[i]
+			continue
+		}
 
+		limitBeforeIncrease := decodeMemcacheValue(memcacheValues, cacheKey.Key)
+		if cacheKey.PreviousKey != "" {
+			limitBeforeIncrease += cacheKey.WeightedPreviousCount(decodeMemcacheValue(memcacheValues, cacheKey.PreviousKey))
 		}
 
 		limitAfterIncrease := limitBeforeIncrease + hitsAddends[i]
@@ -149,6 +163,54 @@ func (this *rateLimitMemcacheImpl) DoLimit(
 	return responseDescriptorStatuses
 }
 
+// Memcache has no atomic scripting, so limits using the token bucket or GCRA algorithm are applied as fixed windows.
+// Configs with such limits are rejected when they are loaded for the memcache backend, so this only applies to limits
+// which are not loaded from configs.
+func fixedWindowFallback(limits []*config.RateLimit) []*config.RateLimit {
+	var fallbackLimits []*config.RateLimit
+	for i, limit := range limits {
+		if limit == nil || (limit.Algorithm != config.TokenBucket && limit.Algorithm != config.GCRA) {
+			continue
+		}
+		if fallbackLimits == nil {
+			fallbackLimits = make([]*config.RateLimit, len(limits))
+			copy(fallbackLimits, limits)
+		}
+		logger.Debugf("applying %s limit as fixed window: %s", limit.Algorithm, limit.FullKey)
+		fallbackLimit := *limit
+		fallbackLimit.Algorithm = config.FixedWindow
+		fallbackLimits[i] = &fallbackLimit
+	}
+	if fallbackLimits == nil {
+		return limits
+	}
+	return fallbackLimits
+}
+
+func decodeMemcacheValue(memcacheValues map[string]*memcache.Item, key string) uint64 {
+	rawMemcacheValue, ok := memcacheValues[key]
+	if !ok {
+		return 0
+	}
+	decoded, err := strconv.ParseInt(string(rawMemcacheValue.Value), 10, 32)
+	if err != nil {
+		logger.Errorf("Unexpected non-numeric value in memcached: %v", rawMemcacheValue)
+		return 0
+	}
+	return uint64(decoded)
+}
+
+// @param message supplies the message of the error.
+// @param err supplies the error of the failed call to memcached.
+// @return a limiter.CacheTimeoutError if the call timed out after the backend timeout, a MemcacheError otherwise.
+func newMemcacheError(message string, err error) limiter.CacheUnavailableError {
+	var connectTimeout *memcache.ConnectTimeoutError
+	if utils.IsTimeout(err) || errors.As(err, &connectTimeout) {
+		return limiter.CacheTimeoutError(message)
+	}
+	return MemcacheError(message)
+}
+
 func (this *rateLimitMemcacheImpl) increaseAsync(cacheKeys []limiter.CacheKey, isOverLimitWithLocalCache []bool,
 	limits []*config.RateLimit, hitsAddends []uint64,
 ) {
@@ -160,16 +222,11 @@ func (this *rateLimitMemcacheImpl) increaseAsync(cacheKeys []limiter.CacheKey, i
 
 		_, err := this.client.Increment(cacheKey.Key, hitsAddends[i])
 		if err == memcache.ErrCacheMiss {
-			expirationSeconds := utils.UnitToDivider(limits[i].Limit.Unit)
-			if this.expirationJitterMaxSeconds > 0 {
-				expirationSeconds += this.jitterRand.Int63n(this.expirationJitterMaxSeconds)
-			}
-
 			// Need to add instead of increment.
 			err = this.client.Add(&memcache.Item{
 				Key:        cacheKey.Key,
 				Value:      []byte(strconv.FormatUint(hitsAddends[i], 10)),
-				Expiration: int32(expirationSeconds),
+				Expiration: this.expiration(this.baseRateLimiter.GetExpirationSeconds(limits[i])),
 			})
 			if err == memcache.ErrNotStored {
 				// There was a race condition to do this add. We should be able to increment
@@ -190,6 +247,14 @@ func (this *rateLimitMemcacheImpl) increaseAsync(cacheKeys []limiter.CacheKey, i
 	}
 }
 
+// Converts a number of seconds into a memcache expiration, which interprets more than 30 days as a unix timestamp.
+func (this *rateLimitMemcacheImpl) expiration(seconds int64) int32 {
+	if seconds > maxRelativeExpirationSeconds {
+		seconds += this.timeSource.UnixNow()
+	}
+	return int32(seconds)
+}
+
 func (this *rateLimitMemcacheImpl) Flush() {
 	this.waitGroup.Wait()
 }
@@ -257,6 +322,11 @@ func newMemcacheFromSettings(s settings.Settings) Client {
 		client = memcache.New(s.MemcacheHostPort...)
 	}
 	client.MaxIdleConns = s.MemcacheMaxIdleConns
+	// Calls taking longer than the timeout fail, and the requests are handled according to the failure modes of their
+	// limits.
+	if s.BackendTimeout > 0 {
+		client.Timeout = s.BackendTimeout
+	}
 	if s.MemcacheTls {
 		client.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
 			var td tls.Dialer
@@ -316,10 +386,20 @@ func NewRateLimitCacheImpl(client Client, timeSource utils.TimeSource, jitterRan
 }
 
 func NewRateLimitCacheImplFromSettings(s settings.Settings, timeSource utils.TimeSource, jitterRand *rand.Rand,
-	localCache *freecache.Cache, scope gostats.Scope, statsManager stats.Manager,
+	localCache *freecache.Cache, scope gostats.Scope, healthChecker *server.HealthChecker, statsManager stats.Manager,
 ) limiter.RateLimitCache {
+	client := CollectStats(newMemcacheFromSettings(s), scope.Scope("memcache"))
+	if s.CircuitBreakerEnabled {
+		var onOpenChange func(bool)
+		if s.CircuitBreakerHealthCheck && healthChecker != nil {
+			onOpenChange = healthChecker.CircuitBreakerOpenChanged
+		}
+		client = WithCircuitBreaker(client, utils.NewCircuitBreaker(timeSource, s.CircuitBreakerFailureThreshold,
+			s.CircuitBreakerLatencyThreshold, s.CircuitBreakerOpenDuration, s.CircuitBreakerHalfOpenProbes,
+			IsMemcacheFailure, onOpenChange, scope.Scope("memcache").Scope("circuit_breaker")))
+	}
 	return NewRateLimitCacheImpl(
-		CollectStats(newMemcacheFromSettings(s), scope.Scope("memcache")),
+		client,
 		timeSource,
 		jitterRand,
 		s.ExpirationJitterMaxSeconds,
@@ -0,0 +1,76 @@
+package memcached
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+type circuitBreakerClient struct {
+	c       Client
+	breaker *utils.CircuitBreaker
+}
+
+// Wraps a client so that its calls go through a circuit breaker. Calls rejected by the open breaker fail with
+// utils.ErrCircuitBreakerOpen, which is handled like any other error of the client.
+func WithCircuitBreaker(c Client, breaker *utils.CircuitBreaker) Client {
+	return circuitBreakerClient{c: c, breaker: breaker}
+}
+
+// Checks whether an error is a failure of memcached, rather than the outcome of a call, e.g. a cache miss.
+func IsMemcacheFailure(err error) bool {
+	switch err {
+	case memcache.ErrCacheMiss, memcache.ErrNotStored, memcache.ErrCASConflict, memcache.ErrMalformedKey:
+		return false
+	default:
+		return true
+	}
+}
+
+func (cbc circuitBreakerClient) GetMulti(keys []string) (results map[string]*memcache.Item, err error) {
+	err = cbc.breaker.Do(func() error {
+		results, err = cbc.c.GetMulti(keys)
+		return err
+	})
+	return
+}
+
+func (cbc circuitBreakerClient) Increment(key string, delta uint64) (newValue uint64, err error) {
+	err = cbc.breaker.Do(func() error {
+		newValue, err = cbc.c.Increment(key, delta)
+		return err
+	})
+	return
+}
+
+func (cbc circuitBreakerClient) Decrement(key string, delta uint64) (newValue uint64, err error) {
+	err = cbc.breaker.Do(func() error {
+		newValue, err = cbc.c.Decrement(key, delta)
+		return err
+	})
+	return
+}
+
+func (cbc circuitBreakerClient) Add(item *memcache.Item) error {
+	return cbc.breaker.Do(func() error {
+		return cbc.c.Add(item)
+	})
+}
+
+func (cbc circuitBreakerClient) Touch(key string, seconds int32) error {
+	return cbc.breaker.Do(func() error {
+		return cbc.c.Touch(key, seconds)
+	})
+}
+
+func (cbc circuitBreakerClient) Set(item *memcache.Item) error {
+	return cbc.breaker.Do(func() error {
+		return cbc.c.Set(item)
+	})
+}
+
+func (cbc circuitBreakerClient) Delete(key string) error {
+	return cbc.breaker.Do(func() error {
+		return cbc.c.Delete(key)
+	})
+}
@@ -4,18 +4,24 @@ import (
 	"github.com/bradfitz/gomemcache/memcache"
 )
 
-// Errors that may be raised during config parsing.
+// Errors that may be raised during config parsing, or by calls to memcached which fail.
 type MemcacheError string
 
 func (e MemcacheError) Error() string {
 	return string(e)
 }
 
+func (e MemcacheError) CacheUnavailable() {}
+
 var _ Client = (*memcache.Client)(nil)
 
 // Interface for memcached, used for mocking.
 type Client interface {
 	GetMulti(keys []string) (map[string]*memcache.Item, error)
 	Increment(key string, delta uint64) (newValue uint64, err error)
+	Decrement(key string, delta uint64) (newValue uint64, err error)
 	Add(item *memcache.Item) error
+	Touch(key string, seconds int32) error
+	Set(item *memcache.Item) error
+	Delete(key string) error
 }
@@ -0,0 +1,194 @@
+package memcached
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Memcache has no sorted sets to keep a lease per slot, so the slots of a concurrency limit are counted per generation:
+// the slots acquired within the same 1/concurrencyGenerations of the lease share a counter, which expires one lease
+// after its generation ended and is never extended. The slots held are the sum of the generations which did not expire
+// yet, so slots whose release was lost expire at most one generation after their lease.
+const concurrencyGenerations = 10
+
+// @return the keys of the generations of a concurrency limit which did not expire yet, oldest first and ending with the
+// current generation, and the expiration of the current generation in seconds.
+func (this *rateLimitMemcacheImpl) concurrencyKeys(key string, limit *config.RateLimit) ([]string, int64) {
+	lease := utils.UnitToDividerWithMultiplier(limit.Limit.Unit, limit.UnitMultiplier)
+	width := max(1, lease/concurrencyGenerations)
+	now := this.timeSource.UnixNow()
+
+	// Generation g expires at (g+1)*width + lease.
+	current := now / width
+	oldest := (now - lease) / width
+	keys := make([]string, 0, current-oldest+1)
+	for g := oldest; g <= current; g++ {
+		keys = append(keys, key+"_"+strconv.FormatInt(g, 10))
+	}
+	return keys, (current+1)*width + lease - now
+}
+
+// @return the number of slots held in the generations of keys.
+func sumConcurrencySlots(values map[string]*memcache.Item, keys []string) uint64 {
+	var held uint64
+	for _, key := range keys {
+		held += decodeMemcacheValue(values, key)
+	}
+	return held
+}
+
+// Acquires the slots of all concurrency limits of a request and clears their cache keys, so that they are skipped
+// by the counter lookups.
+// @return the response descriptor statuses of the concurrency limits, nil for all other limits.
+func (this *rateLimitMemcacheImpl) doConcurrencyLimits(cacheKeys []limiter.CacheKey, limits []*config.RateLimit,
+	hitsAddends []uint64,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	var statuses []*pb.RateLimitResponse_DescriptorStatus
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || limits[i].Algorithm != config.Concurrency {
+			continue
+		}
+		if statuses == nil {
+			statuses = make([]*pb.RateLimitResponse_DescriptorStatus, len(cacheKeys))
+		}
+
+		statuses[i] = this.doConcurrencyLimit(cacheKey.Key, limits[i], hitsAddends[i])
+		cacheKeys[i] = limiter.CacheKey{}
+	}
+	return statuses
+}
+
+func (this *rateLimitMemcacheImpl) doConcurrencyLimit(key string, limit *config.RateLimit,
+	hitsAddend uint64,
+) *pb.RateLimitResponse_DescriptorStatus {
+	logger.Debugf("acquiring %d slots of concurrency limit: %s", hitsAddend, key)
+
+	capacity := uint64(limit.Limit.RequestsPerUnit)
+	keys, expiration := this.concurrencyKeys(key, limit)
+	current := keys[len(keys)-1]
+
+	// Incrementing does not change the expiration of the current generation.
+	_, err := this.client.Increment(current, hitsAddend)
+	if err == memcache.ErrCacheMiss {
+		err = this.client.Add(&memcache.Item{
+			Key:        current,
+			Value:      []byte(strconv.FormatUint(hitsAddend, 10)),
+			Expiration: this.expiration(expiration),
+		})
+		if err == memcache.ErrNotStored {
+			// There was a race condition to do this add. We should be able to increment now instead.
+			_, err = this.client.Increment(current, hitsAddend)
+		}
+	}
+	if err != nil {
+		// Like failed lookups of counters, the request is then handled according to the failure mode of its limits.
+		logger.Errorf("Failed to acquire slots of concurrency limit %s: %s", current, err)
+		panic(newMemcacheError(fmt.Sprintf("Failed to acquire slots of concurrency limit %s: %s", current, err), err))
+	}
+
+	held := hitsAddend
+	values, err := this.client.GetMulti(keys)
+	if err == nil {
+		held = sumConcurrencySlots(values, keys)
+	} else if IsMemcacheFailure(err) {
+		logger.Errorf("Error multi-getting memcache keys (%s): %s", keys, err)
+		// The slots were not acquired.
+		if _, err := this.client.Decrement(current, hitsAddend); err != nil {
+			logger.Errorf("Failed to return slots of concurrency limit %s: %s", current, err)
+		}
+		panic(newMemcacheError(fmt.Sprintf("Error multi-getting memcache keys: %s", err), err))
+	} else {
+		logger.Errorf("Error multi-getting memcache keys (%s): %s", keys, err)
+	}
+
+	allowed := held <= capacity
+	if !allowed && !limit.ShadowMode {
+		// The slots were not acquired.
+		if _, err := this.client.Decrement(current, hitsAddend); err != nil {
+			logger.Errorf("Failed to return slots of concurrency limit %s: %s", current, err)
+		}
+		held -= hitsAddend
+	}
+
+	return this.baseRateLimiter.GetTokenBucketResponseDescriptorStatus(limit, allowed, capacity-min(held, capacity),
+		nil, hitsAddend)
+}
+
+func (this *rateLimitMemcacheImpl) Release(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	hitsAddends := utils.GetHitsAddends(request)
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	statuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || limits[i].Algorithm != config.Concurrency {
+			continue
+		}
+		logger.Debugf("releasing %d slots of concurrency limit: %s", hitsAddends[i], cacheKey.Key)
+
+		keys, _ := this.concurrencyKeys(cacheKey.Key, limits[i])
+		values, err := this.client.GetMulti(keys)
+		if err != nil {
+			logger.Errorf("Error multi-getting memcache keys (%s): %s", keys, err)
+		}
+
+		// The slots are released from the oldest generations, whose leases expire first. Releases of expired slots
+		// are ignored.
+		held := sumConcurrencySlots(values, keys)
+		released := min(hitsAddends[i], held)
+		for toRelease, j := released, 0; toRelease > 0; j++ {
+			n := min(toRelease, decodeMemcacheValue(values, keys[j]))
+			if n == 0 {
+				continue
+			}
+			// Memcache does not decrement below zero.
+			if _, err := this.client.Decrement(keys[j], n); err != nil && err != memcache.ErrCacheMiss {
+				logger.Errorf("Failed to release slots of concurrency limit %s: %s", keys[j], err)
+			}
+			toRelease -= n
+		}
+		held -= released
+
+		capacity := uint64(limits[i].Limit.RequestsPerUnit)
+		statuses[i] = &pb.RateLimitResponse_DescriptorStatus{
+			Code:           pb.RateLimitResponse_OK,
+			CurrentLimit:   limits[i].Limit,
+			LimitRemaining: uint32(capacity - min(held, capacity)),
+		}
+	}
+	return statuses
+}
+
+// Replaces the slots held of a concurrency limit by count slots in the current generation.
+func (this *rateLimitMemcacheImpl) setConcurrencySlots(key string, limit *config.RateLimit, count uint64) {
+	keys, expiration := this.concurrencyKeys(key, limit)
+	current := keys[len(keys)-1]
+	if count > 0 {
+		err := this.client.Set(&memcache.Item{
+			Key:        current,
+			Value:      []byte(strconv.FormatUint(count, 10)),
+			Expiration: this.expiration(expiration),
+		})
+		if err != nil {
+			logger.Errorf("Failed to set key %s: %s", current, err)
+		}
+		keys = keys[:len(keys)-1]
+	}
+	for _, key := range keys {
+		if err := this.client.Delete(key); err != nil && err != memcache.ErrCacheMiss {
+			logger.Errorf("Failed to delete key %s: %s", key, err)
+		}
+	}
+}
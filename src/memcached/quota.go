@@ -0,0 +1,117 @@
+package memcached
+
+import (
+	"strconv"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+)
+
+func (this *rateLimitMemcacheImpl) GetQuotaStatus(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*limiter.QuotaStatus {
+	limits = fixedWindowFallback(limits)
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	keysToGet := make([]string, 0, len(request.Descriptors))
+	concurrencyKeys := make([][]string, len(cacheKeys))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" {
+			continue
+		}
+		if limits[i].Algorithm == config.Concurrency {
+			concurrencyKeys[i], _ = this.concurrencyKeys(cacheKey.Key, limits[i])
+			keysToGet = append(keysToGet, concurrencyKeys[i]...)
+			continue
+		}
+		keysToGet = append(keysToGet, cacheKey.Key)
+		if cacheKey.PreviousKey != "" {
+			keysToGet = append(keysToGet, cacheKey.PreviousKey)
+		}
+	}
+
+	var memcacheValues map[string]*memcache.Item
+	if len(keysToGet) > 0 {
+		var err error
+		memcacheValues, err = this.client.GetMulti(keysToGet)
+		if err != nil {
+			logger.Errorf("Error multi-getting memcache keys (%s): %s", keysToGet, err)
+		}
+	}
+
+	statuses := make([]*limiter.QuotaStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" {
+			continue
+		}
+
+		if limits[i].Algorithm == config.Concurrency {
+			// The counters of the generations of a concurrency limit hold the slots in use.
+			count := sumConcurrencySlots(memcacheValues, concurrencyKeys[i])
+			capacity := uint64(limits[i].Limit.RequestsPerUnit)
+			statuses[i] = limiter.GetScriptedQuotaStatus(limits[i], &pb.RateLimitResponse_DescriptorStatus{
+				Code:           pb.RateLimitResponse_OK,
+				CurrentLimit:   limits[i].Limit,
+				LimitRemaining: uint32(capacity - min(count, capacity)),
+			})
+			continue
+		}
+		count := decodeMemcacheValue(memcacheValues, cacheKey.Key)
+		if cacheKey.PreviousKey != "" {
+			count += cacheKey.WeightedPreviousCount(decodeMemcacheValue(memcacheValues, cacheKey.PreviousKey))
+		}
+		statuses[i] = this.baseRateLimiter.GetCounterQuotaStatus(limits[i], count)
+	}
+	return statuses
+}
+
+func (this *rateLimitMemcacheImpl) SetCounters(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+	count uint64,
+) []*limiter.QuotaStatus {
+	// Memcache keeps a counter for all algorithms, so all limits can be set.
+	limits = fixedWindowFallback(limits)
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" {
+			continue
+		}
+		logger.Debugf("setting cache key to %d hits: %s", count, cacheKey.Key)
+
+		if limits[i].Algorithm == config.Concurrency {
+			this.setConcurrencySlots(cacheKey.Key, limits[i], count)
+		} else if count == 0 {
+			if err := this.client.Delete(cacheKey.Key); err != nil && err != memcache.ErrCacheMiss {
+				logger.Errorf("Failed to delete key %s: %s", cacheKey.Key, err)
+			}
+		} else {
+			err := this.client.Set(&memcache.Item{
+				Key:        cacheKey.Key,
+				Value:      []byte(strconv.FormatUint(count, 10)),
+				Expiration: this.expiration(this.baseRateLimiter.GetExpirationSeconds(limits[i])),
+			})
+			if err != nil {
+				logger.Errorf("Failed to set key %s: %s", cacheKey.Key, err)
+			}
+		}
+		// The previous window of sliding windows is removed, so that exactly count hits are counted.
+		if cacheKey.PreviousKey != "" {
+			if err := this.client.Delete(cacheKey.PreviousKey); err != nil && err != memcache.ErrCacheMiss {
+				logger.Errorf("Failed to delete key %s: %s", cacheKey.PreviousKey, err)
+			}
+		}
+		this.baseRateLimiter.EvictLocalCache(cacheKey.Key)
+	}
+
+	return this.GetQuotaStatus(ctx, request, limits)
+}
@@ -0,0 +1,49 @@
+package memcached
+
+import (
+	"github.com/bradfitz/gomemcache/memcache"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+func (this *rateLimitMemcacheImpl) Refund(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	hitsAddends := utils.GetHitsAddends(request)
+	// Token bucket and GCRA limits are counted as fixed windows, so their hits can be refunded as well.
+	limits = fixedWindowFallback(limits)
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	statuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || !limiter.CountsHitsInWindows(limits[i]) {
+			continue
+		}
+		logger.Debugf("refunding %d hits of cache key: %s", hitsAddends[i], cacheKey.Key)
+
+		// Memcache does not decrement below zero.
+		count, err := this.client.Decrement(cacheKey.Key, hitsAddends[i])
+		if err != nil && err != memcache.ErrCacheMiss {
+			logger.Errorf("Failed to refund hits of %s: %s", cacheKey.Key, err)
+		}
+		if cacheKey.PreviousKey != "" {
+			previousItems, err := this.client.GetMulti([]string{cacheKey.PreviousKey})
+			if err != nil {
+				logger.Errorf("Error multi-getting memcache keys (%s): %s", cacheKey.PreviousKey, err)
+			}
+			count += cacheKey.WeightedPreviousCount(decodeMemcacheValue(previousItems, cacheKey.PreviousKey))
+		}
+		// The key may be below the limit again.
+		this.baseRateLimiter.EvictLocalCache(cacheKey.Key)
+
+		statuses[i] = this.baseRateLimiter.GetRefundResponseDescriptorStatus(limits[i], count)
+	}
+	return statuses
+}
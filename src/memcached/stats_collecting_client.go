@@ -13,9 +13,20 @@ type statsCollectingClient struct {
 	incrementSuccess stats.Counter
 	incrementMiss    stats.Counter
 	incrementError   stats.Counter
+	decrementSuccess stats.Counter
+	decrementMiss    stats.Counter
+	decrementError   stats.Counter
 	addSuccess       stats.Counter
 	addError         stats.Counter
 	addNotStored     stats.Counter
+	touchSuccess     stats.Counter
+	touchMiss        stats.Counter
+	touchError       stats.Counter
+	setSuccess       stats.Counter
+	setError         stats.Counter
+	deleteSuccess    stats.Counter
+	deleteMiss       stats.Counter
+	deleteError      stats.Counter
 	keysRequested    stats.Counter
 	keysFound        stats.Counter
 }
@@ -28,9 +39,20 @@ func CollectStats(c Client, scope stats.Scope) Client {
 		incrementSuccess: scope.NewCounterWithTags("increment", map[string]string{"code": "success"}),
 		incrementMiss:    scope.NewCounterWithTags("increment", map[string]string{"code": "miss"}),
 		incrementError:   scope.NewCounterWithTags("increment", map[string]string{"code": "error"}),
+		decrementSuccess: scope.NewCounterWithTags("decrement", map[string]string{"code": "success"}),
+		decrementMiss:    scope.NewCounterWithTags("decrement", map[string]string{"code": "miss"}),
+		decrementError:   scope.NewCounterWithTags("decrement", map[string]string{"code": "error"}),
 		addSuccess:       scope.NewCounterWithTags("add", map[string]string{"code": "success"}),
 		addError:         scope.NewCounterWithTags("add", map[string]string{"code": "error"}),
 		addNotStored:     scope.NewCounterWithTags("add", map[string]string{"code": "not_stored"}),
+		touchSuccess:     scope.NewCounterWithTags("touch", map[string]string{"code": "success"}),
+		touchMiss:        scope.NewCounterWithTags("touch", map[string]string{"code": "miss"}),
+		touchError:       scope.NewCounterWithTags("touch", map[string]string{"code": "error"}),
+		setSuccess:       scope.NewCounterWithTags("set", map[string]string{"code": "success"}),
+		setError:         scope.NewCounterWithTags("set", map[string]string{"code": "error"}),
+		deleteSuccess:    scope.NewCounterWithTags("delete", map[string]string{"code": "success"}),
+		deleteMiss:       scope.NewCounterWithTags("delete", map[string]string{"code": "miss"}),
+		deleteError:      scope.NewCounterWithTags("delete", map[string]string{"code": "error"}),
 		keysRequested:    scope.NewCounter("keys_requested"),
 		keysFound:        scope.NewCounter("keys_found"),
 	}
@@ -78,3 +100,58 @@ func (scc statsCollectingClient) Add(item *memcache.Item) error {
 
 	return err
 }
+
+func (scc statsCollectingClient) Decrement(key string, delta uint64) (newValue uint64, err error) {
+	newValue, err = scc.c.Decrement(key, delta)
+	switch err {
+	case memcache.ErrCacheMiss:
+		scc.decrementMiss.Inc()
+	case nil:
+		scc.decrementSuccess.Inc()
+	default:
+		scc.decrementError.Inc()
+	}
+	return
+}
+
+func (scc statsCollectingClient) Touch(key string, seconds int32) error {
+	err := scc.c.Touch(key, seconds)
+
+	switch err {
+	case memcache.ErrCacheMiss:
+		scc.touchMiss.Inc()
+	case nil:
+		scc.touchSuccess.Inc()
+	default:
+		scc.touchError.Inc()
+	}
+
+	return err
+}
+
+func (scc statsCollectingClient) Set(item *memcache.Item) error {
+	err := scc.c.Set(item)
+
+	if err != nil {
+		scc.setError.Inc()
+	} else {
+		scc.setSuccess.Inc()
+	}
+
+	return err
+}
+
+func (scc statsCollectingClient) Delete(key string) error {
+	err := scc.c.Delete(key)
+
+	switch err {
+	case memcache.ErrCacheMiss:
+		scc.deleteMiss.Inc()
+	case nil:
+		scc.deleteSuccess.Inc()
+	default:
+		scc.deleteError.Inc()
+	}
+
+	return err
+}
@@ -0,0 +1,160 @@
+// The memory limiter keeps all counters in the process, so limits are enforced per instance of the service and
+// counters are lost on restart. It is meant for single instance deployments and for running the service without
+// an external cache, e.g. in development and tests.
+//
+// Each key is updated atomically, so unlike memcache all algorithms are applied exactly. The entries of the keys are
+// kept in a Store, which is a sharded map by default but may also persist them, e.g. on disk. The limiter only updates
+// them through plain Ops, which may also be applied by the replica owning a key.
+
+package memory
+
+import (
+	"io"
+	"math/rand"
+	"time"
+
+	"github.com/coocood/freecache"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/settings"
+	"github.com/envoyproxy/ratelimit/src/stats"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+type rateLimitMemoryImpl struct {
+	ops             OpApplier
+	baseRateLimiter *limiter.BaseRateLimiter
+}
+
+var _ limiter.RateLimitCache = (*rateLimitMemoryImpl)(nil)
+
+func (this *rateLimitMemoryImpl) DoLimit(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	logger.Debugf("starting cache lookup")
+
+	hitsAddends := utils.GetHitsAddends(request)
+
+	// First build a list of all cache keys that we are actually going to hit.
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeys(request, limits, hitsAddends)
+
+	// The ops of all keys are applied together, so that each replica owning some of them is called once.
+	isOverLimitWithLocalCache := make([]bool, len(cacheKeys))
+	ops := make([]Op, 0, len(cacheKeys))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" {
+			continue
+		}
+		// Token bucket, GCRA and concurrency limits keep their own state and are not counted in windows.
+		if isStateful(limits[i]) {
+			ops = append(ops, statefulOp(cacheKey.Key, limits[i], hitsAddends[i]))
+			continue
+		}
+
+		// Check if key is over the limit in local cache.
+		isOverLimitWithLocalCache[i] = this.baseRateLimiter.IsOverLimitWithLocalCache(cacheKey.Key)
+		if isOverLimitWithLocalCache[i] {
+			continue
+		}
+		logger.Debugf("increasing cache key: %s", cacheKey.Key)
+		// The counter expires along with the window of its limit.
+		ops = append(ops, Op{
+			Key:  cacheKey.Key,
+			Kind: OpIncrease,
+			Hits: hitsAddends[i],
+			Ttl:  this.baseRateLimiter.GetExpirationSeconds(limits[i]) * int64(time.Second),
+		})
+		if cacheKey.PreviousKey != "" {
+			ops = append(ops, Op{Key: cacheKey.PreviousKey, Kind: OpCount})
+		}
+	}
+	results := this.ops.Apply(ctx, ops)
+
+	responseDescriptorStatuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key != "" && isStateful(limits[i]) {
+			responseDescriptorStatuses[i] = this.statefulStatus(limits[i], results[0], hitsAddends[i])
+			results = results[1:]
+			continue
+		}
+
+		var limitBeforeIncrease uint64
+		if cacheKey.Key != "" && !isOverLimitWithLocalCache[i] {
+			limitBeforeIncrease = results[0].Count
+			results = results[1:]
+			if cacheKey.PreviousKey != "" {
+				limitBeforeIncrease += cacheKey.WeightedPreviousCount(results[0].Count)
+				results = results[1:]
+			}
+		}
+		limitAfterIncrease := limitBeforeIncrease + hitsAddends[i]
+
+		limitInfo := limiter.NewRateLimitInfo(limits[i], limitBeforeIncrease, limitAfterIncrease, 0, 0)
+
+		responseDescriptorStatuses[i] = this.baseRateLimiter.GetResponseDescriptorStatus(cacheKey.Key,
+			limitInfo, isOverLimitWithLocalCache[i], hitsAddends[i])
+	}
+
+	return responseDescriptorStatuses
+}
+
+// Hits are counted synchronously, so there is nothing to flush.
+func (this *rateLimitMemoryImpl) Flush() {}
+
+// @param timeSource supplies the time of the windows and expirations.
+// @param shards supplies the number of shards of the map of keys.
+// @param sweepInterval supplies the interval expired keys are removed at, 0 to only ignore them on access.
+// @return the cache, and the closer stopping the removal of expired keys.
+func NewRateLimitCacheImpl(timeSource utils.TimeSource, jitterRand *rand.Rand, expirationJitterMaxSeconds int64,
+	localCache *freecache.Cache, statsManager stats.Manager, nearLimitRatio float32, cacheKeyPrefix string, shards int,
+	sweepInterval time.Duration,
+) (limiter.RateLimitCache, io.Closer) {
+	store := newMapStore(timeSource, shards, sweepInterval)
+	return NewRateLimitCacheImplWithStore(store, timeSource, jitterRand, expirationJitterMaxSeconds, localCache,
+		statsManager, nearLimitRatio, cacheKeyPrefix), store
+}
+
+// Creates a cache keeping the entries of the keys in the given store.
+// @param store supplies the store of the entries, which needs to expire them by the time of timeSource.
+func NewRateLimitCacheImplWithStore(store Store, timeSource utils.TimeSource, jitterRand *rand.Rand,
+	expirationJitterMaxSeconds int64, localCache *freecache.Cache, statsManager stats.Manager, nearLimitRatio float32,
+	cacheKeyPrefix string,
+) limiter.RateLimitCache {
+	return NewRateLimitCacheImplWithApplier(NewStoreApplier(store), timeSource, jitterRand, expirationJitterMaxSeconds,
+		localCache, statsManager, nearLimitRatio, cacheKeyPrefix)
+}
+
+// Creates a cache applying the ops on the entries of the keys with the given applier.
+// @param ops supplies the applier of the ops, e.g. on the replicas owning the keys.
+func NewRateLimitCacheImplWithApplier(ops OpApplier, timeSource utils.TimeSource, jitterRand *rand.Rand,
+	expirationJitterMaxSeconds int64, localCache *freecache.Cache, statsManager stats.Manager, nearLimitRatio float32,
+	cacheKeyPrefix string,
+) limiter.RateLimitCache {
+	return &rateLimitMemoryImpl{
+		ops: ops,
+		baseRateLimiter: limiter.NewBaseRateLimit(timeSource, jitterRand, expirationJitterMaxSeconds, localCache,
+			nearLimitRatio, cacheKeyPrefix, statsManager),
+	}
+}
+
+func NewRateLimitCacheImplFromSettings(s settings.Settings, timeSource utils.TimeSource, jitterRand *rand.Rand,
+	localCache *freecache.Cache, statsManager stats.Manager,
+) (limiter.RateLimitCache, io.Closer) {
+	return NewRateLimitCacheImpl(
+		timeSource,
+		jitterRand,
+		s.ExpirationJitterMaxSeconds,
+		localCache,
+		statsManager,
+		s.NearLimitRatio,
+		s.CacheKeyPrefix,
+		s.MemoryShards,
+		s.MemorySweepInterval,
+	)
+}
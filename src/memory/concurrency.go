@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"time"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Removes the expired leases of a concurrency limit.
+// @return the number of slots still held.
+func (this *Entry) expireLeases(nowMicros int64) uint64 {
+	expired := 0
+	for expired < len(this.Leases) && this.Leases[expired].ExpiresAt <= nowMicros {
+		expired++
+	}
+	this.Leases = this.Leases[expired:]
+
+	var held uint64
+	for _, l := range this.Leases {
+		held += l.Slots
+	}
+	return held
+}
+
+// Acquires Hits slots of a concurrency limit. Each acquisition is a lease lasting one window of the limit.
+func (this Op) acquireSlots(e *Entry, now int64) OpResult {
+	nowMicros := now / int64(time.Microsecond)
+	capacity := uint64(this.Capacity)
+	held := e.expireLeases(nowMicros)
+
+	var result OpResult
+	result.Allowed = held+this.Hits <= capacity
+	if (result.Allowed || this.ShadowMode) && this.Hits > 0 {
+		e.Leases = append(e.Leases, Lease{
+			ExpiresAt: nowMicros + this.Ttl/int64(time.Microsecond),
+			Slots:     this.Hits,
+		})
+		held += this.Hits
+		// The lease just added expires last.
+		e.ExpiresAt = now + this.Ttl
+	}
+	result.Count = held
+
+	if held >= capacity && len(e.Leases) > 0 {
+		result.Wait = max(0, e.Leases[0].ExpiresAt-nowMicros)
+	}
+	return result
+}
+
+// Releases Hits slots of a concurrency limit.
+func (this Op) releaseSlots(e *Entry, now int64) OpResult {
+	e.expireLeases(now / int64(time.Microsecond))
+	// Leases are not tied to requests, so the slots closest to their expiration are released. The remaining leases
+	// expire no earlier than those of the requests still holding a slot.
+	released := this.Hits
+	for released > 0 && len(e.Leases) > 0 {
+		slots := min(released, e.Leases[0].Slots)
+		e.Leases[0].Slots -= slots
+		released -= slots
+		if e.Leases[0].Slots == 0 {
+			e.Leases = e.Leases[1:]
+		}
+	}
+
+	var held uint64
+	for _, l := range e.Leases {
+		held += l.Slots
+	}
+	return OpResult{Count: held}
+}
+
+func (this *rateLimitMemoryImpl) Release(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	hitsAddends := utils.GetHitsAddends(request)
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	ops := make([]Op, 0, len(cacheKeys))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || limits[i].Algorithm != config.Concurrency {
+			continue
+		}
+		logger.Debugf("releasing %d slots of concurrency limit: %s", hitsAddends[i], cacheKey.Key)
+		ops = append(ops, Op{Key: cacheKey.Key, Kind: OpReleaseSlots, Hits: hitsAddends[i]})
+	}
+	results := this.ops.Apply(ctx, ops)
+
+	statuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || limits[i].Algorithm != config.Concurrency {
+			continue
+		}
+		held := results[0].Count
+		results = results[1:]
+
+		capacity := uint64(limits[i].Limit.RequestsPerUnit)
+		statuses[i] = &pb.RateLimitResponse_DescriptorStatus{
+			Code:           pb.RateLimitResponse_OK,
+			CurrentLimit:   limits[i].Limit,
+			LimitRemaining: uint32(capacity - min(held, capacity)),
+		}
+	}
+	return statuses
+}
@@ -0,0 +1,27 @@
+package memory
+
+import (
+	"math"
+	"time"
+)
+
+// Applies Hits hits to a GCRA limit, stored as the theoretical arrival time of the next hit.
+func (this Op) applyGcra(e *Entry, now int64) OpResult {
+	nowMicros := float64(now / int64(time.Microsecond))
+	tolerance := this.Capacity * this.Interval
+	tat := math.Max(e.Tat, nowMicros)
+
+	var result OpResult
+	newTat := tat + float64(this.Hits)*this.Interval
+	if newTat-tolerance <= nowMicros {
+		result.Allowed = true
+		tat = newTat
+		e.Tat = tat
+		// A theoretical arrival time in the past admits a full burst, so the key only needs to be kept until then.
+		e.ExpiresAt = now + int64(math.Ceil(tat-nowMicros))*int64(time.Microsecond) + int64(time.Millisecond)
+	}
+
+	result.Remaining = math.Max(0, math.Floor((nowMicros-(tat-tolerance))/this.Interval))
+	result.Wait = int64(math.Max(0, math.Ceil(tat-tolerance+this.Interval-nowMicros)))
+	return result
+}
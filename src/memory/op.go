@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"golang.org/x/net/context"
+)
+
+type OpKind uint8
+
+const (
+	// Reads the counter of a key.
+	OpCount OpKind = iota
+	// Adds Hits to the counter of a key, which expires Ttl after it was created.
+	OpIncrease
+	// Subtracts Hits from the counter of a key, without dropping below zero.
+	OpRefund
+	// Sets the counter of a key to Hits, expiring Ttl later.
+	OpSetCount
+	// Removes a key.
+	OpDelete
+	// Takes Hits tokens from a token bucket.
+	OpTakeTokens
+	// Applies Hits hits to a GCRA limit.
+	OpGcra
+	// Acquires Hits slots of a concurrency limit, each lease lasting Ttl.
+	OpAcquireSlots
+	// Releases Hits slots of a concurrency limit.
+	OpReleaseSlots
+)
+
+// Operation on the entry of a key. Ops only carry plain values, so that they can be applied by another replica.
+type Op struct {
+	Key  string `json:"k"`
+	Kind OpKind `json:"o"`
+	// Hits, tokens or slots of the op.
+	Hits uint64 `json:"h,omitempty"`
+	// Nanoseconds a new counter or lease lasts.
+	Ttl int64 `json:"t,omitempty"`
+	// Size of a token bucket, burst of a GCRA limit, or slots of a concurrency limit.
+	Capacity float64 `json:"c,omitempty"`
+	// Microseconds it takes to refill one token of a token bucket or GCRA limit.
+	Interval float64 `json:"i,omitempty"`
+	// Whether slots are acquired over the limit of a concurrency limit.
+	ShadowMode bool `json:"s,omitempty"`
+}
+
+type OpResult struct {
+	// Counter read, before an increase, after a refund or set, or slots held by a concurrency limit.
+	Count uint64 `json:"c,omitempty"`
+	// Whether the tokens, hits or slots were admitted.
+	Allowed bool `json:"a,omitempty"`
+	// Tokens left in a token bucket, or hits left of a GCRA limit.
+	Remaining float64 `json:"r,omitempty"`
+	// Microseconds until the next token, hit or slot is available.
+	Wait int64 `json:"w,omitempty"`
+}
+
+// Applies ops to the entries of keys, e.g. in a local Store or on the replicas owning the keys.
+type OpApplier interface {
+	// @param ctx supplies the context bounding the calls to other replicas.
+	// @param ops supplies the ops, which are applied to each key in order.
+	// @return the result of each op.
+	Apply(ctx context.Context, ops []Op) []OpResult
+}
+
+// Applies ops to the entry of a key. Results are assigned rather than accumulated, as updates may be retried.
+func (this Op) apply(e *Entry, now int64) OpResult {
+	switch this.Kind {
+	case OpIncrease:
+		if e.ExpiresAt == 0 {
+			e.ExpiresAt = now + this.Ttl
+		}
+		count := e.Count
+		e.Count += this.Hits
+		return OpResult{Count: count}
+	case OpRefund:
+		// The counter keeps its expiration.
+		e.Count -= min(e.Count, this.Hits)
+		return OpResult{Count: e.Count}
+	case OpSetCount:
+		e.Count = this.Hits
+		e.ExpiresAt = now + this.Ttl
+		return OpResult{Count: e.Count}
+	case OpTakeTokens:
+		return this.takeTokens(e, now)
+	case OpGcra:
+		return this.applyGcra(e, now)
+	case OpAcquireSlots:
+		return this.acquireSlots(e, now)
+	case OpReleaseSlots:
+		return this.releaseSlots(e, now)
+	}
+	return OpResult{}
+}
+
+type storeApplier struct {
+	store Store
+}
+
+func (this storeApplier) Apply(ctx context.Context, ops []Op) []OpResult {
+	results := make([]OpResult, len(ops))
+	for i, op := range ops {
+		switch op.Kind {
+		case OpCount:
+			results[i].Count = this.store.Count(op.Key)
+		case OpDelete:
+			this.store.Delete(op.Key)
+		default:
+			this.store.Update(op.Key, func(e *Entry, now int64) {
+				results[i] = op.apply(e, now)
+			})
+		}
+	}
+	return results
+}
+
+// Creates an applier of ops to the entries kept in a store.
+func NewStoreApplier(store Store) OpApplier {
+	return storeApplier{store: store}
+}
@@ -0,0 +1,106 @@
+package memory
+
+import (
+	"time"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+)
+
+func (this *rateLimitMemoryImpl) GetQuotaStatus(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*limiter.QuotaStatus {
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	ops := make([]Op, 0, len(cacheKeys))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" {
+			continue
+		}
+		// Stateful limits are evaluated for no hits, which refreshes their state without taking any tokens or slots.
+		if isStateful(limits[i]) {
+			ops = append(ops, statefulOp(cacheKey.Key, limits[i], 0))
+			continue
+		}
+		ops = append(ops, Op{Key: cacheKey.Key, Kind: OpCount})
+		if cacheKey.PreviousKey != "" {
+			ops = append(ops, Op{Key: cacheKey.PreviousKey, Kind: OpCount})
+		}
+	}
+	results := this.ops.Apply(ctx, ops)
+
+	statuses := make([]*limiter.QuotaStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" {
+			continue
+		}
+		if isStateful(limits[i]) {
+			statuses[i] = limiter.GetScriptedQuotaStatus(limits[i], this.statefulStatus(limits[i], results[0], 0))
+			results = results[1:]
+			continue
+		}
+		count := results[0].Count
+		results = results[1:]
+		if cacheKey.PreviousKey != "" {
+			count += cacheKey.WeightedPreviousCount(results[0].Count)
+			results = results[1:]
+		}
+		statuses[i] = this.baseRateLimiter.GetCounterQuotaStatus(limits[i], count)
+	}
+	return statuses
+}
+
+func (this *rateLimitMemoryImpl) SetCounters(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+	count uint64,
+) []*limiter.QuotaStatus {
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	isSet := make([]bool, len(cacheKeys))
+	ops := make([]Op, 0, len(cacheKeys))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || (count > 0 && !limiter.CountsHitsInWindows(limits[i])) {
+			continue
+		}
+		logger.Debugf("setting cache key to %d hits: %s", count, cacheKey.Key)
+		isSet[i] = true
+
+		// Resetting a limit removes the state of all algorithms, and the previous window of sliding windows is removed
+		// so that exactly count hits are counted.
+		if count == 0 {
+			ops = append(ops, Op{Key: cacheKey.Key, Kind: OpDelete})
+		} else {
+			ops = append(ops, Op{
+				Key:  cacheKey.Key,
+				Kind: OpSetCount,
+				Hits: count,
+				Ttl:  this.baseRateLimiter.GetExpirationSeconds(limits[i]) * int64(time.Second),
+			})
+		}
+		if cacheKey.PreviousKey != "" {
+			ops = append(ops, Op{Key: cacheKey.PreviousKey, Kind: OpDelete})
+		}
+	}
+	this.ops.Apply(ctx, ops)
+	for i, cacheKey := range cacheKeys {
+		if isSet[i] {
+			this.baseRateLimiter.EvictLocalCache(cacheKey.Key)
+		}
+	}
+
+	statuses := this.GetQuotaStatus(ctx, request, limits)
+	for i := range statuses {
+		if !isSet[i] {
+			statuses[i] = nil
+		}
+	}
+	return statuses
+}
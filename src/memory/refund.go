@@ -0,0 +1,53 @@
+package memory
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+func (this *rateLimitMemoryImpl) Refund(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	hitsAddends := utils.GetHitsAddends(request)
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	ops := make([]Op, 0, len(cacheKeys))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || !limiter.CountsHitsInWindows(limits[i]) {
+			continue
+		}
+		logger.Debugf("refunding %d hits of cache key: %s", hitsAddends[i], cacheKey.Key)
+
+		// The counter does not drop below zero and keeps its expiration.
+		ops = append(ops, Op{Key: cacheKey.Key, Kind: OpRefund, Hits: hitsAddends[i]})
+		if cacheKey.PreviousKey != "" {
+			ops = append(ops, Op{Key: cacheKey.PreviousKey, Kind: OpCount})
+		}
+	}
+	results := this.ops.Apply(ctx, ops)
+
+	statuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || !limiter.CountsHitsInWindows(limits[i]) {
+			continue
+		}
+		count := results[0].Count
+		results = results[1:]
+		if cacheKey.PreviousKey != "" {
+			count += cacheKey.WeightedPreviousCount(results[0].Count)
+			results = results[1:]
+		}
+		// The key may be below the limit again.
+		this.baseRateLimiter.EvictLocalCache(cacheKey.Key)
+
+		statuses[i] = this.baseRateLimiter.GetRefundResponseDescriptorStatus(limits[i], count)
+	}
+	return statuses
+}
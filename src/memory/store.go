@@ -0,0 +1,174 @@
+package memory
+
+import (
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
+
+	logger "github.com/sirupsen/logrus"
+
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// State of a key. Each algorithm only uses its own fields.
+type Entry struct {
+	// Hits of a fixed or sliding window counter.
+	Count uint64 `json:"c,omitempty"`
+	// Tokens of a token bucket, and the unix time in microseconds they were last refilled at.
+	Tokens     float64 `json:"t,omitempty"`
+	RefilledAt int64   `json:"r,omitempty"`
+	// Theoretical arrival time of the next hit of a GCRA limit, in unix microseconds.
+	Tat float64 `json:"a,omitempty"`
+	// Leases of the slots of a concurrency limit, earliest expiration first.
+	Leases []Lease `json:"l,omitempty"`
+	// Unix time in nanoseconds the entry expires at.
+	ExpiresAt int64 `json:"e"`
+}
+
+type Lease struct {
+	// Unix time in microseconds the lease expires at.
+	ExpiresAt int64  `json:"e"`
+	Slots     uint64 `json:"s"`
+}
+
+// Storage of the entries of the limits.
+type Store interface {
+	// Calls f with the entry of a key, atomically with respect to other updates of the key. Missing and expired keys
+	// are passed as a new entry. The entry is kept if f left its expiration in the future, and removed otherwise.
+	// @param key supplies the key.
+	// @param f supplies the update, which is passed the entry and the current unix time in nanoseconds. It may be
+	// called more than once if the update is retried.
+	Update(key string, f func(e *Entry, now int64))
+
+	// Returns the counter of a key, 0 if the key is missing or expired.
+	Count(key string) uint64
+
+	// Removes a key.
+	Delete(key string)
+}
+
+type shard struct {
+	sync.Mutex
+	entries map[string]*Entry
+}
+
+// Map of keys with an expiration, split into shards with their own lock so that requests for different keys rarely
+// wait on each other. Expired entries are ignored on access and removed by a periodic sweep.
+type mapStore struct {
+	shards     []*shard
+	timeSource utils.TimeSource
+	finish     chan struct{}
+	closeOnce  sync.Once
+}
+
+var _ Store = (*mapStore)(nil)
+
+func (this *mapStore) shard(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return this.shards[h.Sum32()%uint32(len(this.shards))]
+}
+
+// Calls f with the entry of a key under the lock of its shard.
+func (this *mapStore) Update(key string, f func(e *Entry, now int64)) {
+	s := this.shard(key)
+	now := this.timeSource.UnixNanoNow()
+
+	s.Lock()
+	defer s.Unlock()
+	e, ok := s.entries[key]
+	if !ok || e.ExpiresAt <= now {
+		e = &Entry{}
+	}
+	f(e, now)
+	if e.ExpiresAt > now {
+		s.entries[key] = e
+	} else {
+		delete(s.entries, key)
+	}
+}
+
+func (this *mapStore) Count(key string) uint64 {
+	s := this.shard(key)
+	now := this.timeSource.UnixNanoNow()
+
+	s.Lock()
+	defer s.Unlock()
+	if e, ok := s.entries[key]; ok && e.ExpiresAt > now {
+		return e.Count
+	}
+	return 0
+}
+
+func (this *mapStore) Delete(key string) {
+	s := this.shard(key)
+	s.Lock()
+	defer s.Unlock()
+	delete(s.entries, key)
+}
+
+// Removes the expired entries of all shards.
+// @return the number of removed entries.
+func (this *mapStore) sweep() int {
+	now := this.timeSource.UnixNanoNow()
+	removed := 0
+	for _, s := range this.shards {
+		s.Lock()
+		for key, e := range s.entries {
+			if e.ExpiresAt <= now {
+				delete(s.entries, key)
+				removed++
+			}
+		}
+		s.Unlock()
+	}
+	return removed
+}
+
+func (this *mapStore) sweepPeriodically(d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			logger.Debugf("removed %d expired keys from memory", this.sweep())
+		case <-this.finish:
+			return
+		}
+	}
+}
+
+// Stops the periodic sweep.
+func (this *mapStore) Close() error {
+	this.closeOnce.Do(func() { close(this.finish) })
+	return nil
+}
+
+// @param timeSource supplies the time the entries expire by.
+// @param shards supplies the number of shards, at least 1.
+// @param sweepInterval supplies the interval expired entries are removed at, 0 to only ignore them on access.
+func newMapStore(timeSource utils.TimeSource, shards int, sweepInterval time.Duration) *mapStore {
+	ret := &mapStore{
+		shards:     make([]*shard, max(shards, 1)),
+		timeSource: timeSource,
+		finish:     make(chan struct{}),
+	}
+	for i := range ret.shards {
+		ret.shards[i] = &shard{entries: make(map[string]*Entry)}
+	}
+	if sweepInterval > 0 {
+		go ret.sweepPeriodically(sweepInterval)
+	}
+	return ret
+}
+
+// Creates a sharded map of keys, e.g. to keep the keys owned by this replica of the service.
+// @param timeSource supplies the time the entries expire by.
+// @param shards supplies the number of shards, at least 1.
+// @param sweepInterval supplies the interval expired entries are removed at, 0 to only ignore them on access.
+// @return the store, and the closer stopping the removal of expired keys.
+func NewMapStore(timeSource utils.TimeSource, shards int, sweepInterval time.Duration) (Store, io.Closer) {
+	store := newMapStore(timeSource, shards, sweepInterval)
+	return store, store
+}
@@ -0,0 +1,54 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+
+	mock_utils "github.com/envoyproxy/ratelimit/test/mocks/utils"
+)
+
+func TestMapStoreExpiration(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	now := int64(1000e9)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	timeSource.EXPECT().UnixNanoNow().DoAndReturn(func() int64 { return now }).AnyTimes()
+	store := newMapStore(timeSource, 4, 0)
+	defer store.Close()
+
+	store.Update("key", func(e *Entry, now int64) {
+		e.Count = 5
+		e.ExpiresAt = now + int64(time.Minute)
+	})
+	store.Update("key2", func(e *Entry, now int64) {
+		e.Count = 1
+		e.ExpiresAt = now + int64(time.Hour)
+	})
+	// Entries without an expiration in the future are not kept.
+	store.Update("key3", func(e *Entry, now int64) { e.Count = 1 })
+	assert.Equal(uint64(5), store.Count("key"))
+	assert.Equal(uint64(0), store.Count("key3"))
+
+	// Expired entries are ignored until they are swept.
+	now += int64(time.Minute)
+	assert.Equal(uint64(0), store.Count("key"))
+	store.Update("key", func(e *Entry, now int64) {
+		assert.Equal(Entry{}, *e)
+	})
+	store.Update("key4", func(e *Entry, now int64) {
+		e.Count = 1
+		e.ExpiresAt = now + int64(time.Second)
+	})
+	now += int64(time.Second)
+	assert.Equal(1, store.sweep())
+	assert.Equal(uint64(1), store.Count("key2"))
+
+	store.Delete("key2")
+	assert.Equal(uint64(0), store.Count("key2"))
+	assert.Equal(0, store.sweep())
+}
@@ -0,0 +1,91 @@
+package memory
+
+import (
+	"math"
+	"time"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Returns the number of microseconds it takes to refill one token of a token bucket or GCRA limit.
+func refillInterval(limit *config.RateLimit) float64 {
+	return float64(utils.UnitToDividerWithMultiplier(limit.Limit.Unit, limit.UnitMultiplier)) *
+		float64(time.Second/time.Microsecond) / math.Max(float64(limit.Limit.RequestsPerUnit), 1)
+}
+
+// Takes Hits tokens from the bucket of a token bucket limit. A missing bucket is a full bucket.
+func (this Op) takeTokens(e *Entry, now int64) OpResult {
+	nowMicros := now / int64(time.Microsecond)
+	if e.ExpiresAt == 0 {
+		e.Tokens = this.Capacity
+		e.RefilledAt = nowMicros
+	}
+	if nowMicros > e.RefilledAt {
+		e.Tokens = math.Min(this.Capacity, e.Tokens+float64(nowMicros-e.RefilledAt)/this.Interval)
+		e.RefilledAt = nowMicros
+	}
+	var result OpResult
+	if e.Tokens >= float64(this.Hits) {
+		e.Tokens -= float64(this.Hits)
+		result.Allowed = true
+	}
+	result.Remaining = e.Tokens
+	if e.Tokens < this.Capacity {
+		result.Wait = int64(math.Ceil((1 - math.Mod(e.Tokens, 1)) * this.Interval))
+	}
+	// The bucket only needs to be kept until it is refilled.
+	e.ExpiresAt = now + int64(math.Ceil((this.Capacity-e.Tokens)*this.Interval))*int64(time.Microsecond) +
+		int64(time.Second)
+	return result
+}
+
+// Returns whether a limit keeps its own state rather than being counted in windows.
+func isStateful(limit *config.RateLimit) bool {
+	return limit != nil &&
+		(limit.Algorithm == config.TokenBucket || limit.Algorithm == config.GCRA || limit.Algorithm == config.Concurrency)
+}
+
+// Returns the op applying hitsAddend hits to a token bucket, GCRA or concurrency limit.
+// @param key supplies the key of the state of the limit.
+// @param limit supplies the limit, for which isStateful holds.
+// @param hitsAddend supplies the number of hits, 0 to only refresh the state.
+func statefulOp(key string, limit *config.RateLimit, hitsAddend uint64) Op {
+	switch limit.Algorithm {
+	case config.GCRA:
+		logger.Debugf("applying %d hits to theoretical arrival time: %s", hitsAddend, key)
+		return Op{Key: key, Kind: OpGcra, Hits: hitsAddend, Capacity: float64(limit.Burst), Interval: refillInterval(limit)}
+	case config.Concurrency:
+		logger.Debugf("acquiring %d slots of concurrency limit: %s", hitsAddend, key)
+		return Op{
+			Key:        key,
+			Kind:       OpAcquireSlots,
+			Hits:       hitsAddend,
+			Ttl:        utils.UnitToDividerWithMultiplier(limit.Limit.Unit, limit.UnitMultiplier) * int64(time.Second),
+			Capacity:   float64(limit.Limit.RequestsPerUnit),
+			ShadowMode: limit.ShadowMode,
+		}
+	default:
+		logger.Debugf("taking %d tokens from bucket: %s", hitsAddend, key)
+		return Op{Key: key, Kind: OpTakeTokens, Hits: hitsAddend, Capacity: float64(limit.Burst), Interval: refillInterval(limit)}
+	}
+}
+
+// Builds the response descriptor status of a token bucket, GCRA or concurrency limit from the result of its op.
+// GCRA admits the same hits as a token bucket of size burst, and a concurrency limit the same hits as a bucket of its
+// free slots, so the statuses are built the same way.
+func (this *rateLimitMemoryImpl) statefulStatus(limit *config.RateLimit, result OpResult,
+	hitsAddend uint64,
+) *pb.RateLimitResponse_DescriptorStatus {
+	remaining := uint64(result.Remaining)
+	if limit.Algorithm == config.Concurrency {
+		capacity := uint64(limit.Limit.RequestsPerUnit)
+		remaining = capacity - min(result.Count, capacity)
+	}
+	return this.baseRateLimiter.GetTokenBucketResponseDescriptorStatus(limit, result.Allowed, remaining,
+		durationpb.New(time.Duration(result.Wait)*time.Microsecond), hitsAddend)
+}
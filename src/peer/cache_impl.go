@@ -0,0 +1,112 @@
+// The peer limiter lets the replicas of the service share their counters without an external cache. The replicas are
+// the members of a consistent hash ring, either a static list or resolved from DNS SRV records, and each key is owned
+// by one of them, which keeps it in memory like the memory limiter. The ops on the keys owned by other replicas are
+// forwarded to them over the gRPC server of the service, batched per replica and request.
+//
+// When replicas join or leave, the keys moving to another owner start from zero there, and expire on their previous
+// owner. While the replicas disagree on the members, e.g. until all of them resolved the SRV records again, a key may
+// be counted by two owners.
+
+package peer
+
+import (
+	"io"
+	"math/rand"
+
+	"github.com/coocood/freecache"
+	gostats "github.com/lyft/gostats"
+	logger "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/memory"
+	"github.com/envoyproxy/ratelimit/src/settings"
+	"github.com/envoyproxy/ratelimit/src/srv"
+	"github.com/envoyproxy/ratelimit/src/stats"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// @param self supplies the address of this replica among the members.
+// @param local supplies the applier of the ops on the keys owned by this replica.
+// @param virtualNodes supplies the number of points of each member on the ring.
+// @param token supplies the bearer token of the calls between the members.
+// @param dialOptions supplies the options of the connections to the other members.
+// @param scope supplies the scope of the stats of the peers.
+func newPeerApplier(self string, local memory.OpApplier, virtualNodes int, token string,
+	dialOptions []grpc.DialOption, scope gostats.Scope,
+) *peerApplier {
+	return &peerApplier{
+		self:         self,
+		local:        local,
+		virtualNodes: virtualNodes,
+		token:        token,
+		dialOptions:  dialOptions,
+		stats:        newPeerStats(scope),
+		ring:         newRing(nil, virtualNodes),
+		finish:       make(chan struct{}),
+	}
+}
+
+func newPeerApplierFromSettings(s settings.Settings, local memory.OpApplier, scope gostats.Scope,
+	resolver srv.SrvResolver,
+) *peerApplier {
+	if s.PeerSrv != "" && len(s.PeerAddresses) > 0 {
+		panic(PeerError("Both PEER_ADDRESSES and PEER_SRV are set"))
+	}
+	if s.PeerSelfAddress == "" {
+		panic(PeerError("PEER_SELF_ADDRESS is not set"))
+	}
+	// The peer service is registered on the gRPC server of the service, so it must not be callable by its clients.
+	if s.PeerToken == "" {
+		panic(PeerError("PEER_TOKEN is not set"))
+	}
+
+	credentialsOption := grpc.WithTransportCredentials(insecure.NewCredentials())
+	if s.PeerTls {
+		credentialsOption = grpc.WithTransportCredentials(credentials.NewTLS(s.PeerTlsConfig))
+	}
+	ret := newPeerApplier(s.PeerSelfAddress, local, s.PeerVirtualNodes, s.PeerToken,
+		[]grpc.DialOption{credentialsOption}, scope)
+
+	if s.PeerSrv == "" {
+		logger.Debugf("Using PEER_ADDRESSES: %v", s.PeerAddresses)
+		ret.setMembers(s.PeerAddresses)
+		return ret
+	}
+	logger.Debugf("Using PEER_SRV: %v", s.PeerSrv)
+	if err := ret.refreshMembers(s.PeerSrv, resolver); err != nil {
+		errorText := "Unable to fetch peers from SRV"
+		logger.Errorf(errorText)
+		panic(PeerError(errorText))
+	}
+	if s.PeerSrvRefresh > 0 {
+		logger.Infof("refreshing peers every: %v milliseconds", s.PeerSrvRefresh.Milliseconds())
+		go ret.refreshMembersPeriodically(s.PeerSrv, s.PeerSrvRefresh, resolver)
+	}
+	return ret
+}
+
+// @param grpcServer supplies the gRPC server of the service, on which the other replicas call this replica. The peer
+// service is registered on it, so it needs to be started afterwards.
+// @param scope supplies the scope of the stats of the peers.
+// @return the cache, and the closer of the connections to the other replicas and of the local store.
+func NewRateLimitCacheImplFromSettings(s settings.Settings, grpcServer *grpc.Server, scope gostats.Scope,
+	timeSource utils.TimeSource, jitterRand *rand.Rand, localCache *freecache.Cache, statsManager stats.Manager,
+) (limiter.RateLimitCache, io.Closer) {
+	store, storeCloser := memory.NewMapStore(timeSource, s.MemoryShards, s.MemorySweepInterval)
+	applier := newPeerApplierFromSettings(s, memory.NewStoreApplier(store), scope, new(srv.DnsSrvResolver))
+	grpcServer.RegisterService(&serviceDesc, applier)
+
+	return memory.NewRateLimitCacheImplWithApplier(
+		applier,
+		timeSource,
+		jitterRand,
+		s.ExpirationJitterMaxSeconds,
+		localCache,
+		statsManager,
+		s.NearLimitRatio,
+		s.CacheKeyPrefix,
+	), &utils.MultiCloser{Closers: []io.Closer{applier, storeCloser}}
+}
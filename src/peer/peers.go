@@ -0,0 +1,257 @@
+package peer
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	gostats "github.com/lyft/gostats"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/memory"
+	"github.com/envoyproxy/ratelimit/src/srv"
+)
+
+type PeerError string
+
+func (e PeerError) Error() string {
+	return string(e)
+}
+
+// A peer which cannot be reached is an unavailable cache like any other, so the failure modes of the limits apply.
+func (e PeerError) CacheUnavailable() {}
+
+var _ limiter.CacheUnavailableError = PeerError("")
+
+type peerStats struct {
+	localOps          gostats.Counter
+	forwardedOps      gostats.Counter
+	forwardErrors     gostats.Counter
+	servedOps         gostats.Counter
+	members           gostats.Gauge
+	membershipChanges gostats.Counter
+}
+
+func newPeerStats(scope gostats.Scope) peerStats {
+	return peerStats{
+		localOps:          scope.NewCounter("local_ops"),
+		forwardedOps:      scope.NewCounter("forwarded_ops"),
+		forwardErrors:     scope.NewCounter("forward_error"),
+		servedOps:         scope.NewCounter("served_ops"),
+		members:           scope.NewGauge("members"),
+		membershipChanges: scope.NewCounter("membership_change"),
+	}
+}
+
+// Applies the ops on the keys owned by this replica to its local store, and forwards the ops on all other keys to the
+// replicas owning them. The members, their ring and connections are replaced together on membership changes.
+type peerApplier struct {
+	self         string
+	local        memory.OpApplier
+	virtualNodes int
+	token        string
+	dialOptions  []grpc.DialOption
+	stats        peerStats
+
+	mu      sync.RWMutex
+	members []string
+	ring    *ring
+	conns   map[string]*grpc.ClientConn
+
+	finish    chan struct{}
+	closeOnce sync.Once
+}
+
+var _ memory.OpApplier = (*peerApplier)(nil)
+
+func (this *peerApplier) Apply(ctx context.Context, ops []memory.Op) []memory.OpResult {
+	this.mu.RLock()
+	ring, conns := this.ring, this.conns
+	this.mu.RUnlock()
+
+	// Indexes of the ops owned by each member, the ops of each key keeping their order.
+	owned := make(map[string][]int)
+	for i, op := range ops {
+		owner := ring.owner(op.Key)
+		owned[owner] = append(owned[owner], i)
+	}
+
+	results := make([]memory.OpResult, len(ops))
+	var wg sync.WaitGroup
+	var errMu sync.Mutex
+	var err error
+	for owner, indexes := range owned {
+		// Without any members, e.g. until they are resolved, the keys are all kept locally.
+		if owner == this.self || owner == "" {
+			continue
+		}
+		wg.Add(1)
+		go func(owner string, indexes []int) {
+			defer wg.Done()
+			if forwardErr := this.forward(ctx, conns[owner], ops, indexes, results); forwardErr != nil {
+				this.stats.forwardErrors.Inc()
+				errMu.Lock()
+				err = fmt.Errorf("failed to forward ops to peer %s: %w", owner, forwardErr)
+				errMu.Unlock()
+			}
+		}(owner, indexes)
+	}
+	wg.Wait()
+
+	// The request fails, so the ops on the keys owned by this replica are not applied. The ops forwarded to the
+	// other members which could be reached are applied all the same.
+	if err != nil {
+		panic(PeerError(err.Error()))
+	}
+	for _, owner := range []string{this.self, ""} {
+		if indexes, ok := owned[owner]; ok {
+			this.stats.localOps.Add(uint64(len(indexes)))
+			this.applyLocally(ctx, ops, indexes, results)
+		}
+	}
+	return results
+}
+
+func (this *peerApplier) applyLocally(ctx context.Context, ops []memory.Op, indexes []int, results []memory.OpResult) {
+	owned := make([]memory.Op, len(indexes))
+	for i, index := range indexes {
+		owned[i] = ops[index]
+	}
+	for i, result := range this.local.Apply(ctx, owned) {
+		results[indexes[i]] = result
+	}
+}
+
+// Sends the ops of indexes to the member owning them, and fills in their results.
+func (this *peerApplier) forward(ctx context.Context, conn *grpc.ClientConn, ops []memory.Op, indexes []int,
+	results []memory.OpResult,
+) error {
+	if conn == nil {
+		return PeerError("no connection")
+	}
+	request := &applyRequest{Ops: make([]memory.Op, len(indexes))}
+	for i, index := range indexes {
+		request.Ops[i] = ops[index]
+	}
+	this.stats.forwardedOps.Add(uint64(len(indexes)))
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+this.token)
+	response := &applyResponse{}
+	if err := conn.Invoke(ctx, applyMethod, request, response, grpc.CallContentSubtype(codecName)); err != nil {
+		return err
+	}
+	if len(response.Results) != len(indexes) {
+		return PeerError(fmt.Sprintf("expected %d results, got %d", len(indexes), len(response.Results)))
+	}
+	for i, index := range indexes {
+		results[index] = response.Results[i]
+	}
+	return nil
+}
+
+func (this *peerApplier) peerToken() string {
+	return this.token
+}
+
+// Applies the ops forwarded by another replica. They are never forwarded again, even if the members of the replicas
+// differ, so that ops cannot loop between replicas.
+func (this *peerApplier) serve(ctx context.Context, request *applyRequest) (*applyResponse, error) {
+	this.stats.servedOps.Add(uint64(len(request.Ops)))
+	return &applyResponse{Results: this.local.Apply(ctx, request.Ops)}, nil
+}
+
+// Replaces the members if they changed. The keys moving to another owner start from zero there.
+// @param members supplies the addresses of the members, in any order.
+func (this *peerApplier) setMembers(members []string) {
+	members = slices.DeleteFunc(slices.Clone(members), func(m string) bool { return m == "" })
+	slices.Sort(members)
+	members = slices.Compact(members)
+
+	this.mu.Lock()
+	if slices.Equal(members, this.members) {
+		this.mu.Unlock()
+		return
+	}
+	conns := make(map[string]*grpc.ClientConn, len(members))
+	var removed []*grpc.ClientConn
+	for _, member := range members {
+		if member == this.self {
+			continue
+		}
+		if conn, ok := this.conns[member]; ok {
+			conns[member] = conn
+			continue
+		}
+		// Connections are established on the first call.
+		conn, err := grpc.NewClient(member, this.dialOptions...)
+		if err != nil {
+			logger.Errorf("Unable to create connection to peer %s: %s", member, err)
+			continue
+		}
+		conns[member] = conn
+	}
+	for member, conn := range this.conns {
+		if _, ok := conns[member]; !ok {
+			removed = append(removed, conn)
+		}
+	}
+	this.members, this.ring, this.conns = members, newRing(members, this.virtualNodes), conns
+	this.mu.Unlock()
+
+	logger.Infof("peers changed to %v", members)
+	if len(members) > 0 && !slices.Contains(members, this.self) {
+		logger.Warnf("this replica %s is not among the peers, so it does not own any keys", this.self)
+	}
+	this.stats.members.Set(uint64(len(members)))
+	this.stats.membershipChanges.Inc()
+	// Calls still in flight to the members which left fail like calls to an unavailable cache.
+	for _, conn := range removed {
+		conn.Close()
+	}
+}
+
+func (this *peerApplier) refreshMembers(peerSrv string, resolver srv.SrvResolver) error {
+	members, err := resolver.ServerStringsFromSrv(peerSrv)
+	if err != nil {
+		return err
+	}
+	this.setMembers(members)
+	return nil
+}
+
+func (this *peerApplier) refreshMembersPeriodically(peerSrv string, d time.Duration, resolver srv.SrvResolver) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			// The members are kept until they are resolved again.
+			if err := this.refreshMembers(peerSrv, resolver); err != nil {
+				logger.Warnf("failed to refresh peers: %s", err)
+			} else {
+				logger.Debug("refreshed peers")
+			}
+		case <-this.finish:
+			return
+		}
+	}
+}
+
+// Stops refreshing the members and closes the connections to them.
+func (this *peerApplier) Close() error {
+	this.closeOnce.Do(func() {
+		close(this.finish)
+		this.mu.Lock()
+		defer this.mu.Unlock()
+		for _, conn := range this.conns {
+			conn.Close()
+		}
+		this.conns = nil
+	})
+	return nil
+}
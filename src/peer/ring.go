@@ -0,0 +1,65 @@
+package peer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// Consistent hash ring of the members. Each member is placed at a number of points of the ring, and a key is owned by
+// the member of the first point at or after the hash of the key. A membership change only moves the keys of the points
+// of the members that joined or left.
+type ring struct {
+	points []uint64
+	// Member of each point.
+	owners []string
+}
+
+func hash(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// @param members supplies the members, each placed at virtualNodes points.
+// @param virtualNodes supplies the number of points of each member, at least 1.
+func newRing(members []string, virtualNodes int) *ring {
+	virtualNodes = max(virtualNodes, 1)
+	type point struct {
+		hash  uint64
+		owner string
+	}
+	points := make([]point, 0, len(members)*virtualNodes)
+	for _, member := range members {
+		for i := 0; i < virtualNodes; i++ {
+			points = append(points, point{hash(member + "#" + strconv.Itoa(i)), member})
+		}
+	}
+	// Members sharing a point are ordered by name, so that all replicas agree on its owner.
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].hash < points[j].hash || (points[i].hash == points[j].hash && points[i].owner < points[j].owner)
+	})
+
+	ret := &ring{
+		points: make([]uint64, len(points)),
+		owners: make([]string, len(points)),
+	}
+	for i, p := range points {
+		ret.points[i] = p.hash
+		ret.owners[i] = p.owner
+	}
+	return ret
+}
+
+// @return the member owning a key, "" if the ring has no members.
+func (this *ring) owner(key string) string {
+	if len(this.points) == 0 {
+		return ""
+	}
+	h := hash(key)
+	i := sort.Search(len(this.points), func(i int) bool { return this.points[i] >= h })
+	if i == len(this.points) {
+		i = 0
+	}
+	return this.owners[i]
+}
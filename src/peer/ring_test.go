@@ -0,0 +1,100 @@
+package peer
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	gostats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/ratelimit/src/settings"
+	mock_srv "github.com/envoyproxy/ratelimit/test/mocks/srv"
+)
+
+func TestRingOwnership(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("", newRing(nil, 100).owner("domain_key_value_0"))
+
+	members := []string{"10.0.0.1:8081", "10.0.0.2:8081", "10.0.0.3:8081"}
+	r := newRing(members, 100)
+	owners := make(map[string]string)
+	counts := make(map[string]int)
+	for i := 0; i < 3000; i++ {
+		key := "domain_key_value" + strconv.Itoa(i) + "_0"
+		owners[key] = r.owner(key)
+		counts[owners[key]]++
+	}
+	for _, member := range members {
+		assert.Greater(counts[member], 600, member)
+	}
+
+	// A joining member only takes over keys, the others keep all the keys they do not give to it.
+	r = newRing(append(members, "10.0.0.4:8081"), 100)
+	moved := 0
+	for key, owner := range owners {
+		if r.owner(key) != owner {
+			assert.Equal("10.0.0.4:8081", r.owner(key))
+			moved++
+		}
+	}
+	assert.Greater(moved, 450)
+	assert.Less(moved, 1050)
+}
+
+func TestMembersFromSrv(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	resolver := mock_srv.NewMockSrvResolver(controller)
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	var s settings.Settings
+	s.PeerSelfAddress = "ratelimit-0.ratelimit.:8081"
+	s.PeerSrv = "_grpc._tcp.ratelimit."
+	s.PeerVirtualNodes = 10
+
+	assert.PanicsWithValue(PeerError("PEER_TOKEN is not set"), func() {
+		newPeerApplierFromSettings(s, nil, statsStore, resolver)
+	})
+	s.PeerToken = "token"
+
+	resolver.EXPECT().ServerStringsFromSrv(s.PeerSrv).Return(nil, errors.New("no such host"))
+	assert.PanicsWithValue(PeerError("Unable to fetch peers from SRV"), func() {
+		newPeerApplierFromSettings(s, nil, statsStore, resolver)
+	})
+
+	resolver.EXPECT().ServerStringsFromSrv(s.PeerSrv).Return(
+		[]string{"ratelimit-1.ratelimit.:8081", "ratelimit-0.ratelimit.:8081"}, nil)
+	applier := newPeerApplierFromSettings(s, nil, statsStore, resolver)
+	defer applier.Close()
+	assert.Equal([]string{"ratelimit-0.ratelimit.:8081", "ratelimit-1.ratelimit.:8081"}, applier.members)
+	assert.Len(applier.conns, 1)
+	conn := applier.conns["ratelimit-1.ratelimit.:8081"]
+
+	// Unchanged members keep their ring and connections.
+	resolver.EXPECT().ServerStringsFromSrv(s.PeerSrv).Return(
+		[]string{"ratelimit-0.ratelimit.:8081", "ratelimit-1.ratelimit.:8081", "ratelimit-1.ratelimit.:8081"}, nil)
+	r := applier.ring
+	assert.Nil(applier.refreshMembers(s.PeerSrv, resolver))
+	assert.Same(r, applier.ring)
+
+	resolver.EXPECT().ServerStringsFromSrv(s.PeerSrv).Return(
+		[]string{"ratelimit-0.ratelimit.:8081", "ratelimit-1.ratelimit.:8081", "ratelimit-2.ratelimit.:8081"}, nil)
+	assert.Nil(applier.refreshMembers(s.PeerSrv, resolver))
+	assert.Len(applier.conns, 2)
+	assert.Same(conn, applier.conns["ratelimit-1.ratelimit.:8081"])
+
+	// Failed lookups keep the members.
+	resolver.EXPECT().ServerStringsFromSrv(s.PeerSrv).Return(nil, errors.New("timeout"))
+	assert.NotNil(applier.refreshMembers(s.PeerSrv, resolver))
+	assert.Len(applier.members, 3)
+
+	resolver.EXPECT().ServerStringsFromSrv(s.PeerSrv).Return([]string{"ratelimit-0.ratelimit.:8081"}, nil)
+	assert.Nil(applier.refreshMembers(s.PeerSrv, resolver))
+	assert.Empty(applier.conns)
+	assert.Equal(uint64(1), statsStore.NewGauge("members").Value())
+	assert.Equal(uint64(3), statsStore.NewCounter("membership_change").Value())
+}
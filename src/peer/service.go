@@ -0,0 +1,102 @@
+package peer
+
+import (
+	"encoding/json"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/envoyproxy/ratelimit/src/memory"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// The ops are plain values, so they are sent as JSON rather than requiring generated protobuf messages. The codec is
+// chosen by the content subtype of the calls, so it does not affect the other services of the gRPC server.
+const codecName = "ratelimit-peer-json"
+
+const applyMethod = "/ratelimit.peer.Peer/Apply"
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type applyRequest struct {
+	Ops []memory.Op `json:"ops"`
+}
+
+type applyResponse struct {
+	Results []memory.OpResult `json:"results"`
+}
+
+type peerServer interface {
+	// @return the bearer token the calls of the other members must carry.
+	peerToken() string
+	serve(ctx context.Context, request *applyRequest) (*applyResponse, error)
+}
+
+// The peer service shares the gRPC server of the service, so only the other members, which hold the peer token, may
+// call it.
+func authenticate(ctx context.Context, token string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, authorization := range md.Get("authorization") {
+		if utils.IsBearerTokenValid(authorization, token) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid peer token")
+}
+
+func applyHandler(srv any, ctx context.Context, dec func(any) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (any, error) {
+	if err := authenticate(ctx, srv.(peerServer).peerToken()); err != nil {
+		return nil, err
+	}
+	request := new(applyRequest)
+	if err := dec(request); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(peerServer).serve(ctx, request)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: applyMethod,
+	}
+	handler := func(ctx context.Context, request any) (any, error) {
+		return srv.(peerServer).serve(ctx, request.(*applyRequest))
+	}
+	return interceptor(ctx, request, info, handler)
+}
+
+// Service the replicas forward the ops on the keys they do not own to.
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: "ratelimit.peer.Peer",
+	HandlerType: (*peerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Apply",
+			Handler:    applyHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "src/peer/service.go",
+}
@@ -59,6 +59,12 @@ func (p *FileProvider) sendEvent() {
 			continue
 		}
 
+		if config.IsOverridesFile(key) {
+			overrides := config.ConfigFileContentToOverrides(key, snapshot.Get(key))
+			files = append(files, config.RateLimitConfigToLoad{Name: key, Overrides: overrides})
+			continue
+		}
+
 		configYaml := config.ConfigFileContentToYaml(key, snapshot.Get(key))
 		files = append(files, config.RateLimitConfigToLoad{Name: key, ConfigYaml: configYaml})
 	}
@@ -105,7 +111,7 @@ func (p *FileProvider) setupRuntime() {
 func NewFileProvider(settings settings.Settings, statsManager stats.Manager, rootStore gostats.Store) RateLimitConfigProvider {
 	p := &FileProvider{
 		settings:              settings,
-		loader:                config.NewRateLimitConfigLoaderImpl(),
+		loader:                config.NewRateLimitConfigLoaderImpl(settings.BackendType),
 		configUpdateEventChan: make(chan ConfigUpdateEvent),
 		runtimeUpdateEvent:    make(chan int),
 		runtimeWatchRoot:      settings.RuntimeWatchRoot,
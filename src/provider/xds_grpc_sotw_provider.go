@@ -49,7 +49,7 @@ func NewXdsGrpcSotwProvider(settings settings.Settings, statsManager stats.Manag
 		ctx:                    ctx,
 		configUpdateEventChan:  make(chan ConfigUpdateEvent),
 		connectionRetryChannel: make(chan bool),
-		loader:                 config.NewRateLimitConfigLoaderImpl(),
+		loader:                 config.NewRateLimitConfigLoaderImpl(settings.BackendType),
 		adsClient:              sotw.NewADSClient(ctx, getClientNode(settings), resource.RateLimitConfigType),
 	}
 	go p.initXdsClient()
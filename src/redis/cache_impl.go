@@ -5,7 +5,9 @@ import (
 	"math/rand"
 
 	"github.com/coocood/freecache"
+	gostats "github.com/lyft/gostats"
 
+	"github.com/envoyproxy/ratelimit/src/config"
 	"github.com/envoyproxy/ratelimit/src/limiter"
 	"github.com/envoyproxy/ratelimit/src/server"
 	"github.com/envoyproxy/ratelimit/src/settings"
@@ -18,15 +20,17 @@ func NewRateLimiterCacheImplFromSettings(s settings.Settings, localCache *freeca
 	var perSecondPool Client
 	if s.RedisPerSecond {
 		perSecondPool = NewClientImpl(srv.Scope().Scope("redis_per_second_pool"), s.RedisPerSecondTls, s.RedisPerSecondAuth, s.RedisPerSecondSocketType,
-			s.RedisPerSecondType, s.RedisPerSecondUrl, s.RedisPerSecondPoolSize, s.RedisPerSecondPipelineWindow, s.RedisPerSecondPipelineLimit, s.RedisTlsConfig, s.RedisHealthCheckActiveConnection, srv)
+			s.RedisPerSecondType, s.RedisPerSecondUrl, s.RedisPerSecondPoolSize, s.RedisPerSecondPipelineWindow, s.RedisPerSecondPipelineLimit, s.BackendTimeout, s.RedisTlsConfig, s.RedisHealthCheckActiveConnection, srv)
 		closer.Closers = append(closer.Closers, perSecondPool)
+		perSecondPool = withCircuitBreakerFromSettings(s, srv, perSecondPool, srv.Scope().Scope("redis_per_second_pool"))
 	}
 
 	otherPool := NewClientImpl(srv.Scope().Scope("redis_pool"), s.RedisTls, s.RedisAuth, s.RedisSocketType, s.RedisType, s.RedisUrl, s.RedisPoolSize,
-		s.RedisPipelineWindow, s.RedisPipelineLimit, s.RedisTlsConfig, s.RedisHealthCheckActiveConnection, srv)
+		s.RedisPipelineWindow, s.RedisPipelineLimit, s.BackendTimeout, s.RedisTlsConfig, s.RedisHealthCheckActiveConnection, srv)
 	closer.Closers = append(closer.Closers, otherPool)
+	otherPool = withCircuitBreakerFromSettings(s, srv, otherPool, srv.Scope().Scope("redis_pool"))
 
-	return NewFixedRateLimitCacheImpl(
+	cache := NewFixedRateLimitCacheImpl(
 		otherPool,
 		perSecondPool,
 		timeSource,
@@ -37,5 +41,41 @@ func NewRateLimiterCacheImplFromSettings(s settings.Settings, localCache *freeca
 		s.CacheKeyPrefix,
 		statsManager,
 		s.StopCacheKeyIncrementWhenOverlimit,
-	), closer
+	)
+	if s.BackendType == "redis_gcra" {
+		cache = NewGcraRateLimitCacheImpl(cache)
+	}
+	return cache, closer
+}
+
+// Wraps a client of the limits in a circuit breaker, if enabled.
+func withCircuitBreakerFromSettings(s settings.Settings, srv server.Server, client Client, scope gostats.Scope) Client {
+	if !s.CircuitBreakerEnabled {
+		return client
+	}
+	var onOpenChange func(bool)
+	if s.CircuitBreakerHealthCheck {
+		onOpenChange = srv.HealthChecker().CircuitBreakerOpenChanged
+	}
+	return WithCircuitBreaker(client, utils.NewCircuitBreaker(utils.NewTimeSourceImpl(), s.CircuitBreakerFailureThreshold,
+		s.CircuitBreakerLatencyThreshold, s.CircuitBreakerOpenDuration, s.CircuitBreakerHalfOpenProbes, IsRedisFailure,
+		onOpenChange, scope.Scope("circuit_breaker")))
+}
+
+func NewTemporaryOverrideStoreFromSettings(s settings.Settings, srv server.Server, timeSource utils.TimeSource) (config.TemporaryOverrideStore, io.Closer) {
+	// Overrides are held by the redis instance holding all limits but per second ones.
+	pool := NewClientImpl(srv.Scope().Scope("redis_overrides_pool"), s.RedisTls, s.RedisAuth, s.RedisSocketType, s.RedisType, s.RedisUrl, 1,
+		0, 0, 0, s.RedisTlsConfig, false, nil)
+	store, storeCloser := NewTemporaryOverrideStore(pool, s.CacheKeyPrefix, timeSource, s.TemporaryOverridesRefreshInterval,
+		srv.Scope().Scope("temporary_overrides"))
+	return store, &utils.MultiCloser{Closers: []io.Closer{storeCloser, pool}}
+}
+
+func NewLimitStoreFromSettings(s settings.Settings, srv server.Server) (config.LimitStore, io.Closer) {
+	// Limits are held by the redis instance holding all limits but per second ones, and read in the request path.
+	pool := NewClientImpl(srv.Scope().Scope("redis_limit_store_pool"), s.RedisTls, s.RedisAuth, s.RedisSocketType, s.RedisType, s.RedisUrl,
+		s.RedisPoolSize, s.RedisPipelineWindow, s.RedisPipelineLimit, s.BackendTimeout, s.RedisTlsConfig, false, nil)
+	store := NewLimitStore(pool, s.LimitStoreHashKey, s.LimitStoreCacheTtl, s.LimitStoreCacheSizeInBytes,
+		srv.Scope().Scope("limit_store"))
+	return store, pool
 }
@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+type circuitBreakerClient struct {
+	Client
+	breaker *utils.CircuitBreaker
+}
+
+// Wraps a client so that its commands go through a circuit breaker. Commands rejected by the open breaker fail with
+// utils.ErrCircuitBreakerOpen, which the callers handle like any other error of the client.
+// @param client supplies the wrapped client.
+// @param breaker supplies the circuit breaker.
+func WithCircuitBreaker(client Client, breaker *utils.CircuitBreaker) Client {
+	return &circuitBreakerClient{Client: client, breaker: breaker}
+}
+
+// Prefixes of the error replies of redis instances which can't serve commands for now.
+var unavailableErrorPrefixes = []string{"LOADING", "BUSY", "MASTERDOWN", "CLUSTERDOWN", "TRYAGAIN", "OOM"}
+
+// Checks whether an error is a failure of redis. Error replies, e.g. of scripts, show that redis is available, unless
+// redis replies that it can't serve commands for now.
+func IsRedisFailure(err error) bool {
+	var replyError resp2.Error
+	if !errors.As(err, &replyError) {
+		return true
+	}
+	for _, prefix := range unavailableErrorPrefixes {
+		if strings.HasPrefix(replyError.Error(), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *circuitBreakerClient) DoCmd(rcv interface{}, cmd, key string, args ...interface{}) error {
+	return c.breaker.Do(func() error {
+		return c.Client.DoCmd(rcv, cmd, key, args...)
+	})
+}
+
+func (c *circuitBreakerClient) PipeDo(pipeline Pipeline) error {
+	return c.breaker.Do(func() error {
+		return c.Client.PipeDo(pipeline)
+	})
+}
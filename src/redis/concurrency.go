@@ -0,0 +1,145 @@
+package redis
+
+import (
+	"math/rand"
+	"strconv"
+	"time"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Acquires slots of a concurrency limit stored as a sorted set of leases scored by their expiration time.
+// KEYS[1]: the lease key.
+// ARGV[1]: the number of slots of the limit.
+// ARGV[2]: the duration of a lease in microseconds.
+// ARGV[3]: the current unix time in microseconds.
+// ARGV[4]: the number of slots to acquire.
+// ARGV[5]: a unique id for the leases of this call.
+// ARGV[6]: "1" to acquire the slots even if the limit is reached, which is used in shadow mode.
+// Returns whether the slots were available, the number of slots left and the number of microseconds until the next
+// lease expires if no slots are left.
+const concurrencyAcquireScript = `
+local capacity = tonumber(ARGV[1])
+local lease = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[4])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+local held = redis.call("ZCARD", KEYS[1])
+
+local allowed = 0
+if held + requested <= capacity then
+  allowed = 1
+end
+if allowed == 1 or ARGV[6] == "1" then
+  local expiration = string.format("%.0f", now + lease)
+  for i = 1, requested do
+    redis.call("ZADD", KEYS[1], expiration, ARGV[5] .. ":" .. i)
+  end
+  held = held + requested
+  -- The leases just added expire last.
+  redis.call("PEXPIRE", KEYS[1], math.ceil(lease / 1000))
+end
+
+local untilNextRelease = 0
+if held >= capacity then
+  local next = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+  untilNextRelease = math.max(0, tonumber(next[2]) - now)
+end
+return {allowed, math.max(0, capacity - held), untilNextRelease}
+`
+
+// Releases slots of a concurrency limit.
+// KEYS[1]: the lease key.
+// ARGV[1]: the number of slots of the limit.
+// ARGV[2]: the current unix time in microseconds.
+// ARGV[3]: the number of slots to release.
+// Returns the number of slots left.
+const concurrencyReleaseScript = `
+local capacity = tonumber(ARGV[1])
+local now = tonumber(ARGV[2])
+local released = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+-- Leases are not tied to requests, so the leases closest to their expiration are released. The remaining leases
+-- expire no earlier than those of the requests still holding a slot.
+if released > 0 then
+  redis.call("ZPOPMIN", KEYS[1], released)
+end
+return {math.max(0, capacity - redis.call("ZCARD", KEYS[1]))}
+`
+
+// Acquires hitsAddend slots of a concurrency limit.
+// @param client supplies the client owning the lease key.
+// @param cacheKey supplies the lease key.
+// @param limit supplies the concurrency limit.
+// @param hitsAddend supplies the number of slots to acquire.
+// @return the response descriptor status.
+func (this *fixedRateLimitCacheImpl) doConcurrencyLimit(client Client, cacheKey string, limit *config.RateLimit,
+	hitsAddend uint64,
+) *pb.RateLimitResponse_DescriptorStatus {
+	logger.Debugf("acquiring %d slots of concurrency limit: %s", hitsAddend, cacheKey)
+
+	// A lease lasts one window of the limit.
+	lease := utils.UnitToDividerWithMultiplier(limit.Limit.Unit, limit.UnitMultiplier) * int64(time.Second/time.Microsecond)
+	now := this.timeSource.UnixNanoNow() / int64(time.Microsecond)
+	leaseId := strconv.FormatUint(rand.Uint64(), 36)
+	shadowMode := "0"
+	if limit.ShadowMode {
+		shadowMode = "1"
+	}
+
+	var result []int64
+	checkError(client.DoCmd(&result, "EVAL", concurrencyAcquireScript, 1, cacheKey, limit.Limit.RequestsPerUnit, lease,
+		now, hitsAddend, leaseId, shadowMode))
+	if len(result) != 3 {
+		panic(RedisError("unexpected concurrency acquire script result"))
+	}
+
+	untilNextRelease := durationpb.New(time.Duration(result[2]) * time.Microsecond)
+	return this.baseRateLimiter.GetTokenBucketResponseDescriptorStatus(limit, result[0] == 1, uint64(result[1]),
+		untilNextRelease, hitsAddend)
+}
+
+func (this *fixedRateLimitCacheImpl) Release(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	hitsAddends := utils.GetHitsAddends(request)
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+	now := this.timeSource.UnixNanoNow() / int64(time.Microsecond)
+
+	statuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || limits[i].Algorithm != config.Concurrency {
+			continue
+		}
+		logger.Debugf("releasing %d slots of concurrency limit: %s", hitsAddends[i], cacheKey.Key)
+
+		client := this.client
+		if this.perSecondClient != nil && cacheKey.PerSecond {
+			client = this.perSecondClient
+		}
+
+		var result []int64
+		checkError(client.DoCmd(&result, "EVAL", concurrencyReleaseScript, 1, cacheKey.Key,
+			limits[i].Limit.RequestsPerUnit, now, hitsAddends[i]))
+		if len(result) != 1 {
+			panic(RedisError("unexpected concurrency release script result"))
+		}
+
+		statuses[i] = &pb.RateLimitResponse_DescriptorStatus{
+			Code:           pb.RateLimitResponse_OK,
+			CurrentLimit:   limits[i].Limit,
+			LimitRemaining: uint32(result[0]),
+		}
+	}
+	return statuses
+}
@@ -9,6 +9,8 @@ func (e RedisError) Error() string {
 	return string(e)
 }
 
+func (e RedisError) CacheUnavailable() {}
+
 // Interface for a redis client.
 type Client interface {
 	// DoCmd is used to perform a redis command and retrieve a result.
@@ -11,6 +11,7 @@ import (
 	"github.com/mediocregopher/radix/v3/trace"
 	logger "github.com/sirupsen/logrus"
 
+	"github.com/envoyproxy/ratelimit/src/limiter"
 	"github.com/envoyproxy/ratelimit/src/server"
 	"github.com/envoyproxy/ratelimit/src/utils"
 )
@@ -65,13 +66,19 @@ type clientImpl struct {
 }
 
 func checkError(err error) {
-	if err != nil {
-		panic(RedisError(err.Error()))
+	if err == nil {
+		return
 	}
+	// Reads and writes time out after the backend timeout.
+	if utils.IsTimeout(err) {
+		panic(limiter.CacheTimeoutError(err.Error()))
+	}
+	panic(RedisError(err.Error()))
 }
 
 func NewClientImpl(scope stats.Scope, useTls bool, auth, redisSocketType, redisType, url string, poolSize int,
-	pipelineWindow time.Duration, pipelineLimit int, tlsConfig *tls.Config, healthCheckActiveConnection bool, srv server.Server,
+	pipelineWindow time.Duration, pipelineLimit int, timeout time.Duration, tlsConfig *tls.Config,
+	healthCheckActiveConnection bool, srv server.Server,
 ) Client {
 	maskedUrl := utils.MaskCredentialsInUrl(url)
 	logger.Warnf("connecting to redis on %s with pool size %d", maskedUrl, poolSize)
@@ -83,6 +90,11 @@ func NewClientImpl(scope stats.Scope, useTls bool, auth, redisSocketType, redisT
 			dialOpts = append(dialOpts, radix.DialUseTLS(tlsConfig))
 		}
 
+		// Calls waiting longer than the timeout for redis fail, so that the failure modes of the limits apply.
+		if timeout > 0 {
+			dialOpts = append(dialOpts, radix.DialReadTimeout(timeout), radix.DialWriteTimeout(timeout))
+		}
+
 		if auth != "" {
 			user, pass, found := strings.Cut(auth, ":")
 			if found {
@@ -28,6 +28,7 @@ type fixedRateLimitCacheImpl struct {
 	// limits regardless of unit. If this client is not nil, then it
 	// is used for limits that have a SECOND unit.
 	perSecondClient                    Client
+	timeSource                         utils.TimeSource
 	stopCacheKeyIncrementWhenOverlimit bool
 	baseRateLimiter                    *limiter.BaseRateLimiter
 }
@@ -82,9 +83,14 @@ func (this *fixedRateLimitCacheImpl) DoLimit(
 	// First build a list of all cache keys that we are actually going to hit.
 	cacheKeys := this.baseRateLimiter.GenerateCacheKeys(request, limits, hitsAddends)
 
+	// Token bucket, GCRA and concurrency limits are evaluated by scripts and are not part of the counter pipelines.
+	scriptedStatuses := this.doScriptedLimits(cacheKeys, limits, hitsAddends)
+
 	isOverLimitWithLocalCache := make([]bool, len(request.Descriptors))
 	results := make([]uint64, len(request.Descriptors))
 	currentCount := make([]uint64, len(request.Descriptors))
+	previousCount := make([]uint64, len(request.Descriptors))
+	previousCountFetched := false
 	var pipeline, perSecondPipeline, pipelineToGet, perSecondPipelineToGet Pipeline
 
 	overlimitIndexes := make([]bool, len(request.Descriptors))
@@ -111,11 +117,32 @@ func (this *fixedRateLimitCacheImpl) DoLimit(
 		}
 	}
 
+	// Hits of leased limits are served from their local lease. Keys whose lease holds too few hits lease the missing
+	// hits along with a new batch in the pipeline instead of only incrementing by their hits.
+	leaseStatuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	leaseRequests := make([]uint64, len(request.Descriptors))
+	leaseLeftovers := make([]uint64, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || overlimitIndexes[i] || !this.baseRateLimiter.IsLeased(limits[i]) {
+			continue
+		}
+		taken, count, leftover := this.baseRateLimiter.TakeFromLease(cacheKey.Key, limits[i], hitsAddends[i])
+		if taken {
+			limits[i].Stats.LeaseHits.Add(hitsAddends[i])
+			limitInfo := limiter.NewRateLimitInfo(limits[i], count-min(hitsAddends[i], count), count, 0, 0)
+			leaseStatuses[i] = this.baseRateLimiter.GetResponseDescriptorStatus(cacheKey.Key, limitInfo, false, hitsAddends[i])
+			continue
+		}
+		leaseLeftovers[i] = leftover
+		leaseRequests[i] = hitsAddends[i] - leftover + limiter.LeaseSize(limits[i])
+	}
+
 	// If none of the keys are over limit in local cache and the stopCacheKeyIncrementWhenOverlimit is true,
 	// then we check if any of the keys are near limit in redis cache.
 	if this.stopCacheKeyIncrementWhenOverlimit && !isCacheKeyOverlimit {
 		for i, cacheKey := range cacheKeys {
-			if cacheKey.Key == "" {
+			// Leased keys are only incremented by the hits they lease.
+			if cacheKey.Key == "" || leaseStatuses[i] != nil || leaseRequests[i] != 0 {
 				continue
 			}
 
@@ -124,13 +151,20 @@ func (this *fixedRateLimitCacheImpl) DoLimit(
 					perSecondPipelineToGet = Pipeline{}
 				}
 				pipelineAppendtoGet(this.perSecondClient, &perSecondPipelineToGet, cacheKey.Key, &currentCount[i])
+				if cacheKey.PreviousKey != "" {
+					pipelineAppendtoGet(this.perSecondClient, &perSecondPipelineToGet, cacheKey.PreviousKey, &previousCount[i])
+				}
 			} else {
 				if pipelineToGet == nil {
 					pipelineToGet = Pipeline{}
 				}
 				pipelineAppendtoGet(this.client, &pipelineToGet, cacheKey.Key, &currentCount[i])
+				if cacheKey.PreviousKey != "" {
+					pipelineAppendtoGet(this.client, &pipelineToGet, cacheKey.PreviousKey, &previousCount[i])
+				}
 			}
 		}
+		previousCountFetched = true
 
 		if pipelineToGet != nil {
 			checkError(this.client.PipeDo(pipelineToGet))
@@ -140,11 +174,11 @@ func (this *fixedRateLimitCacheImpl) DoLimit(
 		}
 
 		for i, cacheKey := range cacheKeys {
-			if cacheKey.Key == "" {
+			if cacheKey.Key == "" || leaseStatuses[i] != nil || leaseRequests[i] != 0 {
 				continue
 			}
 			// Now fetch the pipeline.
-			limitBeforeIncrease := currentCount[i]
+			limitBeforeIncrease := currentCount[i] + cacheKey.WeightedPreviousCount(previousCount[i])
 			limitAfterIncrease := limitBeforeIncrease + hitsAddends[i]
 
 			limitInfo := limiter.NewRateLimitInfo(limits[i], limitBeforeIncrease, limitAfterIncrease, 0, 0)
@@ -158,15 +192,16 @@ func (this *fixedRateLimitCacheImpl) DoLimit(
 
 	// Now, actually setup the pipeline to increase the usage of cache key, skipping empty cache keys.
 	for i, cacheKey := range cacheKeys {
-		if cacheKey.Key == "" || overlimitIndexes[i] {
+		if cacheKey.Key == "" || overlimitIndexes[i] || leaseStatuses[i] != nil {
 			continue
 		}
 
 		logger.Debugf("looking up cache key: %s", cacheKey.Key)
 
-		expirationSeconds := utils.UnitToDivider(limits[i].Limit.Unit)
-		if this.baseRateLimiter.ExpirationJitterMaxSeconds > 0 {
-			expirationSeconds += this.baseRateLimiter.JitterRand.Int63n(this.baseRateLimiter.ExpirationJitterMaxSeconds)
+		expirationSeconds := this.baseRateLimiter.GetExpirationSeconds(limits[i])
+		hitsAddend := this.getHitsAddend(hitsAddends[i], isCacheKeyOverlimit, isCacheKeyNearlimit, nearlimitIndexes[i])
+		if leaseRequests[i] != 0 {
+			hitsAddend = leaseRequests[i]
 		}
 
 		// Use the perSecondConn if it is not nil and the cacheKey represents a per second Limit.
@@ -174,14 +209,18 @@ func (this *fixedRateLimitCacheImpl) DoLimit(
 			if perSecondPipeline == nil {
 				perSecondPipeline = Pipeline{}
 			}
-			pipelineAppend(this.perSecondClient, &perSecondPipeline, cacheKey.Key, this.getHitsAddend(hitsAddends[i],
-				isCacheKeyOverlimit, isCacheKeyNearlimit, nearlimitIndexes[i]), &results[i], expirationSeconds)
+			pipelineAppend(this.perSecondClient, &perSecondPipeline, cacheKey.Key, hitsAddend, &results[i], expirationSeconds)
+			if cacheKey.PreviousKey != "" && !previousCountFetched {
+				pipelineAppendtoGet(this.perSecondClient, &perSecondPipeline, cacheKey.PreviousKey, &previousCount[i])
+			}
 		} else {
 			if pipeline == nil {
 				pipeline = Pipeline{}
 			}
-			pipelineAppend(this.client, &pipeline, cacheKey.Key, this.getHitsAddend(hitsAddends[i], isCacheKeyOverlimit,
-				isCacheKeyNearlimit, nearlimitIndexes[i]), &results[i], expirationSeconds)
+			pipelineAppend(this.client, &pipeline, cacheKey.Key, hitsAddend, &results[i], expirationSeconds)
+			if cacheKey.PreviousKey != "" && !previousCountFetched {
+				pipelineAppendtoGet(this.client, &pipeline, cacheKey.PreviousKey, &previousCount[i])
+			}
 		}
 	}
 
@@ -204,9 +243,35 @@ func (this *fixedRateLimitCacheImpl) DoLimit(
 	// Now fetch the pipeline.
 	responseDescriptorStatuses := make([]*pb.RateLimitResponse_DescriptorStatus,
 		len(request.Descriptors))
+	var returnPipeline, perSecondReturnPipeline Pipeline
+	var returns, perSecondReturns []leaseReturn
 	for i, cacheKey := range cacheKeys {
+		if scriptedStatuses != nil && scriptedStatuses[i] != nil {
+			responseDescriptorStatuses[i] = scriptedStatuses[i]
+			continue
+		}
+		if leaseStatuses[i] != nil {
+			responseDescriptorStatuses[i] = leaseStatuses[i]
+			continue
+		}
+		if leaseRequests[i] != 0 {
+			var returned uint64
+			responseDescriptorStatuses[i], returned = this.settleLease(cacheKey, limits[i], hitsAddends[i],
+				leaseLeftovers[i], leaseRequests[i], results[i])
+			if returned == 0 {
+				continue
+			}
+			if this.perSecondClient != nil && cacheKey.PerSecond {
+				perSecondReturnPipeline = this.perSecondClient.PipeAppend(perSecondReturnPipeline, nil, "DECRBY", cacheKey.Key, returned)
+				perSecondReturns = append(perSecondReturns, leaseReturn{limits[i], returned})
+			} else {
+				returnPipeline = this.client.PipeAppend(returnPipeline, nil, "DECRBY", cacheKey.Key, returned)
+				returns = append(returns, leaseReturn{limits[i], returned})
+			}
+			continue
+		}
 
-		limitAfterIncrease := results[i]
+		limitAfterIncrease := results[i] + cacheKey.WeightedPreviousCount(previousCount[i])
 		limitBeforeIncrease := limitAfterIncrease - hitsAddends[i]
 
 		limitInfo := limiter.NewRateLimitInfo(limits[i], limitBeforeIncrease, limitAfterIncrease, 0, 0)
@@ -216,9 +281,63 @@ func (this *fixedRateLimitCacheImpl) DoLimit(
 
 	}
 
+	returnLeasedHits(this.client, returnPipeline, returns)
+	returnLeasedHits(this.perSecondClient, perSecondReturnPipeline, perSecondReturns)
+
 	return responseDescriptorStatuses
 }
 
+// Hits leased by a limit which are returned to redis.
+type leaseReturn struct {
+	limit *config.RateLimit
+	hits  uint64
+}
+
+// Returns leased hits over their limits to redis, so that other instances can still lease them until the window ends.
+// The hits were already counted, so failing to return them only makes the limits stricter and does not fail the request.
+// @param client supplies the client of the leased keys.
+// @param pipeline supplies the commands returning the hits.
+// @param returns supplies the limits and hits of the commands.
+func returnLeasedHits(client Client, pipeline Pipeline, returns []leaseReturn) {
+	if pipeline == nil {
+		return
+	}
+	if err := client.PipeDo(pipeline); err != nil {
+		logger.Errorf("Failed to return leased hits: %s", err)
+		for _, r := range returns {
+			r.limit.Stats.LeaseReturnError.Inc()
+		}
+		return
+	}
+	for _, r := range returns {
+		r.limit.Stats.LeaseReturned.Add(r.hits)
+	}
+}
+
+// Splits the hits a request leased between its own hits and the lease of its cache key.
+// @param cacheKey supplies the leased cache key.
+// @param limit supplies the limit of the key.
+// @param hitsAddend supplies the hits of the request.
+// @param leftover supplies the hits of the request taken from the previous lease.
+// @param leased supplies the number of hits the key was incremented by.
+// @param count supplies the count of the key after the increment.
+// @return the status of the limit and the leased hits over the limit, which are to be returned to redis.
+func (this *fixedRateLimitCacheImpl) settleLease(cacheKey limiter.CacheKey, limit *config.RateLimit, hitsAddend uint64,
+	leftover uint64, leased uint64, count uint64,
+) (*pb.RateLimitResponse_DescriptorStatus, uint64) {
+	limit.Stats.LeaseAcquired.Inc()
+	countBefore := count - min(leased, count)
+	granted := min(uint64(limit.Limit.RequestsPerUnit)-min(countBefore, uint64(limit.Limit.RequestsPerUnit)), leased)
+	needed := hitsAddend - leftover
+	if granted >= needed {
+		this.baseRateLimiter.AddToLease(cacheKey.Key, limit, granted-needed, countBefore+granted)
+	}
+
+	countAfter := countBefore + needed
+	limitInfo := limiter.NewRateLimitInfo(limit, countAfter-min(hitsAddend, countAfter), countAfter, 0, 0)
+	return this.baseRateLimiter.GetResponseDescriptorStatus(cacheKey.Key, limitInfo, false, hitsAddend), leased - granted
+}
+
 // Flush() is a no-op with redis since quota reads and updates happen synchronously.
 func (this *fixedRateLimitCacheImpl) Flush() {}
 
@@ -229,6 +348,7 @@ func NewFixedRateLimitCacheImpl(client Client, perSecondClient Client, timeSourc
 	return &fixedRateLimitCacheImpl{
 		client:                             client,
 		perSecondClient:                    perSecondClient,
+		timeSource:                         timeSource,
 		stopCacheKeyIncrementWhenOverlimit: stopCacheKeyIncrementWhenOverlimit,
 		baseRateLimiter:                    limiter.NewBaseRateLimit(timeSource, jitterRand, expirationJitterMaxSeconds, localCache, nearLimitRatio, cacheKeyPrefix, statsManager),
 	}
@@ -0,0 +1,71 @@
+package redis
+
+import (
+	"time"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+)
+
+// Applies the generic cell rate algorithm to a key storing the theoretical arrival time of the next hit.
+// KEYS[1]: the theoretical arrival time key.
+// ARGV[1]: the burst of the limit.
+// ARGV[2]: the emission interval, i.e. the number of microseconds between two hits at the rate of the limit.
+// ARGV[3]: the current unix time in microseconds.
+// ARGV[4]: the number of hits.
+// Returns whether the hits were admitted, the number of hits that would be admitted right away and the number of
+// microseconds until the next hit is admitted.
+const gcraScript = `
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local hits = tonumber(ARGV[4])
+
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if tat == nil or tat < now then
+  tat = now
+end
+
+local tolerance = burst * interval
+local newTat = tat + hits * interval
+local allowed = 0
+if newTat - tolerance <= now then
+  allowed = 1
+  tat = newTat
+  -- A theoretical arrival time in the past admits a full burst, so the key only needs to live until then.
+  redis.call("SET", KEYS[1], string.format("%.0f", tat), "PX", math.ceil((tat - now) / 1000) + 1)
+end
+
+local remaining = math.max(0, math.floor((now - (tat - tolerance)) / interval))
+local untilNextHit = math.max(0, math.ceil(tat - tolerance + interval - now))
+return {allowed, remaining, untilNextHit}
+`
+
+// Applies hitsAddend hits to a GCRA limit.
+// @param client supplies the client owning the theoretical arrival time key.
+// @param cacheKey supplies the theoretical arrival time key.
+// @param limit supplies the GCRA limit.
+// @param hitsAddend supplies the number of hits.
+// @return the response descriptor status.
+func (this *fixedRateLimitCacheImpl) doGcraLimit(client Client, cacheKey string, limit *config.RateLimit,
+	hitsAddend uint64,
+) *pb.RateLimitResponse_DescriptorStatus {
+	logger.Debugf("applying %d hits to theoretical arrival time: %s", hitsAddend, cacheKey)
+
+	interval := emissionInterval(limit)
+	now := this.timeSource.UnixNanoNow() / int64(time.Microsecond)
+
+	var result []int64
+	checkError(client.DoCmd(&result, "EVAL", gcraScript, 1, cacheKey, limit.Burst, interval, now, hitsAddend))
+	if len(result) != 3 {
+		panic(RedisError("unexpected GCRA script result"))
+	}
+
+	// GCRA admits the same hits as a token bucket of size burst, so the statuses are built the same way.
+	untilNextHit := durationpb.New(time.Duration(result[2]) * time.Microsecond)
+	return this.baseRateLimiter.GetTokenBucketResponseDescriptorStatus(limit, result[0] == 1, uint64(result[1]),
+		untilNextHit, hitsAddend)
+}
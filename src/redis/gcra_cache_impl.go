@@ -0,0 +1,93 @@
+package redis
+
+import (
+	"context"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+)
+
+// Rate limit cache which applies the generic cell rate algorithm to all limits, regardless of the algorithm
+// configured for them.
+type gcraRateLimitCacheImpl struct {
+	cache limiter.RateLimitCache
+}
+
+func (this *gcraRateLimitCacheImpl) DoLimit(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	return this.cache.DoLimit(ctx, request, toGcraLimits(limits))
+}
+
+// Converts all limits which limit a rate to GCRA limits.
+func toGcraLimits(limits []*config.RateLimit) []*config.RateLimit {
+	gcraLimits := make([]*config.RateLimit, len(limits))
+	for i, limit := range limits {
+		// Concurrency limits do not limit a rate.
+		if limit == nil || limit.Unlimited || limit.Algorithm == config.GCRA || limit.Algorithm == config.Concurrency {
+			gcraLimits[i] = limit
+			continue
+		}
+
+		gcraLimit := *limit
+		gcraLimit.Algorithm = config.GCRA
+		// Limits configured for other algorithms admit up to one unit worth of hits at once.
+		if gcraLimit.Burst == 0 {
+			gcraLimit.Burst = limit.Limit.RequestsPerUnit
+		}
+		gcraLimits[i] = &gcraLimit
+	}
+	return gcraLimits
+}
+
+func (this *gcraRateLimitCacheImpl) Release(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	return this.cache.Release(ctx, request, limits)
+}
+
+func (this *gcraRateLimitCacheImpl) Refund(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	// All limits counting hits are applied with GCRA, whose hits are not refunded.
+	return make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+}
+
+func (this *gcraRateLimitCacheImpl) GetQuotaStatus(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*limiter.QuotaStatus {
+	return this.cache.GetQuotaStatus(ctx, request, toGcraLimits(limits))
+}
+
+func (this *gcraRateLimitCacheImpl) SetCounters(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+	count uint64,
+) []*limiter.QuotaStatus {
+	// GCRA limits can only be reset.
+	return this.cache.SetCounters(ctx, request, toGcraLimits(limits), count)
+}
+
+func (this *gcraRateLimitCacheImpl) Flush() {
+	this.cache.Flush()
+}
+
+// Wraps a redis rate limit cache so that all limits are applied with the generic cell rate algorithm.
+// @param cache supplies the redis rate limit cache.
+// @return the GCRA rate limit cache.
+func NewGcraRateLimitCacheImpl(cache limiter.RateLimitCache) limiter.RateLimitCache {
+	return &gcraRateLimitCacheImpl{
+		cache: cache,
+	}
+}
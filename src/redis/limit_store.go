@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"time"
+
+	"github.com/coocood/freecache"
+	stats "github.com/lyft/gostats"
+	logger "github.com/sirupsen/logrus"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+)
+
+type limitStoreStats struct {
+	cacheHit  stats.Counter
+	cacheMiss stats.Counter
+	notFound  stats.Counter
+	invalid   stats.Counter
+	error     stats.Counter
+}
+
+func newLimitStoreStats(scope stats.Scope) limitStoreStats {
+	ret := limitStoreStats{}
+	ret.cacheHit = scope.NewCounter("cache_hit")
+	ret.cacheMiss = scope.NewCounter("cache_miss")
+	ret.notFound = scope.NewCounter("not_found")
+	ret.invalid = scope.NewCounter("invalid")
+	ret.error = scope.NewCounter("error")
+	return ret
+}
+
+// Store of the limits of values in a redis hash, e.g. written by a billing system. Values read from redis, including
+// values without a limit, are cached locally for a short time to avoid a redis round trip per request.
+type limitStoreImpl struct {
+	client          Client
+	key             string
+	cache           *freecache.Cache
+	cacheTtlSeconds int
+	stats           limitStoreStats
+}
+
+// Create a store of limits.
+// @param client supplies the redis client holding the limits.
+// @param key supplies the key of the hash holding the limits by value.
+// @param cacheTtl supplies the duration values are cached locally for, rounded up to a second.
+// @param cacheSizeInBytes supplies the size of the local cache.
+// @param scope supplies the stats scope of the store.
+func NewLimitStore(client Client, key string, cacheTtl time.Duration, cacheSizeInBytes int, scope stats.Scope) config.LimitStore {
+	return &limitStoreImpl{
+		client:          client,
+		key:             key,
+		cache:           freecache.NewCache(cacheSizeInBytes),
+		cacheTtlSeconds: int(max((cacheTtl+time.Second-1)/time.Second, 1)),
+		stats:           newLimitStoreStats(scope),
+	}
+}
+
+func (this *limitStoreImpl) Get(value string) *config.StoredLimit {
+	if cached, err := this.cache.Get([]byte(value)); err == nil {
+		this.stats.cacheHit.Inc()
+		limit, _ := this.parse(cached)
+		return limit
+	}
+	this.stats.cacheMiss.Inc()
+
+	var stored string
+	if err := this.client.DoCmd(&stored, "HGET", this.key, value); err != nil {
+		// The configured limit applies until the store is reachable again.
+		logger.Errorf("Failed to read the stored limit of %s: %s", value, err)
+		this.stats.error.Inc()
+		return nil
+	}
+	this.cache.Set([]byte(value), []byte(stored), this.cacheTtlSeconds)
+
+	limit, err := this.parse([]byte(stored))
+	if err != nil {
+		logger.Errorf("Ignoring invalid stored limit of %s: %s", value, err)
+		this.stats.invalid.Inc()
+	} else if limit == nil {
+		this.stats.notFound.Inc()
+	}
+	return limit
+}
+
+// Parse a stored limit, an empty value being a value without a limit.
+func (this *limitStoreImpl) parse(stored []byte) (*config.StoredLimit, error) {
+	if len(stored) == 0 {
+		return nil, nil
+	}
+	return config.ParseStoredLimit(string(stored))
+}
@@ -0,0 +1,187 @@
+package redis
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+)
+
+// State of a token bucket, GCRA or concurrency limit, which is read without the scripts of the limits, so that reading
+// the quota does not write to their keys.
+type scriptedState struct {
+	// Remaining tokens and time of the last refill of a token bucket.
+	bucket []string
+	// Theoretical arrival time of a GCRA limit.
+	tat string
+	// Number of leases of a concurrency limit which did not expire, and the member and score of the lease expiring next.
+	held        int64
+	nextRelease []string
+}
+
+func (this *fixedRateLimitCacheImpl) GetQuotaStatus(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*limiter.QuotaStatus {
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	// The current unix time in microseconds, which is only read for scripted limits.
+	var now int64
+	currentCount := make([]uint64, len(request.Descriptors))
+	previousCount := make([]uint64, len(request.Descriptors))
+	states := make([]scriptedState, len(request.Descriptors))
+	var pipeline, perSecondPipeline Pipeline
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" {
+			continue
+		}
+		logger.Debugf("reading cache key: %s", cacheKey.Key)
+		if isScriptedLimit(limits[i]) && now == 0 {
+			now = this.timeSource.UnixNanoNow() / int64(time.Microsecond)
+		}
+
+		client, clientPipeline := this.client, &pipeline
+		if this.perSecondClient != nil && cacheKey.PerSecond {
+			client, clientPipeline = this.perSecondClient, &perSecondPipeline
+		}
+		switch limits[i].Algorithm {
+		case config.TokenBucket:
+			*clientPipeline = client.PipeAppend(*clientPipeline, &states[i].bucket, "HMGET", cacheKey.Key, "tokens", "ts")
+		case config.GCRA:
+			*clientPipeline = client.PipeAppend(*clientPipeline, &states[i].tat, "GET", cacheKey.Key)
+		case config.Concurrency:
+			after := "(" + strconv.FormatInt(now, 10)
+			*clientPipeline = client.PipeAppend(*clientPipeline, &states[i].held, "ZCOUNT", cacheKey.Key, after, "+inf")
+			*clientPipeline = client.PipeAppend(*clientPipeline, &states[i].nextRelease, "ZRANGEBYSCORE", cacheKey.Key,
+				after, "+inf", "WITHSCORES", "LIMIT", 0, 1)
+		default:
+			pipelineAppendtoGet(client, clientPipeline, cacheKey.Key, &currentCount[i])
+			if cacheKey.PreviousKey != "" {
+				pipelineAppendtoGet(client, clientPipeline, cacheKey.PreviousKey, &previousCount[i])
+			}
+		}
+	}
+	if pipeline != nil {
+		checkError(this.client.PipeDo(pipeline))
+	}
+	if perSecondPipeline != nil {
+		checkError(this.perSecondClient.PipeDo(perSecondPipeline))
+	}
+
+	statuses := make([]*limiter.QuotaStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" {
+			continue
+		}
+		if isScriptedLimit(limits[i]) {
+			statuses[i] = limiter.GetScriptedQuotaStatus(limits[i], this.scriptedQuota(limits[i], states[i], now))
+			continue
+		}
+		count := currentCount[i] + cacheKey.WeightedPreviousCount(previousCount[i])
+		statuses[i] = this.baseRateLimiter.GetCounterQuotaStatus(limits[i], count)
+	}
+	return statuses
+}
+
+// Evaluates the state of a token bucket, GCRA or concurrency limit for no hits, like its script does.
+// @param now supplies the current unix time in microseconds.
+// @return the response descriptor status.
+func (this *fixedRateLimitCacheImpl) scriptedQuota(limit *config.RateLimit, state scriptedState, now int64,
+) *pb.RateLimitResponse_DescriptorStatus {
+	var allowed bool
+	var remaining, untilNext float64
+	switch limit.Algorithm {
+	case config.TokenBucket:
+		capacity, interval := float64(limit.Burst), emissionInterval(limit)
+		tokens, ts := capacity, float64(now)
+		if len(state.bucket) == 2 && state.bucket[0] != "" && state.bucket[1] != "" {
+			tokens, _ = strconv.ParseFloat(state.bucket[0], 64)
+			ts, _ = strconv.ParseFloat(state.bucket[1], 64)
+		}
+		if float64(now) > ts {
+			tokens = math.Min(capacity, tokens+(float64(now)-ts)/interval)
+		}
+		allowed, remaining = true, math.Floor(tokens)
+		if tokens < capacity {
+			untilNext = math.Ceil((1 - (tokens - math.Floor(tokens))) * interval)
+		}
+	case config.GCRA:
+		interval := emissionInterval(limit)
+		tolerance := float64(limit.Burst) * interval
+		tat, err := strconv.ParseFloat(state.tat, 64)
+		if err != nil || tat < float64(now) {
+			tat = float64(now)
+		}
+		allowed = tat-tolerance <= float64(now)
+		remaining = math.Max(0, math.Floor((float64(now)-(tat-tolerance))/interval))
+		untilNext = math.Max(0, math.Ceil(tat-tolerance+interval-float64(now)))
+	case config.Concurrency:
+		capacity := int64(limit.Limit.RequestsPerUnit)
+		allowed, remaining = state.held <= capacity, float64(max(0, capacity-state.held))
+		if state.held >= capacity && len(state.nextRelease) == 2 {
+			expiration, _ := strconv.ParseFloat(state.nextRelease[1], 64)
+			untilNext = math.Max(0, expiration-float64(now))
+		}
+	}
+	return this.baseRateLimiter.GetTokenBucketResponseDescriptorStatus(limit, allowed, uint64(remaining),
+		durationpb.New(time.Duration(untilNext)*time.Microsecond), 0)
+}
+
+func (this *fixedRateLimitCacheImpl) SetCounters(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+	count uint64,
+) []*limiter.QuotaStatus {
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	isSet := make([]bool, len(cacheKeys))
+	var pipeline, perSecondPipeline Pipeline
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || (count > 0 && !limiter.CountsHitsInWindows(limits[i])) {
+			continue
+		}
+		logger.Debugf("setting cache key to %d hits: %s", count, cacheKey.Key)
+		isSet[i] = true
+
+		client, clientPipeline := this.client, &pipeline
+		if this.perSecondClient != nil && cacheKey.PerSecond {
+			client, clientPipeline = this.perSecondClient, &perSecondPipeline
+		}
+		// Resetting a limit removes the state of all algorithms, and the previous window of sliding windows is removed
+		// so that exactly count hits are counted.
+		if count == 0 {
+			*clientPipeline = client.PipeAppend(*clientPipeline, nil, "DEL", cacheKey.Key)
+		} else {
+			*clientPipeline = client.PipeAppend(*clientPipeline, nil, "SET", cacheKey.Key, count,
+				"EX", this.baseRateLimiter.GetExpirationSeconds(limits[i]))
+		}
+		if cacheKey.PreviousKey != "" {
+			*clientPipeline = client.PipeAppend(*clientPipeline, nil, "DEL", cacheKey.PreviousKey)
+		}
+		this.baseRateLimiter.EvictLocalCache(cacheKey.Key)
+		this.baseRateLimiter.EvictLease(cacheKey.Key)
+	}
+	if pipeline != nil {
+		checkError(this.client.PipeDo(pipeline))
+	}
+	if perSecondPipeline != nil {
+		checkError(this.perSecondClient.PipeDo(perSecondPipeline))
+	}
+
+	statuses := this.GetQuotaStatus(ctx, request, limits)
+	for i := range statuses {
+		if !isSet[i] {
+			statuses[i] = nil
+		}
+	}
+	return statuses
+}
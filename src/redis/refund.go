@@ -0,0 +1,59 @@
+package redis
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Refunds hits of a counter without dropping below zero. The counter keeps its expiration.
+// KEYS[1]: the counter key.
+// ARGV[1]: the number of hits to refund.
+// Returns the count after the refund.
+const refundScript = `
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+local refunded = math.min(count, tonumber(ARGV[1]))
+if refunded > 0 then
+  count = redis.call("DECRBY", KEYS[1], refunded)
+end
+return count
+`
+
+func (this *fixedRateLimitCacheImpl) Refund(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+	limits []*config.RateLimit,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	hitsAddends := utils.GetHitsAddends(request)
+	cacheKeys := this.baseRateLimiter.GenerateCacheKeysWithoutHits(request, limits)
+
+	statuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || !limiter.CountsHitsInWindows(limits[i]) {
+			continue
+		}
+		logger.Debugf("refunding %d hits of cache key: %s", hitsAddends[i], cacheKey.Key)
+
+		client := this.client
+		if this.perSecondClient != nil && cacheKey.PerSecond {
+			client = this.perSecondClient
+		}
+
+		var count uint64
+		checkError(client.DoCmd(&count, "EVAL", refundScript, 1, cacheKey.Key, hitsAddends[i]))
+		if cacheKey.PreviousKey != "" {
+			var previousCount uint64
+			checkError(client.DoCmd(&previousCount, "GET", cacheKey.PreviousKey))
+			count += cacheKey.WeightedPreviousCount(previousCount)
+		}
+		// The key may be below the limit again.
+		this.baseRateLimiter.EvictLocalCache(cacheKey.Key)
+
+		statuses[i] = this.baseRateLimiter.GetRefundResponseDescriptorStatus(limits[i], count)
+	}
+	return statuses
+}
@@ -0,0 +1,196 @@
+package redis
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	logger "github.com/sirupsen/logrus"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Name of the hash holding the temporary overrides by the keys of the overridden limits.
+const temporaryOverridesKey = "ratelimit_temporary_overrides"
+
+type temporaryOverrideStats struct {
+	active       stats.Gauge
+	created      stats.Counter
+	deleted      stats.Counter
+	expired      stats.Counter
+	refreshError stats.Counter
+}
+
+func newTemporaryOverrideStats(scope stats.Scope) temporaryOverrideStats {
+	ret := temporaryOverrideStats{}
+	ret.active = scope.NewGauge("active")
+	ret.created = scope.NewCounter("created")
+	ret.deleted = scope.NewCounter("deleted")
+	ret.expired = scope.NewCounter("expired")
+	ret.refreshError = scope.NewCounter("refresh_error")
+	return ret
+}
+
+// Store of temporary overrides in a redis hash. Overrides are read from a local copy of the hash, which is refreshed
+// periodically so that all instances see the overrides created by any of them.
+type temporaryOverrideStoreImpl struct {
+	client     Client
+	key        string
+	timeSource utils.TimeSource
+	stats      temporaryOverrideStats
+	mu         sync.RWMutex
+	overrides  map[string]*config.TemporaryOverride
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// Create a store of temporary overrides and load the current overrides.
+// @param client supplies the redis client holding the overrides.
+// @param keyPrefix supplies the prefix of the key of the hash holding the overrides.
+// @param timeSource supplies the time source overrides expire by.
+// @param refreshInterval supplies the interval the local copy of the overrides is refreshed at, 0 to never refresh it.
+// @param scope supplies the stats scope of the store.
+// @return the store and a closer stopping the refreshes.
+func NewTemporaryOverrideStore(client Client, keyPrefix string, timeSource utils.TimeSource,
+	refreshInterval time.Duration, scope stats.Scope,
+) (config.TemporaryOverrideStore, io.Closer) {
+	store := &temporaryOverrideStoreImpl{
+		client:     client,
+		key:        keyPrefix + temporaryOverridesKey,
+		timeSource: timeSource,
+		stats:      newTemporaryOverrideStats(scope),
+		overrides:  map[string]*config.TemporaryOverride{},
+		done:       make(chan struct{}),
+	}
+	store.refresh()
+
+	if refreshInterval > 0 {
+		go func() {
+			ticker := time.NewTicker(refreshInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					store.refresh()
+				case <-store.done:
+					return
+				}
+			}
+		}()
+	}
+	return store, store
+}
+
+// Replace the local copy of the overrides with the overrides in redis, and remove the expired overrides from redis.
+func (this *temporaryOverrideStoreImpl) refresh() {
+	var values map[string]string
+	if err := this.client.DoCmd(&values, "HGETALL", this.key); err != nil {
+		logger.Errorf("Failed to load temporary overrides: %s", err)
+		this.stats.refreshError.Inc()
+		return
+	}
+
+	now := this.timeSource.UnixNow()
+	overrides := make(map[string]*config.TemporaryOverride, len(values))
+	for key, value := range values {
+		override := &config.TemporaryOverride{}
+		if err := json.Unmarshal([]byte(value), override); err != nil || override.Validate() != nil {
+			logger.Errorf("Ignoring invalid temporary override of %s: %s", key, value)
+			continue
+		}
+		if override.ExpiresAt <= now {
+			this.removeExpired(override)
+			continue
+		}
+		overrides[key] = override
+	}
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.overrides = overrides
+	this.stats.active.Set(uint64(len(overrides)))
+}
+
+// Remove an expired override from redis, unless it was replaced meanwhile.
+func (this *temporaryOverrideStoreImpl) removeExpired(override *config.TemporaryOverride) {
+	logger.Debugf("removing expired temporary override of %s", override.Key)
+	value, _ := json.Marshal(override)
+	var current string
+	if err := this.client.DoCmd(&current, "HGET", this.key, override.Key); err != nil || current != string(value) {
+		return
+	}
+	if err := this.client.DoCmd(nil, "HDEL", this.key, override.Key); err != nil {
+		logger.Errorf("Failed to remove expired temporary override of %s: %s", override.Key, err)
+		return
+	}
+	this.stats.expired.Inc()
+}
+
+func (this *temporaryOverrideStoreImpl) Get(key string) *config.TemporaryOverride {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	override := this.overrides[key]
+	if override == nil || override.ExpiresAt <= this.timeSource.UnixNow() {
+		return nil
+	}
+	return override
+}
+
+func (this *temporaryOverrideStoreImpl) List() []*config.TemporaryOverride {
+	this.mu.RLock()
+	defer this.mu.RUnlock()
+	now := this.timeSource.UnixNow()
+	overrides := make([]*config.TemporaryOverride, 0, len(this.overrides))
+	for _, override := range this.overrides {
+		if override.ExpiresAt > now {
+			overrides = append(overrides, override)
+		}
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].Key < overrides[j].Key })
+	return overrides
+}
+
+func (this *temporaryOverrideStoreImpl) Set(override *config.TemporaryOverride) error {
+	if err := override.Validate(); err != nil {
+		return err
+	}
+	value, err := json.Marshal(override)
+	if err != nil {
+		return err
+	}
+	if err := this.client.DoCmd(nil, "HSET", this.key, override.Key, string(value)); err != nil {
+		return RedisError(err.Error())
+	}
+	logger.Warnf("created temporary override of %s: %s", override.Key, value)
+	this.stats.created.Inc()
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	this.overrides[override.Key] = override
+	this.stats.active.Set(uint64(len(this.overrides)))
+	return nil
+}
+
+func (this *temporaryOverrideStoreImpl) Delete(key string) error {
+	if err := this.client.DoCmd(nil, "HDEL", this.key, key); err != nil {
+		return RedisError(err.Error())
+	}
+	logger.Warnf("deleted temporary override of %s", key)
+	this.stats.deleted.Inc()
+
+	this.mu.Lock()
+	defer this.mu.Unlock()
+	delete(this.overrides, key)
+	this.stats.active.Set(uint64(len(this.overrides)))
+	return nil
+}
+
+// Stop refreshing the overrides.
+func (this *temporaryOverrideStoreImpl) Close() error {
+	this.closeOnce.Do(func() { close(this.done) })
+	return nil
+}
@@ -0,0 +1,127 @@
+package redis
+
+import (
+	"math"
+	"time"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	logger "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Refills and takes tokens from a bucket stored as a hash of the remaining tokens and the time of the last refill.
+// KEYS[1]: the bucket key.
+// ARGV[1]: the capacity of the bucket.
+// ARGV[2]: the number of microseconds it takes to refill one token.
+// ARGV[3]: the current unix time in microseconds.
+// ARGV[4]: the number of tokens to take.
+// Returns whether the tokens were taken, the number of whole tokens left and the number of microseconds until the
+// next token is added to the bucket.
+const tokenBucketScript = `
+local capacity = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local requested = tonumber(ARGV[4])
+
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(bucket[1])
+local ts = tonumber(bucket[2])
+if tokens == nil or ts == nil then
+  tokens = capacity
+  ts = now
+end
+if now > ts then
+  tokens = math.min(capacity, tokens + (now - ts) / interval)
+  ts = now
+end
+
+local allowed = 0
+if tokens >= requested then
+  tokens = tokens - requested
+  allowed = 1
+end
+
+-- tostring() only keeps 14 significant digits, which is not enough for timestamps in microseconds.
+redis.call("HMSET", KEYS[1], "tokens", string.format("%.17g", tokens), "ts", string.format("%.0f", ts))
+-- A missing bucket is a full bucket, so the key only needs to live until the bucket is refilled.
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) * interval / 1000) + 1000)
+
+local untilNextToken = 0
+if tokens < capacity then
+  untilNextToken = math.ceil((1 - (tokens % 1)) * interval)
+end
+return {allowed, math.floor(tokens), untilNextToken}
+`
+
+// @return the number of microseconds it takes to refill one token of a token bucket limit, or between two hits at the
+// rate of a GCRA limit.
+func emissionInterval(limit *config.RateLimit) float64 {
+	return float64(utils.UnitToDividerWithMultiplier(limit.Limit.Unit, limit.UnitMultiplier)) * float64(time.Second/time.Microsecond) /
+		math.Max(float64(limit.Limit.RequestsPerUnit), 1)
+}
+
+// Takes hitsAddend tokens from the bucket of a token bucket limit.
+// @param client supplies the client owning the bucket key.
+// @param cacheKey supplies the bucket key.
+// @param limit supplies the token bucket limit.
+// @param hitsAddend supplies the number of tokens to take.
+// @return the response descriptor status.
+func (this *fixedRateLimitCacheImpl) doTokenBucketLimit(client Client, cacheKey string, limit *config.RateLimit,
+	hitsAddend uint64,
+) *pb.RateLimitResponse_DescriptorStatus {
+	logger.Debugf("taking %d tokens from bucket: %s", hitsAddend, cacheKey)
+
+	interval := emissionInterval(limit)
+	now := this.timeSource.UnixNanoNow() / int64(time.Microsecond)
+
+	var result []int64
+	checkError(client.DoCmd(&result, "EVAL", tokenBucketScript, 1, cacheKey, limit.Burst, interval, now, hitsAddend))
+	if len(result) != 3 {
+		panic(RedisError("unexpected token bucket script result"))
+	}
+
+	untilNextToken := durationpb.New(time.Duration(result[2]) * time.Microsecond)
+	return this.baseRateLimiter.GetTokenBucketResponseDescriptorStatus(limit, result[0] == 1, uint64(result[1]),
+		untilNextToken, hitsAddend)
+}
+
+// Checks whether a limit is evaluated by a script rather than counted in windows.
+func isScriptedLimit(limit *config.RateLimit) bool {
+	return limit.Algorithm == config.TokenBucket || limit.Algorithm == config.GCRA || limit.Algorithm == config.Concurrency
+}
+
+// Evaluates all token bucket, GCRA and concurrency limits of a request and clears their cache keys, so that they are
+// skipped by the counter pipelines.
+// @return the response descriptor statuses of the token bucket, GCRA and concurrency limits, nil for all other limits.
+func (this *fixedRateLimitCacheImpl) doScriptedLimits(cacheKeys []limiter.CacheKey, limits []*config.RateLimit,
+	hitsAddends []uint64,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	var statuses []*pb.RateLimitResponse_DescriptorStatus
+	for i, cacheKey := range cacheKeys {
+		if cacheKey.Key == "" || !isScriptedLimit(limits[i]) {
+			continue
+		}
+		if statuses == nil {
+			statuses = make([]*pb.RateLimitResponse_DescriptorStatus, len(cacheKeys))
+		}
+
+		client := this.client
+		if this.perSecondClient != nil && cacheKey.PerSecond {
+			client = this.perSecondClient
+		}
+		switch limits[i].Algorithm {
+		case config.GCRA:
+			statuses[i] = this.doGcraLimit(client, cacheKey.Key, limits[i], hitsAddends[i])
+		case config.Concurrency:
+			statuses[i] = this.doConcurrencyLimit(client, cacheKey.Key, limits[i], hitsAddends[i])
+		default:
+			statuses[i] = this.doTokenBucketLimit(client, cacheKey.Key, limits[i], hitsAddends[i])
+		}
+		cacheKeys[i] = limiter.CacheKey{}
+	}
+	return statuses
+}
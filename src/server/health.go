@@ -22,12 +22,17 @@ type HealthChecker struct {
 	healthMap map[string]bool
 	ok        uint32
 	name      string
+	// Number of open circuit breakers of the cache clients, updated along with their health component.
+	circuitBreakerLock  sync.Mutex
+	openCircuitBreakers int
 }
 
 const (
 	ConfigHealthComponentName = "config"
 	RedisHealthComponentName  = "redis"
 	SigtermComponentName      = "sigterm"
+	// Unhealthy while a circuit breaker of the cache clients is open.
+	CircuitBreakerHealthComponentName = "circuit_breaker"
 )
 
 func areAllComponentsHealthy(healthMap map[string]bool) bool {
@@ -56,6 +61,7 @@ func NewHealthChecker(grpcHealthServer *health.Server, name string, healthyWithA
 	}
 	// True indicates we have not received sigterm
 	ret.healthMap[SigtermComponentName] = true
+	ret.healthMap[CircuitBreakerHealthComponentName] = true
 
 	ret.grpc = grpcHealthServer
 
@@ -128,3 +134,26 @@ func (hc *HealthChecker) Ok(componentName string) error {
 func (hc *HealthChecker) Server() *health.Server {
 	return hc.grpc
 }
+
+// Reports a circuit breaker of the cache clients opening or closing. The circuit breaker component is unhealthy
+// while any breaker is open.
+// @param open supplies whether the breaker opened.
+func (hc *HealthChecker) CircuitBreakerOpenChanged(open bool) {
+	hc.circuitBreakerLock.Lock()
+	defer hc.circuitBreakerLock.Unlock()
+	if open {
+		hc.openCircuitBreakers++
+	} else {
+		hc.openCircuitBreakers--
+	}
+
+	var err error
+	if hc.openCircuitBreakers > 0 {
+		err = hc.Fail(CircuitBreakerHealthComponentName)
+	} else {
+		err = hc.Ok(CircuitBreakerHealthComponentName)
+	}
+	if err != nil {
+		logger.Errorf("Unable to update health status: %s", err)
+	}
+}
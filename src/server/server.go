@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"net/http"
 
 	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
@@ -11,6 +12,9 @@ import (
 	"google.golang.org/grpc"
 )
 
+// Function handling a rate limit request, e.g. a method of the rate limit service.
+type RateLimitRequestHandler func(context.Context, *pb.RateLimitRequest) (*pb.RateLimitResponse, error)
+
 type Server interface {
 	/**
 	 * Starts the HTTP and gRPC servers. This should be done after
@@ -30,6 +34,16 @@ type Server interface {
 	AddDebugHttpEndpoint(path string, help string, handler http.HandlerFunc)
 	AddJsonHandler(pb.RateLimitServiceServer)
 
+	/**
+	 * Add an HTTP endpoint for the JSON form of rate limit requests, which are passed to the handler.
+	 */
+	AddJsonRequestHandler(path string, handler RateLimitRequestHandler)
+
+	/**
+	 * Add an HTTP endpoint like AddJsonRequestHandler, which only serves requests authorized with a bearer token.
+	 */
+	AddAuthenticatedJsonRequestHandler(path string, token string, handler RateLimitRequestHandler)
+
 	/**
 	 * Returns the embedded gRPC server to be used for registering gRPC endpoints.
 	 */
@@ -34,6 +34,7 @@ import (
 
 	"github.com/envoyproxy/ratelimit/src/limiter"
 	"github.com/envoyproxy/ratelimit/src/settings"
+	"github.com/envoyproxy/ratelimit/src/utils"
 
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.opentelemetry.io/otel"
@@ -85,6 +86,12 @@ func (server *server) AddDebugHttpEndpoint(path string, help string, handler htt
 // example usage from cURL with domain "dummy" and descriptor "perday":
 // echo '{"domain": "dummy", "descriptors": [{"entries": [{"key": "perday"}]}]}' | curl -vvvXPOST --data @/dev/stdin localhost:8080/json
 func NewJsonHandler(svc pb.RateLimitServiceServer) func(http.ResponseWriter, *http.Request) {
+	return NewJsonRequestHandler(svc.ShouldRateLimit)
+}
+
+// create an http/1 handler which passes the JSON form of a rate limit request to a handler and responds with the
+// JSON form of its rate limit response, e.g. for the /json/release endpoint.
+func NewJsonRequestHandler(handler RateLimitRequestHandler) func(http.ResponseWriter, *http.Request) {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		var req pb.RateLimitRequest
 
@@ -103,7 +110,7 @@ func NewJsonHandler(svc pb.RateLimitServiceServer) func(http.ResponseWriter, *ht
 			return
 		}
 
-		resp, err := svc.ShouldRateLimit(ctx, &req)
+		resp, err := handler(ctx, &req)
 		if err != nil {
 			logger.Warnf("error: %s", err.Error())
 			writeHttpStatus(writer, http.StatusBadRequest)
@@ -142,6 +149,31 @@ func NewJsonHandler(svc pb.RateLimitServiceServer) func(http.ResponseWriter, *ht
 	}
 }
 
+// create an http/1 handler like NewJsonRequestHandler, which only accepts POST requests authorized with a bearer
+// token, e.g. for the endpoints of the admin API.
+func NewAdminJsonRequestHandler(token string, handler RateLimitRequestHandler) func(http.ResponseWriter, *http.Request) {
+	jsonHandler := NewAuthenticatedRequestHandler(token, NewJsonRequestHandler(handler))
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			writeHttpStatus(writer, http.StatusMethodNotAllowed)
+			return
+		}
+		jsonHandler(writer, request)
+	}
+}
+
+// wrap an http/1 handler so that it only serves requests authorized with a bearer token, e.g. the token of the admin API.
+func NewAuthenticatedRequestHandler(token string, handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if !utils.IsBearerTokenValid(request.Header.Get("Authorization"), token) {
+			logger.Warnf("unauthorized request: %s", request.URL.Path)
+			writeHttpStatus(writer, http.StatusUnauthorized)
+			return
+		}
+		handler(writer, request)
+	}
+}
+
 func writeHttpStatus(writer http.ResponseWriter, code int) {
 	http.Error(writer, http.StatusText(code), code)
 }
@@ -162,6 +194,14 @@ func (server *server) AddJsonHandler(svc pb.RateLimitServiceServer) {
 	server.router.HandleFunc("/json", NewJsonHandler(svc))
 }
 
+func (server *server) AddJsonRequestHandler(path string, handler RateLimitRequestHandler) {
+	server.router.HandleFunc(path, NewJsonRequestHandler(handler))
+}
+
+func (server *server) AddAuthenticatedJsonRequestHandler(path string, token string, handler RateLimitRequestHandler) {
+	server.router.HandleFunc(path, NewAuthenticatedRequestHandler(token, NewJsonRequestHandler(handler)))
+}
+
 func (server *server) GrpcServer() *grpc.Server {
 	return server.grpcServer
 }
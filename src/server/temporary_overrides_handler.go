@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	logger "github.com/sirupsen/logrus"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Request creating a temporary override, which expires TtlSeconds after it is created.
+type temporaryOverrideRequest struct {
+	Key             string `json:"key"`
+	Unit            string `json:"unit"`
+	RequestsPerUnit uint32 `json:"requests_per_unit"`
+	TtlSeconds      int64  `json:"ttl_seconds"`
+}
+
+// create an http/1 handler listing (GET), creating (POST) and deleting (DELETE with a key parameter) temporary
+// overrides. Overrides are listed and returned as JSON.
+// @param store supplies the store of the overrides.
+// @param timeSource supplies the time source the expiry of created overrides is computed with.
+func NewTemporaryOverridesHandler(store config.TemporaryOverrideStore, timeSource utils.TimeSource) func(http.ResponseWriter, *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodGet:
+			writeJson(writer, store.List())
+		case http.MethodPost:
+			var overrideRequest temporaryOverrideRequest
+			if err := json.NewDecoder(request.Body).Decode(&overrideRequest); err != nil || overrideRequest.TtlSeconds <= 0 {
+				logger.Warnf("invalid temporary override request: %v", err)
+				writeHttpStatus(writer, http.StatusBadRequest)
+				return
+			}
+			override := &config.TemporaryOverride{
+				Key:             overrideRequest.Key,
+				Unit:            overrideRequest.Unit,
+				RequestsPerUnit: overrideRequest.RequestsPerUnit,
+				ExpiresAt:       timeSource.UnixNow() + overrideRequest.TtlSeconds,
+			}
+			if err := override.Validate(); err != nil {
+				logger.Warnf("invalid temporary override: %s", err.Error())
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if err := store.Set(override); err != nil {
+				logger.Errorf("error creating temporary override: %s", err.Error())
+				writeHttpStatus(writer, http.StatusInternalServerError)
+				return
+			}
+			writeJson(writer, override)
+		case http.MethodDelete:
+			key := request.URL.Query().Get("key")
+			if key == "" {
+				writeHttpStatus(writer, http.StatusBadRequest)
+				return
+			}
+			if err := store.Delete(key); err != nil {
+				logger.Errorf("error deleting temporary override: %s", err.Error())
+				writeHttpStatus(writer, http.StatusInternalServerError)
+				return
+			}
+			writer.WriteHeader(http.StatusOK)
+		default:
+			writeHttpStatus(writer, http.StatusMethodNotAllowed)
+		}
+	}
+}
+
+func writeJson(writer http.ResponseWriter, value interface{}) {
+	jsonResp, err := json.Marshal(value)
+	if err != nil {
+		logger.Errorf("error marshaling json: %s", err.Error())
+		writeHttpStatus(writer, http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Write(jsonResp)
+}
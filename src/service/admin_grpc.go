@@ -0,0 +1,128 @@
+package ratelimit
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+// Server API of the AdminService defined in api/ratelimit/service/ratelimit/v3/rls_admin.proto. Like the
+// ConcurrencyService, it reuses the messages of the envoy rate limit service and is registered by hand.
+type AdminServiceServer interface {
+	// Reset the counters of the limits matching the descriptors of a request.
+	ResetCounters(context.Context, *pb.RateLimitRequest) (*pb.RateLimitResponse, error)
+	// Set the counters of the limits matching the descriptors of a request to the hits_addend of the request.
+	SetCounters(context.Context, *pb.RateLimitRequest) (*pb.RateLimitResponse, error)
+	// Report the counters of the limits matching the descriptors of a request, like GetQuotaStatus.
+	GetCounters(context.Context, *pb.RateLimitRequest) (*pb.RateLimitResponse, error)
+}
+
+// Admin service which requires the token of the admin API as bearer token in the authorization metadata of calls.
+type authenticatedAdminServiceServer struct {
+	token string
+	srv   AdminServiceServer
+}
+
+// Wrap an admin service so that its calls are authenticated.
+// @param token supplies the token of the admin API.
+// @param srv supplies the admin service.
+func NewAuthenticatedAdminServiceServer(token string, srv AdminServiceServer) AdminServiceServer {
+	return &authenticatedAdminServiceServer{token: token, srv: srv}
+}
+
+// Check whether the authorization metadata of a call holds a bearer token.
+// @param ctx supplies the context of the call.
+// @param token supplies the expected token.
+// @param name supplies the name of the token for the error.
+// @return an Unauthenticated error if the call does not carry the token.
+func authenticate(ctx context.Context, token string, name string) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, authorization := range md.Get("authorization") {
+		if utils.IsBearerTokenValid(authorization, token) {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "invalid "+name+" token")
+}
+
+func (this *authenticatedAdminServiceServer) authenticate(ctx context.Context) error {
+	return authenticate(ctx, this.token, "admin")
+}
+
+func (this *authenticatedAdminServiceServer) ResetCounters(ctx context.Context, request *pb.RateLimitRequest) (*pb.RateLimitResponse, error) {
+	if err := this.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	return this.srv.ResetCounters(ctx, request)
+}
+
+func (this *authenticatedAdminServiceServer) SetCounters(ctx context.Context, request *pb.RateLimitRequest) (*pb.RateLimitResponse, error) {
+	if err := this.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	return this.srv.SetCounters(ctx, request)
+}
+
+func (this *authenticatedAdminServiceServer) GetCounters(ctx context.Context, request *pb.RateLimitRequest) (*pb.RateLimitResponse, error) {
+	if err := this.authenticate(ctx); err != nil {
+		return nil, err
+	}
+	return this.srv.GetCounters(ctx, request)
+}
+
+func RegisterAdminServiceServer(s *grpc.Server, srv AdminServiceServer) {
+	s.RegisterService(&adminServiceDesc, srv)
+}
+
+// Create the handler of a method of the admin service.
+// @param method supplies the name of the method.
+// @param call supplies the call of the method on the admin service.
+func adminServiceHandler(method string,
+	call func(AdminServiceServer, context.Context, *pb.RateLimitRequest) (*pb.RateLimitResponse, error),
+) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+		interceptor grpc.UnaryServerInterceptor,
+	) (interface{}, error) {
+		in := new(pb.RateLimitRequest)
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		if interceptor == nil {
+			return call(srv.(AdminServiceServer), ctx, in)
+		}
+		info := &grpc.UnaryServerInfo{
+			Server:     srv,
+			FullMethod: "/ratelimit.service.ratelimit.v3.AdminService/" + method,
+		}
+		handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+			return call(srv.(AdminServiceServer), ctx, req.(*pb.RateLimitRequest))
+		}
+		return interceptor(ctx, in, info, handler)
+	}
+}
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ratelimit.service.ratelimit.v3.AdminService",
+	HandlerType: (*AdminServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ResetCounters",
+			Handler:    adminServiceHandler("ResetCounters", AdminServiceServer.ResetCounters),
+		},
+		{
+			MethodName: "SetCounters",
+			Handler:    adminServiceHandler("SetCounters", AdminServiceServer.SetCounters),
+		},
+		{
+			MethodName: "GetCounters",
+			Handler:    adminServiceHandler("GetCounters", AdminServiceServer.GetCounters),
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "ratelimit/service/ratelimit/v3/rls_admin.proto",
+}
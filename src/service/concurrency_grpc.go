@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Server API of the ConcurrencyService defined in api/ratelimit/service/ratelimit/v3/rls_concurrency.proto. It
+// reuses the messages of the envoy rate limit service, so the service is registered by hand rather than generated.
+type ConcurrencyServiceServer interface {
+	// Release the slots of the concurrency limits matching the descriptors of a request, once the request that
+	// acquired them through ShouldRateLimit has finished.
+	Release(context.Context, *pb.RateLimitRequest) (*pb.RateLimitResponse, error)
+}
+
+// Concurrency service which requires the refund token as bearer token in the authorization metadata of calls, so that
+// clients of the rate limit service cannot release slots they do not hold.
+type authenticatedConcurrencyServiceServer struct {
+	token string
+	srv   ConcurrencyServiceServer
+}
+
+// Wrap a concurrency service so that its calls are authenticated.
+// @param token supplies the refund token.
+// @param srv supplies the concurrency service.
+func NewAuthenticatedConcurrencyServiceServer(token string, srv ConcurrencyServiceServer) ConcurrencyServiceServer {
+	return &authenticatedConcurrencyServiceServer{token: token, srv: srv}
+}
+
+func (this *authenticatedConcurrencyServiceServer) Release(ctx context.Context, request *pb.RateLimitRequest) (*pb.RateLimitResponse, error) {
+	if err := authenticate(ctx, this.token, "refund"); err != nil {
+		return nil, err
+	}
+	return this.srv.Release(ctx, request)
+}
+
+func RegisterConcurrencyServiceServer(s *grpc.Server, srv ConcurrencyServiceServer) {
+	s.RegisterService(&concurrencyServiceDesc, srv)
+}
+
+func concurrencyServiceReleaseHandler(srv interface{}, ctx context.Context, dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(pb.RateLimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ConcurrencyServiceServer).Release(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ratelimit.service.ratelimit.v3.ConcurrencyService/Release",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ConcurrencyServiceServer).Release(ctx, req.(*pb.RateLimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var concurrencyServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ratelimit.service.ratelimit.v3.ConcurrencyService",
+	HandlerType: (*ConcurrencyServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Release",
+			Handler:    concurrencyServiceReleaseHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "ratelimit/service/ratelimit/v3/rls_concurrency.proto",
+}
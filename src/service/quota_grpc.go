@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Server API of the QuotaService defined in api/ratelimit/service/ratelimit/v3/rls_quota.proto. Like the
+// ConcurrencyService, it reuses the messages of the envoy rate limit service and is registered by hand.
+type QuotaServiceServer interface {
+	// Report the usage of the limits matching the descriptors of a request without counting any hits.
+	GetQuotaStatus(context.Context, *pb.RateLimitRequest) (*pb.RateLimitResponse, error)
+}
+
+func RegisterQuotaServiceServer(s *grpc.Server, srv QuotaServiceServer) {
+	s.RegisterService(&quotaServiceDesc, srv)
+}
+
+func quotaServiceGetQuotaStatusHandler(srv interface{}, ctx context.Context, dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(pb.RateLimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(QuotaServiceServer).GetQuotaStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ratelimit.service.ratelimit.v3.QuotaService/GetQuotaStatus",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(QuotaServiceServer).GetQuotaStatus(ctx, req.(*pb.RateLimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var quotaServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ratelimit.service.ratelimit.v3.QuotaService",
+	HandlerType: (*QuotaServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetQuotaStatus",
+			Handler:    quotaServiceGetQuotaStatusHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "ratelimit/service/ratelimit/v3/rls_quota.proto",
+}
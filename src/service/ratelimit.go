@@ -3,9 +3,11 @@ package ratelimit
 import (
 	"fmt"
 	"math"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
@@ -17,15 +19,17 @@ import (
 	"github.com/envoyproxy/ratelimit/src/utils"
 
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	pb_struct "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
 	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	logger "github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/envoyproxy/ratelimit/src/assert"
 	"github.com/envoyproxy/ratelimit/src/config"
 	"github.com/envoyproxy/ratelimit/src/limiter"
 	"github.com/envoyproxy/ratelimit/src/provider"
-	"github.com/envoyproxy/ratelimit/src/redis"
 	"github.com/envoyproxy/ratelimit/src/server"
 )
 
@@ -33,6 +37,10 @@ var tracer = otel.Tracer("ratelimit")
 
 type RateLimitServiceServer interface {
 	pb.RateLimitServiceServer
+	ConcurrencyServiceServer
+	RefundServiceServer
+	QuotaServiceServer
+	AdminServiceServer
 	GetCurrentConfig() (config.RateLimitConfig, bool)
 	SetConfig(updateEvent provider.ConfigUpdateEvent, healthyWithAtLeastOneConfigLoad bool)
 }
@@ -42,6 +50,9 @@ type service struct {
 	configUpdateEvent           <-chan provider.ConfigUpdateEvent
 	config                      config.RateLimitConfig
 	cache                       limiter.RateLimitCache
+	temporaryOverrides          config.TemporaryOverrideStore
+	limitStore                  config.LimitStore
+	localLimiter                *limiter.LocalLimiter
 	stats                       stats.ServiceStats
 	health                      *server.HealthChecker
 	customHeadersEnabled        bool
@@ -50,6 +61,8 @@ type service struct {
 	customHeaderResetHeader     string
 	customHeaderClock           utils.TimeSource
 	globalShadowMode            bool
+	failureMode                 config.FailureMode
+	backendTimeout              time.Duration
 }
 
 func (this *service) SetConfig(updateEvent provider.ConfigUpdateEvent, healthyWithAtLeastOneConfigLoad bool) {
@@ -84,6 +97,13 @@ func (this *service) SetConfig(updateEvent provider.ConfigUpdateEvent, healthyWi
 
 	rlSettings := settings.NewSettings()
 	this.globalShadowMode = rlSettings.GlobalShadowMode
+	failureMode, valid := config.ParseFailureMode(rlSettings.FailureMode)
+	if !valid || failureMode == config.FailureModeDefault {
+		logger.Errorf("Invalid failure mode '%s', failing requests while the cache is unavailable", rlSettings.FailureMode)
+		failureMode = config.FailureModeError
+	}
+	this.failureMode = failureMode
+	this.backendTimeout = rlSettings.BackendTimeout
 
 	if rlSettings.RateLimitResponseHeadersEnabled {
 		this.customHeadersEnabled = true
@@ -110,11 +130,12 @@ func checkServiceErr(something bool, msg string) {
 	}
 }
 
-func (this *service) constructLimitsToCheck(request *pb.RateLimitRequest, ctx context.Context, snappedConfig config.RateLimitConfig) ([]*config.RateLimit, []bool) {
+func (this *service) constructLimitsToCheck(request *pb.RateLimitRequest, ctx context.Context, snappedConfig config.RateLimitConfig) ([][]*config.RateLimit, []bool, []uint64) {
 	checkServiceErr(snappedConfig != nil, "no rate limit configuration loaded")
 
-	limitsToCheck := make([]*config.RateLimit, len(request.Descriptors))
+	limitsToCheck := make([][]*config.RateLimit, len(request.Descriptors))
 	isUnlimited := make([]bool, len(request.Descriptors))
+	costs := make([]uint64, len(request.Descriptors))
 
 	replacing := make(map[string]bool)
 
@@ -129,49 +150,173 @@ func (this *service) constructLimitsToCheck(request *pb.RateLimitRequest, ctx co
 			}
 			logger.Debugf("got descriptor: %s", strings.Join(descriptorEntryStrings, ","))
 		}
-		limitsToCheck[i] = snappedConfig.GetLimit(ctx, request.Domain, descriptor)
+		limit := snappedConfig.GetLimit(ctx, request.Domain, descriptor)
+		if limit != nil {
+			limitsToCheck[i] = this.withTemporaryOverrides(this.withStoredLimits(descriptor,
+				withAncestorLimits(append([]*config.RateLimit{limit}, limit.AdditionalLimits...))))
+			// The cost of the descriptor applies to all of its limits, including those of its ancestors.
+			costs[i] = limit.Cost
+		}
 		if logger.IsLevelEnabled(logger.DebugLevel) {
-			if limitsToCheck[i] == nil {
+			if limit == nil {
 				logger.Debugf("descriptor does not match any limit, no limits applied")
+			} else if limit.Unlimited {
+				logger.Debugf("descriptor is unlimited, not passing to the cache")
 			} else {
-				if limitsToCheck[i].Unlimited {
-					logger.Debugf("descriptor is unlimited, not passing to the cache")
-				} else {
+				for _, limit := range limitsToCheck[i] {
 					logger.Debugf(
 						"applying limit: %d requests per %s, shadow_mode: %t",
-						limitsToCheck[i].Limit.RequestsPerUnit,
-						limitsToCheck[i].Limit.Unit.String(),
-						limitsToCheck[i].ShadowMode,
+						limit.Limit.RequestsPerUnit,
+						limit.Limit.Unit.String(),
+						limit.ShadowMode,
 					)
 				}
 			}
 		}
 
-		if limitsToCheck[i] != nil {
-			for _, replace := range limitsToCheck[i].Replaces {
+		for _, limit := range limitsToCheck[i] {
+			for _, replace := range limit.Replaces {
 				replacing[replace] = true
 			}
+		}
+
+		if limit != nil && limit.Unlimited {
+			isUnlimited[i] = true
+			limitsToCheck[i] = nil
+		}
+	}
 
-			if limitsToCheck[i].Unlimited {
-				isUnlimited[i] = true
-				limitsToCheck[i] = nil
+	for i, limits := range limitsToCheck {
+		var remainingLimits []*config.RateLimit
+		for _, limit := range limits {
+			if _, exists := replacing[limit.Name]; limit.Name != "" && exists {
+				if logger.IsLevelEnabled(logger.DebugLevel) {
+					logger.Debugf("replacing %s", limit.Name)
+				}
+				continue
 			}
+			remainingLimits = append(remainingLimits, limit)
 		}
+		limitsToCheck[i] = remainingLimits
 	}
+	return limitsToCheck, isUnlimited, costs
+}
 
-	for i, limit := range limitsToCheck {
-		if limit == nil || limit.Name == "" {
+// Replace the limits of limit_from_store descriptors with the stored limits of the values of the descriptor.
+// @param descriptor supplies the descriptor of the request.
+// @param limits supplies the limits of the descriptor, which are replaced in place.
+// @return the limits.
+func (this *service) withStoredLimits(descriptor *pb_struct.RateLimitDescriptor, limits []*config.RateLimit) []*config.RateLimit {
+	if this.limitStore == nil {
+		return limits
+	}
+	for i, limit := range limits {
+		if !limit.LimitFromStore || limit.DescriptorDepth > len(descriptor.Entries) {
 			continue
 		}
-		_, exists := replacing[limit.Name]
-		if exists {
-			limitsToCheck[i] = nil
-			if logger.IsLevelEnabled(logger.DebugLevel) {
-				logger.Debugf("replacing %s", limit.Name)
+		// Limits of ancestor descriptors are stored for the value of their own entry.
+		entry := descriptor.Entries[len(descriptor.Entries)-1]
+		if limit.DescriptorDepth > 0 {
+			entry = descriptor.Entries[limit.DescriptorDepth-1]
+		}
+		if stored := this.limitStore.Get(entry.Value); stored != nil {
+			logger.Debugf("applying stored limit of %s: %d requests per %s", entry.Value, stored.RequestsPerUnit,
+				stored.Unit.String())
+			limits[i] = limit.WithStoredLimit(stored)
+		}
+	}
+	return limits
+}
+
+// Replace the limits which have a temporary override with their overridden copy.
+// @param limits supplies the limits of a descriptor, which are replaced in place.
+// @return the limits.
+func (this *service) withTemporaryOverrides(limits []*config.RateLimit) []*config.RateLimit {
+	if this.temporaryOverrides == nil {
+		return limits
+	}
+	for i, limit := range limits {
+		if limit.Unlimited || limit.Blocked {
+			continue
+		}
+		if override := this.temporaryOverrides.Get(limit.FullKey); override != nil {
+			logger.Debugf("applying temporary override of %s: %d requests per %s", limit.FullKey,
+				override.RequestsPerUnit, override.Unit)
+			limits[i] = limit.WithTemporaryOverride(override)
+		}
+	}
+	return limits
+}
+
+// Add the limits of ancestor descriptors which the limits of a descriptor also count against, including those of
+// further ancestors the ancestor limits count against.
+// @param limits supplies the limits of a descriptor.
+// @return the limits of the descriptor followed by the limits of its ancestors.
+func withAncestorLimits(limits []*config.RateLimit) []*config.RateLimit {
+	for i := 0; i < len(limits); i++ {
+		for _, ancestorLimit := range limits[i].AlsoCountsAgainst {
+			if slices.Contains(limits, ancestorLimit) {
+				continue
 			}
+			limits = append(limits, ancestorLimit)
+			limits = append(limits, ancestorLimit.AdditionalLimits...)
 		}
 	}
-	return limitsToCheck, isUnlimited
+	return limits
+}
+
+// Flatten the limits of the descriptors of a request, so that descriptors with several limits are passed to the
+// cache once per limit.
+// @param request supplies the request to check.
+// @param limitsToCheck supplies the limits of each descriptor of the request.
+// @param costs supplies the cost of each descriptor of the request, which multiplies its hits addend.
+// @return the request with a descriptor per limit, the limit of each of its descriptors and the index of the
+// descriptor of the original request each of its descriptors belongs to.
+func flattenLimitsToCheck(request *pb.RateLimitRequest, limitsToCheck [][]*config.RateLimit, costs []uint64) (
+	*pb.RateLimitRequest, []*config.RateLimit, []int,
+) {
+	isRewritten := false
+	for i, limits := range limitsToCheck {
+		isRewritten = isRewritten || len(limits) > 1 || (len(limits) > 0 && costs[i] > 1)
+	}
+
+	flattenedRequest := request
+	if isRewritten {
+		flattenedRequest = &pb.RateLimitRequest{Domain: request.Domain, HitsAddend: request.HitsAddend}
+	}
+	hitsAddends := utils.GetHitsAddends(request)
+	var flattenedLimits []*config.RateLimit
+	var descriptorIndexes []int
+	for i, limits := range limitsToCheck {
+		descriptor := request.Descriptors[i]
+		if len(limits) == 0 {
+			// Descriptors without limits are passed to the cache with a nil limit.
+			limits = []*config.RateLimit{nil}
+		} else if costs[i] > 1 {
+			// The descriptor of the request is not modified, the cache gets a copy with the cost in its hits addend.
+			descriptor = &pb_struct.RateLimitDescriptor{
+				Entries:    descriptor.Entries,
+				Limit:      descriptor.Limit,
+				HitsAddend: &wrapperspb.UInt64Value{Value: hitsAddends[i] * costs[i]},
+			}
+		}
+		for _, limit := range limits {
+			if isRewritten {
+				flattenedRequest.Descriptors = append(flattenedRequest.Descriptors, descriptor)
+			}
+			flattenedLimits = append(flattenedLimits, limit)
+			descriptorIndexes = append(descriptorIndexes, i)
+		}
+	}
+	return flattenedRequest, flattenedLimits, descriptorIndexes
+}
+
+// Check whether a status of a limit is more restrictive than the status of another limit of the same descriptor.
+func isMoreRestrictive(status *pb.RateLimitResponse_DescriptorStatus, other *pb.RateLimitResponse_DescriptorStatus) bool {
+	if status.Code != other.Code {
+		return status.Code == pb.RateLimitResponse_OVER_LIMIT
+	}
+	return status.CurrentLimit != nil && (other.CurrentLimit == nil || status.LimitRemaining < other.LimitRemaining)
 }
 
 const MaxUint32 = uint32(1<<32 - 1)
@@ -183,13 +328,27 @@ func (this *service) shouldRateLimitWorker(
 	checkServiceErr(len(request.Descriptors) != 0, "rate limit descriptor list must not be empty")
 
 	snappedConfig, globalShadowMode := this.GetCurrentConfig()
-	limitsToCheck, isUnlimited := this.constructLimitsToCheck(request, ctx, snappedConfig)
+	limitsPerDescriptor, isUnlimited, costs := this.constructLimitsToCheck(request, ctx, snappedConfig)
+	blockedLimits := takeBlockedLimits(limitsPerDescriptor)
 
-	assert.Assert(len(limitsToCheck) == len(isUnlimited))
-	assert.Assert(len(limitsToCheck) == len(request.Descriptors))
+	assert.Assert(len(limitsPerDescriptor) == len(isUnlimited))
+	assert.Assert(len(limitsPerDescriptor) == len(request.Descriptors))
 
-	responseDescriptorStatuses := this.cache.DoLimit(ctx, request, limitsToCheck)
-	assert.Assert(len(limitsToCheck) == len(responseDescriptorStatuses))
+	limitsRequest, limits, descriptorIndexes := flattenLimitsToCheck(request, limitsPerDescriptor, costs)
+	limitStatuses, degraded := this.doLimit(ctx, limitsRequest, limits)
+	assert.Assert(len(limits) == len(limitStatuses))
+
+	// Each descriptor reports the most restrictive status of its limits.
+	responseDescriptorStatuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	limitsToCheck := make([]*config.RateLimit, len(request.Descriptors))
+	for i, limitStatus := range limitStatuses {
+		descriptorIndex := descriptorIndexes[i]
+		if responseDescriptorStatuses[descriptorIndex] == nil ||
+			isMoreRestrictive(limitStatus, responseDescriptorStatuses[descriptorIndex]) {
+			responseDescriptorStatuses[descriptorIndex] = limitStatus
+			limitsToCheck[descriptorIndex] = limits[i]
+		}
+	}
 
 	response := &pb.RateLimitResponse{}
 	response.Statuses = make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
@@ -198,13 +357,20 @@ func (this *service) shouldRateLimitWorker(
 	// Keep track of the descriptor which is closest to hit the ratelimit
 	minLimitRemaining := MaxUint32
 	var minimumDescriptor *pb.RateLimitResponse_DescriptorStatus = nil
+	var minimumLimit *config.RateLimit = nil
 
+	hitsAddends := utils.GetHitsAddends(request)
 	for i, descriptorStatus := range responseDescriptorStatuses {
+		if blockedLimits[i] != nil {
+			descriptorStatus = blockedStatus(blockedLimits[i], hitsAddends[i])
+		}
+
 		// Keep track of the descriptor closest to hit the ratelimit
 		if this.customHeadersEnabled &&
 			descriptorStatus.CurrentLimit != nil &&
 			descriptorStatus.LimitRemaining < minLimitRemaining {
 			minimumDescriptor = descriptorStatus
+			minimumLimit = limitsToCheck[i]
 			minLimitRemaining = descriptorStatus.LimitRemaining
 		}
 
@@ -214,11 +380,15 @@ func (this *service) shouldRateLimitWorker(
 				LimitRemaining: math.MaxUint32,
 			}
 		} else {
+			if blockedLimits[i] != nil {
+				limitsToCheck[i] = blockedLimits[i]
+			}
 			response.Statuses[i] = descriptorStatus
 			if descriptorStatus.Code == pb.RateLimitResponse_OVER_LIMIT {
 				finalCode = descriptorStatus.Code
 
 				minimumDescriptor = descriptorStatus
+				minimumLimit = limitsToCheck[i]
 				minLimitRemaining = 0
 			}
 		}
@@ -227,9 +397,9 @@ func (this *service) shouldRateLimitWorker(
 	// Add Headers if requested
 	if this.customHeadersEnabled && minimumDescriptor != nil {
 		response.ResponseHeadersToAdd = []*core.HeaderValue{
-			this.rateLimitLimitHeader(minimumDescriptor),
+			this.rateLimitLimitHeader(minimumDescriptor, minimumLimit),
 			this.rateLimitRemainingHeader(minimumDescriptor),
-			this.rateLimitResetHeader(minimumDescriptor),
+			this.rateLimitResetHeader(minimumDescriptor, minimumLimit),
 		}
 	}
 
@@ -239,16 +409,377 @@ func (this *service) shouldRateLimitWorker(
 		this.stats.GlobalShadowMode.Inc()
 	}
 
+	// Requests over the limit do not proceed, so the slots they acquired of concurrency limits are released. No slots
+	// are acquired while the cache is unavailable.
+	if finalCode == pb.RateLimitResponse_OVER_LIMIT && !degraded {
+		this.releaseAcquiredSlots(ctx, limitsRequest, limits, limitStatuses)
+	}
+
 	response.OverallCode = finalCode
 	return response
 }
 
-func (this *service) rateLimitLimitHeader(descriptor *pb.RateLimitResponse_DescriptorStatus) *core.HeaderValue {
-	// Limit header only provides the mandatory part from the spec, the actual limit
-	// the optional quota policy is currently not provided
+// Check the limits of a request in the cache. While the cache is unavailable, the limits are checked according to
+// their failure mode instead.
+// @param request supplies the request with a descriptor per limit.
+// @param limits supplies the limit of each descriptor of the request.
+// @return the status of each limit, and whether the limits were checked according to their failure mode.
+// @throws CacheUnavailableError if the cache is unavailable and a limit fails requests in this case.
+func (this *service) doLimit(ctx context.Context, request *pb.RateLimitRequest, limits []*config.RateLimit) (
+	limitStatuses []*pb.RateLimitResponse_DescriptorStatus, degraded bool,
+) {
+	this.configLock.RLock()
+	backendTimeout := this.backendTimeout
+	this.configLock.RUnlock()
+
+	defer func() {
+		err := recover()
+		if err == nil {
+			return
+		}
+		cacheError, ok := err.(limiter.CacheUnavailableError)
+		if !ok {
+			panic(err)
+		}
+		if _, ok := cacheError.(limiter.CacheTimeoutError); ok {
+			this.stats.ShouldRateLimit.BackendTimeout.Inc()
+		}
+		limitStatuses = this.failureModeStatuses(request, limits, cacheError)
+		degraded = true
+	}()
+
+	// The cache is waited for until the earlier of the deadline of the request and the backend timeout.
+	if backendTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, backendTimeout)
+		defer cancel()
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return this.cache.DoLimit(ctx, request, limits), false
+	}
+	return waitForCache(ctx, func() []*pb.RateLimitResponse_DescriptorStatus {
+		return this.cache.DoLimit(ctx, request, limits)
+	}), false
+}
+
+// Call the cache, waiting for it until the deadline of a context. The clients of the cache time out their calls after
+// the backend timeout, so calls which are no longer waited for still end shortly after.
+// @param ctx supplies the context with the deadline.
+// @param call supplies the call to the cache.
+// @return the result of the call.
+// @throws CacheTimeoutError if the deadline passed before the call returned.
+func waitForCache(ctx context.Context, call func() []*pb.RateLimitResponse_DescriptorStatus,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	// Requests whose deadline passed are not sent to the cache, as their result would not be used.
+	if err := ctx.Err(); err != nil {
+		panic(limiter.CacheTimeoutError(fmt.Sprintf("backend timeout: %s", err)))
+	}
+
+	type result struct {
+		statuses []*pb.RateLimitResponse_DescriptorStatus
+		err      interface{}
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() {
+			r.err = recover()
+			done <- r
+		}()
+		r.statuses = call()
+	}()
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			panic(r.err)
+		}
+		return r.statuses
+	case <-ctx.Done():
+		panic(limiter.CacheTimeoutError(fmt.Sprintf("backend timeout: %s", ctx.Err())))
+	}
+}
+
+// Returns the failure mode of a limit, the failure mode of the service for limits without one.
+func (this *service) limitFailureMode(limit *config.RateLimit, defaultFailureMode config.FailureMode) config.FailureMode {
+	failureMode := limit.FailureMode
+	if failureMode == config.FailureModeDefault {
+		failureMode = defaultFailureMode
+	}
+	if failureMode == config.FailureModeLocal && this.localLimiter == nil {
+		return config.FailureModeError
+	}
+	return failureMode
+}
+
+// Check the limits of a request according to their failure mode while the cache is unavailable.
+// @param request supplies the request with a descriptor per limit.
+// @param limits supplies the limit of each descriptor of the request.
+// @param cacheError supplies the error of the cache.
+// @return the status of each limit.
+// @throws CacheUnavailableError if a limit fails requests while the cache is unavailable.
+func (this *service) failureModeStatuses(request *pb.RateLimitRequest, limits []*config.RateLimit,
+	cacheError limiter.CacheUnavailableError,
+) []*pb.RateLimitResponse_DescriptorStatus {
+	this.configLock.RLock()
+	defaultFailureMode := this.failureMode
+	this.configLock.RUnlock()
+
+	failureModes := make([]config.FailureMode, len(limits))
+	var localLimits []*config.RateLimit
+	for i, limit := range limits {
+		if limit == nil {
+			continue
+		}
+		failureModes[i] = this.limitFailureMode(limit, defaultFailureMode)
+		switch failureModes[i] {
+		case config.FailureModeError:
+			panic(cacheError)
+		case config.FailureModeLocal:
+			if localLimits == nil {
+				localLimits = make([]*config.RateLimit, len(limits))
+			}
+			localLimits[i] = limit
+		}
+	}
+	logger.Warnf("checking limits according to their failure mode, as the cache is unavailable: %s", cacheError)
+
+	var localStatuses []*pb.RateLimitResponse_DescriptorStatus
+	if localLimits != nil {
+		localStatuses = this.localLimiter.DoLimit(request, localLimits)
+	}
+	limitStatuses := make([]*pb.RateLimitResponse_DescriptorStatus, len(limits))
+	for i, limit := range limits {
+		switch failureModes[i] {
+		case config.FailureModeAllow:
+			this.stats.FailureMode.Allow.Inc()
+			limitStatuses[i] = &pb.RateLimitResponse_DescriptorStatus{
+				Code:           pb.RateLimitResponse_OK,
+				CurrentLimit:   limit.Limit,
+				LimitRemaining: limit.Limit.RequestsPerUnit,
+			}
+		case config.FailureModeDeny:
+			this.stats.FailureMode.Deny.Inc()
+			limitStatuses[i] = &pb.RateLimitResponse_DescriptorStatus{
+				Code:         pb.RateLimitResponse_OVER_LIMIT,
+				CurrentLimit: limit.Limit,
+			}
+			if limit.ShadowMode {
+				limitStatuses[i].Code = pb.RateLimitResponse_OK
+			}
+		case config.FailureModeLocal:
+			this.stats.FailureMode.Local.Inc()
+			limitStatuses[i] = localStatuses[i]
+		default:
+			// Descriptors without limits.
+			limitStatuses[i] = &pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_OK}
+		}
+	}
+	return limitStatuses
+}
+
+// Remove the blocked limits from the limits to check, since the hits of blocked descriptors are not counted.
+// @param limitsPerDescriptor supplies the limits of each descriptor, from which blocked limits are removed.
+// @return the blocked limit of each descriptor, nil for descriptors which are not blocked.
+func takeBlockedLimits(limitsPerDescriptor [][]*config.RateLimit) []*config.RateLimit {
+	blockedLimits := make([]*config.RateLimit, len(limitsPerDescriptor))
+	for i, limits := range limitsPerDescriptor {
+		// A blocked limit is the only limit of its descriptor.
+		if len(limits) == 1 && limits[0].Blocked {
+			blockedLimits[i] = limits[0]
+			limitsPerDescriptor[i] = nil
+		}
+	}
+	return blockedLimits
+}
+
+// Generate the status of a blocked descriptor, which is over the limit unless the limit is in shadow mode.
+// @param limit supplies the blocked limit.
+// @param hitsAddend supplies the hits of the descriptor.
+func blockedStatus(limit *config.RateLimit, hitsAddend uint64) *pb.RateLimitResponse_DescriptorStatus {
+	logger.Debugf("descriptor is blocked: %s", limit.FullKey)
+	limit.Stats.TotalHits.Add(hitsAddend)
+	limit.Stats.OverLimit.Add(hitsAddend)
+	status := &pb.RateLimitResponse_DescriptorStatus{
+		Code:           pb.RateLimitResponse_OVER_LIMIT,
+		CurrentLimit:   limit.Limit,
+		LimitRemaining: 0,
+	}
+	if limit.ShadowMode {
+		status.Code = pb.RateLimitResponse_OK
+		limit.Stats.ShadowMode.Add(hitsAddend)
+	}
+	return status
+}
+
+func (this *service) releaseAcquiredSlots(ctx context.Context, request *pb.RateLimitRequest,
+	limits []*config.RateLimit, statuses []*pb.RateLimitResponse_DescriptorStatus,
+) {
+	var limitsToRelease []*config.RateLimit
+	for i, limit := range limits {
+		// Slots are acquired for all hits within the limit, and for hits over the limit in shadow mode.
+		if limit == nil || limit.Algorithm != config.Concurrency || statuses[i].Code != pb.RateLimitResponse_OK {
+			continue
+		}
+		if limitsToRelease == nil {
+			limitsToRelease = make([]*config.RateLimit, len(limits))
+		}
+		limitsToRelease[i] = limit
+	}
+	if limitsToRelease != nil {
+		this.cache.Release(ctx, request, limitsToRelease)
+	}
+}
+
+func (this *service) releaseWorker(
+	ctx context.Context, request *pb.RateLimitRequest,
+) *pb.RateLimitResponse {
+	checkServiceErr(request.Domain != "", "rate limit domain must not be empty")
+	checkServiceErr(len(request.Descriptors) != 0, "rate limit descriptor list must not be empty")
+
+	snappedConfig, _ := this.GetCurrentConfig()
+	limitsPerDescriptor, _, costs := this.constructLimitsToCheck(request, ctx, snappedConfig)
+	for i, limits := range limitsPerDescriptor {
+		var concurrencyLimits []*config.RateLimit
+		for _, limit := range limits {
+			if limit.Algorithm == config.Concurrency {
+				concurrencyLimits = append(concurrencyLimits, limit)
+			}
+		}
+		limitsPerDescriptor[i] = concurrencyLimits
+	}
+
+	releaseRequest, limitsToRelease, descriptorIndexes := flattenLimitsToCheck(request, limitsPerDescriptor, costs)
+	limitStatuses := this.cache.Release(ctx, releaseRequest, limitsToRelease)
+	assert.Assert(len(limitsToRelease) == len(limitStatuses))
+
+	return newReturnedHitsResponse(len(request.Descriptors), limitStatuses, descriptorIndexes)
+}
+
+func (this *service) refundWorker(
+	ctx context.Context, request *pb.RateLimitRequest,
+) *pb.RateLimitResponse {
+	checkServiceErr(request.Domain != "", "rate limit domain must not be empty")
+	checkServiceErr(len(request.Descriptors) != 0, "rate limit descriptor list must not be empty")
+
+	snappedConfig, _ := this.GetCurrentConfig()
+	limitsPerDescriptor, _, costs := this.constructLimitsToCheck(request, ctx, snappedConfig)
+	takeBlockedLimits(limitsPerDescriptor)
+
+	refundRequest, limitsToRefund, descriptorIndexes := flattenLimitsToCheck(request, limitsPerDescriptor, costs)
+	limitStatuses := this.cache.Refund(ctx, refundRequest, limitsToRefund)
+	assert.Assert(len(limitsToRefund) == len(limitStatuses))
+
+	return newReturnedHitsResponse(len(request.Descriptors), limitStatuses, descriptorIndexes)
+}
+
+// Report the usage of the limits of a request as read or updated by the cache.
+// @param ctx supplies the request context.
+// @param request supplies the request whose limits are reported.
+// @param quotaStatuses supplies the call to the cache returning the usage of the flattened limits of the request.
+func (this *service) quotaStatusWorker(
+	ctx context.Context, request *pb.RateLimitRequest,
+	quotaStatuses func(*pb.RateLimitRequest, []*config.RateLimit) []*limiter.QuotaStatus,
+) *pb.RateLimitResponse {
+	checkServiceErr(request.Domain != "", "rate limit domain must not be empty")
+	checkServiceErr(len(request.Descriptors) != 0, "rate limit descriptor list must not be empty")
+
+	snappedConfig, _ := this.GetCurrentConfig()
+	limitsPerDescriptor, isUnlimited, _ := this.constructLimitsToCheck(request, ctx, snappedConfig)
+	blockedLimits := takeBlockedLimits(limitsPerDescriptor)
+
+	// No hits are counted, so the costs of the descriptors do not apply.
+	quotaRequest, limits, descriptorIndexes := flattenLimitsToCheck(request, limitsPerDescriptor,
+		make([]uint64, len(request.Descriptors)))
+	limitStatuses := quotaStatuses(quotaRequest, limits)
+	assert.Assert(len(limits) == len(limitStatuses))
+
+	response := &pb.RateLimitResponse{OverallCode: pb.RateLimitResponse_OK}
+	response.Statuses = make([]*pb.RateLimitResponse_DescriptorStatus, len(request.Descriptors))
+	quotas := []interface{}{}
+	for i, quotaStatus := range limitStatuses {
+		if quotaStatus == nil {
+			continue
+		}
+		descriptorIndex := descriptorIndexes[i]
+		if response.Statuses[descriptorIndex] == nil ||
+			isMoreRestrictive(quotaStatus.Status, response.Statuses[descriptorIndex]) {
+			response.Statuses[descriptorIndex] = quotaStatus.Status
+		}
+
+		quota := map[string]interface{}{
+			"descriptor_index":  descriptorIndex,
+			"key":               limits[i].Stats.Key,
+			"unit":              limits[i].Limit.Unit.String(),
+			"requests_per_unit": limits[i].Limit.RequestsPerUnit,
+			"count":             quotaStatus.Count,
+			"limit_remaining":   quotaStatus.Status.LimitRemaining,
+		}
+		if quotaStatus.Status.DurationUntilReset != nil {
+			quota["duration_until_reset_seconds"] = quotaStatus.Status.DurationUntilReset.AsDuration().Seconds()
+		}
+		quotas = append(quotas, quota)
+	}
+	for i, status := range response.Statuses {
+		if isUnlimited[i] {
+			response.Statuses[i] = &pb.RateLimitResponse_DescriptorStatus{
+				Code:           pb.RateLimitResponse_OK,
+				LimitRemaining: math.MaxUint32,
+			}
+		} else if blockedLimits[i] != nil {
+			response.Statuses[i] = &pb.RateLimitResponse_DescriptorStatus{
+				Code:           pb.RateLimitResponse_OVER_LIMIT,
+				CurrentLimit:   blockedLimits[i].Limit,
+				LimitRemaining: 0,
+			}
+		} else if status == nil {
+			// No limit matches the descriptor, or none of its limits was updated.
+			response.Statuses[i] = &pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_UNKNOWN}
+		}
+	}
+
+	// The response has no fields for the count of each limit, so they are reported in its dynamic metadata.
+	metadata, err := structpb.NewStruct(map[string]interface{}{"quotas": quotas})
+	checkServiceErr(err == nil, "failed to build quota metadata")
+	response.DynamicMetadata = metadata
+	return response
+}
+
+// Build the response of a call returning hits to the limits of a request, which is always OK.
+// @param descriptors supplies the number of descriptors of the request.
+// @param limitStatuses supplies the statuses of the flattened limits, nil for limits which were skipped.
+// @param descriptorIndexes supplies the index of the descriptor each flattened limit belongs to.
+func newReturnedHitsResponse(descriptors int, limitStatuses []*pb.RateLimitResponse_DescriptorStatus,
+	descriptorIndexes []int,
+) *pb.RateLimitResponse {
+	response := &pb.RateLimitResponse{OverallCode: pb.RateLimitResponse_OK}
+	response.Statuses = make([]*pb.RateLimitResponse_DescriptorStatus, descriptors)
+	for i, limitStatus := range limitStatuses {
+		if limitStatus == nil {
+			limitStatus = &pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_OK}
+		}
+		descriptorIndex := descriptorIndexes[i]
+		if response.Statuses[descriptorIndex] == nil ||
+			isMoreRestrictive(limitStatus, response.Statuses[descriptorIndex]) {
+			response.Statuses[descriptorIndex] = limitStatus
+		}
+	}
+	return response
+}
+
+func (this *service) rateLimitLimitHeader(
+	descriptor *pb.RateLimitResponse_DescriptorStatus, limit *config.RateLimit,
+) *core.HeaderValue {
+	// Limit header only provides the mandatory part from the spec, the actual limit. Windows spanning multiple units
+	// can not be told from the unit of the limit, so their quota policy gives the window in seconds.
+	value := strconv.FormatUint(uint64(descriptor.CurrentLimit.RequestsPerUnit), 10)
+	if limit != nil && limit.UnitMultiplier > 1 {
+		value += ", " + value + ";w=" +
+			strconv.FormatInt(utils.UnitToDividerWithMultiplier(descriptor.CurrentLimit.Unit, limit.UnitMultiplier), 10)
+	}
 	return &core.HeaderValue{
 		Key:   this.customHeaderLimitHeader,
-		Value: strconv.FormatUint(uint64(descriptor.CurrentLimit.RequestsPerUnit), 10),
+		Value: value,
 	}
 }
 
@@ -261,11 +792,18 @@ func (this *service) rateLimitRemainingHeader(descriptor *pb.RateLimitResponse_D
 }
 
 func (this *service) rateLimitResetHeader(
-	descriptor *pb.RateLimitResponse_DescriptorStatus,
+	descriptor *pb.RateLimitResponse_DescriptorStatus, limit *config.RateLimit,
 ) *core.HeaderValue {
+	// The window of the limit may span multiple units or be aligned to the calendar.
+	var unitMultiplier uint32
+	var calendarLocation *time.Location
+	if limit != nil {
+		unitMultiplier = limit.UnitMultiplier
+		calendarLocation = limit.CalendarLocation
+	}
 	return &core.HeaderValue{
 		Key:   this.customHeaderResetHeader,
-		Value: strconv.FormatInt(utils.CalculateReset(&descriptor.CurrentLimit.Unit, this.customHeaderClock).GetSeconds(), 10),
+		Value: strconv.FormatInt(utils.CalculateWindowReset(&descriptor.CurrentLimit.Unit, unitMultiplier, calendarLocation, this.customHeaderClock).GetSeconds(), 10),
 	}
 }
 
@@ -292,7 +830,7 @@ func (this *service) ShouldRateLimit(
 
 		finalResponse = nil
 		switch t := err.(type) {
-		case redis.RedisError:
+		case limiter.CacheUnavailableError:
 			{
 				this.stats.ShouldRateLimit.RedisError.Inc()
 				finalError = t
@@ -313,24 +851,154 @@ func (this *service) ShouldRateLimit(
 	return response, nil
 }
 
+// Handle a call returning the statuses of the limits of a request, e.g. Release or Refund. Errors of the cache and of
+// the request fail the call, and are counted in the stats of the call.
+// @param ctx supplies the request context.
+// @param name supplies the name of the call.
+// @param request supplies the request.
+// @param callStats supplies the stats of the call.
+// @param worker supplies the handler of the request.
+func (this *service) handleRpc(
+	ctx context.Context,
+	name string,
+	request *pb.RateLimitRequest,
+	callStats stats.ShouldRateLimitStats,
+	worker func() *pb.RateLimitResponse,
+) (finalResponse *pb.RateLimitResponse, finalError error) {
+	// Generate trace
+	_, span := tracer.Start(ctx, name+" Execution",
+		trace.WithAttributes(
+			attribute.String("domain", request.Domain),
+			attribute.String("request string", request.String()),
+		),
+	)
+	defer span.End()
+
+	defer func() {
+		err := recover()
+		if err == nil {
+			return
+		}
+
+		logger.Debugf("caught error during call: %v", err)
+
+		finalResponse = nil
+		switch t := err.(type) {
+		case limiter.CacheUnavailableError:
+			{
+				callStats.RedisError.Inc()
+				finalError = t
+			}
+		case serviceError:
+			{
+				callStats.ServiceError.Inc()
+				finalError = t
+			}
+		default:
+			panic(err)
+		}
+	}()
+
+	response := worker()
+	logger.Debugf("returning %s response: %+v", name, response)
+
+	return response, nil
+}
+
+func (this *service) Release(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+) (*pb.RateLimitResponse, error) {
+	return this.handleRpc(ctx, "Release", request, this.stats.Release, func() *pb.RateLimitResponse {
+		return this.releaseWorker(ctx, request)
+	})
+}
+
+func (this *service) Refund(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+) (*pb.RateLimitResponse, error) {
+	return this.handleRpc(ctx, "Refund", request, this.stats.Refund, func() *pb.RateLimitResponse {
+		return this.refundWorker(ctx, request)
+	})
+}
+
+func (this *service) GetQuotaStatus(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+) (*pb.RateLimitResponse, error) {
+	return this.handleRpc(ctx, "GetQuotaStatus", request, this.stats.GetQuotaStatus, func() *pb.RateLimitResponse {
+		return this.quotaStatusWorker(ctx, request,
+			func(quotaRequest *pb.RateLimitRequest, limits []*config.RateLimit) []*limiter.QuotaStatus {
+				return this.cache.GetQuotaStatus(ctx, quotaRequest, limits)
+			})
+	})
+}
+
+func (this *service) ResetCounters(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+) (*pb.RateLimitResponse, error) {
+	return this.adminCall(ctx, "ResetCounters", request, 0)
+}
+
+func (this *service) SetCounters(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+) (*pb.RateLimitResponse, error) {
+	return this.adminCall(ctx, "SetCounters", request, uint64(request.HitsAddend))
+}
+
+func (this *service) GetCounters(
+	ctx context.Context,
+	request *pb.RateLimitRequest,
+) (*pb.RateLimitResponse, error) {
+	return this.GetQuotaStatus(ctx, request)
+}
+
+// Set the counters of the limits of a request through the admin API.
+// @param ctx supplies the request context.
+// @param method supplies the name of the admin method.
+// @param request supplies the request whose limits are set.
+// @param count supplies the number of hits to set, 0 to reset the limits.
+func (this *service) adminCall(
+	ctx context.Context,
+	method string,
+	request *pb.RateLimitRequest,
+	count uint64,
+) (*pb.RateLimitResponse, error) {
+	return this.handleRpc(ctx, method, request, this.stats.Admin, func() *pb.RateLimitResponse {
+		logger.Warnf("admin %s to %d hits: %s", method, count, request.String())
+		return this.quotaStatusWorker(ctx, request,
+			func(setRequest *pb.RateLimitRequest, limits []*config.RateLimit) []*limiter.QuotaStatus {
+				return this.cache.SetCounters(ctx, setRequest, limits, count)
+			})
+	})
+}
+
 func (this *service) GetCurrentConfig() (config.RateLimitConfig, bool) {
 	this.configLock.RLock()
 	defer this.configLock.RUnlock()
 	return this.config, this.globalShadowMode
 }
 
-func NewService(cache limiter.RateLimitCache, configProvider provider.RateLimitConfigProvider, statsManager stats.Manager,
+func NewService(cache limiter.RateLimitCache, temporaryOverrides config.TemporaryOverrideStore, limitStore config.LimitStore,
+	localLimiter *limiter.LocalLimiter, configProvider provider.RateLimitConfigProvider, statsManager stats.Manager,
 	health *server.HealthChecker, clock utils.TimeSource, shadowMode, forceStart bool, healthyWithAtLeastOneConfigLoad bool,
 ) RateLimitServiceServer {
 	newService := &service{
-		configLock:        sync.RWMutex{},
-		configUpdateEvent: configProvider.ConfigUpdateEvent(),
-		config:            nil,
-		cache:             cache,
-		stats:             statsManager.NewServiceStats(),
-		health:            health,
-		globalShadowMode:  shadowMode,
-		customHeaderClock: clock,
+		configLock:         sync.RWMutex{},
+		configUpdateEvent:  configProvider.ConfigUpdateEvent(),
+		config:             nil,
+		cache:              cache,
+		temporaryOverrides: temporaryOverrides,
+		limitStore:         limitStore,
+		localLimiter:       localLimiter,
+		failureMode:        config.FailureModeError,
+		stats:              statsManager.NewServiceStats(),
+		health:             health,
+		globalShadowMode:   shadowMode,
+		customHeaderClock:  clock,
 	}
 
 	if !forceStart {
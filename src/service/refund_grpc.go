@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// Server API of the RefundService defined in api/ratelimit/service/ratelimit/v3/rls_refund.proto. Like the
+// ConcurrencyService, it reuses the messages of the envoy rate limit service and is registered by hand.
+type RefundServiceServer interface {
+	// Refund the hits counted by ShouldRateLimit against the limits matching the descriptors of a request.
+	Refund(context.Context, *pb.RateLimitRequest) (*pb.RateLimitResponse, error)
+}
+
+// Refund service which requires the refund token as bearer token in the authorization metadata of calls, so that
+// clients of the rate limit service cannot give back their own hits.
+type authenticatedRefundServiceServer struct {
+	token string
+	srv   RefundServiceServer
+}
+
+// Wrap a refund service so that its calls are authenticated.
+// @param token supplies the refund token.
+// @param srv supplies the refund service.
+func NewAuthenticatedRefundServiceServer(token string, srv RefundServiceServer) RefundServiceServer {
+	return &authenticatedRefundServiceServer{token: token, srv: srv}
+}
+
+func (this *authenticatedRefundServiceServer) Refund(ctx context.Context, request *pb.RateLimitRequest) (*pb.RateLimitResponse, error) {
+	if err := authenticate(ctx, this.token, "refund"); err != nil {
+		return nil, err
+	}
+	return this.srv.Refund(ctx, request)
+}
+
+func RegisterRefundServiceServer(s *grpc.Server, srv RefundServiceServer) {
+	s.RegisterService(&refundServiceDesc, srv)
+}
+
+func refundServiceRefundHandler(srv interface{}, ctx context.Context, dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor,
+) (interface{}, error) {
+	in := new(pb.RateLimitRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RefundServiceServer).Refund(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/ratelimit.service.ratelimit.v3.RefundService/Refund",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RefundServiceServer).Refund(ctx, req.(*pb.RateLimitRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var refundServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ratelimit.service.ratelimit.v3.RefundService",
+	HandlerType: (*RefundServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Refund",
+			Handler:    refundServiceRefundHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "ratelimit/service/ratelimit/v3/rls_refund.proto",
+}
@@ -1,6 +1,9 @@
 package main
 
 import (
+	// Calendar aligned limits may be configured in any time zone, also on hosts without a time zone database.
+	_ "time/tzdata"
+
 	"github.com/envoyproxy/ratelimit/src/service_cmd/runner"
 	"github.com/envoyproxy/ratelimit/src/settings"
 )
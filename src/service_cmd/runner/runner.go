@@ -14,10 +14,14 @@ import (
 	gostats "github.com/lyft/gostats"
 	logger "github.com/sirupsen/logrus"
 
+	"github.com/envoyproxy/ratelimit/src/boltdb"
+	"github.com/envoyproxy/ratelimit/src/config"
 	"github.com/envoyproxy/ratelimit/src/godogstats"
 	"github.com/envoyproxy/ratelimit/src/limiter"
 	"github.com/envoyproxy/ratelimit/src/memcached"
+	"github.com/envoyproxy/ratelimit/src/memory"
 	"github.com/envoyproxy/ratelimit/src/metrics"
+	"github.com/envoyproxy/ratelimit/src/peer"
 	"github.com/envoyproxy/ratelimit/src/redis"
 	"github.com/envoyproxy/ratelimit/src/server"
 	ratelimit "github.com/envoyproxy/ratelimit/src/service"
@@ -90,7 +94,7 @@ func (runner *Runner) GetStatsStore() gostats.Store {
 
 func createLimiter(srv server.Server, s settings.Settings, localCache *freecache.Cache, statsManager stats.Manager) (limiter.RateLimitCache, io.Closer) {
 	switch s.BackendType {
-	case "redis", "":
+	case "redis", "redis_gcra", "":
 		return redis.NewRateLimiterCacheImplFromSettings(
 			s,
 			localCache,
@@ -107,13 +111,77 @@ func createLimiter(srv server.Server, s settings.Settings, localCache *freecache
 			rand.New(utils.NewLockedSource(time.Now().Unix())),
 			localCache,
 			srv.Scope(),
+			srv.HealthChecker(),
 			statsManager), &utils.MultiCloser{} // memcache client can't be closed
+	case "memory":
+		return memory.NewRateLimitCacheImplFromSettings(
+			s,
+			utils.NewTimeSourceImpl(),
+			rand.New(utils.NewLockedSource(time.Now().Unix())),
+			localCache,
+			statsManager)
+	case "boltdb":
+		return boltdb.NewRateLimitCacheImplFromSettings(
+			s,
+			utils.NewTimeSourceImpl(),
+			rand.New(utils.NewLockedSource(time.Now().Unix())),
+			localCache,
+			statsManager)
+	case "peer":
+		return peer.NewRateLimitCacheImplFromSettings(
+			s,
+			srv.GrpcServer(),
+			srv.Scope().Scope("peer"),
+			utils.NewTimeSourceImpl(),
+			rand.New(utils.NewLockedSource(time.Now().Unix())),
+			localCache,
+			statsManager)
 	default:
 		logger.Fatalf("Invalid setting for BackendType: %s", s.BackendType)
 		panic("This line should not be reachable")
 	}
 }
 
+// Temporary overrides are created through the admin API and shared by all instances through redis.
+func createTemporaryOverrideStore(srv server.Server, s settings.Settings) (config.TemporaryOverrideStore, io.Closer) {
+	if s.AdminApiToken == "" {
+		return nil, &utils.MultiCloser{}
+	}
+	switch s.BackendType {
+	case "redis", "redis_gcra", "":
+		return redis.NewTemporaryOverrideStoreFromSettings(s, srv, utils.NewTimeSourceImpl())
+	default:
+		logger.Warnf("Temporary overrides are not supported by the %s backend", s.BackendType)
+		return nil, &utils.MultiCloser{}
+	}
+}
+
+// Limits of limit_from_store descriptors are read from redis.
+func createLimitStore(srv server.Server, s settings.Settings) (config.LimitStore, io.Closer) {
+	if !s.LimitStoreEnabled {
+		return nil, &utils.MultiCloser{}
+	}
+	switch s.BackendType {
+	case "redis", "redis_gcra", "":
+		return redis.NewLimitStoreFromSettings(s, srv)
+	default:
+		logger.Warnf("Limits from store are not supported by the %s backend", s.BackendType)
+		return nil, &utils.MultiCloser{}
+	}
+}
+
+// Hits of limits with the local failure mode are counted in memory while the cache is unavailable.
+func createLocalLimiter(s settings.Settings, statsManager stats.Manager) *limiter.LocalLimiter {
+	if failureMode, valid := config.ParseFailureMode(s.FailureMode); !valid || failureMode == config.FailureModeDefault {
+		logger.Fatalf("Invalid setting for FailureMode: %s", s.FailureMode)
+	}
+	if s.FailureModeLocalCacheSizeInBytes == 0 {
+		return nil
+	}
+	return limiter.NewLocalLimiter(utils.NewTimeSourceImpl(), s.FailureModeLocalCacheSizeInBytes,
+		s.FailureModeLocalReplicas, s.NearLimitRatio, s.CacheKeyPrefix, statsManager)
+}
+
 func (runner *Runner) Run() {
 	s := runner.settings
 	if s.TracingEnabled {
@@ -156,10 +224,17 @@ func (runner *Runner) Run() {
 	runner.mu.Unlock()
 
 	limiter, limiterCloser := createLimiter(srv, s, localCache, runner.statsManager)
-	runner.ratelimitCloser = limiterCloser
+	temporaryOverrides, temporaryOverridesCloser := createTemporaryOverrideStore(srv, s)
+	limitStore, limitStoreCloser := createLimitStore(srv, s)
+	runner.ratelimitCloser = &utils.MultiCloser{Closers: []io.Closer{limiterCloser, temporaryOverridesCloser, limitStoreCloser}}
+
+	localLimiter := createLocalLimiter(s, runner.statsManager)
 
 	service := ratelimit.NewService(
 		limiter,
+		temporaryOverrides,
+		limitStore,
+		localLimiter,
 		srv.Provider(),
 		runner.statsManager,
 		srv.HealthChecker(),
@@ -178,12 +253,50 @@ func (runner *Runner) Run() {
 			}
 		})
 
+	if s.AdminApiToken != "" {
+		srv.AddDebugHttpEndpoint(
+			"/admin/counters/reset",
+			"reset the counters of the limits of a JSON rate limit request (POST, admin token required)",
+			server.NewAdminJsonRequestHandler(s.AdminApiToken, service.ResetCounters))
+		srv.AddDebugHttpEndpoint(
+			"/admin/counters/set",
+			"set the counters of the limits of a JSON rate limit request to its hits_addend (POST, admin token required)",
+			server.NewAdminJsonRequestHandler(s.AdminApiToken, service.SetCounters))
+		srv.AddDebugHttpEndpoint(
+			"/admin/counters",
+			"report the counters of the limits of a JSON rate limit request (POST, admin token required)",
+			server.NewAdminJsonRequestHandler(s.AdminApiToken, service.GetCounters))
+		if temporaryOverrides != nil {
+			srv.AddDebugHttpEndpoint(
+				"/admin/overrides",
+				"list (GET), create (POST) or delete (DELETE with a key parameter) temporary limit overrides (admin token required)",
+				server.NewAuthenticatedRequestHandler(s.AdminApiToken,
+					server.NewTemporaryOverridesHandler(temporaryOverrides, utils.NewTimeSourceImpl())))
+		}
+	}
+
 	srv.AddJsonHandler(service)
+	if s.RefundApiToken != "" {
+		srv.AddAuthenticatedJsonRequestHandler("/json/release", s.RefundApiToken, service.Release)
+		srv.AddAuthenticatedJsonRequestHandler("/json/refund", s.RefundApiToken, service.Refund)
+	}
+	srv.AddJsonRequestHandler("/json/quota", service.GetQuotaStatus)
 
 	// Ratelimit is compatible with the below proto definition
 	// data-plane-api v3 rls.proto: https://github.com/envoyproxy/data-plane-api/blob/master/envoy/service/ratelimit/v3/rls.proto
 	// v2 proto is no longer supported
 	pb.RegisterRateLimitServiceServer(srv.GrpcServer(), service)
+	if s.RefundApiToken != "" {
+		ratelimit.RegisterConcurrencyServiceServer(srv.GrpcServer(),
+			ratelimit.NewAuthenticatedConcurrencyServiceServer(s.RefundApiToken, service))
+		ratelimit.RegisterRefundServiceServer(srv.GrpcServer(),
+			ratelimit.NewAuthenticatedRefundServiceServer(s.RefundApiToken, service))
+	}
+	ratelimit.RegisterQuotaServiceServer(srv.GrpcServer(), service)
+	if s.AdminApiToken != "" {
+		ratelimit.RegisterAdminServiceServer(srv.GrpcServer(),
+			ratelimit.NewAuthenticatedAdminServiceServer(s.AdminApiToken, service))
+	}
 
 	srv.Start()
 }
@@ -19,6 +19,13 @@ type Settings struct {
 	Port      int    `envconfig:"PORT" default:"8080"`
 	DebugHost string `envconfig:"DEBUG_HOST" default:"0.0.0.0"`
 	DebugPort int    `envconfig:"DEBUG_PORT" default:"6070"`
+	// Token of the admin API, which is only served if the token is set. Requests pass it as a bearer token.
+	AdminApiToken string `envconfig:"ADMIN_API_TOKEN" default:""`
+	// Token of the refunds of hits and the releases of concurrency slots, which are only served if the token is set.
+	// Requests pass it as a bearer token.
+	RefundApiToken string `envconfig:"REFUND_API_TOKEN" default:""`
+	// Interval temporary overrides created through the admin API by other instances are loaded at.
+	TemporaryOverridesRefreshInterval time.Duration `envconfig:"TEMPORARY_OVERRIDES_REFRESH_INTERVAL" default:"10s"`
 
 	// GRPC server settings
 	// If GrpcUds is set we'll listen on the specified unix domain socket address
@@ -110,6 +117,29 @@ type Settings struct {
 	BackendType                        string  `envconfig:"BACKEND_TYPE" default:"redis"`
 	StopCacheKeyIncrementWhenOverlimit bool    `envconfig:"STOP_CACHE_KEY_INCREMENT_WHEN_OVERLIMIT" default:"false"`
 
+	// Read and write timeout of the redis connections and timeout of the memcache calls, 0 for the defaults of the
+	// clients. Requests whose cache calls time out are handled as if the cache was unavailable.
+	BackendTimeout time.Duration `envconfig:"BACKEND_TIMEOUT" default:"0"`
+
+	// Handling of the hits of limits without a failure_mode while the cache is unavailable: error, allow, deny or
+	// local. The local failure mode counts the hits of each limit divided by FailureModeLocalReplicas in memory, in a
+	// cache of FailureModeLocalCacheSizeInBytes.
+	FailureMode                      string `envconfig:"FAILURE_MODE" default:"error"`
+	FailureModeLocalReplicas         uint32 `envconfig:"FAILURE_MODE_LOCAL_REPLICAS" default:"1"`
+	FailureModeLocalCacheSizeInBytes int    `envconfig:"FAILURE_MODE_LOCAL_CACHE_SIZE_IN_BYTES" default:"1048576"`
+
+	// Wrap the redis and memcache clients of the limits in circuit breakers, which open after
+	// CircuitBreakerFailureThreshold consecutive failed calls, including calls slower than a non-zero
+	// CircuitBreakerLatencyThreshold. Open breakers reject calls for CircuitBreakerOpenDuration, then let
+	// CircuitBreakerHalfOpenProbes calls through, which close the breaker once they all succeed. With
+	// CircuitBreakerHealthCheck, the instance is unhealthy while a breaker is open.
+	CircuitBreakerEnabled          bool          `envconfig:"CIRCUIT_BREAKER_ENABLED" default:"false"`
+	CircuitBreakerFailureThreshold uint32        `envconfig:"CIRCUIT_BREAKER_FAILURE_THRESHOLD" default:"5"`
+	CircuitBreakerLatencyThreshold time.Duration `envconfig:"CIRCUIT_BREAKER_LATENCY_THRESHOLD" default:"0"`
+	CircuitBreakerOpenDuration     time.Duration `envconfig:"CIRCUIT_BREAKER_OPEN_DURATION" default:"10s"`
+	CircuitBreakerHalfOpenProbes   uint32        `envconfig:"CIRCUIT_BREAKER_HALF_OPEN_PROBES" default:"1"`
+	CircuitBreakerHealthCheck      bool          `envconfig:"CIRCUIT_BREAKER_HEALTH_CHECK" default:"false"`
+
 	// Settings for optional returning of custom headers
 	RateLimitResponseHeadersEnabled bool `envconfig:"LIMIT_RESPONSE_HEADERS_ENABLED" default:"false"`
 	// value: the current limit
@@ -159,6 +189,12 @@ type Settings struct {
 	RedisPerSecondPipelineLimit int `envconfig:"REDIS_PERSECOND_PIPELINE_LIMIT" default:"0"`
 	// Enable healthcheck to check Redis Connection. If there is no active connection, healthcheck failed.
 	RedisHealthCheckActiveConnection bool `envconfig:"REDIS_HEALTH_CHECK_ACTIVE_CONNECTION" default:"false"`
+	// Read the limits of limit_from_store descriptors from a hash of the redis instance holding all limits but per
+	// second ones. Values read from the hash are cached locally for LimitStoreCacheTtl.
+	LimitStoreEnabled          bool          `envconfig:"LIMIT_STORE_ENABLED" default:"false"`
+	LimitStoreHashKey          string        `envconfig:"LIMIT_STORE_HASH_KEY" default:"ratelimit:limits"`
+	LimitStoreCacheTtl         time.Duration `envconfig:"LIMIT_STORE_CACHE_TTL" default:"10s"`
+	LimitStoreCacheSizeInBytes int           `envconfig:"LIMIT_STORE_CACHE_SIZE_IN_BYTES" default:"1048576"`
 	// Memcache settings
 	MemcacheHostPort []string `envconfig:"MEMCACHE_HOST_PORT" default:""`
 	// MemcacheMaxIdleConns sets the maximum number of idle TCP connections per memcached node.
@@ -176,6 +212,40 @@ type Settings struct {
 	MemcacheTlsCACert                   string `envconfig:"MEMCACHE_TLS_CACERT" default:""`
 	MemcacheTlsSkipHostnameVerification bool   `envconfig:"MEMCACHE_TLS_SKIP_HOSTNAME_VERIFICATION" default:"false"`
 
+	// Memory settings
+	// MemoryShards sets the number of shards of the in-process map of keys, each with its own lock.
+	// Expired keys are removed every MemorySweepInterval, 0 to only ignore them until they are overwritten.
+	MemoryShards        int           `envconfig:"MEMORY_SHARDS" default:"64"`
+	MemorySweepInterval time.Duration `envconfig:"MEMORY_SWEEP_INTERVAL" default:"1m"`
+
+	// BoltDB settings
+	// BoltDbPath sets the database file the counters are kept in, created if missing. Expired counters are removed
+	// every BoltDbCompactInterval. Updates wait up to BoltDbMaxBatchDelay to be committed along with concurrent
+	// updates. BoltDbNoSync skips syncing commits to disk, which may lose the last updates if the host crashes.
+	BoltDbPath            string        `envconfig:"BOLTDB_PATH" default:"/var/lib/ratelimit/ratelimit.db"`
+	BoltDbCompactInterval time.Duration `envconfig:"BOLTDB_COMPACT_INTERVAL" default:"1m"`
+	BoltDbMaxBatchDelay   time.Duration `envconfig:"BOLTDB_MAX_BATCH_DELAY" default:"0"`
+	BoltDbNoSync          bool          `envconfig:"BOLTDB_NO_SYNC" default:"false"`
+
+	// Peer settings
+	// PeerSelfAddress sets the address the other replicas reach the gRPC server of this replica at, exactly as it is
+	// listed in PeerAddresses or returned for PeerSrv. The members are either the static PeerAddresses, or resolved from
+	// PeerSrv every PeerSrvRefresh. Each member owns the keys of PeerVirtualNodes points of the consistent hash ring.
+	// PeerToken is the bearer token the replicas authenticate their calls to each other with, which must be set.
+	// The keys a replica owns are kept like those of the memory backend, using MemoryShards and MemorySweepInterval.
+	PeerSelfAddress                 string        `envconfig:"PEER_SELF_ADDRESS" default:""`
+	PeerAddresses                   []string      `envconfig:"PEER_ADDRESSES" default:""`
+	PeerSrv                         string        `envconfig:"PEER_SRV" default:""`
+	PeerSrvRefresh                  time.Duration `envconfig:"PEER_SRV_REFRESH" default:"10s"`
+	PeerVirtualNodes                int           `envconfig:"PEER_VIRTUAL_NODES" default:"100"`
+	PeerToken                       string        `envconfig:"PEER_TOKEN" default:""`
+	PeerTls                         bool          `envconfig:"PEER_TLS" default:"false"`
+	PeerTlsConfig                   *tls.Config
+	PeerTlsClientCert               string `envconfig:"PEER_TLS_CLIENT_CERT" default:""`
+	PeerTlsClientKey                string `envconfig:"PEER_TLS_CLIENT_KEY" default:""`
+	PeerTlsCACert                   string `envconfig:"PEER_TLS_CACERT" default:""`
+	PeerTlsSkipHostnameVerification bool   `envconfig:"PEER_TLS_SKIP_HOSTNAME_VERIFICATION" default:"false"`
+
 	// Should the ratelimiting be running in Global shadow-mode, ie. never report a ratelimit status, unless a rate was provided from envoy as an override
 	GlobalShadowMode bool `envconfig:"SHADOW_MODE" default:"false"`
 
@@ -204,6 +274,7 @@ func NewSettings() Settings {
 	// When we require TLS to connect to Redis, we check if we need to connect using the provided key-pair.
 	RedisTlsConfig(s.RedisTls || s.RedisPerSecondTls)(&s)
 	MemcacheTlsConfig(s.MemcacheTls)(&s)
+	PeerTlsConfig(s.PeerTls)(&s)
 	GrpcServerTlsConfig()(&s)
 	ConfigGrpcXdsServerTlsConfig()(&s)
 	return s
@@ -230,6 +301,15 @@ func MemcacheTlsConfig(memcacheTls bool) Option {
 	}
 }
 
+func PeerTlsConfig(peerTls bool) Option {
+	return func(s *Settings) {
+		s.PeerTlsConfig = &tls.Config{}
+		if peerTls {
+			s.PeerTlsConfig = utils.TlsConfigFromFiles(s.PeerTlsClientCert, s.PeerTlsClientKey, s.PeerTlsCACert, utils.ServerCA, s.PeerTlsSkipHostnameVerification)
+		}
+	}
+}
+
 func GrpcServerTlsConfig() Option {
 	return func(s *Settings) {
 		if s.GrpcServerUseTLS {
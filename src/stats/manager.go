@@ -30,10 +30,19 @@ type ManagerImpl struct {
 }
 
 // Stats for panic recoveries.
-// Identifies if a recovered panic is a redis.RedisError or a ServiceError.
+// Identifies if a recovered panic is a limiter.CacheUnavailableError, e.g. a redis.RedisError, or a ServiceError.
 type ShouldRateLimitStats struct {
 	RedisError   gostats.Counter
 	ServiceError gostats.Counter
+	// Calls to the cache which timed out, after the deadline of the request or the backend timeout.
+	BackendTimeout gostats.Counter
+}
+
+// Stats for the decisions on limits taken by their failure mode while the cache is unavailable.
+type FailureModeStats struct {
+	Allow gostats.Counter
+	Deny  gostats.Counter
+	Local gostats.Counter
 }
 
 // Stats for server errors.
@@ -42,7 +51,12 @@ type ServiceStats struct {
 	ConfigLoadSuccess gostats.Counter
 	ConfigLoadError   gostats.Counter
 	ShouldRateLimit   ShouldRateLimitStats
+	Release           ShouldRateLimitStats
+	Refund            ShouldRateLimitStats
+	GetQuotaStatus    ShouldRateLimitStats
+	Admin             ShouldRateLimitStats
 	GlobalShadowMode  gostats.Counter
+	FailureMode       FailureModeStats
 }
 
 // Stats for an individual rate limit config entry.
@@ -54,6 +68,12 @@ type RateLimitStats struct {
 	OverLimitWithLocalCache gostats.Counter
 	WithinLimit             gostats.Counter
 	ShadowMode              gostats.Counter
+	// Leases of hits taken from the cache, hits served from leases, leased hits returned to the cache and failures
+	// to return them.
+	LeaseAcquired    gostats.Counter
+	LeaseHits        gostats.Counter
+	LeaseReturned    gostats.Counter
+	LeaseReturnError gostats.Counter
 }
 
 // Stats for a domain entry
@@ -36,6 +36,10 @@ func (this *ManagerImpl) NewStats(key string) RateLimitStats {
 	ret.OverLimitWithLocalCache = this.rlStatsScope.NewCounter(key + ".over_limit_with_local_cache")
 	ret.WithinLimit = this.rlStatsScope.NewCounter(key + ".within_limit")
 	ret.ShadowMode = this.rlStatsScope.NewCounter(key + ".shadow_mode")
+	ret.LeaseAcquired = this.rlStatsScope.NewCounter(key + ".lease_acquired")
+	ret.LeaseHits = this.rlStatsScope.NewCounter(key + ".lease_hits")
+	ret.LeaseReturned = this.rlStatsScope.NewCounter(key + ".lease_returned")
+	ret.LeaseReturnError = this.rlStatsScope.NewCounter(key + ".lease_return_error")
 	return ret
 }
 
@@ -47,9 +51,14 @@ func (this *ManagerImpl) NewDomainStats(domain string) DomainStats {
 }
 
 func (this *ManagerImpl) NewShouldRateLimitStats() ShouldRateLimitStats {
+	return newCallStats(this.shouldRateLimitScope)
+}
+
+func newCallStats(scope gostats.Scope) ShouldRateLimitStats {
 	ret := ShouldRateLimitStats{}
-	ret.RedisError = this.shouldRateLimitScope.NewCounter("redis_error")
-	ret.ServiceError = this.shouldRateLimitScope.NewCounter("service_error")
+	ret.RedisError = scope.NewCounter("redis_error")
+	ret.ServiceError = scope.NewCounter("service_error")
+	ret.BackendTimeout = scope.NewCounter("backend_timeout")
 	return ret
 }
 
@@ -58,7 +67,17 @@ func (this *ManagerImpl) NewServiceStats() ServiceStats {
 	ret.ConfigLoadSuccess = this.serviceStatsScope.NewCounter("config_load_success")
 	ret.ConfigLoadError = this.serviceStatsScope.NewCounter("config_load_error")
 	ret.ShouldRateLimit = this.NewShouldRateLimitStats()
+	ret.Release = newCallStats(this.serviceStatsScope.Scope("call.release"))
+	ret.Refund = newCallStats(this.serviceStatsScope.Scope("call.refund"))
+	ret.GetQuotaStatus = newCallStats(this.serviceStatsScope.Scope("call.get_quota_status"))
+	ret.Admin = newCallStats(this.serviceStatsScope.Scope("call.admin"))
 	ret.GlobalShadowMode = this.serviceStatsScope.NewCounter("global_shadow_mode")
+	failureMode := this.shouldRateLimitScope.Scope("failure_mode")
+	ret.FailureMode = FailureModeStats{
+		Allow: failureMode.NewCounter("allow"),
+		Deny:  failureMode.NewCounter("deny"),
+		Local: failureMode.NewCounter("local"),
+	}
 	return ret
 }
 
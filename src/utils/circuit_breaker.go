@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	stats "github.com/lyft/gostats"
+	logger "github.com/sirupsen/logrus"
+)
+
+type CircuitBreakerState uint64
+
+const (
+	// Calls go through, and consecutive failures are counted.
+	CircuitBreakerClosed CircuitBreakerState = iota
+	// Calls are rejected until the open duration elapsed.
+	CircuitBreakerOpen
+	// A limited number of probe calls go through, which close the breaker once they all succeed.
+	CircuitBreakerHalfOpen
+)
+
+// Error of the calls rejected by an open circuit breaker.
+var ErrCircuitBreakerOpen = errors.New("circuit breaker is open")
+
+type circuitBreakerStats struct {
+	state         stats.Gauge
+	opened        stats.Counter
+	rejected      stats.Counter
+	latencyBreach stats.Counter
+}
+
+func newCircuitBreakerStats(scope stats.Scope) circuitBreakerStats {
+	ret := circuitBreakerStats{}
+	ret.state = scope.NewGauge("state")
+	ret.opened = scope.NewCounter("opened")
+	ret.rejected = scope.NewCounter("rejected")
+	ret.latencyBreach = scope.NewCounter("latency_breach")
+	return ret
+}
+
+// Stops calling a backend after consecutive failed calls, so that requests fail fast instead of waiting on the
+// backend while it is degraded.
+type CircuitBreaker struct {
+	lock             sync.Mutex
+	timeSource       TimeSource
+	failureThreshold uint32
+	latencyThreshold time.Duration
+	openDuration     time.Duration
+	halfOpenProbes   uint32
+	isFailure        func(error) bool
+	onOpenChange     func(open bool)
+	stats            circuitBreakerStats
+
+	state               CircuitBreakerState
+	consecutiveFailures uint32
+	openedAt            int64
+	probesInFlight      uint32
+	probeSuccesses      uint32
+}
+
+// Checks whether a call may go through, moving an open breaker to half-open once its open duration elapsed.
+// @return whether the call may go through, and whether it is a probe of a half-open breaker.
+func (this *CircuitBreaker) allow() (allowed bool, probe bool) {
+	if this.state == CircuitBreakerOpen &&
+		this.timeSource.UnixNanoNow()-this.openedAt >= this.openDuration.Nanoseconds() {
+		logger.Warnf("circuit breaker half-open, probing the backend")
+		this.setState(CircuitBreakerHalfOpen)
+		this.probesInFlight = 0
+		this.probeSuccesses = 0
+	}
+	switch this.state {
+	case CircuitBreakerClosed:
+		return true, false
+	case CircuitBreakerHalfOpen:
+		if this.probesInFlight < this.halfOpenProbes {
+			this.probesInFlight++
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// Records the outcome of a call.
+// @param probe supplies whether the call was a probe of a half-open breaker.
+// @param failed supplies whether the call failed.
+func (this *CircuitBreaker) record(probe bool, failed bool) {
+	if probe {
+		this.probesInFlight--
+	}
+	switch this.state {
+	case CircuitBreakerClosed:
+		if !failed {
+			this.consecutiveFailures = 0
+			return
+		}
+		this.consecutiveFailures++
+		if this.consecutiveFailures >= this.failureThreshold {
+			logger.Errorf("circuit breaker open after %d consecutive failures", this.consecutiveFailures)
+			this.open()
+			this.onOpenChange(true)
+		}
+	case CircuitBreakerHalfOpen:
+		// Calls which started before the breaker opened don't tell whether the backend recovered.
+		if !probe {
+			return
+		}
+		if failed {
+			logger.Errorf("circuit breaker probe failed, open again")
+			this.open()
+			return
+		}
+		this.probeSuccesses++
+		if this.probeSuccesses >= this.halfOpenProbes {
+			logger.Warnf("circuit breaker closed after %d successful probes", this.probeSuccesses)
+			this.setState(CircuitBreakerClosed)
+			this.consecutiveFailures = 0
+			this.onOpenChange(false)
+		}
+	}
+}
+
+func (this *CircuitBreaker) open() {
+	this.setState(CircuitBreakerOpen)
+	this.openedAt = this.timeSource.UnixNanoNow()
+	this.consecutiveFailures = 0
+	this.stats.opened.Inc()
+}
+
+func (this *CircuitBreaker) setState(state CircuitBreakerState) {
+	this.state = state
+	this.stats.state.Set(uint64(state))
+}
+
+// Returns the state of the breaker.
+func (this *CircuitBreaker) State() CircuitBreakerState {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.state
+}
+
+// Calls the backend unless the breaker is open. Calls failing or taking longer than the latency threshold count
+// as failures.
+// @param call supplies the call to the backend.
+// @return the error of the call, or ErrCircuitBreakerOpen if the call was rejected.
+func (this *CircuitBreaker) Do(call func() error) error {
+	this.lock.Lock()
+	allowed, probe := this.allow()
+	this.lock.Unlock()
+	if !allowed {
+		this.stats.rejected.Inc()
+		return ErrCircuitBreakerOpen
+	}
+
+	start := this.timeSource.UnixNanoNow()
+	err := call()
+	failed := err != nil && this.isFailure(err)
+	if !failed && this.latencyThreshold > 0 && this.timeSource.UnixNanoNow()-start > this.latencyThreshold.Nanoseconds() {
+		this.stats.latencyBreach.Inc()
+		failed = true
+	}
+
+	this.lock.Lock()
+	this.record(probe, failed)
+	this.lock.Unlock()
+	return err
+}
+
+// @param timeSource supplies the time of the calls.
+// @param failureThreshold supplies the number of consecutive failed calls opening the breaker, at least 1.
+// @param latencyThreshold supplies the latency above which calls count as failures, 0 to ignore the latency.
+// @param openDuration supplies the duration calls are rejected for once the breaker opened.
+// @param halfOpenProbes supplies the number of probe calls of a half-open breaker, which all need to succeed to
+// close it, at least 1.
+// @param isFailure supplies whether an error of a call is a failure of the backend, rather than e.g. a cache miss.
+// @param onOpenChange supplies the listener notified when the breaker opens and when it closes again, or nil.
+// @param scope supplies the scope of the stats of the breaker.
+func NewCircuitBreaker(timeSource TimeSource, failureThreshold uint32, latencyThreshold time.Duration,
+	openDuration time.Duration, halfOpenProbes uint32, isFailure func(error) bool, onOpenChange func(open bool),
+	scope stats.Scope,
+) *CircuitBreaker {
+	if onOpenChange == nil {
+		onOpenChange = func(bool) {}
+	}
+	ret := &CircuitBreaker{
+		timeSource:       timeSource,
+		failureThreshold: max(failureThreshold, 1),
+		latencyThreshold: latencyThreshold,
+		openDuration:     openDuration,
+		halfOpenProbes:   max(halfOpenProbes, 1),
+		isFailure:        isFailure,
+		onOpenChange:     onOpenChange,
+		stats:            newCircuitBreakerStats(scope),
+	}
+	ret.setState(CircuitBreakerClosed)
+	return ret
+}
@@ -1,8 +1,13 @@
 package utils
 
 import (
+	"crypto/subtle"
+	"errors"
+	"net"
+	"net/netip"
 	"regexp"
 	"strings"
+	"time"
 
 	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	"google.golang.org/protobuf/types/known/durationpb"
@@ -12,6 +17,9 @@ import (
 type TimeSource interface {
 	// @return the current unix time in seconds.
 	UnixNow() int64
+
+	// @return the current unix time in nanoseconds.
+	UnixNanoNow() int64
 }
 
 // Convert a rate limit into a time divider.
@@ -38,10 +46,78 @@ func UnitToDivider(unit pb.RateLimitResponse_RateLimit_Unit) int64 {
 	panic("should not get here")
 }
 
+// Convert a rate limit window of multiple units into a time divider.
+// @param unit supplies the unit of the window.
+// @param unitMultiplier supplies the number of units in the window, 0 is the same as 1.
+// @return the divider to use in time computations.
+func UnitToDividerWithMultiplier(unit pb.RateLimitResponse_RateLimit_Unit, unitMultiplier uint32) int64 {
+	if unitMultiplier == 0 {
+		unitMultiplier = 1
+	}
+	return UnitToDivider(unit) * int64(unitMultiplier)
+}
+
 func CalculateReset(unit *pb.RateLimitResponse_RateLimit_Unit, timeSource TimeSource) *durationpb.Duration {
-	sec := UnitToDivider(*unit)
+	return CalculateResetWithMultiplier(unit, 1, timeSource)
+}
+
+func CalculateResetWithMultiplier(unit *pb.RateLimitResponse_RateLimit_Unit, unitMultiplier uint32, timeSource TimeSource) *durationpb.Duration {
+	return CalculateWindowReset(unit, unitMultiplier, nil, timeSource)
+}
+
+func CalculateWindowReset(unit *pb.RateLimitResponse_RateLimit_Unit, unitMultiplier uint32, location *time.Location, timeSource TimeSource) *durationpb.Duration {
 	now := timeSource.UnixNow()
-	return &durationpb.Duration{Seconds: sec - now%sec}
+	_, windowEnd := Window(*unit, unitMultiplier, location, now)
+	return &durationpb.Duration{Seconds: windowEnd - now}
+}
+
+// Compute the rate limit window containing a point in time.
+// @param unit supplies the unit of the window.
+// @param unitMultiplier supplies the number of units in the window, 0 is the same as 1.
+// @param location supplies the time zone of calendar aligned windows, or nil for windows aligned to the unix epoch.
+// @param now supplies the unix time in seconds.
+// @return the unix times in seconds the window starts and ends at.
+func Window(unit pb.RateLimitResponse_RateLimit_Unit, unitMultiplier uint32, location *time.Location, now int64) (int64, int64) {
+	if location == nil {
+		divider := UnitToDividerWithMultiplier(unit, unitMultiplier)
+		windowStart := (now / divider) * divider
+		return windowStart, windowStart + divider
+	}
+	return calendarWindow(unit, location, now)
+}
+
+// Calendar aligned windows start at the beginning of the unit in the local time of a time zone, e.g. at midnight
+// for days, on Mondays for weeks as in ISO 8601, and on the 1st for months. Their length varies with the calendar and
+// with daylight saving time.
+func calendarWindow(unit pb.RateLimitResponse_RateLimit_Unit, location *time.Location, now int64) (int64, int64) {
+	t := time.Unix(now, 0).In(location)
+	year, month, day := t.Date()
+	var start, end time.Time
+	switch unit {
+	case pb.RateLimitResponse_RateLimit_SECOND, pb.RateLimitResponse_RateLimit_MINUTE, pb.RateLimitResponse_RateLimit_HOUR:
+		// Hours are aligned with the offset of the time zone rather than with time.Date, as hours repeated when
+		// daylight saving time ends would otherwise map to the same window.
+		divider := UnitToDivider(unit)
+		_, offset := t.Zone()
+		windowStart := now - ((now+int64(offset))%divider+divider)%divider
+		return windowStart, windowStart + divider
+	case pb.RateLimitResponse_RateLimit_DAY:
+		start = time.Date(year, month, day, 0, 0, 0, 0, location)
+		end = time.Date(year, month, day+1, 0, 0, 0, 0, location)
+	case pb.RateLimitResponse_RateLimit_WEEK:
+		daysSinceMonday := (int(t.Weekday()) + 6) % 7
+		start = time.Date(year, month, day-daysSinceMonday, 0, 0, 0, 0, location)
+		end = time.Date(year, month, day-daysSinceMonday+7, 0, 0, 0, 0, location)
+	case pb.RateLimitResponse_RateLimit_MONTH:
+		start = time.Date(year, month, 1, 0, 0, 0, 0, location)
+		end = time.Date(year, month+1, 1, 0, 0, 0, 0, location)
+	case pb.RateLimitResponse_RateLimit_YEAR:
+		start = time.Date(year, 1, 1, 0, 0, 0, 0, location)
+		end = time.Date(year+1, 1, 1, 0, 0, 0, 0, location)
+	default:
+		panic("should not get here")
+	}
+	return start.Unix(), end.Unix()
 }
 
 // Mask credentials from a redis connection string like
@@ -64,8 +140,22 @@ func MaskCredentialsInUrl(url string) string {
 
 var ipv4Regex = regexp.MustCompile(`\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}`)
 
-// Remove invalid characters from the stat name.
+// Candidate IPv6 addresses, including IPv4-mapped ones, and IPv4 or IPv6 CIDR ranges.
+var ipAddressRegex = regexp.MustCompile(`[0-9A-Fa-f]*:[0-9A-Fa-f:.]*(?:/\d{1,3})?|\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}/\d{1,2}`)
+
+var ipAddressReplacer = strings.NewReplacer(".", "_", ":", "_", "/", "_")
+
+// Remove invalid characters from the stat name. The separators of IP addresses and CIDR ranges are replaced, so that
+// e.g. 2001:db8::/32 becomes 2001_db8___32.
 func SanitizeStatName(s string) string {
+	s = ipAddressRegex.ReplaceAllStringFunc(s, func(candidate string) string {
+		if _, err := netip.ParseAddr(candidate); err != nil {
+			if _, err := netip.ParsePrefix(candidate); err != nil {
+				return candidate
+			}
+		}
+		return ipAddressReplacer.Replace(candidate)
+	})
 	r := strings.NewReplacer(":", "_", "|", "_")
 	return ipv4Regex.ReplaceAllStringFunc(r.Replace(s), func(ip string) string {
 		return strings.ReplaceAll(ip, ".", "_")
@@ -88,3 +178,17 @@ func GetHitsAddends(request *pb.RateLimitRequest) []uint64 {
 	}
 	return hitsAddends
 }
+
+// Check whether an authorization header holds a bearer token.
+// @param authorization supplies the value of the authorization header.
+// @param token supplies the expected token, which must not be empty.
+func IsBearerTokenValid(authorization string, token string) bool {
+	bearerToken, found := strings.CutPrefix(authorization, "Bearer ")
+	return found && token != "" && subtle.ConstantTimeCompare([]byte(bearerToken), []byte(token)) == 1
+}
+
+// Check whether an error is the timeout of a network call, e.g. of a read past the deadline of a connection.
+func IsTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
@@ -0,0 +1,121 @@
+package boltdb_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/golang/mock/gomock"
+	gostats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/ratelimit/src/boltdb"
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/settings"
+	"github.com/envoyproxy/ratelimit/test/common"
+	mockstats "github.com/envoyproxy/ratelimit/test/mocks/stats"
+	mock_utils "github.com/envoyproxy/ratelimit/test/mocks/utils"
+)
+
+func TestBoltDbSurvivesRestart(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	now := int64(1000e9)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	timeSource.EXPECT().UnixNow().DoAndReturn(func() int64 { return now / 1e9 }).AnyTimes()
+	timeSource.EXPECT().UnixNanoNow().DoAndReturn(func() int64 { return now }).AnyTimes()
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+
+	var s settings.Settings
+	s.NearLimitRatio = 0.8
+	s.BoltDbPath = filepath.Join(t.TempDir(), "ratelimit.db")
+
+	request := common.NewRateLimitRequestWithPerDescriptorHitsAddend("domain",
+		[][][2]string{{{"key", "value"}}, {{"key2", "value2"}}}, []uint64{3, 1})
+	limits := []*config.RateLimit{
+		config.NewRateLimit(5, pb.RateLimitResponse_RateLimit_DAY, sm.NewStats("key_value"), false, false, "", nil, false),
+		config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_DAY, sm.NewStats("key2_value2"), false, false, "", nil, false),
+	}
+	limits[1].Algorithm = config.Concurrency
+
+	cache, closer := boltdb.NewRateLimitCacheImplFromSettings(s, timeSource, nil, nil, sm)
+	statuses := cache.DoLimit(context.Background(), request, limits)
+	assert.Equal(pb.RateLimitResponse_OK, statuses[0].Code)
+	assert.Equal(uint32(2), statuses[0].LimitRemaining)
+	assert.Equal(uint32(1), statuses[1].LimitRemaining)
+	assert.Nil(closer.Close())
+
+	// The counters and leases are read back from the file after a restart.
+	now += 3600e9
+	cache, closer = boltdb.NewRateLimitCacheImplFromSettings(s, timeSource, nil, nil, sm)
+	defer closer.Close()
+	statuses = cache.DoLimit(context.Background(), request, limits)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, statuses[0].Code)
+	assert.Equal(uint32(0), statuses[1].LimitRemaining)
+	assert.Equal(uint32(1), cache.Release(context.Background(), request, limits)[1].LimitRemaining)
+
+	quota := cache.GetQuotaStatus(context.Background(), request, limits)
+	assert.Equal(uint64(6), quota[0].Count)
+
+	// The counters of the next day start from zero.
+	now += 86400e9
+	statuses = cache.DoLimit(context.Background(), request, limits)
+	assert.Equal(pb.RateLimitResponse_OK, statuses[0].Code)
+	assert.Equal(uint32(2), statuses[0].LimitRemaining)
+}
+
+func TestBoltDbInvalidPath(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+
+	var s settings.Settings
+	s.BoltDbPath = filepath.Join(t.TempDir(), "missing", "ratelimit.db")
+
+	assert.Panics(func() {
+		boltdb.NewRateLimitCacheImplFromSettings(s, timeSource, nil, nil, mockstats.NewMockStatManager(statsStore))
+	})
+}
+
+func TestBoltDbUnavailable(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	timeSource.EXPECT().UnixNow().Return(int64(1000)).AnyTimes()
+	timeSource.EXPECT().UnixNanoNow().Return(int64(1000e9)).AnyTimes()
+	sm := mockstats.NewMockStatManager(gostats.NewStore(gostats.NewNullSink(), false))
+
+	var s settings.Settings
+	s.NearLimitRatio = 0.8
+	s.BoltDbPath = filepath.Join(t.TempDir(), "ratelimit.db")
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(5, pb.RateLimitResponse_RateLimit_DAY, sm.NewStats("key_value"), false, false, "", nil, false),
+	}
+
+	// Errors of the database fail the request like an unavailable cache, so that the failure modes apply.
+	cache, closer := boltdb.NewRateLimitCacheImplFromSettings(s, timeSource, nil, nil, sm)
+	assert.Nil(closer.Close())
+	assert.Panics(func() {
+		defer func() {
+			err := recover()
+			_, ok := err.(boltdb.BoltError)
+			assert.True(ok, "%v", err)
+			_, ok = err.(limiter.CacheUnavailableError)
+			assert.True(ok, "%v", err)
+			panic(err)
+		}()
+		cache.DoLimit(context.Background(), request, limits)
+	})
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
 	mockstats "github.com/envoyproxy/ratelimit/test/mocks/stats"
 )
 
@@ -27,6 +28,14 @@ func loadFile(path string) []config.RateLimitConfigToLoad {
 	return []config.RateLimitConfigToLoad{{Name: path, ConfigYaml: configYaml}}
 }
 
+func loadOverridesFile(path string) config.RateLimitConfigToLoad {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	return config.RateLimitConfigToLoad{Name: path, Overrides: config.ConfigFileContentToOverrides(path, string(contents))}
+}
+
 func TestBasicConfig(t *testing.T) {
 	assert := assert.New(t)
 	stats := stats.NewStore(stats.NewNullSink(), false)
@@ -952,3 +961,801 @@ func TestDetailedMetric(t *testing.T) {
 		})
 	}
 }
+
+func TestSlidingWindowConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("sliding_window.yaml"), mockstats.NewMockStatManager(stats), false)
+	rlConfig.Dump()
+
+	rl := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key1", Value: "value1"}},
+		})
+	assert.Equal(config.SlidingWindow, rl.Algorithm)
+
+	rl = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key2", Value: "value2"}},
+		})
+	assert.Equal(config.FixedWindow, rl.Algorithm)
+
+	rl = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key3", Value: "value3"}},
+		})
+	assert.Equal(config.FixedWindow, rl.Algorithm)
+}
+
+func TestBadAlgorithm(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("bad_algorithm.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"bad_algorithm.yaml: invalid rate limit algorithm 'foo'")
+}
+
+func TestTokenBucketConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("token_bucket.yaml"), mockstats.NewMockStatManager(stats), false)
+
+	rl := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key1", Value: "value1"}},
+		})
+	assert.Equal(config.TokenBucket, rl.Algorithm)
+	assert.EqualValues(10, rl.Limit.RequestsPerUnit)
+	assert.EqualValues(50, rl.Burst)
+
+	// The burst defaults to the requests per unit.
+	rl = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key2", Value: "value2"}},
+		})
+	assert.Equal(config.TokenBucket, rl.Algorithm)
+	assert.EqualValues(10, rl.Burst)
+
+	rl = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key3", Value: "value3"}},
+		})
+	assert.Equal(config.GCRA, rl.Algorithm)
+	assert.EqualValues(5, rl.Burst)
+
+	rl = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key4", Value: "value4"}},
+		})
+	assert.Equal(config.Concurrency, rl.Algorithm)
+	assert.EqualValues(3, rl.Limit.RequestsPerUnit)
+	assert.EqualValues(5, rl.UnitMultiplier)
+}
+
+func TestBurstWithoutTokenBucket(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("burst_without_token_bucket.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"burst_without_token_bucket.yaml: burst is only supported by the token_bucket and gcra algorithms")
+}
+
+func TestUnitMultiplierConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("unit_multiplier.yaml"), mockstats.NewMockStatManager(stats), false)
+
+	rl := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key1", Value: "value1"}},
+		})
+	assert.Equal(pb.RateLimitResponse_RateLimit_MINUTE, rl.Limit.Unit)
+	assert.EqualValues(15, rl.UnitMultiplier)
+
+	// The unit multiplier defaults to a window of a single unit.
+	rl = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key2", Value: "value2"}},
+		})
+	assert.EqualValues(1, rl.UnitMultiplier)
+}
+
+func TestUnlimitedWithUnitMultiplier(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("unlimited_with_unit_multiplier.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"unlimited_with_unit_multiplier.yaml: should not specify rate limit unit multiplier when unlimited")
+}
+
+func TestCalendarAlignedConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("calendar_aligned.yaml"), mockstats.NewMockStatManager(stats), false)
+
+	rl := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key1", Value: "value1"}},
+		})
+	assert.Equal("Europe/Berlin", rl.CalendarLocation.String())
+
+	// Calendar aligned limits default to UTC.
+	rl = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key2", Value: "value2"}},
+		})
+	assert.Equal("UTC", rl.CalendarLocation.String())
+
+	rl = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key3", Value: "value3"}},
+		})
+	assert.Nil(rl.CalendarLocation)
+}
+
+func TestBadTimezone(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("bad_timezone.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"bad_timezone.yaml: invalid timezone 'Mars/Olympus_Mons'")
+}
+
+func TestTimezoneWithoutCalendarAligned(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("timezone_without_calendar_aligned.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"timezone_without_calendar_aligned.yaml: timezone is only supported by calendar aligned limits")
+}
+
+func TestCalendarAlignedWithUnitMultiplier(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("calendar_aligned_with_unit_multiplier.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"calendar_aligned_with_unit_multiplier.yaml: unit_multiplier is not supported by calendar aligned limits")
+}
+
+func TestMultipleLimitsConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("multiple_limits.yaml"), mockstats.NewMockStatManager(stats), false)
+	rlConfig.Dump()
+
+	rl := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key1", Value: "value1"}},
+		})
+	assert.EqualValues(10, rl.Limit.RequestsPerUnit)
+	assert.Equal(pb.RateLimitResponse_RateLimit_SECOND, rl.Limit.Unit)
+	assert.Equal("", rl.KeySuffix)
+	assert.Equal(2, len(rl.AdditionalLimits))
+	assert.EqualValues(1000, rl.AdditionalLimits[0].Limit.RequestsPerUnit)
+	assert.Equal(pb.RateLimitResponse_RateLimit_HOUR, rl.AdditionalLimits[0].Limit.Unit)
+	assert.Equal("per_hour", rl.AdditionalLimits[0].KeySuffix)
+	assert.EqualValues(500, rl.AdditionalLimits[1].Limit.RequestsPerUnit)
+	assert.EqualValues(15, rl.AdditionalLimits[1].UnitMultiplier)
+	assert.Equal(config.SlidingWindow, rl.AdditionalLimits[1].Algorithm)
+	assert.Equal("per_15_minute", rl.AdditionalLimits[1].KeySuffix)
+
+	// Each limit has its own stats.
+	rl.Stats.TotalHits.Inc()
+	rl.AdditionalLimits[0].Stats.TotalHits.Inc()
+	rl.AdditionalLimits[1].Stats.TotalHits.Inc()
+	assert.EqualValues(1, stats.NewCounter("test-domain.key1.total_hits").Value())
+	assert.EqualValues(1, stats.NewCounter("test-domain.key1.per_hour.total_hits").Value())
+	assert.EqualValues(1, stats.NewCounter("test-domain.key1.per_15_minute.total_hits").Value())
+
+	// Detailed metrics are kept per limit.
+	rl = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key2", Value: "value2"}},
+		})
+	assert.EqualValues(5, rl.Limit.RequestsPerUnit)
+	assert.Equal(1, len(rl.AdditionalLimits))
+	assert.EqualValues(100, rl.AdditionalLimits[0].Limit.RequestsPerUnit)
+	assert.Equal("per_day", rl.AdditionalLimits[0].KeySuffix)
+	rl.Stats.TotalHits.Inc()
+	rl.AdditionalLimits[0].Stats.TotalHits.Inc()
+	assert.EqualValues(1, stats.NewCounter("test-domain.key2_value2.total_hits").Value())
+	assert.EqualValues(1, stats.NewCounter("test-domain.key2_value2.per_day.total_hits").Value())
+}
+
+func TestMultipleLimitsDuplicateWindow(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("multiple_limits_duplicate_window.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"multiple_limits_duplicate_window.yaml: duplicate rate limit window 'hour' in list of rate limits")
+}
+
+func TestMultipleLimitsUnlimited(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("multiple_limits_unlimited.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"multiple_limits_unlimited.yaml: unlimited is not supported in a list of rate limits")
+}
+
+func TestValueMatchersConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("value_matchers.yaml"), mockstats.NewMockStatManager(stats), false)
+	rlConfig.Dump()
+
+	getLimit := func(entries ...*pb_struct.RateLimitDescriptor_Entry) *config.RateLimit {
+		return rlConfig.GetLimit(context.TODO(), "test-domain", &pb_struct.RateLimitDescriptor{Entries: entries})
+	}
+
+	// Exact values take precedence over sets, prefixes, regexes and defaults, in this order.
+	assert.EqualValues(1, getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "agent", Value: "curl"}).Limit.RequestsPerUnit)
+	assert.EqualValues(2, getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "agent", Value: "wget"}).Limit.RequestsPerUnit)
+	assert.EqualValues(2, getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "agent", Value: "httpie"}).Limit.RequestsPerUnit)
+	assert.EqualValues(3, getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "agent", Value: "wget2"}).Limit.RequestsPerUnit)
+	// The first matching regex applies.
+	assert.EqualValues(4, getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "agent", Value: "winhttp"}).Limit.RequestsPerUnit)
+	assert.EqualValues(5, getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "agent", Value: "okhttp"}).Limit.RequestsPerUnit)
+	// Regexes have to match the whole value.
+	assert.EqualValues(6, getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "agent", Value: "okhttp/4"}).Limit.RequestsPerUnit)
+
+	// Value sets and regexes can be nested.
+	rl := getLimit(
+		&pb_struct.RateLimitDescriptor_Entry{Key: "region", Value: "eu"},
+		&pb_struct.RateLimitDescriptor_Entry{Key: "tier", Value: "gold"})
+	assert.EqualValues(7, rl.Limit.RequestsPerUnit)
+	rl.Stats.TotalHits.Inc()
+	assert.EqualValues(1, stats.NewCounter("test-domain.region_eu_us.tier_gold_silver.total_hits").Value())
+	assert.Nil(getLimit(
+		&pb_struct.RateLimitDescriptor_Entry{Key: "region", Value: "ap"},
+		&pb_struct.RateLimitDescriptor_Entry{Key: "tier", Value: "gold"}))
+	assert.Nil(getLimit(
+		&pb_struct.RateLimitDescriptor_Entry{Key: "region", Value: "us"},
+		&pb_struct.RateLimitDescriptor_Entry{Key: "tier", Value: "bronze"}))
+}
+
+func TestMultipleValueMatchers(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("multiple_value_matchers.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"multiple_value_matchers.yaml: descriptor should not have more than one of value, values, value_regex and value_cidr")
+}
+
+func TestDuplicateSetValue(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("duplicate_set_value.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"duplicate_set_value.yaml: duplicate value 'wget' in value sets of key 'agent'")
+}
+
+func TestBadValueRegex(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("bad_value_regex.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"bad_value_regex.yaml: invalid value_regex 'curl(': error parsing regexp: missing closing ): `curl(`")
+}
+
+func TestOverridesConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	assert.True(config.IsOverridesFile("config.tenants.overrides.csv"))
+	assert.True(config.IsOverridesFile("config.tenants.overrides.json"))
+	assert.False(config.IsOverridesFile("config.tenants.yaml"))
+
+	configs := append(loadFile("overrides_config.yaml"),
+		loadOverridesFile("tenants.overrides.csv"), loadOverridesFile("tenants.overrides.json"))
+	rlConfig := config.NewRateLimitConfigImpl(configs, mockstats.NewMockStatManager(stats), false)
+	rlConfig.Dump()
+
+	getLimit := func(entries ...*pb_struct.RateLimitDescriptor_Entry) *config.RateLimit {
+		return rlConfig.GetLimit(context.TODO(), "tenants", &pb_struct.RateLimitDescriptor{Entries: entries})
+	}
+
+	rl := getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "tenant", Value: "x"})
+	assert.EqualValues(5000, rl.Limit.RequestsPerUnit)
+	assert.Equal(pb.RateLimitResponse_RateLimit_MINUTE, rl.Limit.Unit)
+	// Overrides keep the other settings of the limit of the descriptor.
+	assert.Equal(config.SlidingWindow, rl.Algorithm)
+	assert.Equal("tenants.tenant", rl.FullKey)
+	rl.Stats.TotalHits.Inc()
+	assert.EqualValues(1, stats.NewCounter("tenants.tenant_x.total_hits").Value())
+
+	rl = getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "tenant", Value: "y"})
+	assert.EqualValues(200, rl.Limit.RequestsPerUnit)
+	rl = getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "tenant", Value: "z"})
+	assert.EqualValues(3, rl.Limit.RequestsPerUnit)
+	assert.Equal(pb.RateLimitResponse_RateLimit_SECOND, rl.Limit.Unit)
+
+	// Values without an override fall back to the config.
+	rl = getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "tenant", Value: "w"})
+	assert.EqualValues(100, rl.Limit.RequestsPerUnit)
+
+	// Overrides of nested descriptors include the values of their parents.
+	rl = getLimit(
+		&pb_struct.RateLimitDescriptor_Entry{Key: "org", Value: "acme"},
+		&pb_struct.RateLimitDescriptor_Entry{Key: "tenant", Value: "x"})
+	assert.EqualValues(50, rl.Limit.RequestsPerUnit)
+	assert.Equal(pb.RateLimitResponse_RateLimit_HOUR, rl.Limit.Unit)
+	rl = getLimit(
+		&pb_struct.RateLimitDescriptor_Entry{Key: "org", Value: "acme"},
+		&pb_struct.RateLimitDescriptor_Entry{Key: "tenant", Value: "y"})
+	assert.EqualValues(10, rl.Limit.RequestsPerUnit)
+
+	// Overrides only apply to descriptors with a limit in a config.
+	assert.Nil(rlConfig.GetLimit(context.TODO(), "other", &pb_struct.RateLimitDescriptor{
+		Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "tenant", Value: "x"}},
+	}))
+}
+
+func TestDuplicateOverride(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				[]config.RateLimitConfigToLoad{loadOverridesFile("duplicate.overrides.csv")},
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"duplicate.overrides.csv: duplicate override 'tenants.tenant_x'")
+}
+
+func TestBadOverrideUnit(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				[]config.RateLimitConfigToLoad{loadOverridesFile("bad_unit.overrides.json")},
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"bad_unit.overrides.json: invalid rate limit unit 'fortnight'")
+}
+
+func TestBadOverridesFile(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() { loadOverridesFile("bad_requests_per_unit.overrides.csv") },
+		"bad_requests_per_unit.overrides.csv: invalid requests_per_unit 'lots' on line 3")
+	expectConfigPanic(
+		t,
+		func() { loadOverridesFile("missing_column.overrides.csv") },
+		"missing_column.overrides.csv: overrides file has no 'unit' column")
+}
+
+func TestAlsoCountsAgainstConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("also_counts_against.yaml"), mockstats.NewMockStatManager(stats), false)
+	rlConfig.Dump()
+
+	org := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "org", Value: "o"}},
+		})
+	tenant := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "org", Value: "o"}, {Key: "tenant", Value: "t"}},
+		})
+	user := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "org", Value: "o"}, {Key: "tenant", Value: "t"}, {Key: "user", Value: "u"}},
+		})
+	assert.EqualValues(10000, org.Limit.RequestsPerUnit)
+	assert.Equal(1, org.DescriptorDepth)
+	assert.Empty(org.AlsoCountsAgainst)
+	assert.EqualValues(1000, tenant.Limit.RequestsPerUnit)
+	assert.Equal(2, tenant.DescriptorDepth)
+	assert.Equal([]*config.RateLimit{org}, tenant.AlsoCountsAgainst)
+	assert.EqualValues(100, user.Limit.RequestsPerUnit)
+	assert.Equal(3, user.DescriptorDepth)
+	assert.Equal([]*config.RateLimit{tenant}, user.AlsoCountsAgainst)
+}
+
+func TestAlsoCountsAgainstUnknownAncestor(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("also_counts_against_unknown.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"also_counts_against_unknown.yaml: also_counts_against 'tenant' is not an ancestor descriptor with a limit")
+}
+
+func TestCostConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("cost.yaml"), mockstats.NewMockStatManager(stats), false)
+	rlConfig.Dump()
+
+	search := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "tenant", Value: "a"}, {Key: "endpoint", Value: "/search"}},
+		})
+	assert.EqualValues(5, search.Cost)
+
+	ping := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "tenant", Value: "a"}, {Key: "endpoint", Value: "/ping"}},
+		})
+	assert.EqualValues(0, ping.Cost)
+	assert.EqualValues(0, ping.AlsoCountsAgainst[0].Cost)
+}
+
+func TestCostWithoutRateLimit(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("cost_without_limit.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"cost_without_limit.yaml: descriptor 'test-domain.endpoint_/search' has a cost but no rate_limit")
+}
+
+func TestTemporaryOverride(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	statsManager := mockstats.NewMockStatManager(stats)
+
+	assert.EqualError((&config.TemporaryOverride{Unit: "minute", ExpiresAt: 1}).Validate(), "override should have a key")
+	assert.EqualError(
+		(&config.TemporaryOverride{Key: "test-domain.key", Unit: "fortnight", ExpiresAt: 1}).Validate(),
+		"invalid rate limit unit 'fortnight'")
+	assert.EqualError((&config.TemporaryOverride{Key: "test-domain.key", Unit: "minute"}).Validate(), "override should expire")
+
+	limit := config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, statsManager.NewStats("test-domain.key"), false, false, "name", nil, false)
+	limit.Algorithm = config.TokenBucket
+	limit.Burst = 20
+	limit.UnitMultiplier = 5
+
+	overridden := limit.WithTemporaryOverride(&config.TemporaryOverride{Key: "test-domain.key", Unit: "minute", RequestsPerUnit: 2, ExpiresAt: 1})
+	assert.Equal(uint32(2), overridden.Limit.RequestsPerUnit)
+	assert.Equal(pb.RateLimitResponse_RateLimit_MINUTE, overridden.Limit.Unit)
+	assert.Equal("name", overridden.Limit.Name)
+	assert.Equal(uint32(5), overridden.UnitMultiplier)
+	assert.Equal(uint32(4), overridden.Burst)
+	assert.Equal(limit.Stats, overridden.Stats)
+	assert.Equal(uint32(10), limit.Limit.RequestsPerUnit)
+
+	// A different unit replaces the unit multiplier.
+	overridden = limit.WithTemporaryOverride(&config.TemporaryOverride{Key: "test-domain.key", Unit: "HOUR", RequestsPerUnit: 100, ExpiresAt: 1})
+	assert.Equal(pb.RateLimitResponse_RateLimit_HOUR, overridden.Limit.Unit)
+	assert.Equal(uint32(1), overridden.UnitMultiplier)
+	assert.Equal(uint32(200), overridden.Burst)
+}
+
+func TestBlockConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("block.yaml"), mockstats.NewMockStatManager(stats), false)
+	assert.Contains(rlConfig.Dump(), "test-domain.user_abuser: blocked, shadow_mode: false\n")
+
+	getLimit := func(key string, value string) *config.RateLimit {
+		return rlConfig.GetLimit(
+			context.TODO(), "test-domain",
+			&pb_struct.RateLimitDescriptor{Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: key, Value: value}}})
+	}
+
+	abuser := getLimit("user", "abuser")
+	assert.True(abuser.Blocked)
+	assert.Equal(&pb.RateLimitResponse_RateLimit{RequestsPerUnit: 0, Unit: pb.RateLimitResponse_RateLimit_SECOND}, abuser.Limit)
+	assert.Nil(getLimit("user", "other"))
+
+	// Exact addresses take precedence over ranges, and longer prefixes over shorter ones.
+	assert.True(getLimit("remote_address", "10.1.2.3").Unlimited)
+	assert.True(getLimit("remote_address", "::ffff:10.1.2.3").Unlimited)
+	assert.True(getLimit("remote_address", "10.1.2.4").Blocked)
+	assert.Equal("test-domain.remote_address_10.1.0.0/16", getLimit("remote_address", "10.1.2.4").FullKey)
+	assert.Equal(uint32(100), getLimit("remote_address", "10.2.0.1").Limit.RequestsPerUnit)
+	assert.Equal(uint32(10), getLimit("remote_address", "2001:db8:1:0::1").Limit.RequestsPerUnit)
+	ipv6Range := getLimit("remote_address", "2001:db8::2")
+	assert.True(ipv6Range.Blocked)
+	assert.Equal(pb.RateLimitResponse_RateLimit_HOUR, ipv6Range.Limit.Unit)
+
+	// Other addresses and values which are not addresses fall back to the default.
+	fallback := getLimit("remote_address", "192.168.0.1")
+	assert.True(fallback.Blocked)
+	assert.True(fallback.ShadowMode)
+	assert.Equal("test-domain.remote_address", getLimit("remote_address", "unknown").FullKey)
+}
+
+func TestBlockWithRequestsPerUnit(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("block_with_requests_per_unit.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"block_with_requests_per_unit.yaml: should only specify the rate limit unit when blocked")
+}
+
+func TestBlockInList(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("block_in_list.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"block_in_list.yaml: block is not supported in a list of rate limits")
+}
+
+func TestBadRemoteAddressRange(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("bad_remote_address_range.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		`bad_remote_address_range.yaml: invalid remote_address range '10.0.0.0/33': netip.ParsePrefix("10.0.0.0/33"): prefix length out of range`)
+}
+
+func TestDuplicateRemoteAddressRange(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("duplicate_remote_address_range.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"duplicate_remote_address_range.yaml: duplicate remote_address range '10.0.0.0/8'")
+}
+
+func TestValueCidrConfig(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("value_cidr.yaml"), mockstats.NewMockStatManager(stats), false)
+
+	getLimit := func(entries ...*pb_struct.RateLimitDescriptor_Entry) *config.RateLimit {
+		return rlConfig.GetLimit(context.TODO(), "test-domain", &pb_struct.RateLimitDescriptor{Entries: entries})
+	}
+	remoteAddress := func(value string) *config.RateLimit {
+		return getLimit(&pb_struct.RateLimitDescriptor_Entry{Key: "remote_address", Value: value})
+	}
+
+	// The longest prefix containing the address is matched, also for ranges which are not octet aligned.
+	assert.Equal(uint32(100), remoteAddress("10.127.255.255").Limit.RequestsPerUnit)
+	assert.Equal(uint32(1000), remoteAddress("10.128.0.1").Limit.RequestsPerUnit)
+	assert.Equal("test-domain.remote_address_10.128.0.0/9", remoteAddress("10.128.0.1").FullKey)
+	assert.Equal(uint32(1000), remoteAddress("::ffff:10.200.0.1").Limit.RequestsPerUnit)
+	assert.Equal(uint32(20), remoteAddress("172.31.0.1").Limit.RequestsPerUnit)
+	assert.Nil(remoteAddress("172.32.0.1"))
+	assert.Nil(remoteAddress("not-an-address"))
+
+	// IPv6 ranges only match IPv6 addresses.
+	assert.Equal(uint32(50), remoteAddress("2001:db8:3:ffff::1").Limit.RequestsPerUnit)
+	assert.Equal(uint32(500), remoteAddress("2001:DB8:4::1").Limit.RequestsPerUnit)
+	assert.Equal(uint32(50), remoteAddress("2001:db8:5::1").Limit.RequestsPerUnit)
+	assert.Equal(uint32(5), remoteAddress("2001:dbc::1").Limit.RequestsPerUnit)
+	assert.Equal(uint32(5), remoteAddress("fe80::1%eth0").Limit.RequestsPerUnit)
+
+	// value_cidr matches the values of any key, and ranges can have nested descriptors.
+	assert.Equal(
+		"test-domain.client_ip_192.168.0.0/16.path_/api",
+		getLimit(
+			&pb_struct.RateLimitDescriptor_Entry{Key: "client_ip", Value: "192.168.1.1"},
+			&pb_struct.RateLimitDescriptor_Entry{Key: "path", Value: "/api"}).FullKey)
+	assert.Nil(getLimit(
+		&pb_struct.RateLimitDescriptor_Entry{Key: "client_ip", Value: "192.169.1.1"},
+		&pb_struct.RateLimitDescriptor_Entry{Key: "path", Value: "/api"}))
+}
+
+func TestBadValueCidr(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("bad_value_cidr.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		`bad_value_cidr.yaml: invalid value_cidr '10.0.0.1': netip.ParsePrefix("10.0.0.1"): no '/'`)
+}
+
+func TestDuplicateValueCidr(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("duplicate_value_cidr.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"duplicate_value_cidr.yaml: duplicate remote_address range '10.0.0.0/8'")
+}
+
+func TestLimitFromStore(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("limit_from_store.yaml"), mockstats.NewMockStatManager(stats), false)
+	assert.Contains(rlConfig.Dump(), "test-domain.api_key: unit=MINUTE requests_per_unit=10, shadow_mode: false, limit_from_store: true\n")
+
+	limit := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "api_key", Value: "key1"}}})
+	assert.True(limit.LimitFromStore)
+
+	// Stored limits keep the unit of the configured limit unless they have their own.
+	stored := limit.WithStoredLimit(&config.StoredLimit{RequestsPerUnit: 100})
+	assert.Equal(&pb.RateLimitResponse_RateLimit{RequestsPerUnit: 100, Unit: pb.RateLimitResponse_RateLimit_MINUTE}, stored.Limit)
+	assert.Equal(limit.Stats, stored.Stats)
+	assert.Equal(uint32(10), limit.Limit.RequestsPerUnit)
+
+	parsed, err := config.ParseStoredLimit("1000/Hour")
+	assert.NoError(err)
+	assert.Equal(&config.StoredLimit{RequestsPerUnit: 1000, Unit: pb.RateLimitResponse_RateLimit_HOUR}, parsed)
+	parsed, err = config.ParseStoredLimit(" 5 ")
+	assert.NoError(err)
+	assert.Equal(&config.StoredLimit{RequestsPerUnit: 5}, parsed)
+	_, err = config.ParseStoredLimit("5/fortnight")
+	assert.EqualError(err, "invalid rate limit unit 'fortnight'")
+	_, err = config.ParseStoredLimit("-1")
+	assert.EqualError(err, "invalid requests per unit '-1'")
+}
+
+func TestLimitFromStoreWithoutRateLimit(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("limit_from_store_without_rate_limit.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"limit_from_store_without_rate_limit.yaml: descriptor 'test-domain.api_key' with limit_from_store should have a single rate_limit")
+}
+
+func TestLeaseRatio(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("lease_ratio.yaml"), mockstats.NewMockStatManager(stats), false)
+	limit := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key1", Value: "value1"}}})
+	assert.Equal(0.01, limit.LeaseRatio)
+	assert.Equal(uint64(3000), limiter.LeaseSize(limit))
+
+	// Leases hold at least one hit.
+	limit.Limit.RequestsPerUnit = 10
+	assert.Equal(uint64(1), limiter.LeaseSize(limit))
+}
+
+func TestBadLeaseRatio(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("bad_lease_ratio.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"bad_lease_ratio.yaml: invalid lease_ratio '1.5', should be above 0 and at most 1")
+}
+
+func TestLeaseRatioSlidingWindow(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("lease_ratio_sliding_window.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"lease_ratio_sliding_window.yaml: lease_ratio is only supported by the fixed_window algorithm")
+}
+
+func TestFailureMode(t *testing.T) {
+	assert := assert.New(t)
+	stats := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := config.NewRateLimitConfigImpl(loadFile("failure_mode.yaml"), mockstats.NewMockStatManager(stats), false)
+
+	// Descriptors inherit the failure mode of their parent, or of the domain.
+	limit := rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key1", Value: "value1"}}})
+	assert.Equal(config.FailureModeDeny, limit.FailureMode)
+
+	limit = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{Entries: []*pb_struct.RateLimitDescriptor_Entry{
+			{Key: "key1", Value: "value1"}, {Key: "subkey1", Value: "value1"},
+		}})
+	assert.Equal(config.FailureModeLocal, limit.FailureMode)
+
+	limit = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{Entries: []*pb_struct.RateLimitDescriptor_Entry{
+			{Key: "key1", Value: "value1"}, {Key: "subkey1", Value: "value1"}, {Key: "subsubkey1", Value: "value1"},
+		}})
+	assert.Equal(config.FailureModeLocal, limit.FailureMode)
+
+	limit = rlConfig.GetLimit(
+		context.TODO(), "test-domain",
+		&pb_struct.RateLimitDescriptor{Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key2", Value: "value2"}}})
+	assert.Equal(config.FailureModeAllow, limit.FailureMode)
+	assert.Equal(config.FailureModeAllow, limit.AdditionalLimits[0].FailureMode)
+	assert.Contains(rlConfig.Dump(), "test-domain.key2: unit=SECOND requests_per_unit=10, shadow_mode: false, failure_mode: allow\n")
+}
+
+func TestBadFailureMode(t *testing.T) {
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigImpl(
+				loadFile("bad_failure_mode.yaml"),
+				mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false)), false)
+		},
+		"bad_failure_mode.yaml: invalid failure_mode 'open'")
+}
+
+func TestUnsupportedAlgorithm(t *testing.T) {
+	statsManager := mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false))
+
+	// Memcache cannot apply token buckets and GCRA.
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigLoaderImpl("memcache").Load(loadFile("token_bucket.yaml"), statsManager, false)
+		},
+		"token_bucket.yaml: rate limit algorithm 'token_bucket' is not supported by the memcache backend")
+	expectConfigPanic(
+		t,
+		func() {
+			config.NewRateLimitConfigLoaderImpl("memcache").Load(loadFile("gcra.yaml"), statsManager, false)
+		},
+		"gcra.yaml: rate limit algorithm 'gcra' is not supported by the memcache backend")
+
+	rlConfig := config.NewRateLimitConfigLoaderImpl("redis").Load(loadFile("token_bucket.yaml"), statsManager, false)
+	assert.False(t, rlConfig.IsEmptyDomains())
+}
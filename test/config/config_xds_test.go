@@ -0,0 +1,212 @@
+package config_test
+
+import (
+	"context"
+	"testing"
+
+	pb_struct "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	rls_config "github.com/envoyproxy/go-control-plane/ratelimit/config/ratelimit/v3"
+	stats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	mockstats "github.com/envoyproxy/ratelimit/test/mocks/stats"
+)
+
+// Sets fields of rls_conf.proto which the messages of go-control-plane do not have.
+func withFields[M proto.Message](message M, fields ...[]byte) M {
+	var unknown []byte
+	for _, field := range fields {
+		unknown = append(unknown, field...)
+	}
+	message.ProtoReflect().SetUnknown(unknown)
+	return message
+}
+
+func varintField(number protowire.Number, value uint64) []byte {
+	return protowire.AppendVarint(protowire.AppendTag(nil, number, protowire.VarintType), value)
+}
+
+func stringField(number protowire.Number, value string) []byte {
+	return protowire.AppendString(protowire.AppendTag(nil, number, protowire.BytesType), value)
+}
+
+func messageField(t *testing.T, number protowire.Number, message proto.Message) []byte {
+	value, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return protowire.AppendBytes(protowire.AppendTag(nil, number, protowire.BytesType), value)
+}
+
+// Loads a config the way it is received from an xDS server.
+func loadXdsConfig(t *testing.T, xdsConfig *rls_config.RateLimitConfig, statsStore stats.Store) config.RateLimitConfig {
+	resource, err := anypb.New(xdsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := &rls_config.RateLimitConfig{}
+	if err := anypb.UnmarshalTo(resource, received, proto.UnmarshalOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	return config.NewRateLimitConfigImpl(
+		[]config.RateLimitConfigToLoad{{Name: received.Name, ConfigYaml: config.ConfigXdsProtoToYaml(received)}},
+		mockstats.NewMockStatManager(statsStore), false)
+}
+
+func TestXdsConfigAlgorithm(t *testing.T) {
+	assert := assert.New(t)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := loadXdsConfig(t, &rls_config.RateLimitConfig{
+		Name:   "xds",
+		Domain: "test-domain",
+		Descriptors: []*rls_config.RateLimitDescriptor{
+			{
+				Key: "key1",
+				RateLimit: withFields(&rls_config.RateLimitPolicy{
+					Unit:            rls_config.RateLimitUnit_MINUTE,
+					RequestsPerUnit: 10,
+				}, varintField(6, 1)),
+			},
+			{
+				Key: "key2",
+				RateLimit: &rls_config.RateLimitPolicy{
+					Unit:            rls_config.RateLimitUnit_MINUTE,
+					RequestsPerUnit: 10,
+				},
+			},
+		},
+	}, statsStore)
+
+	rl := rlConfig.GetLimit(context.TODO(), "test-domain", &pb_struct.RateLimitDescriptor{
+		Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key1"}},
+	})
+	assert.Equal(config.SlidingWindow, rl.Algorithm)
+
+	rl = rlConfig.GetLimit(context.TODO(), "test-domain", &pb_struct.RateLimitDescriptor{
+		Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key2"}},
+	})
+	assert.Equal(config.FixedWindow, rl.Algorithm)
+
+	expectConfigPanic(
+		t,
+		func() {
+			loadXdsConfig(t, &rls_config.RateLimitConfig{
+				Name:   "xds",
+				Domain: "test-domain",
+				Descriptors: []*rls_config.RateLimitDescriptor{
+					{
+						Key: "key1",
+						RateLimit: withFields(&rls_config.RateLimitPolicy{
+							Unit:            rls_config.RateLimitUnit_MINUTE,
+							RequestsPerUnit: 10,
+						}, varintField(6, 9)),
+					},
+				},
+			}, statsStore)
+		},
+		"xds: invalid rate limit algorithm '9'")
+}
+
+func TestXdsConfigUnitMultiplier(t *testing.T) {
+	assert := assert.New(t)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := loadXdsConfig(t, &rls_config.RateLimitConfig{
+		Name:   "xds",
+		Domain: "test-domain",
+		Descriptors: []*rls_config.RateLimitDescriptor{
+			{
+				Key: "key1",
+				RateLimit: withFields(&rls_config.RateLimitPolicy{
+					Unit:            rls_config.RateLimitUnit_MINUTE,
+					RequestsPerUnit: 100,
+				}, varintField(7, 15)),
+			},
+		},
+	}, statsStore)
+
+	rl := rlConfig.GetLimit(context.TODO(), "test-domain", &pb_struct.RateLimitDescriptor{
+		Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key1"}},
+	})
+	assert.Equal(pb.RateLimitResponse_RateLimit_MINUTE, rl.Limit.Unit)
+	assert.EqualValues(15, rl.UnitMultiplier)
+}
+
+func TestXdsConfigMultipleLimits(t *testing.T) {
+	assert := assert.New(t)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	rlConfig := loadXdsConfig(t, &rls_config.RateLimitConfig{
+		Name:   "xds",
+		Domain: "test-domain",
+		Descriptors: []*rls_config.RateLimitDescriptor{
+			withFields(&rls_config.RateLimitDescriptor{
+				Key: "key1",
+				RateLimit: &rls_config.RateLimitPolicy{
+					Unit:            rls_config.RateLimitUnit_SECOND,
+					RequestsPerUnit: 10,
+				},
+			}, messageField(t, 7, withFields(&rls_config.RateLimitPolicy{
+				Unit:            rls_config.RateLimitUnit_HOUR,
+				RequestsPerUnit: 1000,
+			}, varintField(6, 1)))),
+		},
+	}, statsStore)
+
+	rl := rlConfig.GetLimit(context.TODO(), "test-domain", &pb_struct.RateLimitDescriptor{
+		Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "key1"}},
+	})
+	assert.Equal(pb.RateLimitResponse_RateLimit_SECOND, rl.Limit.Unit)
+	assert.EqualValues(10, rl.Limit.RequestsPerUnit)
+	assert.Len(rl.AdditionalLimits, 1)
+	assert.Equal(pb.RateLimitResponse_RateLimit_HOUR, rl.AdditionalLimits[0].Limit.Unit)
+	assert.EqualValues(1000, rl.AdditionalLimits[0].Limit.RequestsPerUnit)
+	assert.Equal(config.SlidingWindow, rl.AdditionalLimits[0].Algorithm)
+	assert.Equal("per_hour", rl.AdditionalLimits[0].KeySuffix)
+}
+
+func TestXdsConfigValueMatchers(t *testing.T) {
+	assert := assert.New(t)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	policy := func(requestsPerUnit uint32) *rls_config.RateLimitPolicy {
+		return &rls_config.RateLimitPolicy{Unit: rls_config.RateLimitUnit_SECOND, RequestsPerUnit: requestsPerUnit}
+	}
+	rlConfig := loadXdsConfig(t, &rls_config.RateLimitConfig{
+		Name:   "xds",
+		Domain: "test-domain",
+		Descriptors: []*rls_config.RateLimitDescriptor{
+			withFields(&rls_config.RateLimitDescriptor{Key: "agent", RateLimit: policy(1)},
+				stringField(8, "curl"), stringField(8, "wget")),
+			withFields(&rls_config.RateLimitDescriptor{Key: "agent", RateLimit: policy(2)},
+				stringField(9, "(ok|win)http")),
+		},
+	}, statsStore)
+
+	getLimit := func(value string) *config.RateLimit {
+		return rlConfig.GetLimit(context.TODO(), "test-domain", &pb_struct.RateLimitDescriptor{
+			Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "agent", Value: value}},
+		})
+	}
+	assert.EqualValues(1, getLimit("curl").Limit.RequestsPerUnit)
+	assert.EqualValues(1, getLimit("wget").Limit.RequestsPerUnit)
+	assert.EqualValues(2, getLimit("okhttp").Limit.RequestsPerUnit)
+	assert.Nil(getLimit("okhttp/4"))
+
+	// Regexes are compiled when the config is loaded.
+	expectConfigPanic(
+		t,
+		func() {
+			loadXdsConfig(t, &rls_config.RateLimitConfig{
+				Name:   "xds",
+				Domain: "test-domain",
+				Descriptors: []*rls_config.RateLimitDescriptor{
+					withFields(&rls_config.RateLimitDescriptor{Key: "agent", RateLimit: policy(1)},
+						stringField(9, "curl(")),
+				},
+			}, statsStore)
+		},
+		"xds: invalid value_regex 'curl(': error parsing regexp: missing closing ): `curl(`")
+}
@@ -3,6 +3,7 @@ package limiter
 import (
 	"math/rand"
 	"testing"
+	"time"
 
 	mockstats "github.com/envoyproxy/ratelimit/test/mocks/stats"
 
@@ -11,6 +12,7 @@ import (
 	"github.com/golang/mock/gomock"
 	stats "github.com/lyft/gostats"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/envoyproxy/ratelimit/src/config"
 	"github.com/envoyproxy/ratelimit/src/limiter"
@@ -229,3 +231,204 @@ func TestGetResponseStatusBelowLimitShadowMode(t *testing.T) {
 	// No shadow_mode so, no stats change
 	assert.Equal(uint64(0), limits[0].Stats.ShadowMode.Value())
 }
+
+func TestGenerateCacheKeysSlidingWindow(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	jitterSource := mock_utils.NewMockJitterRandSource(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	timeSource.EXPECT().UnixNow().Return(int64(1245))
+	baseRateLimit := limiter.NewBaseRateLimit(timeSource, rand.New(jitterSource), 3600, nil, 0.8, "", sm)
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.SlidingWindow
+	cacheKeys := baseRateLimit.GenerateCacheKeys(request, limits, []uint64{1})
+	assert.Equal(1, len(cacheKeys))
+	assert.Equal("domain_key_value_1200", cacheKeys[0].Key)
+	assert.Equal("domain_key_value_1140", cacheKeys[0].PreviousKey)
+	// 45 seconds into the current window, a quarter of the previous window still counts.
+	assert.Equal(0.25, cacheKeys[0].PreviousWeight)
+	assert.Equal(uint64(2), cacheKeys[0].WeightedPreviousCount(10))
+}
+
+func TestGetExpirationSecondsSlidingWindow(t *testing.T) {
+	assert := assert.New(t)
+	sm := mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false))
+	baseRateLimit := limiter.NewBaseRateLimit(nil, nil, 0, nil, 0.8, "", sm)
+	limit := config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)
+	assert.Equal(int64(60), baseRateLimit.GetExpirationSeconds(limit))
+	limit.Algorithm = config.SlidingWindow
+	// The counter is read again as the previous window during the next minute.
+	assert.Equal(int64(120), baseRateLimit.GetExpirationSeconds(limit))
+}
+
+func TestGenerateCacheKeysUnitMultiplier(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	timeSource.EXPECT().UnixNow().Return(int64(2222))
+	baseRateLimit := limiter.NewBaseRateLimit(timeSource, nil, 0, nil, 0.8, "", sm)
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].UnitMultiplier = 15
+	cacheKeys := baseRateLimit.GenerateCacheKeys(request, limits, []uint64{1})
+	assert.Equal(1, len(cacheKeys))
+	// The window of 15 minutes started at 1800.
+	assert.Equal("domain_key_value_1800", cacheKeys[0].Key)
+	assert.Equal(int64(900), baseRateLimit.GetExpirationSeconds(limits[0]))
+}
+
+func TestGetResponseStatusUnitMultiplier(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	sm := mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false))
+	timeSource.EXPECT().UnixNow().Return(int64(2222))
+	baseRateLimit := limiter.NewBaseRateLimit(timeSource, nil, 0, nil, 0.8, "", sm)
+	limits := []*config.RateLimit{config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].UnitMultiplier = 15
+	limitInfo := limiter.NewRateLimitInfo(limits[0], 2, 3, 0, 0)
+	responseStatus := baseRateLimit.GetResponseDescriptorStatus("key", limitInfo, false, 1)
+	assert.Equal(pb.RateLimitResponse_OK, responseStatus.GetCode())
+	assert.Equal(uint32(97), responseStatus.GetLimitRemaining())
+	// The window resets at 2700.
+	assert.Equal(int64(478), responseStatus.GetDurationUntilReset().GetSeconds())
+}
+
+func TestGenerateCacheKeysCalendarAligned(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	// 2024-03-08 00:00:00 UTC, a week into March.
+	timeSource.EXPECT().UnixNow().Return(int64(1709856000)).Times(3)
+	baseRateLimit := limiter.NewBaseRateLimit(timeSource, nil, 0, nil, 0.8, "", sm)
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_MONTH, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].CalendarLocation = time.UTC
+	limits[0].Algorithm = config.SlidingWindow
+	cacheKeys := baseRateLimit.GenerateCacheKeys(request, limits, []uint64{1})
+	assert.Equal(1, len(cacheKeys))
+	// The window starts on 2024-03-01 and the previous window on 2024-02-01.
+	assert.Equal("domain_key_value_1709251200", cacheKeys[0].Key)
+	assert.Equal("domain_key_value_1706745600", cacheKeys[0].PreviousKey)
+	assert.Equal(24.0/31.0, cacheKeys[0].PreviousWeight)
+	// The counter is kept until the end of April.
+	assert.Equal(int64(54*24*3600), baseRateLimit.GetExpirationSeconds(limits[0]))
+	// The window resets at the end of March.
+	limitInfo := limiter.NewRateLimitInfo(limits[0], 0, 1, 0, 0)
+	responseStatus := baseRateLimit.GetResponseDescriptorStatus("key", limitInfo, false, 1)
+	assert.Equal(int64(24*24*3600), responseStatus.GetDurationUntilReset().GetSeconds())
+}
+
+func TestGenerateCacheKeysAdditionalLimit(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	timeSource.EXPECT().UnixNow().Return(int64(3600)).Times(1)
+	baseRateLimit := limiter.NewBaseRateLimit(timeSource, nil, 0, nil, 0.8, "", sm)
+	request := common.NewRateLimitRequest("domain", [][][2]string{
+		{{"key", "value"}},
+		{{"key", "value"}},
+		{{"key", "value"}, {"per", "hour"}},
+	}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false),
+		config.NewRateLimit(1000, pb.RateLimitResponse_RateLimit_HOUR, sm.NewStats("key_value.per_hour"), false, false, "", nil, false),
+		config.NewRateLimit(1000, pb.RateLimitResponse_RateLimit_HOUR, sm.NewStats("key_value.per_hour"), false, false, "", nil, false),
+	}
+	limits[1].KeySuffix = "per_hour"
+	cacheKeys := baseRateLimit.GenerateCacheKeys(request, limits, []uint64{1, 1, 1})
+	assert.Equal(3, len(cacheKeys))
+	// Both windows start at the same time, so the additional limit needs its own key.
+	assert.Equal("domain_key_value_3600", cacheKeys[0].Key)
+	assert.Equal("domain_key_value|limit=per_hour|3600", cacheKeys[1].Key)
+	// Descriptor entries do not collide with the suffix of an additional limit.
+	assert.Equal("domain_key_value_per_hour_3600", cacheKeys[2].Key)
+}
+
+func TestGenerateCacheKeysAncestorLimit(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).Times(1)
+	baseRateLimit := limiter.NewBaseRateLimit(timeSource, nil, 0, nil, 0.8, "", sm)
+	request := common.NewRateLimitRequest("domain", [][][2]string{
+		{{"tenant", "a"}, {"user", "b"}},
+		{{"tenant", "a"}, {"user", "b"}},
+	}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("tenant.user"), false, false, "", nil, false),
+		config.NewRateLimit(1000, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("tenant"), false, false, "", nil, false),
+	}
+	limits[0].DescriptorDepth = 2
+	limits[1].DescriptorDepth = 1
+	cacheKeys := baseRateLimit.GenerateCacheKeys(request, limits, []uint64{1, 1})
+	assert.Equal(2, len(cacheKeys))
+	// The limit of the ancestor is counted for the entries of the ancestor only.
+	assert.Equal("domain_tenant_a_user_b_1200", cacheKeys[0].Key)
+	assert.Equal("domain_tenant_a_1200", cacheKeys[1].Key)
+}
+
+func TestGenerateCacheKeysTokenBucket(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	timeSource.EXPECT().UnixNow().Return(int64(1234))
+	baseRateLimit := limiter.NewBaseRateLimit(timeSource, nil, 3600, nil, 0.8, "prefix:", sm)
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.TokenBucket
+	cacheKeys := baseRateLimit.GenerateCacheKeys(request, limits, []uint64{1})
+	// Token buckets are not bound to a window.
+	assert.Equal("prefix:domain_key_value", cacheKeys[0].Key)
+	assert.True(cacheKeys[0].PerSecond)
+}
+
+func TestGetTokenBucketResponseStatus(t *testing.T) {
+	assert := assert.New(t)
+	sm := mockstats.NewMockStatManager(stats.NewStore(stats.NewNullSink(), false))
+	baseRateLimit := limiter.NewBaseRateLimit(nil, nil, 3600, nil, 0.8, "", sm)
+	limit := config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)
+	limit.Algorithm = config.TokenBucket
+	limit.Burst = 10
+
+	responseStatus := baseRateLimit.GetTokenBucketResponseDescriptorStatus(limit, true, 5, &durationpb.Duration{Nanos: 1000}, 1)
+	assert.Equal(pb.RateLimitResponse_OK, responseStatus.GetCode())
+	assert.Equal(uint32(5), responseStatus.GetLimitRemaining())
+	assert.Equal(int32(1000), responseStatus.GetDurationUntilReset().GetNanos())
+	assert.Equal(uint64(1), limit.Stats.WithinLimit.Value())
+	assert.Equal(uint64(0), limit.Stats.NearLimit.Value())
+
+	responseStatus = baseRateLimit.GetTokenBucketResponseDescriptorStatus(limit, true, 1, &durationpb.Duration{Nanos: 1000}, 1)
+	assert.Equal(pb.RateLimitResponse_OK, responseStatus.GetCode())
+	assert.Equal(uint64(1), limit.Stats.NearLimit.Value())
+
+	responseStatus = baseRateLimit.GetTokenBucketResponseDescriptorStatus(limit, false, 0, &durationpb.Duration{Nanos: 1000}, 2)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, responseStatus.GetCode())
+	assert.Equal(uint64(2), limit.Stats.OverLimit.Value())
+
+	// Shadow mode turns an empty bucket into OK.
+	limit.ShadowMode = true
+	responseStatus = baseRateLimit.GetTokenBucketResponseDescriptorStatus(limit, false, 0, &durationpb.Duration{Nanos: 1000}, 1)
+	assert.Equal(pb.RateLimitResponse_OK, responseStatus.GetCode())
+	assert.Equal(uint64(1), limit.Stats.ShadowMode.Value())
+}
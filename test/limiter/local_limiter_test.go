@@ -0,0 +1,52 @@
+package limiter
+
+import (
+	"testing"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/golang/mock/gomock"
+	stats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/test/common"
+	mockstats "github.com/envoyproxy/ratelimit/test/mocks/stats"
+	mock_utils "github.com/envoyproxy/ratelimit/test/mocks/utils"
+)
+
+func TestLocalLimiter(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).AnyTimes()
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	localLimiter := limiter.NewLocalLimiter(timeSource, 1024*1024, 3, 0.8, "", sm)
+
+	// Each of the 3 replicas enforces a third of the limit, rounded up.
+	limit := config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)
+	concurrencyLimit := config.NewRateLimit(5, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key2_value2"), false, false, "", nil, false)
+	concurrencyLimit.Algorithm = config.Concurrency
+	limits := []*config.RateLimit{limit, concurrencyLimit, nil}
+	request := common.NewRateLimitRequestWithPerDescriptorHitsAddend("domain",
+		[][][2]string{{{"key", "value"}}, {{"key2", "value2"}}, {{"key3", "value3"}}}, []uint64{3, 1, 1})
+
+	statuses := localLimiter.DoLimit(request, limits)
+	assert.Equal(pb.RateLimitResponse_OK, statuses[0].Code)
+	assert.EqualValues(4, statuses[0].CurrentLimit.RequestsPerUnit)
+	assert.EqualValues(1, statuses[0].LimitRemaining)
+	assert.Equal(&pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_OK}, statuses[1])
+	assert.Equal(&pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_OK}, statuses[2])
+	// The original limit is not changed.
+	assert.EqualValues(10, limit.Limit.RequestsPerUnit)
+
+	statuses = localLimiter.DoLimit(request, limits)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, statuses[0].Code)
+	assert.EqualValues(0, statuses[0].LimitRemaining)
+	assert.EqualValues(2, limit.Stats.OverLimit.Value())
+	assert.EqualValues(3, limit.Stats.WithinLimit.Value())
+	// The hits are counted in the total hits by the cache which failed.
+	assert.EqualValues(0, limit.Stats.TotalHits.Value())
+}
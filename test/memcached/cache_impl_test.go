@@ -7,8 +7,10 @@ package memcached_test
 import (
 	"context"
 	"math/rand"
+	"net"
 	"strconv"
 	"testing"
+	"time"
 
 	mockstats "github.com/envoyproxy/ratelimit/test/mocks/stats"
 
@@ -17,6 +19,7 @@ import (
 
 	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	stats "github.com/lyft/gostats"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	"github.com/envoyproxy/ratelimit/src/config"
 	"github.com/envoyproxy/ratelimit/src/limiter"
@@ -143,22 +146,29 @@ func TestMemcachedGetError(t *testing.T) {
 	sm := mockstats.NewMockStatManager(statsStore)
 	cache := memcached.NewRateLimitCacheImpl(client, timeSource, nil, 0, nil, sm, 0.8, "")
 
+	// Failures of memcached are handled according to the failure mode of the limits, and the hits are not counted in
+	// memcached.
 	timeSource.EXPECT().UnixNow().Return(int64(1234)).MaxTimes(3)
 	client.EXPECT().GetMulti([]string{"domain_key_value_1234"}).Return(
 		nil, memcache.ErrNoServers,
 	)
-	client.EXPECT().Increment("domain_key_value_1234", uint64(1)).Return(uint64(5), nil)
 
 	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
 	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)}
 
-	assert.Equal(
-		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 9, DurationUntilReset: utils.CalculateReset(&limits[0].Limit.Unit, timeSource)}},
-		cache.DoLimit(context.Background(), request, limits))
-	assert.Equal(uint64(1), limits[0].Stats.TotalHits.Value())
-	assert.Equal(uint64(0), limits[0].Stats.OverLimit.Value())
-	assert.Equal(uint64(0), limits[0].Stats.NearLimit.Value())
-	assert.Equal(uint64(1), limits[0].Stats.WithinLimit.Value())
+	assert.PanicsWithValue(memcached.MemcacheError("Error multi-getting memcache keys: memcache: no servers configured or available"), func() {
+		cache.DoLimit(context.Background(), request, limits)
+	})
+	assert.Equal(uint64(0), limits[0].Stats.WithinLimit.Value())
+
+	// Calls timing out after the backend timeout fail with a timeout.
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).MaxTimes(3)
+	client.EXPECT().GetMulti([]string{"domain_key_value_1234"}).Return(
+		nil, &memcache.ConnectTimeoutError{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 11211}},
+	)
+	assert.PanicsWithValue(limiter.CacheTimeoutError("Error multi-getting memcache keys: memcache: connect timeout to 127.0.0.1:11211"), func() {
+		cache.DoLimit(context.Background(), request, limits)
+	})
 
 	// No error, but the key is missing
 	timeSource.EXPECT().UnixNow().Return(int64(1234)).MaxTimes(3)
@@ -629,7 +639,7 @@ func TestNewRateLimitCacheImplFromSettingsWhenSrvCannotBeResolved(t *testing.T)
 	s.MemcacheSrv = "_something._tcp.example.invalid"
 
 	assert.Panics(func() {
-		memcached.NewRateLimitCacheImplFromSettings(s, timeSource, nil, nil, statsStore, mockstats.NewMockStatManager(statsStore))
+		memcached.NewRateLimitCacheImplFromSettings(s, timeSource, nil, nil, statsStore, nil, mockstats.NewMockStatManager(statsStore))
 	})
 }
 
@@ -649,7 +659,7 @@ func TestNewRateLimitCacheImplFromSettingsWhenHostAndPortAndSrvAreBothSet(t *tes
 	s.MemcacheHostPort = []string{"example.org:11211"}
 
 	assert.Panics(func() {
-		memcached.NewRateLimitCacheImplFromSettings(s, timeSource, nil, nil, statsStore, mockstats.NewMockStatManager(statsStore))
+		memcached.NewRateLimitCacheImplFromSettings(s, timeSource, nil, nil, statsStore, nil, mockstats.NewMockStatManager(statsStore))
 	})
 }
 
@@ -695,3 +705,326 @@ func getMultiResult(vals map[string]int) map[string]*memcache.Item {
 	}
 	return result
 }
+
+func TestMemcachedSlidingWindow(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	client := mock_memcached.NewMockClient(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache := memcached.NewRateLimitCacheImpl(client, timeSource, nil, 0, nil, sm, 0.8, "")
+
+	// 45 seconds into the minute, a quarter of the previous minute's hits still count.
+	timeSource.EXPECT().UnixNow().Return(int64(1245)).MaxTimes(3)
+	client.EXPECT().GetMulti([]string{"domain_key_value_1200", "domain_key_value_1140"}).Return(
+		getMultiResult(map[string]int{"domain_key_value_1200": 4, "domain_key_value_1140": 8}), nil,
+	)
+	client.EXPECT().Increment("domain_key_value_1200", uint64(1)).Return(uint64(5), nil)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.SlidingWindow
+
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 3, DurationUntilReset: utils.CalculateReset(&limits[0].Limit.Unit, timeSource)}},
+		cache.DoLimit(context.Background(), request, limits))
+	assert.Equal(uint64(1), limits[0].Stats.TotalHits.Value())
+	assert.Equal(uint64(1), limits[0].Stats.WithinLimit.Value())
+
+	// A new window key is added with an expiration of two windows.
+	timeSource.EXPECT().UnixNow().Return(int64(1265)).MaxTimes(3)
+	client.EXPECT().GetMulti([]string{"domain_key_value_1260", "domain_key_value_1200"}).Return(
+		getMultiResult(map[string]int{"domain_key_value_1200": 5}), nil,
+	)
+	client.EXPECT().Increment("domain_key_value_1260", uint64(1)).Return(uint64(0), memcache.ErrCacheMiss)
+	client.EXPECT().Add(
+		&memcache.Item{
+			Key:        "domain_key_value_1260",
+			Value:      []byte(strconv.FormatUint(1, 10)),
+			Expiration: int32(120),
+		},
+	).Return(nil)
+
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 5, DurationUntilReset: utils.CalculateReset(&limits[0].Limit.Unit, timeSource)}},
+		cache.DoLimit(context.Background(), request, limits))
+
+	cache.Flush()
+}
+
+func TestMemcachedCalendarAlignedExpiration(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	client := mock_memcached.NewMockClient(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache := memcached.NewRateLimitCacheImpl(client, timeSource, nil, 0, nil, sm, 0.8, "")
+
+	// 2024-03-08 00:00:00 UTC, the counter is kept until the end of April.
+	timeSource.EXPECT().UnixNow().Return(int64(1709856000)).AnyTimes()
+	client.EXPECT().GetMulti([]string{"domain_key_value_1709251200", "domain_key_value_1706745600"}).Return(
+		getMultiResult(map[string]int{}), nil,
+	)
+	client.EXPECT().Increment("domain_key_value_1709251200", uint64(1)).Return(uint64(0), memcache.ErrCacheMiss)
+	// Expirations of more than 30 days are passed as unix timestamps.
+	client.EXPECT().Add(
+		&memcache.Item{
+			Key:        "domain_key_value_1709251200",
+			Value:      []byte(strconv.FormatUint(1, 10)),
+			Expiration: int32(1714521600),
+		},
+	).Return(nil)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MONTH, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.SlidingWindow
+	limits[0].CalendarLocation = time.UTC
+
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 9, DurationUntilReset: &durationpb.Duration{Seconds: 24 * 24 * 3600}}},
+		cache.DoLimit(context.Background(), request, limits))
+
+	cache.Flush()
+}
+
+func TestMemcachedTokenBucketFallback(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	client := mock_memcached.NewMockClient(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache := memcached.NewRateLimitCacheImpl(client, timeSource, nil, 0, nil, sm, 0.8, "")
+
+	// Token buckets are counted in fixed windows.
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).MaxTimes(3)
+	client.EXPECT().GetMulti([]string{"domain_key_value_1234"}).Return(
+		getMultiResult(map[string]int{"domain_key_value_1234": 4}), nil,
+	)
+	client.EXPECT().Increment("domain_key_value_1234", uint64(1)).Return(uint64(5), nil)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.TokenBucket
+	limits[0].Burst = 20
+
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 5, DurationUntilReset: utils.CalculateReset(&limits[0].Limit.Unit, timeSource)}},
+		cache.DoLimit(context.Background(), request, limits))
+	assert.Equal(uint64(1), limits[0].Stats.TotalHits.Value())
+	assert.Equal(config.TokenBucket, limits[0].Algorithm)
+
+	cache.Flush()
+}
+
+// @return the keys of the generations from first to last of a memcache concurrency limit.
+func concurrencyKeys(key string, first int, last int) []string {
+	var keys []string
+	for g := first; g <= last; g++ {
+		keys = append(keys, key+"_"+strconv.Itoa(g))
+	}
+	return keys
+}
+
+func TestMemcachedConcurrency(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	client := mock_memcached.NewMockClient(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache := memcached.NewRateLimitCacheImpl(client, timeSource, nil, 0, nil, sm, 0.8, "")
+
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).AnyTimes()
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.Concurrency
+
+	// The slots of a minute are counted in generations of 6 seconds, of which those of the last lease are held.
+	keys := concurrencyKeys("domain_key_value", 195, 205)
+
+	// The first slot of a generation adds its counter, which expires one lease after the generation ended.
+	client.EXPECT().Increment("domain_key_value_205", uint64(1)).Return(uint64(0), memcache.ErrCacheMiss)
+	client.EXPECT().Add(
+		&memcache.Item{
+			Key:        "domain_key_value_205",
+			Value:      []byte(strconv.FormatUint(1, 10)),
+			Expiration: int32(62),
+		},
+	).Return(nil)
+	client.EXPECT().GetMulti(keys).Return(getMultiResult(map[string]int{"domain_key_value_205": 1}), nil)
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 1}},
+		cache.DoLimit(context.Background(), request, limits))
+
+	// Slots over the limit are returned, the expirations are never extended.
+	client.EXPECT().Increment("domain_key_value_205", uint64(1)).Return(uint64(2), nil)
+	client.EXPECT().GetMulti(keys).Return(
+		getMultiResult(map[string]int{"domain_key_value_195": 1, "domain_key_value_205": 2}), nil,
+	)
+	client.EXPECT().Decrement("domain_key_value_205", uint64(1)).Return(uint64(1), nil)
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[0].Limit, LimitRemaining: 0}},
+		cache.DoLimit(context.Background(), request, limits))
+
+	// Slots are released from the oldest generation.
+	client.EXPECT().GetMulti(keys).Return(
+		getMultiResult(map[string]int{"domain_key_value_195": 1, "domain_key_value_205": 1}), nil,
+	)
+	client.EXPECT().Decrement("domain_key_value_195", uint64(1)).Return(uint64(0), nil)
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 1}},
+		cache.Release(context.Background(), request, limits))
+
+	// Releases of expired slots are ignored.
+	client.EXPECT().GetMulti(keys).Return(getMultiResult(map[string]int{}), nil)
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 2}},
+		cache.Release(context.Background(), request, limits))
+
+	// Resetting the limit removes all generations.
+	client.EXPECT().Set(&memcache.Item{Key: "domain_key_value_205", Value: []byte("1"), Expiration: 62}).Return(nil)
+	for _, key := range keys[:len(keys)-1] {
+		client.EXPECT().Delete(key).Return(memcache.ErrCacheMiss)
+	}
+	client.EXPECT().GetMulti(keys).Return(getMultiResult(map[string]int{"domain_key_value_205": 1}), nil)
+	assert.Equal(uint64(1), cache.SetCounters(context.Background(), request, limits, 1)[0].Count)
+
+	assert.Equal(uint64(2), limits[0].Stats.TotalHits.Value())
+	assert.Equal(uint64(1), limits[0].Stats.OverLimit.Value())
+	assert.Equal(uint64(1), limits[0].Stats.WithinLimit.Value())
+
+	// Slots acquired by requests failing while memcached is unavailable are returned.
+	client.EXPECT().Increment("domain_key_value_205", uint64(1)).Return(uint64(2), nil)
+	client.EXPECT().GetMulti(keys).Return(nil, memcache.ErrNoServers)
+	client.EXPECT().Decrement("domain_key_value_205", uint64(1)).Return(uint64(1), nil)
+	assert.PanicsWithValue(memcached.MemcacheError("Error multi-getting memcache keys: memcache: no servers configured or available"), func() {
+		cache.DoLimit(context.Background(), request, limits)
+	})
+	client.EXPECT().Increment("domain_key_value_205", uint64(1)).Return(uint64(0), memcache.ErrNoServers)
+	assert.PanicsWithValue(memcached.MemcacheError("Failed to acquire slots of concurrency limit domain_key_value_205: memcache: no servers configured or available"), func() {
+		cache.DoLimit(context.Background(), request, limits)
+	})
+
+	cache.Flush()
+}
+
+func TestMemcachedRefund(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	client := mock_memcached.NewMockClient(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache := memcached.NewRateLimitCacheImpl(client, timeSource, nil, 0, nil, sm, 0.8, "")
+
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).AnyTimes()
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}, {{"key2", "value2"}}}, 2)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(5, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false),
+		config.NewRateLimit(5, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key2_value2"), false, false, "", nil, false),
+	}
+	// Token bucket limits are counted as fixed windows.
+	limits[1].Algorithm = config.TokenBucket
+
+	client.EXPECT().Decrement("domain_key_value_1234", uint64(2)).Return(uint64(1), nil)
+	client.EXPECT().Decrement("domain_key2_value2_1200", uint64(2)).Return(uint64(0), memcache.ErrCacheMiss)
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 4, DurationUntilReset: utils.CalculateReset(&limits[0].Limit.Unit, timeSource)},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 5, DurationUntilReset: utils.CalculateReset(&limits[1].Limit.Unit, timeSource)},
+		},
+		cache.Refund(context.Background(), request, limits))
+	assert.Equal(uint64(0), limits[0].Stats.TotalHits.Value())
+
+	cache.Flush()
+}
+
+func TestMemcachedGetQuotaStatus(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	client := mock_memcached.NewMockClient(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache := memcached.NewRateLimitCacheImpl(client, timeSource, nil, 0, nil, sm, 0.8, "")
+
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).AnyTimes()
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}, {{"key2", "value2"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(5, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false),
+		config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key2_value2"), false, false, "", nil, false),
+	}
+	limits[1].Algorithm = config.Concurrency
+
+	client.EXPECT().GetMulti(append([]string{"domain_key_value_1200"}, concurrencyKeys("domain_key2_value2", 195, 205)...)).Return(
+		getMultiResult(map[string]int{"domain_key_value_1200": 4, "domain_key2_value2_195": 1, "domain_key2_value2_205": 1}), nil,
+	)
+	assert.Equal(
+		[]*limiter.QuotaStatus{
+			{
+				Count: 4,
+				Status: &pb.RateLimitResponse_DescriptorStatus{
+					Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 1,
+					DurationUntilReset: utils.CalculateReset(&limits[0].Limit.Unit, timeSource),
+				},
+			},
+			{
+				Count:  2,
+				Status: &pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[1].Limit, LimitRemaining: 0},
+			},
+		},
+		cache.GetQuotaStatus(context.Background(), request, limits))
+	assert.Equal(uint64(0), limits[0].Stats.TotalHits.Value())
+
+	cache.Flush()
+}
+
+func TestMemcachedSetCounters(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	client := mock_memcached.NewMockClient(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache := memcached.NewRateLimitCacheImpl(client, timeSource, nil, 0, nil, sm, 0.8, "")
+
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).AnyTimes()
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(5, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false),
+	}
+
+	client.EXPECT().Set(&memcache.Item{Key: "domain_key_value_1200", Value: []byte("3"), Expiration: 60}).Return(nil)
+	client.EXPECT().GetMulti([]string{"domain_key_value_1200"}).Return(
+		getMultiResult(map[string]int{"domain_key_value_1200": 3}), nil,
+	)
+	statuses := cache.SetCounters(context.Background(), request, limits, 3)
+	assert.Equal(uint64(3), statuses[0].Count)
+	assert.Equal(uint32(2), statuses[0].Status.LimitRemaining)
+
+	// Resetting a missing key is not an error.
+	client.EXPECT().Delete("domain_key_value_1200").Return(memcache.ErrCacheMiss)
+	client.EXPECT().GetMulti([]string{"domain_key_value_1200"}).Return(getMultiResult(map[string]int{}), nil)
+	statuses = cache.SetCounters(context.Background(), request, limits, 0)
+	assert.Equal(uint64(0), statuses[0].Count)
+	assert.Equal(uint32(5), statuses[0].Status.LimitRemaining)
+
+	cache.Flush()
+}
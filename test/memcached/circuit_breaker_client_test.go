@@ -0,0 +1,85 @@
+package memcached_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/golang/mock/gomock"
+	stats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/memcached"
+	"github.com/envoyproxy/ratelimit/src/utils"
+	"github.com/envoyproxy/ratelimit/test/common"
+	mock_memcached "github.com/envoyproxy/ratelimit/test/mocks/memcached"
+	mockstats "github.com/envoyproxy/ratelimit/test/mocks/stats"
+	mock_utils "github.com/envoyproxy/ratelimit/test/mocks/utils"
+)
+
+func TestCircuitBreakerClient(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	client := mock_memcached.NewMockClient(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	breaker := utils.NewCircuitBreaker(utils.NewTimeSourceImpl(), 2, 0, time.Hour, 1, memcached.IsMemcacheFailure, nil,
+		statsStore.Scope("circuit_breaker"))
+	cbc := memcached.WithCircuitBreaker(client, breaker)
+
+	// Cache misses show that memcached is available.
+	client.EXPECT().Increment("foo", uint64(1)).Return(uint64(0), memcache.ErrCacheMiss).Times(2)
+	_, err := cbc.Increment("foo", 1)
+	assert.Equal(memcache.ErrCacheMiss, err)
+	_, err = cbc.Increment("foo", 1)
+	assert.Equal(memcache.ErrCacheMiss, err)
+	assert.Equal(utils.CircuitBreakerClosed, breaker.State())
+
+	client.EXPECT().GetMulti([]string{"foo"}).Return(nil, errors.New("connection refused")).Times(2)
+	_, err = cbc.GetMulti([]string{"foo"})
+	assert.Error(err)
+	_, err = cbc.GetMulti([]string{"foo"})
+	assert.Error(err)
+	assert.Equal(utils.CircuitBreakerOpen, breaker.State())
+
+	_, err = cbc.GetMulti([]string{"foo"})
+	assert.Equal(utils.ErrCircuitBreakerOpen, err)
+	assert.Equal(utils.ErrCircuitBreakerOpen, cbc.Add(&memcache.Item{Key: "foo"}))
+}
+
+func TestCircuitBreakerClientDoLimit(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	client := mock_memcached.NewMockClient(controller)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	breaker := utils.NewCircuitBreaker(utils.NewTimeSourceImpl(), 1, 0, time.Hour, 1, memcached.IsMemcacheFailure, nil,
+		statsStore.Scope("circuit_breaker"))
+	cache := memcached.NewRateLimitCacheImpl(memcached.WithCircuitBreaker(client, breaker), timeSource, nil, 0, nil, sm,
+		0.8, "")
+
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).AnyTimes()
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)}
+
+	// Requests fail with a cache unavailable error, which applies the failure modes of their limits, while memcached
+	// fails and once the breaker rejects the calls.
+	client.EXPECT().GetMulti([]string{"domain_key_value_1234"}).Return(nil, errors.New("connection refused"))
+	assert.PanicsWithValue(memcached.MemcacheError("Error multi-getting memcache keys: connection refused"), func() {
+		cache.DoLimit(context.Background(), request, limits)
+	})
+	assert.Equal(utils.CircuitBreakerOpen, breaker.State())
+	assert.PanicsWithValue(memcached.MemcacheError("Error multi-getting memcache keys: circuit breaker is open"), func() {
+		cache.DoLimit(context.Background(), request, limits)
+	})
+	assert.Implements((*limiter.CacheUnavailableError)(nil), memcached.MemcacheError(""))
+
+	cache.Flush()
+}
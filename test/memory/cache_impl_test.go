@@ -0,0 +1,364 @@
+package memory_test
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+
+	"github.com/coocood/freecache"
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/golang/mock/gomock"
+	gostats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/memory"
+	"github.com/envoyproxy/ratelimit/src/stats"
+	"github.com/envoyproxy/ratelimit/test/common"
+	mockstats "github.com/envoyproxy/ratelimit/test/mocks/stats"
+	mock_utils "github.com/envoyproxy/ratelimit/test/mocks/utils"
+)
+
+// Returns a cache and a pointer to its current unix time in nanoseconds.
+func newCache(controller *gomock.Controller, sm stats.Manager, localCache *freecache.Cache) (limiter.RateLimitCache, *int64) {
+	now := int64(1000e9)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	timeSource.EXPECT().UnixNow().DoAndReturn(func() int64 { return now / 1e9 }).AnyTimes()
+	timeSource.EXPECT().UnixNanoNow().DoAndReturn(func() int64 { return now }).AnyTimes()
+	cache, _ := memory.NewRateLimitCacheImpl(timeSource, rand.New(rand.NewSource(1)), 0, localCache, sm, 0.8, "", 4, 0)
+	return cache, &now
+}
+
+func TestMemory(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache, now := newCache(controller, sm, nil)
+
+	request := common.NewRateLimitRequestWithPerDescriptorHitsAddend("domain",
+		[][][2]string{{{"key", "value"}}, {{"key2", "value2"}}}, []uint64{2, 1})
+	limits := []*config.RateLimit{
+		config.NewRateLimit(5, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false),
+		nil,
+	}
+
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 3, DurationUntilReset: &durationpb.Duration{Seconds: 20}},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: nil, LimitRemaining: 0},
+		},
+		cache.DoLimit(context.Background(), request, limits))
+	assert.Equal(pb.RateLimitResponse_OK, cache.DoLimit(context.Background(), request, limits)[0].Code)
+	status := cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+
+	assert.Equal(uint64(6), limits[0].Stats.TotalHits.Value())
+	assert.Equal(uint64(1), limits[0].Stats.OverLimit.Value())
+	assert.Equal(uint64(1), limits[0].Stats.NearLimit.Value())
+	assert.Equal(uint64(4), limits[0].Stats.WithinLimit.Value())
+
+	// The counter of the next window starts from zero.
+	*now = 1020e9
+	status = cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(3), status.LimitRemaining)
+}
+
+func TestMemoryOverLimitWithLocalCache(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	localCache := freecache.NewCache(100)
+	cache, _ := newCache(controller, sm, localCache)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(1, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+
+	cache.DoLimit(context.Background(), request, limits)
+	cache.DoLimit(context.Background(), request, limits)
+	assert.Equal(1, int(localCache.EntryCount()))
+
+	// Keys over the limit in the local cache are not counted.
+	status := cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.Equal(uint64(1), limits[0].Stats.OverLimitWithLocalCache.Value())
+	assert.Equal(uint64(2), cache.GetQuotaStatus(context.Background(), request, limits)[0].Count)
+}
+
+func TestMemorySlidingWindow(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache, now := newCache(controller, sm, nil)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 4)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.SlidingWindow
+
+	*now = 1020e9
+	cache.DoLimit(context.Background(), request, limits)
+	cache.DoLimit(context.Background(), request, limits)
+
+	// 15 seconds into the next window, three quarters of the 8 hits of the previous window still count.
+	*now = 1095e9
+	status := cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+	status = cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+}
+
+func TestMemoryTokenBucket(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache, now := newCache(controller, sm, nil)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.TokenBucket
+	limits[0].Burst = 3
+
+	doLimit := func(nowNanos int64) *pb.RateLimitResponse_DescriptorStatus {
+		*now = nowNanos
+		return cache.DoLimit(context.Background(), request, limits)[0]
+	}
+
+	// The bucket starts full and allows a burst of 3.
+	for remaining := uint32(2); ; remaining-- {
+		status := doLimit(1000e9)
+		assert.Equal(pb.RateLimitResponse_OK, status.Code)
+		assert.Equal(remaining, status.LimitRemaining)
+		assert.Equal(int32(500e6), status.DurationUntilReset.Nanos)
+		if remaining == 0 {
+			break
+		}
+	}
+	status := doLimit(1000e9)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+
+	// One token is added every 500ms.
+	status = doLimit(1000e9 + 250e6)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.Equal(int32(250e6), status.DurationUntilReset.Nanos)
+	status = doLimit(1000e9 + 500e6)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+
+	// The bucket never holds more than the burst.
+	status = doLimit(1090e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(2), status.LimitRemaining)
+
+	assert.Equal(uint64(2), limits[0].Stats.OverLimit.Value())
+	assert.Equal(uint64(5), limits[0].Stats.WithinLimit.Value())
+}
+
+func TestMemoryGcra(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache, now := newCache(controller, sm, nil)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.GCRA
+	limits[0].Burst = 3
+
+	doLimit := func(nowNanos int64) *pb.RateLimitResponse_DescriptorStatus {
+		*now = nowNanos
+		return cache.DoLimit(context.Background(), request, limits)[0]
+	}
+
+	// A burst of 3 is admitted right away.
+	for remaining := uint32(2); remaining > 0; remaining-- {
+		status := doLimit(1000e9)
+		assert.Equal(pb.RateLimitResponse_OK, status.Code)
+		assert.Equal(remaining, status.LimitRemaining)
+		assert.Equal(int32(0), status.DurationUntilReset.Nanos)
+	}
+	status := doLimit(1000e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+	assert.Equal(int32(500e6), status.DurationUntilReset.Nanos)
+
+	// Further hits are admitted exactly every 500ms.
+	status = doLimit(1000e9 + 200e6)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.Equal(int32(300e6), status.DurationUntilReset.Nanos)
+	status = doLimit(1000e9 + 500e6)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+	assert.Equal(int32(500e6), status.DurationUntilReset.Nanos)
+
+	// The burst is restored once the theoretical arrival time has passed.
+	status = doLimit(1090e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(2), status.LimitRemaining)
+
+	assert.Equal(uint64(1), limits[0].Stats.OverLimit.Value())
+	assert.Equal(uint64(5), limits[0].Stats.WithinLimit.Value())
+}
+
+func TestMemoryConcurrency(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache, now := newCache(controller, sm, nil)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.Concurrency
+
+	doLimit := func(nowNanos int64) *pb.RateLimitResponse_DescriptorStatus {
+		*now = nowNanos
+		return cache.DoLimit(context.Background(), request, limits)[0]
+	}
+	release := func(nowNanos int64) *pb.RateLimitResponse_DescriptorStatus {
+		*now = nowNanos
+		return cache.Release(context.Background(), request, limits)[0]
+	}
+
+	status := doLimit(1000e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(1), status.LimitRemaining)
+	status = doLimit(1010e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+	// The first lease expires after a minute.
+	assert.Equal(int64(50), status.DurationUntilReset.Seconds)
+
+	status = doLimit(1020e9)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.Equal(int64(40), status.DurationUntilReset.Seconds)
+
+	// A released slot can be acquired again.
+	status = release(1030e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(1), status.LimitRemaining)
+	status = doLimit(1040e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+
+	// The slots of lost releases are freed once their leases expire.
+	status = doLimit(1090e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+
+	// Releasing more slots than are held frees all slots.
+	release(1091e9)
+	status = release(1092e9)
+	assert.Equal(uint32(2), status.LimitRemaining)
+
+	// In shadow mode, slots are acquired over the limit, so that they can be released.
+	limits[0].ShadowMode = true
+	request = common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 3)
+	status = doLimit(1100e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint64(3), limits[0].Stats.ShadowMode.Value())
+	request = common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 2)
+	status = release(1100e9)
+	assert.Equal(uint32(1), status.LimitRemaining)
+}
+
+func TestMemoryRefund(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	localCache := freecache.NewCache(100)
+	cache, _ := newCache(controller, sm, localCache)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 2)
+	limits := []*config.RateLimit{config.NewRateLimit(3, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+
+	cache.DoLimit(context.Background(), request, limits)
+	status := cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.Equal(1, int(localCache.EntryCount()))
+
+	// The refund decrements the counter and evicts the key from the local cache.
+	status = cache.Refund(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(1), status.LimitRemaining)
+	assert.Equal(int64(20), status.DurationUntilReset.Seconds)
+	assert.Equal(0, int(localCache.EntryCount()))
+
+	// The counter does not drop below zero.
+	cache.Refund(context.Background(), request, limits)
+	status = cache.Refund(context.Background(), request, limits)[0]
+	assert.Equal(uint32(3), status.LimitRemaining)
+	assert.Equal(uint64(0), cache.GetQuotaStatus(context.Background(), request, limits)[0].Count)
+
+	// Limits which do not count hits in windows are skipped.
+	limits[0].Algorithm = config.TokenBucket
+	assert.Nil(cache.Refund(context.Background(), request, limits)[0])
+}
+
+func TestMemorySetCounters(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := mockstats.NewMockStatManager(statsStore)
+	cache, _ := newCache(controller, sm, nil)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}, {{"key2", "value2"}}, {{"key3", "value3"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(3, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key2_value2"), false, false, "", nil, false),
+		nil,
+	}
+	limits[1].Algorithm = config.TokenBucket
+	limits[1].Burst = 10
+
+	for i := 0; i < 3; i++ {
+		cache.DoLimit(context.Background(), request, limits)
+	}
+
+	// Reading the quota does not count any hits.
+	statuses := cache.GetQuotaStatus(context.Background(), request, limits)
+	assert.Equal(uint64(3), statuses[0].Count)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, statuses[0].Status.Code)
+	assert.Equal(uint64(3), statuses[1].Count)
+	assert.Equal(uint32(7), statuses[1].Status.LimitRemaining)
+	assert.Nil(statuses[2])
+	assert.Equal(uint64(3), limits[1].Stats.TotalHits.Value())
+
+	// Only the fixed window limit is set.
+	statuses = cache.SetCounters(context.Background(), request, limits, 1)
+	assert.Equal(uint64(1), statuses[0].Count)
+	assert.Equal(uint32(2), statuses[0].Status.LimitRemaining)
+	assert.Nil(statuses[1])
+
+	// Resetting removes the state of all limits.
+	statuses = cache.SetCounters(context.Background(), request, limits, 0)
+	assert.Equal(uint64(0), statuses[0].Count)
+	assert.Equal(uint32(3), statuses[0].Status.LimitRemaining)
+	assert.Equal(uint64(0), statuses[1].Count)
+	assert.Equal(uint32(10), statuses[1].Status.LimitRemaining)
+}
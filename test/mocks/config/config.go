@@ -1,5 +1,5 @@
 // Code generated by MockGen. DO NOT EDIT.
-// Source: github.com/envoyproxy/ratelimit/src/config (interfaces: RateLimitConfig,RateLimitConfigLoader)
+// Source: github.com/envoyproxy/ratelimit/src/config (interfaces: RateLimitConfig,RateLimitConfigLoader,TemporaryOverrideStore,LimitStore)
 
 // Package mock_config is a generated GoMock package.
 package mock_config
@@ -116,3 +116,119 @@ func (mr *MockRateLimitConfigLoaderMockRecorder) Load(arg0, arg1, arg2 interface
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Load", reflect.TypeOf((*MockRateLimitConfigLoader)(nil).Load), arg0, arg1, arg2)
 }
+
+// MockTemporaryOverrideStore is a mock of TemporaryOverrideStore interface
+type MockTemporaryOverrideStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockTemporaryOverrideStoreMockRecorder
+}
+
+// MockTemporaryOverrideStoreMockRecorder is the mock recorder for MockTemporaryOverrideStore
+type MockTemporaryOverrideStoreMockRecorder struct {
+	mock *MockTemporaryOverrideStore
+}
+
+// NewMockTemporaryOverrideStore creates a new mock instance
+func NewMockTemporaryOverrideStore(ctrl *gomock.Controller) *MockTemporaryOverrideStore {
+	mock := &MockTemporaryOverrideStore{ctrl: ctrl}
+	mock.recorder = &MockTemporaryOverrideStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockTemporaryOverrideStore) EXPECT() *MockTemporaryOverrideStoreMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method
+func (m *MockTemporaryOverrideStore) Get(arg0 string) *config.TemporaryOverride {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", arg0)
+	ret0, _ := ret[0].(*config.TemporaryOverride)
+	return ret0
+}
+
+// Get indicates an expected call of Get
+func (mr *MockTemporaryOverrideStoreMockRecorder) Get(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockTemporaryOverrideStore)(nil).Get), arg0)
+}
+
+// List mocks base method
+func (m *MockTemporaryOverrideStore) List() []*config.TemporaryOverride {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List")
+	ret0, _ := ret[0].([]*config.TemporaryOverride)
+	return ret0
+}
+
+// List indicates an expected call of List
+func (mr *MockTemporaryOverrideStoreMockRecorder) List() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockTemporaryOverrideStore)(nil).List))
+}
+
+// Set mocks base method
+func (m *MockTemporaryOverrideStore) Set(arg0 *config.TemporaryOverride) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Set", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Set indicates an expected call of Set
+func (mr *MockTemporaryOverrideStoreMockRecorder) Set(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Set", reflect.TypeOf((*MockTemporaryOverrideStore)(nil).Set), arg0)
+}
+
+// Delete mocks base method
+func (m *MockTemporaryOverrideStore) Delete(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete
+func (mr *MockTemporaryOverrideStoreMockRecorder) Delete(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockTemporaryOverrideStore)(nil).Delete), arg0)
+}
+
+// MockLimitStore is a mock of LimitStore interface
+type MockLimitStore struct {
+	ctrl     *gomock.Controller
+	recorder *MockLimitStoreMockRecorder
+}
+
+// MockLimitStoreMockRecorder is the mock recorder for MockLimitStore
+type MockLimitStoreMockRecorder struct {
+	mock *MockLimitStore
+}
+
+// NewMockLimitStore creates a new mock instance
+func NewMockLimitStore(ctrl *gomock.Controller) *MockLimitStore {
+	mock := &MockLimitStore{ctrl: ctrl}
+	mock.recorder = &MockLimitStoreMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockLimitStore) EXPECT() *MockLimitStoreMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method
+func (m *MockLimitStore) Get(arg0 string) *config.StoredLimit {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", arg0)
+	ret0, _ := ret[0].(*config.StoredLimit)
+	return ret0
+}
+
+// Get indicates an expected call of Get
+func (mr *MockLimitStoreMockRecorder) Get(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockLimitStore)(nil).Get), arg0)
+}
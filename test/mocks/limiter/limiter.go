@@ -12,6 +12,7 @@ import (
 	gomock "github.com/golang/mock/gomock"
 
 	config "github.com/envoyproxy/ratelimit/src/config"
+	limiter "github.com/envoyproxy/ratelimit/src/limiter"
 )
 
 // MockRateLimitCache is a mock of RateLimitCache interface
@@ -51,6 +52,62 @@ func (mr *MockRateLimitCacheMockRecorder) DoLimit(arg0, arg1, arg2 interface{})
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DoLimit", reflect.TypeOf((*MockRateLimitCache)(nil).DoLimit), arg0, arg1, arg2)
 }
 
+// Release mocks base method
+func (m *MockRateLimitCache) Release(arg0 context.Context, arg1 *envoy_service_ratelimit_v3.RateLimitRequest, arg2 []*config.RateLimit) []*envoy_service_ratelimit_v3.RateLimitResponse_DescriptorStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Release", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]*envoy_service_ratelimit_v3.RateLimitResponse_DescriptorStatus)
+	return ret0
+}
+
+// Release indicates an expected call of Release
+func (mr *MockRateLimitCacheMockRecorder) Release(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Release", reflect.TypeOf((*MockRateLimitCache)(nil).Release), arg0, arg1, arg2)
+}
+
+// Refund mocks base method
+func (m *MockRateLimitCache) Refund(arg0 context.Context, arg1 *envoy_service_ratelimit_v3.RateLimitRequest, arg2 []*config.RateLimit) []*envoy_service_ratelimit_v3.RateLimitResponse_DescriptorStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Refund", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]*envoy_service_ratelimit_v3.RateLimitResponse_DescriptorStatus)
+	return ret0
+}
+
+// Refund indicates an expected call of Refund
+func (mr *MockRateLimitCacheMockRecorder) Refund(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Refund", reflect.TypeOf((*MockRateLimitCache)(nil).Refund), arg0, arg1, arg2)
+}
+
+// GetQuotaStatus mocks base method
+func (m *MockRateLimitCache) GetQuotaStatus(arg0 context.Context, arg1 *envoy_service_ratelimit_v3.RateLimitRequest, arg2 []*config.RateLimit) []*limiter.QuotaStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetQuotaStatus", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]*limiter.QuotaStatus)
+	return ret0
+}
+
+// GetQuotaStatus indicates an expected call of GetQuotaStatus
+func (mr *MockRateLimitCacheMockRecorder) GetQuotaStatus(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetQuotaStatus", reflect.TypeOf((*MockRateLimitCache)(nil).GetQuotaStatus), arg0, arg1, arg2)
+}
+
+// SetCounters mocks base method
+func (m *MockRateLimitCache) SetCounters(arg0 context.Context, arg1 *envoy_service_ratelimit_v3.RateLimitRequest, arg2 []*config.RateLimit, arg3 uint64) []*limiter.QuotaStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetCounters", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]*limiter.QuotaStatus)
+	return ret0
+}
+
+// SetCounters indicates an expected call of SetCounters
+func (mr *MockRateLimitCacheMockRecorder) SetCounters(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetCounters", reflect.TypeOf((*MockRateLimitCache)(nil).SetCounters), arg0, arg1, arg2, arg3)
+}
+
 // Flush mocks base method
 func (m *MockRateLimitCache) Flush() {
 	m.ctrl.T.Helper()
@@ -21,6 +21,7 @@ func (m *MockStatManager) NewShouldRateLimitStats() stats.ShouldRateLimitStats {
 	ret := stats.ShouldRateLimitStats{}
 	ret.RedisError = s.NewCounter("redis_error")
 	ret.ServiceError = s.NewCounter("service_error")
+	ret.BackendTimeout = s.NewCounter("backend_timeout")
 	return ret
 }
 
@@ -29,7 +30,37 @@ func (m *MockStatManager) NewServiceStats() stats.ServiceStats {
 	ret.ConfigLoadSuccess = m.store.NewCounter("config_load_success")
 	ret.ConfigLoadError = m.store.NewCounter("config_load_error")
 	ret.ShouldRateLimit = m.NewShouldRateLimitStats()
+	release := m.store.Scope("call.release")
+	ret.Release = stats.ShouldRateLimitStats{
+		RedisError:     release.NewCounter("redis_error"),
+		ServiceError:   release.NewCounter("service_error"),
+		BackendTimeout: release.NewCounter("backend_timeout"),
+	}
+	refund := m.store.Scope("call.refund")
+	ret.Refund = stats.ShouldRateLimitStats{
+		RedisError:     refund.NewCounter("redis_error"),
+		ServiceError:   refund.NewCounter("service_error"),
+		BackendTimeout: refund.NewCounter("backend_timeout"),
+	}
+	getQuotaStatus := m.store.Scope("call.get_quota_status")
+	ret.GetQuotaStatus = stats.ShouldRateLimitStats{
+		RedisError:     getQuotaStatus.NewCounter("redis_error"),
+		ServiceError:   getQuotaStatus.NewCounter("service_error"),
+		BackendTimeout: getQuotaStatus.NewCounter("backend_timeout"),
+	}
+	admin := m.store.Scope("call.admin")
+	ret.Admin = stats.ShouldRateLimitStats{
+		RedisError:     admin.NewCounter("redis_error"),
+		ServiceError:   admin.NewCounter("service_error"),
+		BackendTimeout: admin.NewCounter("backend_timeout"),
+	}
 	ret.GlobalShadowMode = m.store.NewCounter("global_shadow_mode")
+	failureMode := m.store.Scope("call.should_rate_limit.failure_mode")
+	ret.FailureMode = stats.FailureModeStats{
+		Allow: failureMode.NewCounter("allow"),
+		Deny:  failureMode.NewCounter("deny"),
+		Local: failureMode.NewCounter("local"),
+	}
 	return ret
 }
 
@@ -44,6 +75,10 @@ func (m *MockStatManager) NewStats(key string) stats.RateLimitStats {
 	ret.OverLimitWithLocalCache = m.store.NewCounter(key + ".over_limit_with_local_cache")
 	ret.WithinLimit = m.store.NewCounter(key + ".within_limit")
 	ret.ShadowMode = m.store.NewCounter(key + ".shadow_mode")
+	ret.LeaseAcquired = m.store.NewCounter(key + ".lease_acquired")
+	ret.LeaseHits = m.store.NewCounter(key + ".lease_hits")
+	ret.LeaseReturned = m.store.NewCounter(key + ".lease_returned")
+	ret.LeaseReturnError = m.store.NewCounter(key + ".lease_return_error")
 
 	return ret
 }
@@ -47,6 +47,20 @@ func (mr *MockTimeSourceMockRecorder) UnixNow() *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnixNow", reflect.TypeOf((*MockTimeSource)(nil).UnixNow))
 }
 
+// UnixNanoNow mocks base method
+func (m *MockTimeSource) UnixNanoNow() int64 {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnixNanoNow")
+	ret0, _ := ret[0].(int64)
+	return ret0
+}
+
+// UnixNanoNow indicates an expected call of UnixNanoNow
+func (mr *MockTimeSourceMockRecorder) UnixNanoNow() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnixNanoNow", reflect.TypeOf((*MockTimeSource)(nil).UnixNanoNow))
+}
+
 // MockJitterRandSource is a mock of JitterRandSource interface
 type MockJitterRandSource struct {
 	ctrl     *gomock.Controller
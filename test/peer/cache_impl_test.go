@@ -0,0 +1,184 @@
+package peer_test
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	"github.com/golang/mock/gomock"
+	gostats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/peer"
+	"github.com/envoyproxy/ratelimit/src/settings"
+	"github.com/envoyproxy/ratelimit/src/stats"
+	"github.com/envoyproxy/ratelimit/test/common"
+	mockstats "github.com/envoyproxy/ratelimit/test/mocks/stats"
+	mock_utils "github.com/envoyproxy/ratelimit/test/mocks/utils"
+)
+
+type replica struct {
+	address    string
+	cache      limiter.RateLimitCache
+	grpcServer *grpc.Server
+	statsStore gostats.Store
+}
+
+// Starts replicas forming a cluster on local ports, which are stopped along with the test.
+func newReplicas(t *testing.T, controller *gomock.Controller, n int) []replica {
+	now := int64(1000e9)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	timeSource.EXPECT().UnixNow().DoAndReturn(func() int64 { return now / 1e9 }).AnyTimes()
+	timeSource.EXPECT().UnixNanoNow().DoAndReturn(func() int64 { return now }).AnyTimes()
+
+	listeners := make([]net.Listener, n)
+	addresses := make([]string, n)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = listener
+		addresses[i] = listener.Addr().String()
+	}
+
+	replicas := make([]replica, n)
+	for i := range replicas {
+		var s settings.Settings
+		s.NearLimitRatio = 0.8
+		s.MemoryShards = 4
+		s.PeerSelfAddress = addresses[i]
+		s.PeerAddresses = addresses
+		s.PeerVirtualNodes = 100
+		s.PeerToken = "token"
+
+		replicas[i].address = addresses[i]
+		replicas[i].statsStore = gostats.NewStore(gostats.NewNullSink(), false)
+		replicas[i].grpcServer = grpc.NewServer()
+		cache, closer := peer.NewRateLimitCacheImplFromSettings(s, replicas[i].grpcServer,
+			replicas[i].statsStore.Scope("peer"), timeSource, nil, nil, mockstats.NewMockStatManager(replicas[i].statsStore))
+		replicas[i].cache = cache
+		go replicas[i].grpcServer.Serve(listeners[i])
+		t.Cleanup(func() {
+			replicas[i].grpcServer.Stop()
+			closer.Close()
+		})
+	}
+	return replicas
+}
+
+// Returns a request of 10 descriptors, each limited to 2 hits per minute.
+func newRequest(sm stats.Manager) (*pb.RateLimitRequest, []*config.RateLimit) {
+	var descriptors [][][2]string
+	var limits []*config.RateLimit
+	for i := 0; i < 10; i++ {
+		descriptors = append(descriptors, [][2]string{{"key", "value" + strconv.Itoa(i)}})
+		limits = append(limits, config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_MINUTE,
+			sm.NewStats("key_value"+strconv.Itoa(i)), false, false, "", nil, false))
+	}
+	return common.NewRateLimitRequest("domain", descriptors, 1), limits
+}
+
+func TestPeerSharesCounters(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	replicas := newReplicas(t, controller, 2)
+	sm := mockstats.NewMockStatManager(gostats.NewStore(gostats.NewNullSink(), false))
+
+	// The keys of the descriptors are spread over both replicas.
+	request, limits := newRequest(sm)
+	limits[9].Algorithm = config.TokenBucket
+	limits[9].Burst = 2
+
+	for _, r := range replicas {
+		for _, status := range r.cache.DoLimit(context.Background(), request, limits) {
+			assert.Equal(pb.RateLimitResponse_OK, status.Code)
+		}
+	}
+	for _, status := range replicas[1].cache.DoLimit(context.Background(), request, limits) {
+		assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	}
+	quota := replicas[0].cache.GetQuotaStatus(context.Background(), request, limits)
+	assert.Equal(uint64(3), quota[0].Count)
+
+	for _, r := range replicas {
+		local := r.statsStore.NewCounter("peer.local_ops").Value()
+		forwarded := r.statsStore.NewCounter("peer.forwarded_ops").Value()
+		served := r.statsStore.NewCounter("peer.served_ops").Value()
+		assert.Greater(local, uint64(0))
+		assert.Greater(forwarded, uint64(0))
+		assert.Greater(served, uint64(0))
+		assert.Equal(uint64(2), r.statsStore.NewGauge("peer.members").Value())
+	}
+}
+
+func TestPeerUnavailable(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	replicas := newReplicas(t, controller, 2)
+	sm := mockstats.NewMockStatManager(gostats.NewStore(gostats.NewNullSink(), false))
+
+	request, limits := newRequest(sm)
+
+	// An unavailable owner fails the request like an unavailable cache, so that the failure modes apply.
+	replicas[1].grpcServer.Stop()
+	assert.Panics(func() {
+		defer func() {
+			err := recover()
+			_, ok := err.(peer.PeerError)
+			assert.True(ok, "%v", err)
+			_, ok = err.(limiter.CacheUnavailableError)
+			assert.True(ok, "%v", err)
+			panic(err)
+		}()
+		replicas[0].cache.DoLimit(context.Background(), request, limits)
+	})
+	assert.Equal(uint64(1), replicas[0].statsStore.NewCounter("peer.forward_error").Value())
+	// The hits on the keys owned by the available replica are not counted either.
+	assert.Equal(uint64(0), replicas[0].statsStore.NewCounter("peer.local_ops").Value())
+}
+
+func TestPeerUnauthenticated(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	replicas := newReplicas(t, controller, 1)
+	conn, err := grpc.NewClient(replicas[0].address, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Only the other replicas, which hold the peer token, may apply ops.
+	for _, ctx := range []context.Context{
+		context.Background(),
+		metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer other"),
+	} {
+		request := map[string]any{"ops": []any{}}
+		var response map[string]any
+		err := conn.Invoke(ctx, "/ratelimit.peer.Peer/Apply", request, &response,
+			grpc.CallContentSubtype("ratelimit-peer-json"))
+		assert.Equal(codes.Unauthenticated, status.Code(err))
+	}
+	assert.Equal(uint64(0), replicas[0].statsStore.NewCounter("peer.served_ops").Value())
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer token")
+	request := map[string]any{"ops": []any{}}
+	var response map[string]any
+	assert.NoError(conn.Invoke(ctx, "/ratelimit.peer.Peer/Apply", request, &response,
+		grpc.CallContentSubtype("ratelimit-peer-json")))
+}
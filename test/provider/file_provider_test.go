@@ -0,0 +1,56 @@
+package provider_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	pb_struct "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
+	gostats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/ratelimit/src/provider"
+	"github.com/envoyproxy/ratelimit/src/settings"
+	"github.com/envoyproxy/ratelimit/test/mocks/stats"
+)
+
+func TestFileProviderOverrides(t *testing.T) {
+	assert := assert.New(t)
+
+	runtimePath := t.TempDir()
+	configPath := filepath.Join(runtimePath, "ratelimit", "config")
+	assert.NoError(os.MkdirAll(configPath, 0o755))
+	files := map[string]string{
+		"tenants.yaml": "domain: tenants\n" +
+			"descriptors:\n" +
+			"  - key: tenant\n" +
+			"    rate_limit:\n" +
+			"      unit: minute\n" +
+			"      requests_per_unit: 100\n",
+		"tenants.overrides.csv":  "domain,descriptor,value,unit,requests_per_unit\ntenants,tenant,x,minute,5000\n",
+		"tenants.overrides.json": `[{"domain": "tenants", "descriptor": "tenant", "value": "y", "unit": "second", "requests_per_unit": 3}]`,
+	}
+	for name, content := range files {
+		assert.NoError(os.WriteFile(filepath.Join(configPath, name), []byte(content), 0o644))
+	}
+
+	s := settings.Settings{
+		RuntimePath:         runtimePath,
+		RuntimeAppDirectory: "ratelimit",
+	}
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	p := provider.NewFileProvider(s, stats.NewMockStatManager(statsStore), statsStore)
+	defer p.Stop()
+
+	config, err := (<-p.ConfigUpdateEvent()).GetConfig()
+	assert.Nil(err)
+	tenant := func(value string) *pb_struct.RateLimitDescriptor {
+		return &pb_struct.RateLimitDescriptor{Entries: []*pb_struct.RateLimitDescriptor_Entry{{Key: "tenant", Value: value}}}
+	}
+
+	// Overrides files of both formats are loaded along with the config they override.
+	assert.EqualValues(5000, config.GetLimit(context.TODO(), "tenants", tenant("x")).Limit.RequestsPerUnit)
+	assert.EqualValues(3, config.GetLimit(context.TODO(), "tenants", tenant("y")).Limit.RequestsPerUnit)
+	assert.EqualValues(100, config.GetLimit(context.TODO(), "tenants", tenant("z")).Limit.RequestsPerUnit)
+}
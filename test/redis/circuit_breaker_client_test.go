@@ -0,0 +1,42 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	gostats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/ratelimit/src/redis"
+	"github.com/envoyproxy/ratelimit/src/utils"
+)
+
+func TestCircuitBreakerClient(t *testing.T) {
+	assert := assert.New(t)
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	breaker := utils.NewCircuitBreaker(utils.NewTimeSourceImpl(), 2, 0, time.Hour, 1, redis.IsRedisFailure, nil,
+		statsStore.Scope("circuit_breaker"))
+	client = redis.WithCircuitBreaker(client, breaker)
+
+	// Error replies show that redis is available.
+	redisSrv.Set("key", "value")
+	var count int64
+	assert.Error(client.DoCmd(&count, "INCRBY", "key", 1))
+	assert.Error(client.DoCmd(&count, "INCRBY", "key", 1))
+	assert.Equal(utils.CircuitBreakerClosed, breaker.State())
+
+	// Redis instances which can't serve commands open the breaker.
+	redisSrv.SetError("LOADING Redis is loading the dataset in memory")
+	assert.Error(client.PipeDo(client.PipeAppend(nil, &count, "INCRBY", "key2", 1)))
+	assert.Error(client.DoCmd(&count, "INCRBY", "key2", 1))
+	assert.Equal(utils.CircuitBreakerOpen, breaker.State())
+
+	redisSrv.SetError("")
+	assert.Equal(utils.ErrCircuitBreakerOpen, client.DoCmd(&count, "INCRBY", "key2", 1))
+	assert.Equal(utils.ErrCircuitBreakerOpen, client.PipeDo(client.PipeAppend(nil, &count, "INCRBY", "key2", 1)))
+	assert.False(redisSrv.Exists("key2"))
+}
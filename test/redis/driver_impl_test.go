@@ -2,6 +2,7 @@ package redis_test
 
 import (
 	"fmt"
+	"net"
 	"testing"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	stats "github.com/lyft/gostats"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/envoyproxy/ratelimit/src/limiter"
 	"github.com/envoyproxy/ratelimit/src/redis"
 )
 
@@ -38,7 +40,7 @@ func testNewClientImpl(t *testing.T, pipelineWindow time.Duration, pipelineLimit
 		statsStore := stats.NewStore(stats.NewNullSink(), false)
 
 		mkRedisClient := func(auth, addr string) redis.Client {
-			return redis.NewClientImpl(statsStore, false, auth, "tcp", "single", addr, 1, pipelineWindow, pipelineLimit, nil, false, nil)
+			return redis.NewClientImpl(statsStore, false, auth, "tcp", "single", addr, 1, pipelineWindow, pipelineLimit, 0, nil, false, nil)
 		}
 
 		t.Run("connection refused", func(t *testing.T) {
@@ -131,7 +133,7 @@ func TestDoCmd(t *testing.T) {
 	statsStore := stats.NewStore(stats.NewNullSink(), false)
 
 	mkRedisClient := func(addr string) redis.Client {
-		return redis.NewClientImpl(statsStore, false, "", "tcp", "single", addr, 1, 0, 0, nil, false, nil)
+		return redis.NewClientImpl(statsStore, false, "", "tcp", "single", addr, 1, 0, 0, 0, nil, false, nil)
 	}
 
 	t.Run("SETGET ok", func(t *testing.T) {
@@ -171,12 +173,45 @@ func TestDoCmd(t *testing.T) {
 	})
 }
 
+func TestClientTimeout(t *testing.T) {
+	statsStore := stats.NewStore(stats.NewNullSink(), false)
+
+	// A server which accepts connections but never replies.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	start := time.Now()
+	err = expectPanicError(t, func() {
+		redis.NewClientImpl(statsStore, false, "", "tcp", "single", listener.Addr().String(), 1, 0, 0,
+			50*time.Millisecond, nil, false, nil)
+	})
+	assert.Contains(t, err.Error(), "i/o timeout")
+	assert.IsType(t, limiter.CacheTimeoutError(""), err)
+	assert.Less(t, time.Since(start), time.Second)
+}
+
 func testPipeDo(t *testing.T, pipelineWindow time.Duration, pipelineLimit int) func(t *testing.T) {
 	return func(t *testing.T) {
 		statsStore := stats.NewStore(stats.NewNullSink(), false)
 
 		mkRedisClient := func(addr string) redis.Client {
-			return redis.NewClientImpl(statsStore, false, "", "tcp", "single", addr, 1, pipelineWindow, pipelineLimit, nil, false, nil)
+			return redis.NewClientImpl(statsStore, false, "", "tcp", "single", addr, 1, pipelineWindow, pipelineLimit, 0, nil, false, nil)
 		}
 
 		t.Run("SETGET ok", func(t *testing.T) {
@@ -2,6 +2,7 @@ package redis_test
 
 import (
 	"context"
+	"errors"
 	"math/rand"
 	"testing"
 
@@ -9,6 +10,7 @@ import (
 
 	"github.com/coocood/freecache"
 	"github.com/mediocregopher/radix/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
 
 	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	gostats "github.com/lyft/gostats"
@@ -749,3 +751,630 @@ func TestOverLimitWithStopCacheKeyIncrementWhenOverlimitConfig(t *testing.T) {
 	// Check the local cache stats.
 	testLocalCacheStats(localCacheStats, statsStore, sink, 0, 2, 3, 0, 1)
 }
+
+func TestRedisSlidingWindow(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	client := mock_redis.NewMockClient(controller)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	localCache := freecache.NewCache(100)
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, localCache, 0.8, "", sm, false)
+
+	// 45 seconds into the minute, a quarter of the previous minute's hits still count.
+	timeSource.EXPECT().UnixNow().Return(int64(1245)).MaxTimes(3)
+	client.EXPECT().PipeAppend(gomock.Any(), gomock.Any(), "INCRBY", "domain_key_value_1200", uint64(1)).SetArg(1, uint64(5)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeAppend(gomock.Any(), gomock.Any(), "EXPIRE", "domain_key_value_1200", int64(120)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeAppend(gomock.Any(), gomock.Any(), "GET", "domain_key_value_1140").SetArg(1, uint64(8)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeDo(gomock.Any()).Return(nil)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.SlidingWindow
+
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 3, DurationUntilReset: utils.CalculateReset(&limits[0].Limit.Unit, timeSource)}},
+		cache.DoLimit(context.Background(), request, limits))
+	assert.Equal(uint64(1), limits[0].Stats.TotalHits.Value())
+	assert.Equal(uint64(1), limits[0].Stats.WithinLimit.Value())
+
+	// The previous window pushes the current window over the limit.
+	timeSource.EXPECT().UnixNow().Return(int64(1245)).MaxTimes(3)
+	client.EXPECT().PipeAppend(gomock.Any(), gomock.Any(), "INCRBY", "domain_key_value_1200", uint64(1)).SetArg(1, uint64(9)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeAppend(gomock.Any(), gomock.Any(), "EXPIRE", "domain_key_value_1200", int64(120)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeAppend(gomock.Any(), gomock.Any(), "GET", "domain_key_value_1140").SetArg(1, uint64(8)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeDo(gomock.Any()).Return(nil)
+
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[0].Limit, LimitRemaining: 0, DurationUntilReset: utils.CalculateReset(&limits[0].Limit.Unit, timeSource)}},
+		cache.DoLimit(context.Background(), request, limits))
+	assert.Equal(uint64(2), limits[0].Stats.TotalHits.Value())
+	assert.Equal(uint64(1), limits[0].Stats.OverLimit.Value())
+
+	// Sliding window keys are not stored in the local cache, since they may drop below the limit within the window.
+	assert.Equal(int64(0), localCache.EntryCount())
+}
+
+func TestRedisTokenBucket(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	client := mock_redis.NewMockClient(controller)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, nil, 0.8, "", sm, false)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}, {{"key2", "value2"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key2_value2"), false, false, "", nil, false),
+	}
+	limits[0].Algorithm = config.TokenBucket
+	limits[0].Burst = 20
+
+	// The token bucket is evaluated by a script on a single key, while the fixed window limit is pipelined.
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).MaxTimes(3)
+	timeSource.EXPECT().UnixNanoNow().Return(int64(1234500000000))
+	client.EXPECT().DoCmd(gomock.Any(), "EVAL", gomock.Any(), 1, "domain_key_value", uint32(20), float64(100000), int64(1234500000), uint64(1)).
+		SetArg(0, []int64{1, 3, 40000}).Return(nil)
+	client.EXPECT().PipeAppend(gomock.Any(), gomock.Any(), "INCRBY", "domain_key2_value2_1234", uint64(1)).SetArg(1, uint64(5)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeAppend(gomock.Any(), gomock.Any(), "EXPIRE", "domain_key2_value2_1234", int64(1)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeDo(gomock.Any()).Return(nil)
+
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 3, DurationUntilReset: &durationpb.Duration{Nanos: 40000000}},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 5, DurationUntilReset: utils.CalculateReset(&limits[1].Limit.Unit, timeSource)},
+		},
+		cache.DoLimit(context.Background(), request, limits))
+	assert.Equal(uint64(1), limits[0].Stats.TotalHits.Value())
+	assert.Equal(uint64(1), limits[0].Stats.WithinLimit.Value())
+	// 17 of 20 tokens are used, which is above the near limit ratio.
+	assert.Equal(uint64(1), limits[0].Stats.NearLimit.Value())
+
+	// An empty bucket is over the limit.
+	request = common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 2)
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).MaxTimes(3)
+	timeSource.EXPECT().UnixNanoNow().Return(int64(1234600000000))
+	client.EXPECT().DoCmd(gomock.Any(), "EVAL", gomock.Any(), 1, "domain_key_value", uint32(20), float64(100000), int64(1234600000), uint64(2)).
+		SetArg(0, []int64{0, 1, 20000}).Return(nil)
+
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[0].Limit, LimitRemaining: 1, DurationUntilReset: &durationpb.Duration{Nanos: 20000000}},
+		},
+		cache.DoLimit(context.Background(), request, limits[:1]))
+	assert.Equal(uint64(3), limits[0].Stats.TotalHits.Value())
+	assert.Equal(uint64(2), limits[0].Stats.OverLimit.Value())
+}
+
+func TestRedisTokenBucketScript(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, nil, 0.8, "", sm, false)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.TokenBucket
+	limits[0].Burst = 3
+
+	doLimit := func(nowNanos int64) *pb.RateLimitResponse_DescriptorStatus {
+		timeSource.EXPECT().UnixNow().Return(nowNanos / 1e9).AnyTimes()
+		timeSource.EXPECT().UnixNanoNow().Return(nowNanos)
+		return cache.DoLimit(context.Background(), request, limits)[0]
+	}
+
+	// The bucket starts full and allows a burst of 3.
+	for remaining := uint32(2); ; remaining-- {
+		status := doLimit(1000e9)
+		assert.Equal(pb.RateLimitResponse_OK, status.Code)
+		assert.Equal(remaining, status.LimitRemaining)
+		assert.Equal(int32(500e6), status.DurationUntilReset.Nanos)
+		if remaining == 0 {
+			break
+		}
+	}
+	status := doLimit(1000e9)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.True(redisSrv.Exists("domain_key_value"))
+
+	// One token is added every 500ms.
+	status = doLimit(1000e9 + 250e6)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.Equal(int32(250e6), status.DurationUntilReset.Nanos)
+	status = doLimit(1000e9 + 500e6)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+
+	// The bucket never holds more than the burst.
+	status = doLimit(1090e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(2), status.LimitRemaining)
+
+	assert.Equal(uint64(2), limits[0].Stats.OverLimit.Value())
+	assert.Equal(uint64(5), limits[0].Stats.WithinLimit.Value())
+}
+
+func TestRedisGcraScript(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, nil, 0.8, "", sm, false)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.GCRA
+	limits[0].Burst = 3
+
+	doLimit := func(nowNanos int64) *pb.RateLimitResponse_DescriptorStatus {
+		timeSource.EXPECT().UnixNow().Return(nowNanos / 1e9).AnyTimes()
+		timeSource.EXPECT().UnixNanoNow().Return(nowNanos)
+		return cache.DoLimit(context.Background(), request, limits)[0]
+	}
+
+	// A burst of 3 is admitted right away.
+	for remaining := uint32(2); remaining > 0; remaining-- {
+		status := doLimit(1000e9)
+		assert.Equal(pb.RateLimitResponse_OK, status.Code)
+		assert.Equal(remaining, status.LimitRemaining)
+		assert.Equal(int32(0), status.DurationUntilReset.Nanos)
+	}
+	status := doLimit(1000e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+	assert.Equal(int32(500e6), status.DurationUntilReset.Nanos)
+	assert.True(redisSrv.Exists("domain_key_value"))
+
+	// Further hits are admitted exactly every 500ms.
+	status = doLimit(1000e9 + 200e6)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.Equal(int32(300e6), status.DurationUntilReset.Nanos)
+	status = doLimit(1000e9 + 500e6)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+	assert.Equal(int32(500e6), status.DurationUntilReset.Nanos)
+
+	// The burst is restored once the theoretical arrival time has passed.
+	status = doLimit(1090e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(2), status.LimitRemaining)
+
+	assert.Equal(uint64(1), limits[0].Stats.OverLimit.Value())
+	assert.Equal(uint64(5), limits[0].Stats.WithinLimit.Value())
+}
+
+func TestRedisGcraBackend(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	client := mock_redis.NewMockClient(controller)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	cache := redis.NewGcraRateLimitCacheImpl(
+		redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, nil, 0.8, "", sm, false))
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, sm.NewStats("key_value"), false, false, "", nil, false)}
+
+	// A fixed window limit is applied with GCRA and a burst of one unit.
+	timeSource.EXPECT().UnixNow().Return(int64(1234)).AnyTimes()
+	timeSource.EXPECT().UnixNanoNow().Return(int64(1234500000000))
+	client.EXPECT().DoCmd(gomock.Any(), "EVAL", gomock.Any(), 1, "domain_key_value", uint32(10), float64(100000), int64(1234500000), uint64(1)).
+		SetArg(0, []int64{1, 9, 0}).Return(nil)
+
+	assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 9, DurationUntilReset: &durationpb.Duration{}},
+		},
+		cache.DoLimit(context.Background(), request, limits))
+	assert.Equal(uint64(1), limits[0].Stats.WithinLimit.Value())
+	// The configured limit is left untouched.
+	assert.Equal(config.FixedWindow, limits[0].Algorithm)
+	assert.Equal(uint32(0), limits[0].Burst)
+}
+
+func TestRedisConcurrencyScript(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, nil, 0.8, "", sm, false)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].Algorithm = config.Concurrency
+
+	doLimit := func(nowNanos int64) *pb.RateLimitResponse_DescriptorStatus {
+		timeSource.EXPECT().UnixNow().Return(nowNanos / 1e9).AnyTimes()
+		timeSource.EXPECT().UnixNanoNow().Return(nowNanos)
+		return cache.DoLimit(context.Background(), request, limits)[0]
+	}
+	release := func(nowNanos int64) *pb.RateLimitResponse_DescriptorStatus {
+		timeSource.EXPECT().UnixNow().Return(nowNanos / 1e9).AnyTimes()
+		timeSource.EXPECT().UnixNanoNow().Return(nowNanos)
+		return cache.Release(context.Background(), request, limits)[0]
+	}
+
+	status := doLimit(1000e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(1), status.LimitRemaining)
+	status = doLimit(1010e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+	// The first lease expires after a minute.
+	assert.Equal(int64(50), status.DurationUntilReset.Seconds)
+
+	status = doLimit(1020e9)
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.Equal(int64(40), status.DurationUntilReset.Seconds)
+
+	// A released slot can be acquired again.
+	status = release(1030e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(1), status.LimitRemaining)
+	status = doLimit(1040e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+
+	// The slots of lost releases are freed once their leases expire.
+	status = doLimit(1090e9)
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+
+	// Releasing more slots than are held frees all slots.
+	release(1091e9)
+	status = release(1092e9)
+	assert.Equal(uint32(2), status.LimitRemaining)
+
+	assert.Equal(uint64(5), limits[0].Stats.TotalHits.Value())
+	assert.Equal(uint64(1), limits[0].Stats.OverLimit.Value())
+	assert.Equal(uint64(4), limits[0].Stats.WithinLimit.Value())
+}
+
+func TestRedisConcurrencyShadowMode(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, nil, 0.8, "", sm, false)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 2)
+	limits := []*config.RateLimit{config.NewRateLimit(1, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, true, "", nil, false)}
+	limits[0].Algorithm = config.Concurrency
+
+	// Slots are acquired over the limit, so that they can be released.
+	timeSource.EXPECT().UnixNow().Return(int64(1000)).AnyTimes()
+	timeSource.EXPECT().UnixNanoNow().Return(int64(1000e9)).Times(2)
+	status := cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+	assert.Equal(uint64(2), limits[0].Stats.ShadowMode.Value())
+	assert.Equal(int64(2), func() int64 {
+		members, _ := redisSrv.ZMembers("domain_key_value")
+		return int64(len(members))
+	}())
+
+	status = cache.Release(context.Background(), request, limits)[0]
+	assert.Equal(uint32(1), status.LimitRemaining)
+}
+
+func TestRedisRefund(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	localCache := freecache.NewCache(100)
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, localCache, 0.8, "", sm, false)
+
+	timeSource.EXPECT().UnixNow().Return(int64(1000)).AnyTimes()
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 2)
+	limits := []*config.RateLimit{config.NewRateLimit(3, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+
+	cache.DoLimit(context.Background(), request, limits)
+	status := cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	_, err := localCache.Get([]byte("domain_key_value_960"))
+	assert.Nil(err)
+
+	// The refund decrements the counter and evicts the key from the local cache.
+	status = cache.Refund(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(1), status.LimitRemaining)
+	assert.Equal(int64(20), status.DurationUntilReset.Seconds)
+	value, _ := redisSrv.Get("domain_key_value_960")
+	assert.Equal("2", value)
+	assert.Equal(0, int(localCache.EntryCount()))
+
+	// The counter does not drop below zero.
+	cache.Refund(context.Background(), request, limits)
+	status = cache.Refund(context.Background(), request, limits)[0]
+	assert.Equal(uint32(3), status.LimitRemaining)
+	value, _ = redisSrv.Get("domain_key_value_960")
+	assert.Equal("0", value)
+	assert.Equal(uint64(4), limits[0].Stats.TotalHits.Value())
+
+	// Limits which do not count hits in windows are skipped.
+	limits[0].Algorithm = config.TokenBucket
+	assert.Nil(cache.Refund(context.Background(), request, limits)[0])
+}
+
+func TestRedisGetQuotaStatus(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, nil, 0.8, "", sm, false)
+
+	timeSource.EXPECT().UnixNow().Return(int64(1000)).AnyTimes()
+	timeSource.EXPECT().UnixNanoNow().Return(int64(1000e9)).AnyTimes()
+	request := common.NewRateLimitRequest("domain", [][][2]string{
+		{{"key", "value"}}, {{"key2", "value2"}}, {{"key3", "value3"}}, {{"key4", "value4"}}, {{"key5", "value5"}},
+	}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(3, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key2_value2"), false, false, "", nil, false),
+		nil,
+		config.NewRateLimit(5, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key4_value4"), false, false, "", nil, false),
+		config.NewRateLimit(2, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key5_value5"), false, false, "", nil, false),
+	}
+	limits[1].Algorithm = config.TokenBucket
+	limits[1].Burst = 10
+	limits[3].Algorithm = config.GCRA
+	limits[3].Burst = 5
+	limits[4].Algorithm = config.Concurrency
+
+	// Unused limits have their whole quota, and reading it does not create their keys.
+	statuses := cache.GetQuotaStatus(context.Background(), request, limits)
+	assert.Equal(uint64(0), statuses[0].Count)
+	assert.Equal(uint32(3), statuses[0].Status.LimitRemaining)
+	assert.Equal(uint64(0), statuses[1].Count)
+	assert.Equal(uint32(10), statuses[1].Status.LimitRemaining)
+	assert.Nil(statuses[2])
+	assert.Equal(uint64(0), statuses[3].Count)
+	assert.Equal(uint32(5), statuses[3].Status.LimitRemaining)
+	assert.Equal(uint64(0), statuses[4].Count)
+	assert.Equal(uint32(2), statuses[4].Status.LimitRemaining)
+	assert.Empty(redisSrv.Keys())
+
+	cache.DoLimit(context.Background(), request, limits)
+	cache.DoLimit(context.Background(), request, limits)
+	cache.DoLimit(context.Background(), request, limits)
+	dump := redisSrv.Dump()
+	statuses = cache.GetQuotaStatus(context.Background(), request, limits)
+	assert.Equal(dump, redisSrv.Dump())
+	assert.Equal(uint64(3), statuses[0].Count)
+	assert.Equal(
+		&pb.RateLimitResponse_DescriptorStatus{
+			Code:               pb.RateLimitResponse_OVER_LIMIT,
+			CurrentLimit:       limits[0].Limit,
+			LimitRemaining:     0,
+			DurationUntilReset: &durationpb.Duration{Seconds: 20},
+		},
+		statuses[0].Status)
+	assert.Equal(uint64(3), statuses[1].Count)
+	assert.Equal(pb.RateLimitResponse_OK, statuses[1].Status.Code)
+	assert.Equal(uint32(7), statuses[1].Status.LimitRemaining)
+	assert.Equal(uint64(3), statuses[3].Count)
+	assert.Equal(uint32(2), statuses[3].Status.LimitRemaining)
+	assert.Equal(&durationpb.Duration{}, statuses[3].Status.DurationUntilReset)
+	assert.Equal(
+		&pb.RateLimitResponse_DescriptorStatus{
+			Code:               pb.RateLimitResponse_OVER_LIMIT,
+			CurrentLimit:       limits[4].Limit,
+			LimitRemaining:     0,
+			DurationUntilReset: &durationpb.Duration{Seconds: 60},
+		},
+		statuses[4].Status)
+
+	// Reading the quota does not count any hits.
+	value, _ := redisSrv.Get("domain_key_value_960")
+	assert.Equal("3", value)
+	assert.Equal(uint64(3), limits[0].Stats.TotalHits.Value())
+	assert.Equal(uint64(3), limits[1].Stats.TotalHits.Value())
+	assert.Equal(uint64(3), limits[1].Stats.WithinLimit.Value())
+}
+
+func TestRedisSetCounters(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	localCache := freecache.NewCache(100)
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, localCache, 0.8, "", sm, false)
+
+	timeSource.EXPECT().UnixNow().Return(int64(1000)).AnyTimes()
+	timeSource.EXPECT().UnixNanoNow().Return(int64(1000e9)).AnyTimes()
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}, {{"key2", "value2"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(3, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key2_value2"), false, false, "", nil, false),
+	}
+	limits[1].Algorithm = config.TokenBucket
+	limits[1].Burst = 10
+
+	for i := 0; i < 4; i++ {
+		cache.DoLimit(context.Background(), request, limits)
+	}
+	assert.Equal(1, int(localCache.EntryCount()))
+
+	// Only the fixed window limit is set, and its key is evicted from the local cache.
+	statuses := cache.SetCounters(context.Background(), request, limits, 1)
+	assert.Equal(uint64(1), statuses[0].Count)
+	assert.Equal(uint32(2), statuses[0].Status.LimitRemaining)
+	assert.Nil(statuses[1])
+	assert.Equal(0, int(localCache.EntryCount()))
+	value, _ := redisSrv.Get("domain_key_value_960")
+	assert.Equal("1", value)
+	assert.Equal(60, int(redisSrv.TTL("domain_key_value_960").Seconds()))
+
+	responses := cache.DoLimit(context.Background(), request, limits)
+	assert.Equal(pb.RateLimitResponse_OK, responses[0].Code)
+	assert.Equal(uint32(1), responses[0].LimitRemaining)
+	assert.Equal(pb.RateLimitResponse_OK, responses[1].Code)
+	assert.Equal(uint32(5), responses[1].LimitRemaining)
+
+	// Resetting removes the state of all limits.
+	statuses = cache.SetCounters(context.Background(), request, limits, 0)
+	assert.Equal(uint64(0), statuses[0].Count)
+	assert.Equal(uint32(3), statuses[0].Status.LimitRemaining)
+	assert.Equal(uint64(0), statuses[1].Count)
+	assert.Equal(uint32(10), statuses[1].Status.LimitRemaining)
+	assert.False(redisSrv.Exists("domain_key_value_960"))
+}
+
+func TestRedisLease(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	timeSource.EXPECT().UnixNow().Return(int64(1000)).AnyTimes()
+	// Each cache is an instance with its own local cache.
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, freecache.NewCache(1024*1024), 0.8, "", sm, false)
+	otherCache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, freecache.NewCache(1024*1024), 0.8, "", sm, false)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].LeaseRatio = 0.1
+	count := func() string {
+		value, _ := redisSrv.Get("domain_key_value_960")
+		return value
+	}
+
+	// The first hit leases a batch of 10 hits along with its own hit, and the following hits are served locally.
+	status := cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(99), status.LimitRemaining)
+	assert.Equal("11", count())
+	for i := 0; i < 10; i++ {
+		status = cache.DoLimit(context.Background(), request, limits)[0]
+	}
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(89), status.LimitRemaining)
+	assert.Equal("11", count())
+	status = cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(uint32(88), status.LimitRemaining)
+	assert.Equal("22", count())
+	assert.EqualValues(2, statsStore.NewCounter("key_value.lease_acquired").Value())
+	assert.EqualValues(10, statsStore.NewCounter("key_value.lease_hits").Value())
+	assert.EqualValues(12, statsStore.NewCounter("key_value.within_limit").Value())
+
+	// Leased hits over the limit are returned, so that the count reaches the limit exactly.
+	redisSrv.Set("domain_key_value_960", "95")
+	status = otherCache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(4), status.LimitRemaining)
+	assert.Equal("100", count())
+	assert.EqualValues(6, statsStore.NewCounter("key_value.lease_returned").Value())
+	for i := 0; i < 4; i++ {
+		status = otherCache.DoLimit(context.Background(), request, limits)[0]
+		assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	}
+	status = otherCache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.Equal("100", count())
+	assert.EqualValues(17, statsStore.NewCounter("key_value.lease_returned").Value())
+
+	// Keys over the limit are then rejected locally, and setting the counter removes the lease.
+	status = otherCache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OVER_LIMIT, status.Code)
+	assert.EqualValues(1, statsStore.NewCounter("key_value.over_limit_with_local_cache").Value())
+	cache.SetCounters(context.Background(), request, limits, 0)
+	cache.DoLimit(context.Background(), request, limits)
+	assert.Equal("11", count())
+}
+
+func TestRedisLeaseReturnError(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	sm := stats.NewMockStatManager(statsStore)
+	client := mock_redis.NewMockClient(controller)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	timeSource.EXPECT().UnixNow().Return(int64(1000)).AnyTimes()
+	cache := redis.NewFixedRateLimitCacheImpl(client, nil, timeSource, rand.New(rand.NewSource(1)), 0, freecache.NewCache(1024*1024), 0.8, "", sm, false)
+
+	request := common.NewRateLimitRequest("domain", [][][2]string{{{"key", "value"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, sm.NewStats("key_value"), false, false, "", nil, false)}
+	limits[0].LeaseRatio = 0.1
+
+	// The hit leases a batch of one hit which the limit can't grant, and failing to return it still answers the request.
+	client.EXPECT().PipeAppend(gomock.Any(), gomock.Any(), "INCRBY", "domain_key_value_960", uint64(2)).SetArg(1, uint64(11)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeAppend(gomock.Any(), gomock.Any(), "EXPIRE", "domain_key_value_960", int64(60)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeDo(gomock.Any()).Return(nil)
+	client.EXPECT().PipeAppend(gomock.Any(), nil, "DECRBY", "domain_key_value_960", uint64(1)).DoAndReturn(pipeAppend)
+	client.EXPECT().PipeDo(gomock.Any()).Return(errors.New("connection refused"))
+
+	status := cache.DoLimit(context.Background(), request, limits)[0]
+	assert.Equal(pb.RateLimitResponse_OK, status.Code)
+	assert.Equal(uint32(0), status.LimitRemaining)
+	assert.EqualValues(0, statsStore.NewCounter("key_value.lease_returned").Value())
+	assert.EqualValues(1, statsStore.NewCounter("key_value.lease_return_error").Value())
+}
@@ -0,0 +1,47 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
+	gostats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/redis"
+)
+
+func TestLimitStore(t *testing.T) {
+	assert := assert.New(t)
+
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+	redisSrv.HSet("ratelimit:limits", "gold", "1000/hour")
+	redisSrv.HSet("ratelimit:limits", "silver", "100")
+	redisSrv.HSet("ratelimit:limits", "broken", "many")
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	store := redis.NewLimitStore(client, "ratelimit:limits", 10*time.Second, 512*1024, statsStore.Scope("limit_store"))
+
+	assert.Equal(&config.StoredLimit{RequestsPerUnit: 1000, Unit: pb.RateLimitResponse_RateLimit_HOUR}, store.Get("gold"))
+	assert.Equal(&config.StoredLimit{RequestsPerUnit: 100}, store.Get("silver"))
+	assert.Nil(store.Get("bronze"))
+	assert.Nil(store.Get("broken"))
+	assert.EqualValues(4, statsStore.NewCounter("limit_store.cache_miss").Value())
+	assert.EqualValues(1, statsStore.NewCounter("limit_store.not_found").Value())
+	assert.EqualValues(1, statsStore.NewCounter("limit_store.invalid").Value())
+
+	// Values, including values without a limit, are read from the local cache until it expires.
+	redisSrv.HSet("ratelimit:limits", "gold", "10/hour")
+	redisSrv.HSet("ratelimit:limits", "bronze", "10")
+	assert.Equal(&config.StoredLimit{RequestsPerUnit: 1000, Unit: pb.RateLimitResponse_RateLimit_HOUR}, store.Get("gold"))
+	assert.Nil(store.Get("bronze"))
+	assert.EqualValues(2, statsStore.NewCounter("limit_store.cache_hit").Value())
+
+	// Errors are not cached, and the configured limit applies meanwhile.
+	redisSrv.Close()
+	assert.Nil(store.Get("platinum"))
+	assert.EqualValues(1, statsStore.NewCounter("limit_store.error").Value())
+}
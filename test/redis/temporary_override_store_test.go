@@ -0,0 +1,63 @@
+package redis_test
+
+import (
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	gostats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/redis"
+	mock_utils "github.com/envoyproxy/ratelimit/test/mocks/utils"
+)
+
+func TestTemporaryOverrideStore(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	redisSrv := mustNewRedisServer()
+	defer redisSrv.Close()
+
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	client := redis.NewClientImpl(statsStore, false, "", "tcp", "single", redisSrv.Addr(), 1, 0, 0, 0, nil, false, nil)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	now := int64(1000)
+	timeSource.EXPECT().UnixNow().DoAndReturn(func() int64 { return now }).AnyTimes()
+
+	store, closer := redis.NewTemporaryOverrideStore(client, "prefix_", timeSource, 0, statsStore.Scope("temporary_overrides"))
+	defer closer.Close()
+	assert.Empty(store.List())
+
+	override := &config.TemporaryOverride{Key: "domain.key_value", Unit: "minute", RequestsPerUnit: 5, ExpiresAt: 1600}
+	assert.Nil(store.Set(override))
+	assert.Nil(store.Set(&config.TemporaryOverride{Key: "domain.key2", Unit: "hour", RequestsPerUnit: 50, ExpiresAt: 1100}))
+	assert.Error(store.Set(&config.TemporaryOverride{Key: "domain.key3", Unit: "hour", RequestsPerUnit: 50}))
+	assert.Equal(override, store.Get("domain.key_value"))
+	assert.Nil(store.Get("domain.key3"))
+	assert.EqualValues(2, statsStore.NewGauge("temporary_overrides.active").Value())
+	assert.True(redisSrv.Exists("prefix_ratelimit_temporary_overrides"))
+
+	// Other instances load the overrides, and expired overrides are removed.
+	now = 1200
+	otherStore, otherCloser := redis.NewTemporaryOverrideStore(client, "prefix_", timeSource, 0, statsStore.Scope("other_temporary_overrides"))
+	defer otherCloser.Close()
+	assert.Equal([]*config.TemporaryOverride{override}, otherStore.List())
+	assert.Equal([]*config.TemporaryOverride{override}, store.List())
+	assert.Nil(store.Get("domain.key2"))
+	assert.EqualValues(1, statsStore.NewCounter("other_temporary_overrides.expired").Value())
+	assert.EqualValues(1, statsStore.NewGauge("other_temporary_overrides.active").Value())
+	keys, _ := redisSrv.HKeys("prefix_ratelimit_temporary_overrides")
+	assert.Equal([]string{"domain.key_value"}, keys)
+
+	assert.Nil(otherStore.Delete("domain.key_value"))
+	assert.Nil(otherStore.Get("domain.key_value"))
+	assert.False(redisSrv.Exists("prefix_ratelimit_temporary_overrides"))
+	assert.EqualValues(1, statsStore.NewCounter("other_temporary_overrides.deleted").Value())
+
+	// Overrides cannot be changed without redis, but the loaded overrides remain.
+	redisSrv.SetError("unavailable")
+	assert.Error(store.Set(override))
+	assert.Equal(override, store.Get("domain.key_value"))
+}
@@ -212,3 +212,30 @@ func TestGrpcHealthyWithAtLeastOneConfigLoaded(t *testing.T) {
 		t.Errorf("expected status NOT_SERVING actual %v", res.Status)
 	}
 }
+
+func TestHealthCheckCircuitBreaker(t *testing.T) {
+	defer signal.Reset(syscall.SIGTERM)
+
+	hc := server.NewHealthChecker(health.NewServer(), "ratelimit", false)
+	status := func() int {
+		recorder := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "http://1.2.3.4/healthcheck", nil)
+		hc.ServeHTTP(recorder, r)
+		return recorder.Code
+	}
+
+	// The instance is unhealthy until all open breakers closed again.
+	hc.CircuitBreakerOpenChanged(true)
+	hc.CircuitBreakerOpenChanged(true)
+	if code := status(); code != 500 {
+		t.Errorf("expected code 500 actual %d", code)
+	}
+	hc.CircuitBreakerOpenChanged(false)
+	if code := status(); code != 500 {
+		t.Errorf("expected code 500 actual %d", code)
+	}
+	hc.CircuitBreakerOpenChanged(false)
+	if code := status(); code != 200 {
+		t.Errorf("expected code 200 actual %d", code)
+	}
+}
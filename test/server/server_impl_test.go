@@ -17,8 +17,11 @@ import (
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/envoyproxy/ratelimit/src/config"
 	"github.com/envoyproxy/ratelimit/src/server"
+	mock_config "github.com/envoyproxy/ratelimit/test/mocks/config"
 	mock_v3 "github.com/envoyproxy/ratelimit/test/mocks/rls"
+	mock_utils "github.com/envoyproxy/ratelimit/test/mocks/utils"
 )
 
 func assertHttpResponse(t *testing.T,
@@ -84,3 +87,85 @@ func TestJsonHandler(t *testing.T) {
 	}, nil)
 	assertHttpResponse(t, handler, `{"domain": "foo"}`, 429, "application/json", `{"overallCode":"OVER_LIMIT"}`)
 }
+
+func TestAdminJsonRequestHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	calls := 0
+	handler := server.NewAdminJsonRequestHandler("secret",
+		func(ctx context.Context, request *pb.RateLimitRequest) (*pb.RateLimitResponse, error) {
+			calls++
+			return &pb.RateLimitResponse{OverallCode: pb.RateLimitResponse_OK}, nil
+		})
+
+	serve := func(method string, authorization string) *http.Response {
+		req := httptest.NewRequest(method, "/admin/counters/reset", strings.NewReader(`{"domain": "foo"}`))
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Result()
+	}
+
+	assert.Equal(http.StatusMethodNotAllowed, serve(http.MethodGet, "Bearer secret").StatusCode)
+	assert.Equal(http.StatusUnauthorized, serve(http.MethodPost, "").StatusCode)
+	assert.Equal(http.StatusUnauthorized, serve(http.MethodPost, "Bearer wrong").StatusCode)
+	assert.Equal(0, calls)
+
+	resp := serve(http.MethodPost, "Bearer secret")
+	body, _ := io.ReadAll(resp.Body)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal(`{"overallCode":"OK"}`, string(body))
+	assert.Equal(1, calls)
+}
+
+func TestTemporaryOverridesHandler(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+
+	store := mock_config.NewMockTemporaryOverrideStore(controller)
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	timeSource.EXPECT().UnixNow().Return(int64(1000)).AnyTimes()
+	handler := server.NewTemporaryOverridesHandler(store, timeSource)
+
+	serve := func(method string, target string, body string) (int, string) {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		resp := w.Result()
+		respBody, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(respBody)
+	}
+
+	override := &config.TemporaryOverride{Key: "domain.key_value", Unit: "minute", RequestsPerUnit: 5, ExpiresAt: 1600}
+	store.EXPECT().Set(override).Return(nil)
+	code, body := serve(http.MethodPost, "/admin/overrides",
+		`{"key": "domain.key_value", "unit": "minute", "requests_per_unit": 5, "ttl_seconds": 600}`)
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(`{"key":"domain.key_value","unit":"minute","requests_per_unit":5,"expires_at":1600}`, body)
+
+	store.EXPECT().List().Return([]*config.TemporaryOverride{override})
+	code, body = serve(http.MethodGet, "/admin/overrides", "")
+	assert.Equal(http.StatusOK, code)
+	assert.Equal(`[{"key":"domain.key_value","unit":"minute","requests_per_unit":5,"expires_at":1600}]`, body)
+
+	store.EXPECT().Delete("domain.key_value").Return(nil)
+	code, _ = serve(http.MethodDelete, "/admin/overrides?key=domain.key_value", "")
+	assert.Equal(http.StatusOK, code)
+
+	// Invalid requests do not reach the store.
+	code, _ = serve(http.MethodPost, "/admin/overrides", `{"key": "domain.key_value", "unit": "minute", "requests_per_unit": 5}`)
+	assert.Equal(http.StatusBadRequest, code)
+	code, body = serve(http.MethodPost, "/admin/overrides", `{"key": "domain.key_value", "unit": "week2", "ttl_seconds": 60}`)
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Equal("invalid rate limit unit 'week2'\n", body)
+	code, _ = serve(http.MethodDelete, "/admin/overrides", "")
+	assert.Equal(http.StatusBadRequest, code)
+	code, _ = serve(http.MethodPut, "/admin/overrides", "")
+	assert.Equal(http.StatusMethodNotAllowed, code)
+
+	store.EXPECT().Set(gomock.Any()).Return(fmt.Errorf("unavailable"))
+	code, _ = serve(http.MethodPost, "/admin/overrides", `{"key": "domain.key", "unit": "hour", "requests_per_unit": 5, "ttl_seconds": 60}`)
+	assert.Equal(http.StatusInternalServerError, code)
+}
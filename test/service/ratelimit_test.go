@@ -7,6 +7,7 @@ import (
 	"sync"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/envoyproxy/ratelimit/src/provider"
 	"github.com/envoyproxy/ratelimit/src/stats"
@@ -14,18 +15,27 @@ import (
 	"github.com/envoyproxy/ratelimit/src/utils"
 
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	pb_struct "github.com/envoyproxy/go-control-plane/envoy/extensions/common/ratelimit/v3"
 	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	"github.com/golang/mock/gomock"
 	gostats "github.com/lyft/gostats"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/envoyproxy/ratelimit/src/trace"
 
+	"github.com/envoyproxy/ratelimit/src/boltdb"
 	"github.com/envoyproxy/ratelimit/src/config"
+	"github.com/envoyproxy/ratelimit/src/limiter"
+	"github.com/envoyproxy/ratelimit/src/memcached"
 	"github.com/envoyproxy/ratelimit/src/redis"
 	server "github.com/envoyproxy/ratelimit/src/server"
 	ratelimit "github.com/envoyproxy/ratelimit/src/service"
@@ -71,6 +81,9 @@ type rateLimitServiceTestSuite struct {
 	configUpdateEventChan chan provider.ConfigUpdateEvent
 	configUpdateEvent     *mock_provider.MockConfigUpdateEvent
 	config                *mock_config.MockRateLimitConfig
+	temporaryOverrides    config.TemporaryOverrideStore
+	limitStore            config.LimitStore
+	localLimiter          *limiter.LocalLimiter
 	health                *server.HealthChecker
 	statsManager          stats.Manager
 	statStore             gostats.Store
@@ -83,6 +96,8 @@ type MockClock struct {
 
 func (c MockClock) UnixNow() int64 { return c.now }
 
+func (c MockClock) UnixNanoNow() int64 { return c.now * 1e9 }
+
 func commonSetup(t *testing.T) rateLimitServiceTestSuite {
 	ret := rateLimitServiceTestSuite{}
 	ret.assert = assert.New(t)
@@ -111,7 +126,7 @@ func (this *rateLimitServiceTestSuite) setupBasicService() ratelimit.RateLimitSe
 
 	testSpanExporter.Reset()
 
-	svc := ratelimit.NewService(this.cache, this.configProvider, this.statsManager, this.health, MockClock{now: int64(2222)}, false, false, false)
+	svc := ratelimit.NewService(this.cache, this.temporaryOverrides, this.limitStore, this.localLimiter, this.configProvider, this.statsManager, this.health, MockClock{now: int64(2222)}, false, false, false)
 	barrier.wait() // wait for initial config load
 	return svc
 }
@@ -457,6 +472,57 @@ func TestServiceWithDefaultRatelimitHeaders(test *testing.T) {
 	t.assert.Nil(err)
 }
 
+func TestServiceRatelimitHeadersWithUnitMultiplier(test *testing.T) {
+	os.Setenv("LIMIT_RESPONSE_HEADERS_ENABLED", "true")
+	defer func() {
+		os.Unsetenv("LIMIT_RESPONSE_HEADERS_ENABLED")
+	}()
+
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	// Config reload.
+	barrier := newBarrier()
+	t.configUpdateEvent.EXPECT().GetConfig().DoAndReturn(func() (config.RateLimitConfig, any) {
+		barrier.signal()
+		return t.config, nil
+	})
+	t.configUpdateEventChan <- t.configUpdateEvent
+	barrier.wait()
+
+	// Make request
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"foo", "bar"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("key"), false, false, "", nil, false),
+	}
+	limits[0].UnitMultiplier = 15
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.cache.EXPECT().DoLimit(context.Background(), request, limits).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 40},
+		})
+
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	common.AssertProtoEqual(
+		t.assert,
+		&pb.RateLimitResponse{
+			OverallCode: pb.RateLimitResponse_OK,
+			Statuses: []*pb.RateLimitResponse_DescriptorStatus{
+				{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 40},
+			},
+			ResponseHeadersToAdd: []*core.HeaderValue{
+				{Key: "RateLimit-Limit", Value: "100, 100;w=900"},
+				{Key: "RateLimit-Remaining", Value: "40"},
+				// The 15 minute window ends at 2700.
+				{Key: "RateLimit-Reset", Value: "478"},
+			},
+		},
+		response)
+	t.assert.Nil(err)
+}
+
 func TestEmptyDomain(test *testing.T) {
 	t := commonSetup(test)
 	defer t.controller.Finish()
@@ -511,7 +577,7 @@ func TestInitialLoadError(test *testing.T) {
 		return nil, config.RateLimitConfigError("load error")
 	})
 	go func() { t.configUpdateEventChan <- t.configUpdateEvent }() // initial config update from provider
-	service := ratelimit.NewService(t.cache, t.configProvider, t.statsManager, t.health, t.mockClock, false, false, false)
+	service := ratelimit.NewService(t.cache, nil, nil, nil, t.configProvider, t.statsManager, t.health, t.mockClock, false, false, false)
 	barrier.wait()
 
 	request := common.NewRateLimitRequest("test-domain", [][][2]string{{{"hello", "world"}}}, 1)
@@ -600,7 +666,7 @@ func TestServiceHealthStatus(test *testing.T) {
 
 	// Set up the service
 	t.configProvider.EXPECT().ConfigUpdateEvent().Return(t.configUpdateEventChan).Times(1)
-	_ = ratelimit.NewService(t.cache, t.configProvider, t.statsManager, hc, MockClock{now: int64(2222)}, false, true, healthyWithAtLeastOneConfigLoaded)
+	_ = ratelimit.NewService(t.cache, nil, nil, nil, t.configProvider, t.statsManager, hc, MockClock{now: int64(2222)}, false, true, healthyWithAtLeastOneConfigLoaded)
 
 	// Health check request
 	req := &healthpb.HealthCheckRequest{
@@ -629,7 +695,7 @@ func TestServiceHealthStatusAtLeastOneConfigLoaded(test *testing.T) {
 	t.configUpdateEvent.EXPECT().GetConfig().DoAndReturn(func() (config.RateLimitConfig, any) {
 		return t.config, nil
 	}).Times(2)
-	service := ratelimit.NewService(t.cache, t.configProvider, t.statsManager, hc, MockClock{now: int64(2222)}, false, true, healthyWithAtLeastOneConfigLoaded)
+	service := ratelimit.NewService(t.cache, nil, nil, nil, t.configProvider, t.statsManager, hc, MockClock{now: int64(2222)}, false, true, healthyWithAtLeastOneConfigLoaded)
 	// Health check request
 	req := &healthpb.HealthCheckRequest{
 		Service: "ratelimit",
@@ -665,3 +731,796 @@ func TestServiceHealthStatusAtLeastOneConfigLoaded(test *testing.T) {
 		test.Errorf("expected status NOT_SERVING actual %v", res.Status)
 	}
 }
+
+func TestServiceReleasesSlotsOverLimit(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"foo", "bar"}}, {{"hello", "world"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("key"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("key"), false, false, "", nil, false),
+	}
+	limits[0].Algorithm = config.Concurrency
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[1]).Return(limits[1])
+	t.cache.EXPECT().DoLimit(context.Background(), request, limits).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 9},
+			{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[1].Limit, LimitRemaining: 0},
+		})
+	// The slot acquired for the rejected request is released.
+	t.cache.EXPECT().Release(context.Background(), request, []*config.RateLimit{limits[0], nil}).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 10},
+			nil,
+		})
+
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	t.assert.Equal(
+		&pb.RateLimitResponse{
+			OverallCode: pb.RateLimitResponse_OVER_LIMIT,
+			Statuses: []*pb.RateLimitResponse_DescriptorStatus{
+				{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 9},
+				{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[1].Limit, LimitRemaining: 0},
+			},
+		},
+		response)
+	t.assert.Nil(err)
+}
+
+func TestServiceRelease(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"foo", "bar"}}, {{"hello", "world"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("key"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("key"), false, false, "", nil, false),
+	}
+	limits[1].Algorithm = config.Concurrency
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[1]).Return(limits[1])
+	// Only concurrency limits are released.
+	t.cache.EXPECT().Release(context.Background(), request, []*config.RateLimit{nil, limits[1]}).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			nil,
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 10},
+		})
+
+	response, err := service.Release(context.Background(), request)
+	t.assert.Equal(
+		&pb.RateLimitResponse{
+			OverallCode: pb.RateLimitResponse_OK,
+			Statuses: []*pb.RateLimitResponse_DescriptorStatus{
+				{Code: pb.RateLimitResponse_OK},
+				{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 10},
+			},
+		},
+		response)
+	t.assert.Nil(err)
+
+	request = common.NewRateLimitRequest("", [][][2]string{{{"hello", "world"}}}, 1)
+	response, err = service.Release(context.Background(), request)
+	t.assert.Nil(response)
+	t.assert.Equal("rate limit domain must not be empty", err.Error())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.release.service_error").Value())
+}
+
+func TestServiceMultipleLimits(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"foo", "bar"}}, {{"hello", "world"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, t.statsManager.NewStats("foo_bar"), false, false, "", nil, false),
+		config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_HOUR, t.statsManager.NewStats("foo_bar.per_hour"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("hello_world"), false, false, "", nil, false),
+	}
+	limits[1].KeySuffix = "per_hour"
+	limits[0].AdditionalLimits = []*config.RateLimit{limits[1]}
+
+	// Each limit is checked with its own copy of the descriptor.
+	flattenedRequest := &pb.RateLimitRequest{
+		Domain:      "different-domain",
+		Descriptors: []*pb_struct.RateLimitDescriptor{request.Descriptors[0], request.Descriptors[0], request.Descriptors[1]},
+		HitsAddend:  1,
+	}
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0]).Times(2)
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[1]).Return(limits[2]).Times(2)
+	t.cache.EXPECT().DoLimit(context.Background(), flattenedRequest, limits).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 9},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 2},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[2].Limit, LimitRemaining: 5},
+		})
+
+	// The status of the limit with the fewest remaining requests is returned.
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	t.assert.Equal(
+		&pb.RateLimitResponse{
+			OverallCode: pb.RateLimitResponse_OK,
+			Statuses: []*pb.RateLimitResponse_DescriptorStatus{
+				{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 2},
+				{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[2].Limit, LimitRemaining: 5},
+			},
+		},
+		response)
+	t.assert.Nil(err)
+
+	// A limit over the limit is more restrictive than any limit within the limit.
+	t.cache.EXPECT().DoLimit(context.Background(), flattenedRequest, limits).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[0].Limit, LimitRemaining: 0},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 0},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[2].Limit, LimitRemaining: 4},
+		})
+	response, err = service.ShouldRateLimit(context.Background(), request)
+	t.assert.Equal(
+		&pb.RateLimitResponse{
+			OverallCode: pb.RateLimitResponse_OVER_LIMIT,
+			Statuses: []*pb.RateLimitResponse_DescriptorStatus{
+				{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[0].Limit, LimitRemaining: 0},
+				{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[2].Limit, LimitRemaining: 4},
+			},
+		},
+		response)
+	t.assert.Nil(err)
+}
+
+func TestServiceMultipleLimitsReplaced(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"foo", "bar"}}, {{"hello", "world"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_SECOND, t.statsManager.NewStats("foo_bar"), false, false, "", nil, false),
+		config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_HOUR, t.statsManager.NewStats("foo_bar.per_hour"), false, false, "hourly", nil, false),
+		config.NewRateLimit(1000, pb.RateLimitResponse_RateLimit_HOUR, t.statsManager.NewStats("hello_world"), false, false, "", []string{"hourly"}, false),
+	}
+	limits[1].KeySuffix = "per_hour"
+	limits[0].AdditionalLimits = []*config.RateLimit{limits[1]}
+
+	// Only the replaced limit of the descriptor is dropped.
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[1]).Return(limits[2])
+	t.cache.EXPECT().DoLimit(context.Background(), request, []*config.RateLimit{limits[0], limits[2]}).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 9},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[2].Limit, LimitRemaining: 999},
+		})
+
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	t.assert.Equal(
+		&pb.RateLimitResponse{
+			OverallCode: pb.RateLimitResponse_OK,
+			Statuses: []*pb.RateLimitResponse_DescriptorStatus{
+				{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 9},
+				{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[2].Limit, LimitRemaining: 999},
+			},
+		},
+		response)
+	t.assert.Nil(err)
+}
+
+func TestServiceAlsoCountsAgainst(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"tenant", "a"}, {"user", "b"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("tenant.user"), false, false, "", nil, false),
+		config.NewRateLimit(1000, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("tenant"), false, false, "", nil, false),
+	}
+	limits[1].DescriptorDepth = 1
+	limits[0].DescriptorDepth = 2
+	limits[0].AlsoCountsAgainst = []*config.RateLimit{limits[1]}
+
+	// The hit is counted against the limit of the tenant as well.
+	flattenedRequest := &pb.RateLimitRequest{
+		Domain:      "different-domain",
+		Descriptors: []*pb_struct.RateLimitDescriptor{request.Descriptors[0], request.Descriptors[0]},
+		HitsAddend:  1,
+	}
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.cache.EXPECT().DoLimit(context.Background(), flattenedRequest, limits).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 50},
+			{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[1].Limit, LimitRemaining: 0},
+		})
+
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	t.assert.Equal(
+		&pb.RateLimitResponse{
+			OverallCode: pb.RateLimitResponse_OVER_LIMIT,
+			Statuses: []*pb.RateLimitResponse_DescriptorStatus{
+				{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[1].Limit, LimitRemaining: 0},
+			},
+		},
+		response)
+	t.assert.Nil(err)
+}
+
+func TestServiceCost(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"endpoint", "/search"}}, {{"endpoint", "/ping"}}}, 2)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("endpoint_/search"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("endpoint"), false, false, "", nil, false),
+	}
+	limits[0].Cost = 5
+
+	// Only the descriptor with a cost gets a hits addend, which is multiplied with the hits addend of the request.
+	costRequest := &pb.RateLimitRequest{
+		Domain: "different-domain",
+		Descriptors: []*pb_struct.RateLimitDescriptor{
+			{
+				Entries:    request.Descriptors[0].Entries,
+				HitsAddend: &wrapperspb.UInt64Value{Value: 10},
+			},
+			request.Descriptors[1],
+		},
+		HitsAddend: 2,
+	}
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[1]).Return(limits[1])
+	t.cache.EXPECT().DoLimit(context.Background(), costRequest, limits).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[0].Limit, LimitRemaining: 0},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 8},
+		})
+
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	t.assert.Equal(pb.RateLimitResponse_OVER_LIMIT, response.OverallCode)
+	t.assert.Nil(request.Descriptors[0].HitsAddend)
+	t.assert.Nil(err)
+}
+
+func TestServiceRefund(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"foo", "bar"}}, {{"hello", "world"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("key"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("key"), false, false, "", nil, false),
+		config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_HOUR, t.statsManager.NewStats("key"), false, false, "", nil, false),
+	}
+	limits[0].AdditionalLimits = []*config.RateLimit{limits[2]}
+
+	// All limits of a descriptor are refunded.
+	refundRequest := &pb.RateLimitRequest{
+		Domain:      "different-domain",
+		Descriptors: []*pb_struct.RateLimitDescriptor{request.Descriptors[0], request.Descriptors[0], request.Descriptors[1]},
+		HitsAddend:  1,
+	}
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[1]).Return(limits[1])
+	t.cache.EXPECT().Refund(context.Background(), refundRequest, []*config.RateLimit{limits[0], limits[2], limits[1]}).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 5},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[2].Limit, LimitRemaining: 3},
+			nil,
+		})
+
+	response, err := service.Refund(context.Background(), request)
+	t.assert.Equal(
+		&pb.RateLimitResponse{
+			OverallCode: pb.RateLimitResponse_OK,
+			Statuses: []*pb.RateLimitResponse_DescriptorStatus{
+				{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[2].Limit, LimitRemaining: 3},
+				{Code: pb.RateLimitResponse_OK},
+			},
+		},
+		response)
+	t.assert.Nil(err)
+
+	request = common.NewRateLimitRequest("", [][][2]string{{{"hello", "world"}}}, 1)
+	response, err = service.Refund(context.Background(), request)
+	t.assert.Nil(response)
+	t.assert.Equal("rate limit domain must not be empty", err.Error())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.refund.service_error").Value())
+}
+
+func TestServiceGetQuotaStatus(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"foo", "bar"}}, {{"hello", "world"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("foo_bar"), false, false, "", nil, false),
+		config.NewRateLimit(100, pb.RateLimitResponse_RateLimit_HOUR, t.statsManager.NewStats("foo_bar.per_hour"), false, false, "", nil, false),
+	}
+	limits[0].AdditionalLimits = []*config.RateLimit{limits[1]}
+
+	quotaRequest := &pb.RateLimitRequest{
+		Domain:      "different-domain",
+		Descriptors: []*pb_struct.RateLimitDescriptor{request.Descriptors[0], request.Descriptors[0], request.Descriptors[1]},
+		HitsAddend:  1,
+	}
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[1]).Return(nil)
+	t.cache.EXPECT().GetQuotaStatus(context.Background(), quotaRequest, []*config.RateLimit{limits[0], limits[1], nil}).Return(
+		[]*limiter.QuotaStatus{
+			{Count: 8, Status: &pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 2}},
+			{
+				Count: 95,
+				Status: &pb.RateLimitResponse_DescriptorStatus{
+					Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 5,
+					DurationUntilReset: &durationpb.Duration{Seconds: 60},
+				},
+			},
+			nil,
+		})
+
+	response, err := service.GetQuotaStatus(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(pb.RateLimitResponse_OK, response.OverallCode)
+	t.assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 2},
+			{Code: pb.RateLimitResponse_UNKNOWN},
+		},
+		response.Statuses)
+	t.assert.Equal(
+		map[string]interface{}{
+			"quotas": []interface{}{
+				map[string]interface{}{
+					"descriptor_index": 0.0, "key": "foo_bar", "unit": "MINUTE", "requests_per_unit": 10.0,
+					"count": 8.0, "limit_remaining": 2.0,
+				},
+				map[string]interface{}{
+					"descriptor_index": 0.0, "key": "foo_bar.per_hour", "unit": "HOUR", "requests_per_unit": 100.0,
+					"count": 95.0, "limit_remaining": 5.0, "duration_until_reset_seconds": 60.0,
+				},
+			},
+		},
+		response.DynamicMetadata.AsMap())
+
+	request = common.NewRateLimitRequest("", [][][2]string{{{"hello", "world"}}}, 1)
+	response, err = service.GetQuotaStatus(context.Background(), request)
+	t.assert.Nil(response)
+	t.assert.Equal("rate limit domain must not be empty", err.Error())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.get_quota_status.service_error").Value())
+}
+
+func TestServiceSetCounters(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"foo", "bar"}}, {{"hello", "world"}}}, 5)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("foo_bar"), false, false, "", nil, false),
+		config.NewRateLimit(3, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("hello_world"), false, false, "", nil, false),
+	}
+	limits[1].Algorithm = config.Concurrency
+
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0]).Times(2)
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[1]).Return(limits[1]).Times(2)
+
+	// Limits which are not set are reported as unknown.
+	t.cache.EXPECT().SetCounters(context.Background(), request, limits, uint64(5)).Return(
+		[]*limiter.QuotaStatus{
+			{Count: 5, Status: &pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 5}},
+			nil,
+		})
+	response, err := service.SetCounters(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 5},
+			{Code: pb.RateLimitResponse_UNKNOWN},
+		},
+		response.Statuses)
+
+	t.cache.EXPECT().SetCounters(context.Background(), request, limits, uint64(0)).Return(
+		[]*limiter.QuotaStatus{
+			{Count: 0, Status: &pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 10}},
+			{Count: 0, Status: &pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 3}},
+		})
+	response, err = service.ResetCounters(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 10},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 3},
+		},
+		response.Statuses)
+
+	request = common.NewRateLimitRequest("", [][][2]string{{{"hello", "world"}}}, 1)
+	response, err = service.ResetCounters(context.Background(), request)
+	t.assert.Nil(response)
+	t.assert.Equal("rate limit domain must not be empty", err.Error())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.admin.service_error").Value())
+}
+
+func TestAuthenticatedAdminServiceServer(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := ratelimit.NewAuthenticatedAdminServiceServer("secret", t.setupBasicService())
+
+	request := common.NewRateLimitRequest("", [][][2]string{{{"hello", "world"}}}, 1)
+	for _, ctx := range []context.Context{
+		context.Background(),
+		metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "secret")),
+		metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer wrong")),
+	} {
+		response, err := service.ResetCounters(ctx, request)
+		t.assert.Nil(response)
+		t.assert.Equal(codes.Unauthenticated, status.Code(err))
+	}
+	t.assert.EqualValues(0, t.statStore.NewCounter("call.admin.service_error").Value())
+
+	// Authenticated calls reach the service.
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+	_, err := service.ResetCounters(ctx, request)
+	t.assert.Equal("rate limit domain must not be empty", err.Error())
+	_, err = service.SetCounters(ctx, request)
+	t.assert.Equal("rate limit domain must not be empty", err.Error())
+	t.assert.EqualValues(2, t.statStore.NewCounter("call.admin.service_error").Value())
+}
+
+func TestAuthenticatedRefundAndConcurrencyServiceServers(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+	refundService := ratelimit.NewAuthenticatedRefundServiceServer("secret", service)
+	concurrencyService := ratelimit.NewAuthenticatedConcurrencyServiceServer("secret", service)
+
+	request := common.NewRateLimitRequest("", [][][2]string{{{"hello", "world"}}}, 1)
+	for _, ctx := range []context.Context{
+		context.Background(),
+		metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer wrong")),
+	} {
+		response, err := refundService.Refund(ctx, request)
+		t.assert.Nil(response)
+		t.assert.Equal(codes.Unauthenticated, status.Code(err))
+		response, err = concurrencyService.Release(ctx, request)
+		t.assert.Nil(response)
+		t.assert.Equal(codes.Unauthenticated, status.Code(err))
+	}
+	t.assert.EqualValues(0, t.statStore.NewCounter("call.refund.service_error").Value())
+	t.assert.EqualValues(0, t.statStore.NewCounter("call.release.service_error").Value())
+
+	// Authenticated calls reach the service.
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer secret"))
+	_, err := refundService.Refund(ctx, request)
+	t.assert.Equal("rate limit domain must not be empty", err.Error())
+	_, err = concurrencyService.Release(ctx, request)
+	t.assert.Equal("rate limit domain must not be empty", err.Error())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.refund.service_error").Value())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.release.service_error").Value())
+}
+
+func TestServiceTemporaryOverride(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	temporaryOverrides := mock_config.NewMockTemporaryOverrideStore(t.controller)
+	t.temporaryOverrides = temporaryOverrides
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"foo", "bar"}}, {{"hello", "world"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("foo_bar"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("hello_world"), false, false, "", nil, false),
+	}
+
+	// Only the overridden limit is replaced, keeping its stats.
+	override := &config.TemporaryOverride{Key: "foo_bar", Unit: "second", RequestsPerUnit: 1, ExpiresAt: 3000}
+	overridden := limits[0].WithTemporaryOverride(override)
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[1]).Return(limits[1])
+	temporaryOverrides.EXPECT().Get("foo_bar").Return(override)
+	temporaryOverrides.EXPECT().Get("hello_world").Return(nil)
+	t.cache.EXPECT().DoLimit(context.Background(), request, []*config.RateLimit{overridden, limits[1]}).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: overridden.Limit, LimitRemaining: 0},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 9},
+		})
+
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{
+				Code:           pb.RateLimitResponse_OVER_LIMIT,
+				CurrentLimit:   &pb.RateLimitResponse_RateLimit{RequestsPerUnit: 1, Unit: pb.RateLimitResponse_RateLimit_SECOND},
+				LimitRemaining: 0,
+			},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 9},
+		},
+		response.Statuses)
+	t.assert.Equal(uint32(10), limits[0].Limit.RequestsPerUnit)
+}
+
+func TestServiceLimitFromStore(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	limitStore := mock_config.NewMockLimitStore(t.controller)
+	t.limitStore = limitStore
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"api_key", "gold"}}, {{"api_key", "unknown"}}, {{"hello", "world"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("api_key"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("api_key"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("hello_world"), false, false, "", nil, false),
+	}
+	limits[0].LimitFromStore = true
+	limits[1].LimitFromStore = true
+
+	// Values without a stored limit and limits without limit_from_store keep the configured limit.
+	stored := limits[0].WithStoredLimit(&config.StoredLimit{RequestsPerUnit: 1000, Unit: pb.RateLimitResponse_RateLimit_HOUR})
+	for i, limit := range limits {
+		t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[i]).Return(limit)
+	}
+	limitStore.EXPECT().Get("gold").Return(&config.StoredLimit{RequestsPerUnit: 1000, Unit: pb.RateLimitResponse_RateLimit_HOUR})
+	limitStore.EXPECT().Get("unknown").Return(nil)
+	t.cache.EXPECT().DoLimit(context.Background(), request, []*config.RateLimit{stored, limits[1], limits[2]}).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: stored.Limit, LimitRemaining: 999},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 9},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[2].Limit, LimitRemaining: 9},
+		})
+
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(
+		&pb.RateLimitResponse_RateLimit{RequestsPerUnit: 1000, Unit: pb.RateLimitResponse_RateLimit_HOUR},
+		response.Statuses[0].CurrentLimit)
+	t.assert.Equal(uint32(10), limits[0].Limit.RequestsPerUnit)
+}
+
+func TestServiceFailureMode(test *testing.T) {
+	os.Setenv("FAILURE_MODE", "allow")
+	defer func() {
+		os.Unsetenv("FAILURE_MODE")
+	}()
+
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	t.localLimiter = limiter.NewLocalLimiter(MockClock{now: int64(2222)}, 1024*1024, 2, 0.8, "", t.statsManager)
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"foo", "bar"}}, {{"hello", "world"}}, {{"local", "value"}}, {{"no", "limit"}}}, 1)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("foo_bar"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("hello_world"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("local_value"), false, false, "", nil, false),
+		nil,
+	}
+	limits[1].FailureMode = config.FailureModeDeny
+	limits[2].FailureMode = config.FailureModeLocal
+	for i, limit := range limits {
+		t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[i]).Return(limit)
+	}
+	t.cache.EXPECT().DoLimit(context.Background(), request, limits).Do(
+		func(context.Context, *pb.RateLimitRequest, []*config.RateLimit) {
+			panic(redis.RedisError("cache error"))
+		})
+
+	// Limits without a failure mode are allowed by the failure mode of the service, and local limits are divided
+	// by the number of replicas.
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(pb.RateLimitResponse_OVER_LIMIT, response.OverallCode)
+	t.assert.Equal(&pb.RateLimitResponse_DescriptorStatus{
+		Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit, LimitRemaining: 10,
+	}, response.Statuses[0])
+	t.assert.Equal(&pb.RateLimitResponse_DescriptorStatus{
+		Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[1].Limit,
+	}, response.Statuses[1])
+	t.assert.Equal(pb.RateLimitResponse_OK, response.Statuses[2].Code)
+	t.assert.EqualValues(5, response.Statuses[2].CurrentLimit.RequestsPerUnit)
+	t.assert.EqualValues(4, response.Statuses[2].LimitRemaining)
+	t.assert.Equal(&pb.RateLimitResponse_DescriptorStatus{Code: pb.RateLimitResponse_OK}, response.Statuses[3])
+	t.assert.EqualValues(0, t.statStore.NewCounter("call.should_rate_limit.redis_error").Value())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.should_rate_limit.failure_mode.allow").Value())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.should_rate_limit.failure_mode.deny").Value())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.should_rate_limit.failure_mode.local").Value())
+
+	// A single limit with the error failure mode fails the request, with the errors of any unavailable cache.
+	limits[0].FailureMode = config.FailureModeError
+	for i, limit := range limits {
+		t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[i]).Return(limit)
+	}
+	t.cache.EXPECT().DoLimit(context.Background(), request, limits).Do(
+		func(context.Context, *pb.RateLimitRequest, []*config.RateLimit) {
+			panic(boltdb.BoltError("database not open"))
+		})
+	response, err = service.ShouldRateLimit(context.Background(), request)
+	t.assert.Nil(response)
+	t.assert.Equal("database not open", err.Error())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.should_rate_limit.redis_error").Value())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.should_rate_limit.failure_mode.allow").Value())
+
+	// Failures of memcached are handled the same way.
+	limits[0].FailureMode = config.FailureModeDefault
+	for i, limit := range limits {
+		t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[i]).Return(limit)
+	}
+	t.cache.EXPECT().DoLimit(context.Background(), request, limits).Do(
+		func(context.Context, *pb.RateLimitRequest, []*config.RateLimit) {
+			panic(memcached.MemcacheError("Error multi-getting memcache keys: memcache: no servers configured or available"))
+		})
+	response, err = service.ShouldRateLimit(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(pb.RateLimitResponse_OVER_LIMIT, response.OverallCode)
+	t.assert.Equal(pb.RateLimitResponse_OVER_LIMIT, response.Statuses[1].Code)
+	t.assert.EqualValues(2, t.statStore.NewCounter("call.should_rate_limit.failure_mode.allow").Value())
+	t.assert.EqualValues(2, t.statStore.NewCounter("call.should_rate_limit.failure_mode.deny").Value())
+	t.assert.EqualValues(2, t.statStore.NewCounter("call.should_rate_limit.failure_mode.local").Value())
+}
+
+func TestServiceBackendTimeout(test *testing.T) {
+	os.Setenv("BACKEND_TIMEOUT", "10ms")
+	defer func() {
+		os.Unsetenv("BACKEND_TIMEOUT")
+	}()
+
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest("different-domain", [][][2]string{{{"foo", "bar"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("key"), false, false, "", nil, false)}
+	limits[0].FailureMode = config.FailureModeAllow
+
+	// Requests are not kept waiting for the cache longer than the backend timeout, and are handled by the failure mode
+	// of their limits.
+	release := make(chan struct{})
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.cache.EXPECT().DoLimit(gomock.Any(), request, limits).DoAndReturn(
+		func(context.Context, *pb.RateLimitRequest, []*config.RateLimit) []*pb.RateLimitResponse_DescriptorStatus {
+			<-release
+			return []*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[0].Limit}}
+		})
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	close(release)
+	t.assert.Nil(err)
+	t.assert.Equal(pb.RateLimitResponse_OK, response.OverallCode)
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.should_rate_limit.backend_timeout").Value())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.should_rate_limit.failure_mode.allow").Value())
+
+	// The clients of the cache fail calls which time out with a CacheTimeoutError.
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.cache.EXPECT().DoLimit(gomock.Any(), request, limits).Do(
+		func(context.Context, *pb.RateLimitRequest, []*config.RateLimit) {
+			panic(limiter.CacheTimeoutError("i/o timeout"))
+		})
+	response, err = service.ShouldRateLimit(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(pb.RateLimitResponse_OK, response.OverallCode)
+	t.assert.EqualValues(2, t.statStore.NewCounter("call.should_rate_limit.backend_timeout").Value())
+	t.assert.EqualValues(2, t.statStore.NewCounter("call.should_rate_limit.failure_mode.allow").Value())
+
+	// Other errors of the cache are not timeouts.
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.cache.EXPECT().DoLimit(gomock.Any(), request, limits).Do(
+		func(context.Context, *pb.RateLimitRequest, []*config.RateLimit) {
+			panic(redis.RedisError("connection refused"))
+		})
+	response, err = service.ShouldRateLimit(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(pb.RateLimitResponse_OK, response.OverallCode)
+	t.assert.EqualValues(2, t.statStore.NewCounter("call.should_rate_limit.backend_timeout").Value())
+	t.assert.EqualValues(3, t.statStore.NewCounter("call.should_rate_limit.failure_mode.allow").Value())
+
+	// Requests complete normally within the backend timeout.
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.cache.EXPECT().DoLimit(gomock.Any(), request, limits).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[0].Limit}})
+	response, err = service.ShouldRateLimit(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(pb.RateLimitResponse_OVER_LIMIT, response.OverallCode)
+	t.assert.EqualValues(2, t.statStore.NewCounter("call.should_rate_limit.backend_timeout").Value())
+}
+
+func TestServiceRequestDeadline(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest("different-domain", [][][2]string{{{"foo", "bar"}}}, 1)
+	limits := []*config.RateLimit{config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("key"), false, false, "", nil, false)}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	t.config.EXPECT().GetLimit(ctx, "different-domain", request.Descriptors[0]).Return(limits[0])
+
+	// Without a failure mode, requests whose deadline passed fail without calling the cache.
+	response, err := service.ShouldRateLimit(ctx, request)
+	t.assert.Nil(response)
+	t.assert.Equal("backend timeout: context deadline exceeded", err.Error())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.should_rate_limit.backend_timeout").Value())
+	t.assert.EqualValues(1, t.statStore.NewCounter("call.should_rate_limit.redis_error").Value())
+
+	// Requests whose deadline passes while waiting for the cache fail the same way.
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	release := make(chan struct{})
+	t.config.EXPECT().GetLimit(ctx, "different-domain", request.Descriptors[0]).Return(limits[0])
+	t.cache.EXPECT().DoLimit(gomock.Any(), request, limits).DoAndReturn(
+		func(context.Context, *pb.RateLimitRequest, []*config.RateLimit) []*pb.RateLimitResponse_DescriptorStatus {
+			<-release
+			return []*pb.RateLimitResponse_DescriptorStatus{{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[0].Limit}}
+		})
+	response, err = service.ShouldRateLimit(ctx, request)
+	close(release)
+	t.assert.Nil(response)
+	t.assert.Equal("backend timeout: context deadline exceeded", err.Error())
+	t.assert.EqualValues(2, t.statStore.NewCounter("call.should_rate_limit.backend_timeout").Value())
+	t.assert.EqualValues(2, t.statStore.NewCounter("call.should_rate_limit.redis_error").Value())
+}
+
+func TestServiceBlock(test *testing.T) {
+	t := commonSetup(test)
+	defer t.controller.Finish()
+	service := t.setupBasicService()
+
+	request := common.NewRateLimitRequest(
+		"different-domain", [][][2]string{{{"remote_address", "10.0.0.1"}}, {{"hello", "world"}}}, 2)
+	limits := []*config.RateLimit{
+		config.NewRateLimit(0, pb.RateLimitResponse_RateLimit_SECOND, t.statsManager.NewStats("remote_address_10.0.0.0/8"), false, false, "", nil, false),
+		config.NewRateLimit(10, pb.RateLimitResponse_RateLimit_MINUTE, t.statsManager.NewStats("hello_world"), false, false, "", nil, false),
+	}
+	limits[0].Blocked = true
+
+	// The blocked descriptor is passed to the cache without a limit.
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[0]).Return(limits[0]).Times(2)
+	t.config.EXPECT().GetLimit(context.Background(), "different-domain", request.Descriptors[1]).Return(limits[1]).Times(2)
+	t.cache.EXPECT().DoLimit(context.Background(), request, []*config.RateLimit{nil, limits[1]}).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: nil, LimitRemaining: 0},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 8},
+		})
+	response, err := service.ShouldRateLimit(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(
+		&pb.RateLimitResponse{
+			OverallCode: pb.RateLimitResponse_OVER_LIMIT,
+			Statuses: []*pb.RateLimitResponse_DescriptorStatus{
+				{Code: pb.RateLimitResponse_OVER_LIMIT, CurrentLimit: limits[0].Limit, LimitRemaining: 0},
+				{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 8},
+			},
+		},
+		response)
+	t.assert.EqualValues(2, limits[0].Stats.TotalHits.Value())
+	t.assert.EqualValues(2, limits[0].Stats.OverLimit.Value())
+
+	// Blocked limits in shadow mode only count the hits.
+	limits[0].ShadowMode = true
+	t.cache.EXPECT().DoLimit(context.Background(), request, []*config.RateLimit{nil, limits[1]}).Return(
+		[]*pb.RateLimitResponse_DescriptorStatus{
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: nil, LimitRemaining: 0},
+			{Code: pb.RateLimitResponse_OK, CurrentLimit: limits[1].Limit, LimitRemaining: 6},
+		})
+	response, err = service.ShouldRateLimit(context.Background(), request)
+	t.assert.Nil(err)
+	t.assert.Equal(pb.RateLimitResponse_OK, response.OverallCode)
+	t.assert.Equal(pb.RateLimitResponse_OK, response.Statuses[0].Code)
+	t.assert.EqualValues(4, limits[0].Stats.OverLimit.Value())
+	t.assert.EqualValues(2, limits[0].Stats.ShadowMode.Value())
+}
@@ -0,0 +1,88 @@
+package utils_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	gostats "github.com/lyft/gostats"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/envoyproxy/ratelimit/src/utils"
+	mock_utils "github.com/envoyproxy/ratelimit/test/mocks/utils"
+)
+
+var errCacheMiss = errors.New("cache miss")
+
+func TestCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	timeSource := mock_utils.NewMockTimeSource(controller)
+	now := int64(0)
+	timeSource.EXPECT().UnixNanoNow().DoAndReturn(func() int64 { return now }).AnyTimes()
+	statsStore := gostats.NewStore(gostats.NewNullSink(), false)
+	var openChanges []bool
+	breaker := utils.NewCircuitBreaker(timeSource, 2, 100*time.Millisecond, 10*time.Second, 2,
+		func(err error) bool { return err != errCacheMiss },
+		func(open bool) { openChanges = append(openChanges, open) },
+		statsStore.Scope("circuit_breaker"))
+
+	succeed := func() error { return nil }
+	fail := func() error { return errors.New("unavailable") }
+	calls := 0
+	count := func(call func() error) func() error {
+		return func() error {
+			calls++
+			return call()
+		}
+	}
+
+	// Successful calls and errors which are not failures reset the consecutive failures.
+	assert.Error(breaker.Do(fail))
+	assert.Equal(errCacheMiss, breaker.Do(func() error { return errCacheMiss }))
+	assert.Error(breaker.Do(fail))
+	assert.Nil(breaker.Do(succeed))
+	assert.Error(breaker.Do(fail))
+	assert.Equal(utils.CircuitBreakerClosed, breaker.State())
+
+	// Slow calls count as failures.
+	assert.Nil(breaker.Do(func() error {
+		now += (200 * time.Millisecond).Nanoseconds()
+		return nil
+	}))
+	assert.Equal(utils.CircuitBreakerOpen, breaker.State())
+	assert.Equal([]bool{true}, openChanges)
+	assert.EqualValues(1, statsStore.NewCounter("circuit_breaker.latency_breach").Value())
+	assert.EqualValues(1, statsStore.NewCounter("circuit_breaker.opened").Value())
+	assert.EqualValues(utils.CircuitBreakerOpen, statsStore.NewGauge("circuit_breaker.state").Value())
+
+	// Calls are rejected while the breaker is open.
+	assert.Equal(utils.ErrCircuitBreakerOpen, breaker.Do(count(succeed)))
+	assert.Equal(0, calls)
+	assert.EqualValues(1, statsStore.NewCounter("circuit_breaker.rejected").Value())
+
+	// A failed probe opens the breaker again.
+	now += (10 * time.Second).Nanoseconds()
+	assert.Nil(breaker.Do(count(succeed)))
+	assert.Equal(utils.CircuitBreakerHalfOpen, breaker.State())
+	assert.Error(breaker.Do(count(fail)))
+	assert.Equal(utils.CircuitBreakerOpen, breaker.State())
+	assert.Equal(2, calls)
+	assert.EqualValues(2, statsStore.NewCounter("circuit_breaker.opened").Value())
+
+	// Half-open breakers only let the probes through at once, and close once they all succeed.
+	now += (10 * time.Second).Nanoseconds()
+	assert.Nil(breaker.Do(func() error {
+		assert.Nil(breaker.Do(func() error {
+			assert.Equal(utils.ErrCircuitBreakerOpen, breaker.Do(count(succeed)))
+			return nil
+		}))
+		return nil
+	}))
+	assert.Equal(2, calls)
+	assert.Equal(utils.CircuitBreakerClosed, breaker.State())
+	assert.Equal([]bool{true, false}, openChanges)
+	assert.EqualValues(utils.CircuitBreakerClosed, statsStore.NewGauge("circuit_breaker.state").Value())
+}
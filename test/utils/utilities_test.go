@@ -2,7 +2,9 @@ package utils_test
 
 import (
 	"testing"
+	"time"
 
+	pb "github.com/envoyproxy/go-control-plane/envoy/service/ratelimit/v3"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/envoyproxy/ratelimit/src/utils"
@@ -39,3 +41,88 @@ func TestMaskCredentialsInUrlSentinel(t *testing.T) {
 	expected = "foob@r,redis://*****@redis1:6379,redis://*****@redis2:6379"
 	assert.Equal(t, expected, utils.MaskCredentialsInUrl(url))
 }
+
+func TestUnitToDividerWithMultiplier(t *testing.T) {
+	assert.Equal(t, int64(60), utils.UnitToDividerWithMultiplier(pb.RateLimitResponse_RateLimit_MINUTE, 0))
+	assert.Equal(t, int64(60), utils.UnitToDividerWithMultiplier(pb.RateLimitResponse_RateLimit_MINUTE, 1))
+	assert.Equal(t, int64(900), utils.UnitToDividerWithMultiplier(pb.RateLimitResponse_RateLimit_MINUTE, 15))
+	assert.Equal(t, int64(5), utils.UnitToDividerWithMultiplier(pb.RateLimitResponse_RateLimit_SECOND, 5))
+}
+
+type fixedTimeSource int64
+
+func (s fixedTimeSource) UnixNow() int64     { return int64(s) }
+func (s fixedTimeSource) UnixNanoNow() int64 { return int64(s) * 1e9 }
+
+func TestCalculateResetWithMultiplier(t *testing.T) {
+	unit := pb.RateLimitResponse_RateLimit_MINUTE
+	assert.Equal(t, int64(38), utils.CalculateReset(&unit, fixedTimeSource(1222)).GetSeconds())
+	// 1222 is 322 seconds into the 15 minute window starting at 900.
+	assert.Equal(t, int64(578), utils.CalculateResetWithMultiplier(&unit, 15, fixedTimeSource(1222)).GetSeconds())
+}
+
+func TestCalendarWindow(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+	unix := func(value string) int64 {
+		parsed, err := time.ParseInLocation(time.DateTime, value, newYork)
+		assert.NoError(t, err)
+		return parsed.Unix()
+	}
+	now := unix("2024-02-14 18:30:15")
+
+	// Windows aligned to the unix epoch ignore the calendar.
+	start, end := utils.Window(pb.RateLimitResponse_RateLimit_MONTH, 1, nil, now)
+	assert.Equal(t, int64(30*24*3600), end-start)
+
+	start, end = utils.Window(pb.RateLimitResponse_RateLimit_MONTH, 1, newYork, now)
+	assert.Equal(t, unix("2024-02-01 00:00:00"), start)
+	assert.Equal(t, unix("2024-03-01 00:00:00"), end)
+
+	start, end = utils.Window(pb.RateLimitResponse_RateLimit_YEAR, 1, newYork, now)
+	assert.Equal(t, unix("2024-01-01 00:00:00"), start)
+	assert.Equal(t, unix("2025-01-01 00:00:00"), end)
+
+	// ISO weeks start on Mondays.
+	start, end = utils.Window(pb.RateLimitResponse_RateLimit_WEEK, 1, newYork, now)
+	assert.Equal(t, unix("2024-02-12 00:00:00"), start)
+	assert.Equal(t, unix("2024-02-19 00:00:00"), end)
+
+	start, end = utils.Window(pb.RateLimitResponse_RateLimit_DAY, 1, newYork, now)
+	assert.Equal(t, unix("2024-02-14 00:00:00"), start)
+	assert.Equal(t, unix("2024-02-15 00:00:00"), end)
+
+	// The day daylight saving time starts on has 23 hours.
+	start, end = utils.Window(pb.RateLimitResponse_RateLimit_DAY, 1, newYork, unix("2024-03-10 12:00:00"))
+	assert.Equal(t, int64(23*3600), end-start)
+
+	// The hour repeated when daylight saving time ends is two separate windows.
+	firstStart, firstEnd := utils.Window(pb.RateLimitResponse_RateLimit_HOUR, 1, newYork, unix("2024-11-03 00:59:59")+1800)
+	secondStart, secondEnd := utils.Window(pb.RateLimitResponse_RateLimit_HOUR, 1, newYork, unix("2024-11-03 00:59:59")+5400)
+	assert.Equal(t, firstEnd, secondStart)
+	assert.Equal(t, int64(3600), firstEnd-firstStart)
+	assert.Equal(t, int64(3600), secondEnd-secondStart)
+}
+
+func TestCalculateWindowReset(t *testing.T) {
+	unit := pb.RateLimitResponse_RateLimit_MONTH
+	// 2024-02-29 23:00:00 UTC is one hour before the end of the month.
+	assert.Equal(t, int64(3600), utils.CalculateWindowReset(&unit, 1, time.UTC, fixedTimeSource(1709247600)).GetSeconds())
+}
+
+func TestIsBearerTokenValid(t *testing.T) {
+	assert.True(t, utils.IsBearerTokenValid("Bearer secret", "secret"))
+	assert.False(t, utils.IsBearerTokenValid("Bearer wrong", "secret"))
+	assert.False(t, utils.IsBearerTokenValid("secret", "secret"))
+	assert.False(t, utils.IsBearerTokenValid("Bearer ", ""))
+	assert.False(t, utils.IsBearerTokenValid("", "secret"))
+}
+
+func TestSanitizeStatName(t *testing.T) {
+	assert.Equal(t, "domain.key_value_a_b", utils.SanitizeStatName("domain.key_value:a|b"))
+	assert.Equal(t, "domain.remote_address_10_0_0_1", utils.SanitizeStatName("domain.remote_address_10.0.0.1"))
+	assert.Equal(t, "domain.remote_address_10_0_0_0_8.path_/api", utils.SanitizeStatName("domain.remote_address_10.0.0.0/8.path_/api"))
+	assert.Equal(t, "domain.remote_address_2001_db8__1", utils.SanitizeStatName("domain.remote_address_2001:db8::1"))
+	assert.Equal(t, "domain.remote_address___ffff_10_0_0_1", utils.SanitizeStatName("domain.remote_address_::ffff:10.0.0.1"))
+	assert.Equal(t, "domain.remote_address_2001_db8___32.user", utils.SanitizeStatName("domain.remote_address_2001:db8::/32.user"))
+}